@@ -0,0 +1,144 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"go/ast"
+	"strconv"
+)
+
+//======================================================================
+// ARENA -- Automatic variables ("_1", "_2", ...) hold the internal state
+// of function instances (like the stages of a DELAY3). They are not part
+// of the model state (levels, rates, constants) but are kept in an arena
+// indexed by the number of the variable: the variables of a function
+// instance occupy consecutive slots. For inspection and output they have
+// names derived from the function and the equation (like "_DELAY3$SHIP$L1").
+//======================================================================
+
+// arena holds the values of automatic variables
+type arena struct {
+	vals []Variable // values of variables (by number)
+	set  []bool     // variable has a value
+}
+
+// slot returns the index of an automatic variable ("_<n>").
+func slot(name string) (n int) {
+	for _, c := range name[1:] {
+		n = n*10 + int(c-'0')
+	}
+	return
+}
+
+// get the value of an automatic variable; returns false if the variable
+// has no value yet.
+func (a *arena) get(name string) (Variable, bool) {
+	if n := slot(name); n < len(a.vals) && a.set[n] {
+		return a.vals[n], true
+	}
+	return 0, false
+}
+
+// put sets the value of an automatic variable.
+func (a *arena) put(name string, val Variable) {
+	n := slot(name)
+	if n >= len(a.vals) {
+		size := 2 * (n + 1)
+		vals, set := make([]Variable, size), make([]bool, size)
+		copy(vals, a.vals)
+		copy(set, a.set)
+		a.vals, a.set = vals, set
+	}
+	a.vals[n], a.set[n] = val, true
+}
+
+// reserve space for given number of variables: setting a variable in the
+// reserved space doesn't grow the arena (so distinct variables can be set
+// concurrently).
+func (a *arena) reserve(n int) {
+	if n > len(a.vals) {
+		vals, set := make([]Variable, n), make([]bool, n)
+		copy(vals, a.vals)
+		copy(set, a.set)
+		a.vals, a.set = vals, set
+	}
+}
+
+// reset the arena: all automatic variables lose their values.
+func (a *arena) reset() {
+	a.vals, a.set = nil, nil
+}
+
+// clone the arena
+func (a *arena) clone() arena {
+	c := arena{
+		vals: make([]Variable, len(a.vals)),
+		set:  make([]bool, len(a.set)),
+	}
+	copy(c.vals, a.vals)
+	copy(c.set, a.set)
+	return c
+}
+
+// internNames returns the derived (stable) names of the automatic
+// variables of function instances in the model equations: the variable
+// "_<n>" is named "_<function>$<target>$<variable>" like "_DELAY3$SHIP$L1"
+// (with "$<k>" after the target for the k-th call of a function in an
+// equation). Automatic variables in initial equations are not named.
+func (mdl *Model) internNames() map[string]string {
+	names := make(map[string]string)
+	for _, eqn := range mdl.equations().List() {
+		if eqn.Mode == "N" {
+			continue
+		}
+		calls := make(map[string]int)
+		ast.Inspect(eqn.Formula, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			fcn, ok := call.Fun.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			f, ok := fcnList[fcn.Name]
+			if !ok || f.NumVars == 0 || len(call.Args) < f.NumVars {
+				return true
+			}
+			calls[fcn.Name]++
+			prefix := "_" + fcn.Name + "$" + eqn.Target.Name
+			if k := calls[fcn.Name]; k > 1 {
+				prefix += "$" + strconv.Itoa(k)
+			}
+			for i, arg := range call.Args[len(call.Args)-f.NumVars:] {
+				if id, ok := arg.(*ast.Ident); ok {
+					v := "V" + strconv.Itoa(i+1)
+					if i < len(f.Vars) {
+						v = f.Vars[i]
+					}
+					names[id.Name] = prefix + "$" + v
+				}
+			}
+			return true
+		})
+	}
+	return names
+}
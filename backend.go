@@ -0,0 +1,176 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//======================================================================
+// Custom output backends: print and plot output can be passed to sinks
+// implemented outside the interpreter (like message queues, databases or
+// live charts). Backends are registered by file extension (".EXT") or by
+// name; a print or plot target "NAME" or "NAME:..." (or a file with a
+// registered extension) creates a new backend instance for the target.
+//======================================================================
+
+// PrintBackend is a custom sink for printed values.
+type PrintBackend interface {
+	// Start is called at the start of a printed run with the names of the
+	// printed variables (including TIME).
+	Start(runID string, names []string) *Result
+	// AddSample is called for each printed epoch with the printed values.
+	AddSample(values State) *Result
+	// Finish is called at the end of a printed run.
+	Finish() *Result
+}
+
+// PlotBackend is a custom sink for plotted values.
+type PlotBackend interface {
+	// Start is called at the start of a plotted run with the names of the
+	// plotted variables (including TIME).
+	Start(runID string, names []string) *Result
+	// AddSample is called for each plotted epoch with the plotted values.
+	AddSample(values State) *Result
+	// Finish is called at the end of a plotted run.
+	Finish() *Result
+}
+
+// Factories for custom backends (keyed by extension or name)
+var (
+	backendLock   sync.RWMutex
+	printBackends = make(map[string]func(target string) PrintBackend)
+	plotBackends  = make(map[string]func(target string) PlotBackend)
+)
+
+// RegisterPrintBackend registers a factory for print backends. The key is
+// either a file extension (like ".mq") or a name (like "db").
+func RegisterPrintBackend(key string, create func(target string) PrintBackend) {
+	backendLock.Lock()
+	defer backendLock.Unlock()
+	printBackends[strings.ToUpper(key)] = create
+}
+
+// RegisterPlotBackend registers a factory for plot backends. The key is
+// either a file extension (like ".mq") or a name (like "chart").
+func RegisterPlotBackend(key string, create func(target string) PlotBackend) {
+	backendLock.Lock()
+	defer backendLock.Unlock()
+	plotBackends[strings.ToUpper(key)] = create
+}
+
+// backendKeys returns the registry keys matching a target (name first).
+func backendKeys(target string) (keys []string) {
+	name := target
+	if pos := strings.Index(name, ":"); pos != -1 {
+		name = name[:pos]
+	}
+	keys = append(keys, strings.ToUpper(name))
+	if pos := strings.LastIndex(target, "."); pos != -1 {
+		keys = append(keys, strings.ToUpper(target[pos:]))
+	}
+	return
+}
+
+// newPrintBackend returns a print backend for a target (or nil if no
+// backend is registered for it).
+func newPrintBackend(target string) PrintBackend {
+	backendLock.RLock()
+	defer backendLock.RUnlock()
+	for _, key := range backendKeys(target) {
+		if create, ok := printBackends[key]; ok {
+			return create(target)
+		}
+	}
+	return nil
+}
+
+// newPlotBackend returns a plot backend for a target (or nil if no
+// backend is registered for it).
+func newPlotBackend(target string) PlotBackend {
+	backendLock.RLock()
+	defer backendLock.RUnlock()
+	for _, key := range backendKeys(target) {
+		if create, ok := plotBackends[key]; ok {
+			return create(target)
+		}
+	}
+	return nil
+}
+
+// backend is a print or plot backend.
+type backend interface {
+	Start(runID string, names []string) *Result
+	AddSample(values State) *Result
+	Finish() *Result
+}
+
+// backendList is a list of backends used by a printer or plotter.
+type backendList []backend
+
+// start a run for all backends.
+func (list backendList) start(runID string, names []string) *Result {
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.Strings(sorted)
+	for _, be := range list {
+		if res := be.Start(runID, sorted); !res.Ok {
+			return res
+		}
+	}
+	return Success()
+}
+
+// add a sample to all backends.
+func (list backendList) add(values State) *Result {
+	for _, be := range list {
+		if res := be.AddSample(values); !res.Ok {
+			return res
+		}
+	}
+	return Success()
+}
+
+// finish a run for all backends.
+func (list backendList) finish() *Result {
+	for _, be := range list {
+		if res := be.Finish(); !res.Ok {
+			return res
+		}
+	}
+	return Success()
+}
+
+// close all backends that hold resources (implement io.Closer).
+func (list backendList) close() (res *Result) {
+	res = Success()
+	for _, be := range list {
+		if c, ok := be.(io.Closer); ok {
+			if err := c.Close(); err != nil && res.Ok {
+				res = Failure(err)
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,235 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//======================================================================
+// BATCH -- A manifest (in JSON format) describes multiple runs of models
+// that are executed as a batch:
+//
+//     {
+//         "runs": [
+//             { "id": "base", "model": "flu.dynamo", "seed": 1 },
+//             { "id": "high", "model": "flu.dynamo", "seed": 1,
+//               "overrides": { "CONTACT": 0.4 },
+//               "print": "high.csv", "plot": "high.gnuplot" }
+//         ]
+//     }
+//
+// Relative file names are resolved against the directory of the manifest.
+// A summary table lists the outcome of all runs.
+//======================================================================
+
+// BatchRun describes a single entry in a batch manifest
+type BatchRun struct {
+	ID        string             `json:"id"`        // identifier of entry
+	Model     string             `json:"model"`     // model file
+	Overrides map[string]float64 `json:"overrides"` // overridden constants
+	Seed      int64              `json:"seed"`      // seed for random numbers (0 = default)
+	Print     string             `json:"print"`     // printer file
+	Plot      string             `json:"plot"`      // plotter file
+}
+
+// Manifest is a list of model runs executed as a batch
+type Manifest struct {
+	Runs []*BatchRun `json:"runs"` // list of batch entries
+	dir  string      // base directory for relative file names
+}
+
+// ReadManifest reads a batch manifest from file.
+func ReadManifest(fname string) (m *Manifest, res *Result) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, Failure(err)
+	}
+	defer f.Close()
+	if m, res = ParseManifest(f); res.Ok {
+		m.dir = filepath.Dir(fname)
+	}
+	return
+}
+
+// ParseManifest reads a batch manifest from a stream.
+func ParseManifest(rdr io.Reader) (m *Manifest, res *Result) {
+	m = new(Manifest)
+	dec := json.NewDecoder(rdr)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(m); err != nil {
+		return nil, Failure(ErrBatchManifest+": %s", err.Error())
+	}
+	if len(m.Runs) == 0 {
+		return nil, Failure(ErrBatchManifest + ": no runs")
+	}
+	ids := make(map[string]bool)
+	for i, br := range m.Runs {
+		if len(br.Model) == 0 {
+			return nil, Failure(ErrBatchManifest+": no model in entry %d", i+1)
+		}
+		if len(br.ID) == 0 {
+			br.ID = "#" + strconv.Itoa(i+1)
+		}
+		if ids[br.ID] {
+			return nil, Failure(ErrBatchManifest+": duplicate id '%s'", br.ID)
+		}
+		ids[br.ID] = true
+	}
+	return m, Success()
+}
+
+// BatchResult is the outcome of a batch entry
+type BatchResult struct {
+	Run      *BatchRun           // batch entry
+	Res      *Result             // result of parsing and running the model
+	Seed     int64               // seed used for random numbers
+	Warnings int                 // number of warnings
+	Results  map[string]*Dataset // results of all model runs
+	Duration time.Duration       // execution time
+}
+
+// Execute all entries of the manifest; the setup function is called for
+// every new model (before parsing) to apply common settings.
+func (m *Manifest) Execute(setup func(mdl *Model)) (list []*BatchResult) {
+	for _, br := range m.Runs {
+		Logf(LOG_INFO, LOG_RUN, "Batch entry '%s' (%s)...", br.ID, br.Model)
+		list = append(list, m.execute(br, setup))
+	}
+	return
+}
+
+// execute a single batch entry
+func (m *Manifest) execute(br *BatchRun, setup func(mdl *Model)) (out *BatchResult) {
+	out = &BatchResult{Run: br, Seed: br.Seed}
+	start, warnings := time.Now(), Warnings()
+	defer func() {
+		out.Duration = time.Since(start)
+		out.Warnings = Warnings() - warnings
+	}()
+	path := func(fname string) string {
+		if len(fname) == 0 || filepath.IsAbs(fname) {
+			return fname
+		}
+		return filepath.Join(m.dir, fname)
+	}
+	f, err := os.Open(path(br.Model))
+	if err != nil {
+		out.Res = Failure(err)
+		return
+	}
+	defer f.Close()
+
+	mdl := NewModel(path(br.Print), path(br.Plot))
+	if setup != nil {
+		setup(mdl)
+	}
+	if br.Seed != 0 {
+		mdl.Seed = br.Seed
+	}
+	mdl.Overrides = make(State)
+	for name, val := range br.Overrides {
+		mdl.Overrides[name] = Variable(val)
+	}
+	out.Res = mdl.Parse(f)
+	if res := mdl.Quit(); out.Res.Ok {
+		out.Res = res
+	}
+	out.Results = mdl.Results
+	for _, ds := range mdl.Results {
+		if ds.Provenance != nil {
+			out.Seed = ds.Provenance.Seed
+		}
+	}
+	return
+}
+
+// WriteBatchSummary writes a summary table of batch results.
+func WriteBatchSummary(w io.Writer, list []*BatchResult) {
+	fmt.Fprintf(w, "%-12s %-24s %20s %8s %5s  %-20s %s\n", "ID", "MODEL", "SEED", "TIME", "WARN", "RUNS", "RESULT")
+	fmt.Fprintln(w, strings.Repeat("-", 100))
+	for _, br := range list {
+		runs := make([]string, 0, len(br.Results))
+		for id := range br.Results {
+			runs = append(runs, id)
+		}
+		sort.Strings(runs)
+		outcome := "OK"
+		if !br.Res.Ok {
+			outcome = "FAILED: " + br.Res.Error()
+		}
+		fmt.Fprintf(w, "%-12s %-24s %20d %7.2fs %5d  %-20s %s\n",
+			br.Run.ID, br.Run.Model, br.Seed, br.Duration.Seconds(), br.Warnings, strings.Join(runs, ","), outcome)
+	}
+}
+
+// BatchOutcome returns the combined outcome of a batch: the result of the
+// first failed entry (or success), the number of completed runs and the
+// total number of warnings.
+func BatchOutcome(list []*BatchResult) (res *Result, runs, warnings int) {
+	res = Success()
+	for _, br := range list {
+		if res.Ok && !br.Res.Ok {
+			res = br.Res
+		}
+		runs += len(br.Results)
+		warnings += br.Warnings
+	}
+	return
+}
+
+// applyOverrides replaces the overridden constants in the model equations
+// (like in an edited model) before a run.
+func (mdl *Model) applyOverrides() (res *Result) {
+	res = Success()
+	if len(mdl.Overrides) == 0 {
+		return
+	}
+	names := make([]string, 0, len(mdl.Overrides))
+	for name := range mdl.Overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	edit := mdl.Edit
+	mdl.Edit = true
+	defer func() {
+		mdl.Edit = edit
+	}()
+	for _, name := range names {
+		if eqn := mdl.Eqns.Find(name); eqn == nil || eqn.Mode != "C" {
+			return Failure(ErrModelEqnBadMode+": %s is not a constant", name)
+		}
+		val := strconv.FormatFloat(float64(mdl.Overrides[name]), 'g', -1, 64)
+		Logf(LOG_VERBOSE, LOG_RUN, "      Overriding %s = %s", name, val)
+		if res = mdl.AddStatement(&Line{Mode: "C", Stmt: name + "=" + val}); !res.Ok {
+			return
+		}
+	}
+	return
+}
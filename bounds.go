@@ -0,0 +1,188 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Handling of bound violations
+const (
+	BOUND_WARN  = "WARN"  // log a warning (first violation only)
+	BOUND_CLAMP = "CLAMP" // set level to bound (and warn)
+	BOUND_ABORT = "ABORT" // abort the model run
+)
+
+//----------------------------------------------------------------------
+// BOUNDS -- Levels can have lower and upper bounds (like non-negative
+// stocks) that are declared in NOTE lines before the level equation:
+//
+//     NOTE @min 0
+//     NOTE @bound CLAMP
+//     L    INV.K=INV.J+(DT)(PROD.JK-SHIP.JK)
+//
+// or as inline attributes of the equation (separated by semicolons):
+//
+//     L    INV.K=INV.J+(DT)(PROD.JK-SHIP.JK);MIN=0;BOUND=CLAMP
+//
+// Bounds are checked for the initial values and after each computation
+// of new levels.
+//----------------------------------------------------------------------
+
+// splitAttributes splits the inline attributes (";KEY=VALUE") from an
+// equation statement.
+func splitAttributes(stmt *Line) (eqn *Line, attrs map[string]string, res *Result) {
+	res = Success()
+	parts := strings.Split(stmt.Stmt, ";")
+	if len(parts) == 1 {
+		return stmt, nil, res
+	}
+	attrs = make(map[string]string)
+	for _, attr := range parts[1:] {
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) != 2 {
+			res = Failure(ErrParseSyntax+": %s", attr)
+			return
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		switch key {
+		case META_MIN, META_MAX, META_BOUND, META_TYPE:
+			attrs[key] = strings.TrimSpace(kv[1])
+		default:
+			res = Failure(ErrParseSyntax+": unknown attribute %s", kv[0])
+			return
+		}
+	}
+	eqn = &Line{
+		Mode:    stmt.Mode,
+		Stmt:    parts[0],
+		Comment: stmt.Comment,
+	}
+	return
+}
+
+// bound of a level
+type bound struct {
+	name     string  // name of level
+	min, max float64 // bounds (-Inf/+Inf if not set)
+	mode     string  // handling of violations (BOUND_???)
+	count    int     // number of violations in run
+}
+
+// bounds returns the list of bounds for levels in a list of equations.
+func (mdl *Model) bounds(eqns *EqnList) (list []*bound, res *Result) {
+	res = Success()
+	// collect metadata for levels
+	meta := make(map[string]map[string]string)
+	levels := make(map[string]bool)
+	for _, eqn := range eqns.List() {
+		name := eqn.Target.Name
+		if eqn.Mode == "L" {
+			levels[name] = true
+		}
+		for _, key := range []string{META_MIN, META_MAX, META_BOUND} {
+			if val, ok := eqn.Meta[key]; ok {
+				if meta[name] == nil {
+					meta[name] = make(map[string]string)
+				}
+				meta[name][key] = val
+			}
+		}
+	}
+	var names []string
+	for name := range meta {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := meta[name]
+		if !levels[name] {
+			Logf(LOG_WARN, LOG_MODEL, "Bounds on non-level %s ignored\n", name)
+			continue
+		}
+		b := &bound{
+			name: name,
+			min:  math.Inf(-1),
+			max:  math.Inf(1),
+			mode: mdl.BoundMode,
+		}
+		for key, v := range map[string]*float64{META_MIN: &b.min, META_MAX: &b.max} {
+			if val, ok := m[key]; ok {
+				if *v, ok = parseNumber(val); !ok {
+					res = Failure(ErrParseNotANumber+": @%s %s (%s)", key, val, name)
+					return
+				}
+			}
+		}
+		if mode, ok := m[META_BOUND]; ok {
+			b.mode = strings.ToUpper(mode)
+		}
+		if len(b.mode) == 0 {
+			b.mode = BOUND_WARN
+		}
+		switch b.mode {
+		case BOUND_WARN, BOUND_CLAMP, BOUND_ABORT:
+		default:
+			res = Failure(ErrParseSyntax+": @%s %s (%s)", META_BOUND, b.mode, name)
+			return
+		}
+		list = append(list, b)
+	}
+	return
+}
+
+// checkBounds checks (and enforces) the bounds of levels.
+func (mdl *Model) checkBounds() (res *Result) {
+	res = Success()
+	for _, b := range mdl.run.bounds {
+		val := float64(mdl.Current[b.name])
+		limit := val
+		if val < b.min {
+			limit = b.min
+		} else if val > b.max {
+			limit = b.max
+		} else {
+			continue
+		}
+		time := mdl.Current["TIME"]
+		if b.mode == BOUND_ABORT {
+			return Failure(ErrModelBounds+": %s=%g at TIME %g", b.name, val, time)
+		}
+		if b.count++; b.count == 1 {
+			Logf(LOG_WARN, LOG_RUN, "%s out of bounds (%g) at TIME %g\n", b.name, val, time)
+		}
+		if b.mode == BOUND_CLAMP {
+			mdl.Current[b.name] = Variable(limit)
+		}
+	}
+	return
+}
+
+// reportBounds logs the number of bound violations in a run.
+func (mdl *Model) reportBounds() {
+	for _, b := range mdl.run.bounds {
+		if b.count > 1 {
+			Logf(LOG_WARN, LOG_RUN, "%s out of bounds in %d epochs\n", b.name, b.count)
+		}
+	}
+}
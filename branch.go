@@ -0,0 +1,263 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+//======================================================================
+// BRANCHING -- A named snapshot of a model run can be taken at a given
+// time; new runs can later be branched from the snapshot with altered
+// constants ("what if we intervene at year 30"):
+//
+//     SNAPSHOT Y30=30
+//     RUN      BASE
+//     BRANCH   POLICY=Y30/TAX=0.2,SUBSID=1
+//
+// If the plotter generates GNUplot scripts, the divergence between the
+// base run and the branched run is plotted from the branch point.
+//======================================================================
+
+// branchPoint is a named snapshot of a model run
+type branchPoint struct {
+	*snapshot
+	runID string   // identifier of run
+	all   *EqnList // all (sorted) model equations
+	eqns  *EqnList // run-time equations
+	ds    *Dataset // recorded results up to snapshot
+	dt    Variable // time step
+	start Variable // start time of run
+}
+
+// Snapshot takes a named snapshot of the running model. New runs can be
+// branched from it later.
+func (mdl *Model) Snapshot(name string) (res *Result) {
+	if mdl.run == nil {
+		return Failure(ErrModelNotRunning)
+	}
+	mdl.branches[name] = &branchPoint{
+		snapshot: mdl.snapshot(),
+		runID:    mdl.RunID,
+		all:      mdl.Eqns,
+		eqns:     mdl.run.eqns,
+		ds:       mdl.run.ds.Clone(),
+		dt:       mdl.run.dt,
+		start:    mdl.run.start,
+	}
+	return Success()
+}
+
+// SnapshotAt requests a named snapshot at given time in the next run.
+func (mdl *Model) SnapshotAt(name string, t float64) {
+	mdl.snapAt[name] = t
+}
+
+// take requested snapshots in a run (called before each step).
+func (mdl *Model) takeSnapshots() (res *Result) {
+	res = Success()
+	for name, t := range mdl.snapAt {
+		if compare(float64(mdl.Current["TIME"]), t) >= 0 {
+			if res = mdl.Snapshot(name); !res.Ok {
+				break
+			}
+			delete(mdl.snapAt, name)
+		}
+	}
+	return
+}
+
+// Branch a new run with given identifier from a named snapshot. The
+// constants in 'changes' are set to new values before the branched run
+// continues. No print or plot output is generated for the run; the
+// results are available as a dataset in Model.Results.
+func (mdl *Model) Branch(name, runID string, changes State) (res *Result) {
+	bp, ok := mdl.branches[name]
+	if !ok {
+		return Failure(ErrModelNoSnapshot+": %s", name)
+	}
+	if mdl.run != nil {
+		return Failure(ErrModelRunning)
+	}
+	// save model settings
+	current, last, auto, id := mdl.Current, mdl.Last, mdl.auto, mdl.RunID
+	prt, plt, game, pacer := mdl.Print, mdl.Plot, mdl.Game, mdl.Pacer
+	defer func() {
+		mdl.Current, mdl.Last, mdl.auto, mdl.RunID = current, last, auto, id
+		mdl.Print, mdl.Plot, mdl.Game, mdl.Pacer = prt, plt, game, pacer
+		mdl.run = nil
+	}()
+	mdl.Print, mdl.Plot = NewPrinter("", mdl), NewPlotter("", mdl)
+	mdl.Game, mdl.Pacer = nil, nil
+
+	// restore state and set altered constants
+	mdl.Current, mdl.Last = bp.current.Clone(), bp.last.Clone()
+	mdl.auto = bp.auto.clone()
+	for c, val := range changes {
+		if eqn := bp.all.Find(c); eqn == nil || eqn.Mode != "C" {
+			return Failure(ErrModelEqnBadMode+": %s is not a constant", c)
+		}
+		mdl.Current[c] = val
+	}
+	// re-compute constants derived from altered constants
+	for _, eqn := range bp.all.List() {
+		if _, ok := changes[eqn.Target.Name]; ok || eqn.Mode != "C" || len(eqn.Dependencies) == 0 {
+			continue
+		}
+		if _, res = eqn.Eval(mdl); !res.Ok {
+			return
+		}
+	}
+	// run branch
+	Logf(LOG_INFO, LOG_RUN, "   Branching system model '%s' from '%s'...", runID, name)
+	mdl.RunID = runID
+	ds := bp.ds.Clone()
+	ds.RunID = runID
+	if p := ds.Provenance; p != nil {
+		// provenance of branched run (with altered constants)
+		bpp := *p
+		bpp.Constants = make(map[string]float64)
+		for name, val := range p.Constants {
+			bpp.Constants[name] = val
+		}
+		for name, val := range changes {
+			bpp.Constants[name] = float64(val)
+		}
+		ds.Provenance = &bpp
+	}
+	mdl.run = &runState{
+		eqns:  bp.eqns,
+		ds:    ds,
+		epoch: bp.epoch,
+		t:     bp.t,
+		start: bp.start,
+		dt:    bp.dt,
+		hist:  newHistory(0),
+		suppl: supplements(bp.eqns),
+	}
+	if mdl.Repro {
+		// continue with compensations of the snapshot
+		if mdl.run.comp = make(State); bp.comp != nil {
+			mdl.run.comp = bp.comp.Clone()
+		}
+	}
+	if res = mdl.checkConserved(bp.all); !res.Ok {
+		mdl.run = nil
+		return
+	}
+	if mdl.run.bounds, res = mdl.bounds(bp.all); !res.Ok {
+		mdl.run = nil
+		return
+	}
+	for !mdl.Done() {
+		if res = mdl.step(); !res.Ok {
+			break
+		}
+	}
+	mdl.Finish()
+
+	// plot divergence
+	if res.Ok && plt != nil {
+		names := make([]string, 0, len(plt.vars))
+		for name := range plt.vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, r := range plt.renderers {
+			gnu, ok := r.(*gnuRenderer)
+			if !ok || gnu.file == nil {
+				continue
+			}
+			if res = WriteDivergence(gnu.file, gnu.base, mdl.Results[bp.runID], ds, float64(bp.t), names); !res.Ok {
+				break
+			}
+		}
+	}
+	return
+}
+
+// addBranch handles a BRANCH statement: "RUNID=SNAPSHOT/C1=V1,..."
+func (mdl *Model) addBranch(stmt string) (res *Result) {
+	grps := strings.Split(stmt, "/")
+	def := strings.Split(grps[0], "=")
+	if len(def) != 2 || len(grps) > 2 {
+		return Failure(ErrParseSyntax+": %s", stmt)
+	}
+	changes := make(State)
+	if len(grps) == 2 {
+		for _, c := range strings.Split(grps[1], ",") {
+			x := strings.Split(c, "=")
+			if len(x) != 2 {
+				return Failure(ErrParseSyntax+": %s", c)
+			}
+			val, ok := parseNumber(x[1])
+			if !ok {
+				return Failure(ErrParseNotANumber+": %s", x[1])
+			}
+			changes[x[0]] = Variable(val)
+		}
+	}
+	return mdl.Branch(def[1], def[0], changes)
+}
+
+// WriteDivergence writes a GNUplot script that plots the named variables
+// of a base run and a branched run from the branch point (time t0). Each
+// variable is plotted into a separate SVG file.
+func WriteDivergence(w io.Writer, base string, run1, run2 *Dataset, t0 float64, names []string) (res *Result) {
+	res = Success()
+	if run1 == nil || run2 == nil {
+		return Failure(ErrModelRunMismatch)
+	}
+	for _, ds := range []*Dataset{run1, run2} {
+		if ds.Provenance != nil {
+			fmt.Fprintf(w, "# run '%s':\n", ds.RunID)
+			for _, line := range ds.Provenance.Lines() {
+				fmt.Fprintf(w, "#    %s\n", line)
+			}
+		}
+	}
+	time := run1.Vars["TIME"]
+	for _, name := range names {
+		v1, v2 := run1.Vars[name], run2.Vars[name]
+		if v1 == nil || v2 == nil {
+			return Failure(ErrModelNoVariable+": %s", name)
+		}
+		fmt.Fprintf(w, "\n$div_%s << EOD\n", name)
+		for i, t := range time {
+			if t < t0 || i >= len(v1) || i >= len(v2) {
+				continue
+			}
+			fmt.Fprintf(w, "%f %f %f\n", t, v1[i], v2[i])
+		}
+		fmt.Fprintln(w, "EOD")
+		fmt.Fprintln(w, "reset")
+		fmt.Fprintln(w, "set key outside")
+		fmt.Fprintf(w, "set title \"%s: %s vs. %s\"\n", name, run1.RunID, run2.RunID)
+		fmt.Fprintln(w, "set term svg size 700,500")
+		fmt.Fprintf(w, "set output \"%s_%s_%s.svg\"\n", base, run2.RunID, name)
+		fmt.Fprintf(w, "plot $div_%s using 1:2 with lines title \"%s\", ", name, run1.RunID)
+		fmt.Fprintf(w, "$div_%s using 1:3 with lines title \"%s\"\n", name, run2.RunID)
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"strconv"
+	"strings"
+)
+
+//======================================================================
+// Programmatic model construction
+//
+// Go programs can build models without generating DYNAMO source text.
+// The builder methods assemble statements that are added to the model
+// the same way as parsed statements, so the same validation and sorting
+// applies. Expressions use DYNAMO notation (like "INV.J+DT*IN.JK").
+//======================================================================
+
+// add an equation statement with given mode
+func (mdl *Model) addEqn(mode, target, expr string) *Result {
+	expr = strings.ReplaceAll(strings.ToUpper(expr), " ", "")
+	return mdl.AddStatement(&Line{
+		Mode: mode,
+		Stmt: strings.ToUpper(target) + "=" + expr,
+	})
+}
+
+// AddLevel adds a level equation "L NAME.K=expr" to the model. If 'init'
+// is not empty, an initializer equation "N NAME=init" is added too.
+func (mdl *Model) AddLevel(name, expr, init string) (res *Result) {
+	if res = mdl.addEqn("L", name+".K", expr); res.Ok && len(init) > 0 {
+		res = mdl.AddInit(name, init)
+	}
+	return
+}
+
+// AddRate adds a rate equation "R NAME.KL=expr" to the model.
+func (mdl *Model) AddRate(name, expr string) *Result {
+	return mdl.addEqn("R", name+".KL", expr)
+}
+
+// AddAux adds an auxiliary equation "A NAME.K=expr" to the model.
+func (mdl *Model) AddAux(name, expr string) *Result {
+	return mdl.addEqn("A", name+".K", expr)
+}
+
+// AddSupplement adds a supplementary equation "S NAME.K=expr" to the model.
+func (mdl *Model) AddSupplement(name, expr string) *Result {
+	return mdl.addEqn("S", name+".K", expr)
+}
+
+// AddInit adds an initializer equation "N NAME=expr" to the model.
+func (mdl *Model) AddInit(name, expr string) *Result {
+	return mdl.addEqn("N", name, expr)
+}
+
+// AddConstant adds a constant "C NAME=val" to the model.
+func (mdl *Model) AddConstant(name string, val float64) *Result {
+	return mdl.addEqn("C", name, strconv.FormatFloat(val, 'g', -1, 64))
+}
+
+// AddTable adds a table "T NAME=v1/v2/..." to the model.
+func (mdl *Model) AddTable(name string, vals []float64) *Result {
+	list := make([]string, len(vals))
+	for i, v := range vals {
+		list[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return mdl.AddStatement(&Line{
+		Mode: "T",
+		Stmt: strings.ToUpper(name) + "=" + strings.Join(list, "/"),
+	})
+}
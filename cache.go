@@ -0,0 +1,141 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"go/ast"
+)
+
+//======================================================================
+// CACHE -- Auxiliaries that are pure functions of their inputs are not
+// re-evaluated in a step if the inputs did not change since their last
+// evaluation: the value of the auxiliary is kept. The inputs are the
+// variables an equation depends on (from the dependency graph) and the
+// phases of STEP functions (before or after the step time), so e.g. an
+// auxiliary depending on constants and a STEP is only evaluated again
+// when the step time is reached. Equations that call functions with
+// internal state, random numbers or other dependencies on TIME are always
+// evaluated.
+//======================================================================
+
+// pureFcns are functions that only depend on their arguments (STEP is
+// handled separately).
+var pureFcns = map[string]bool{
+	"SQRT": true, "SIN": true, "COS": true, "EXP": true, "LOG": true,
+	"POW": true, "MAX": true, "MIN": true, "SUM": true, "MEAN": true,
+	"CLIP": true, "SWITCH": true, "IFTHENELSE": true, "STRTIM": true,
+	"TABLE": true, "TABHL": true, "TABXT": true, "TABPL": true, "TABLE2": true,
+}
+
+// cacheEntry holds the inputs of an auxiliary at its last evaluation.
+type cacheEntry struct {
+	pure   bool       // equation is a pure function of its inputs
+	inputs []*Name    // variables used in the formula (without tables)
+	steps  []ast.Expr // step times of STEP functions
+	vals   []Variable // values of inputs and phases of STEP functions
+	valid  bool       // values are valid (all inputs were known)
+}
+
+// caching returns true if unchanged auxiliaries are skipped in a run: not
+// in debugged or profiled runs (that record all evaluations) or with a run
+// policy (that records numeric anomalies during evaluation).
+func (mdl *Model) caching() bool {
+	return !mdl.NoCache && mdl.Dbg == nil && mdl.Profile == nil && !mdl.Policy.active()
+}
+
+// newCacheEntry analyzes an equation for caching.
+func (mdl *Model) newCacheEntry(eqn *Equation) *cacheEntry {
+	ce := &cacheEntry{pure: eqn.Mode == "A"}
+	ast.Inspect(eqn.Formula, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && ce.pure {
+			fcn, _ := call.Fun.(*ast.Ident)
+			switch {
+			case fcn == nil:
+				ce.pure = false
+			case fcn.Name == "STEP" && len(call.Args) == 2:
+				ce.steps = append(ce.steps, call.Args[1])
+			case !pureFcns[fcn.Name]:
+				ce.pure = false
+			}
+		}
+		return ce.pure
+	})
+	for _, list := range [][]*Name{eqn.Dependencies, eqn.References} {
+		for _, name := range list {
+			if _, ok := mdl.Tables[name.Name]; !ok {
+				ce.inputs = append(ce.inputs, name)
+			}
+		}
+	}
+	ce.vals = make([]Variable, len(ce.inputs)+len(ce.steps))
+	return ce
+}
+
+// cached returns true if the value of an equation in the current state is
+// unchanged since its last evaluation. Otherwise the inputs are recorded
+// for the following evaluation.
+func (mdl *Model) cached(eqn *Equation) bool {
+	run := mdl.run
+	if run == nil || run.cache == nil {
+		return false
+	}
+	ce, ok := run.cache[eqn]
+	if !ok {
+		ce = mdl.newCacheEntry(eqn)
+		run.cache[eqn] = ce
+	}
+	if !ce.pure {
+		return false
+	}
+	// compare inputs with recorded values
+	same, valid := ce.valid, true
+	set := func(i int, val Variable) {
+		if val != ce.vals[i] {
+			ce.vals[i], same = val, false
+		}
+	}
+	for i, name := range ce.inputs {
+		state := mdl.Current
+		if name.Stage == NAME_STAGE_OLD {
+			state = mdl.Last
+		}
+		val, ok := state[name.Name]
+		valid = valid && ok
+		set(i, val)
+	}
+	time := mdl.Current["TIME"]
+	for i, expr := range ce.steps {
+		missing := make(map[string]*Name)
+		t, res := eval(expr, mdl, missing)
+		valid = valid && res.Ok && len(missing) == 0
+		phase := Variable(0)
+		if time.Compare(t) >= 0 {
+			phase = 1
+		}
+		set(len(ce.inputs)+i, phase)
+	}
+	if same && valid {
+		run.hits++
+		return true
+	}
+	ce.valid = valid
+	return false
+}
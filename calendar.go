@@ -0,0 +1,147 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time units
+const (
+	TUNIT_YEAR    = "YEAR"
+	TUNIT_QUARTER = "QUARTER"
+	TUNIT_MONTH   = "MONTH"
+	TUNIT_WEEK    = "WEEK"
+	TUNIT_DAY     = "DAY"
+	TUNIT_HOUR    = "HOUR"
+)
+
+// number of months per time unit (or 0 for fixed durations)
+var unitMonths = map[string]int{
+	TUNIT_YEAR:    12,
+	TUNIT_QUARTER: 3,
+	TUNIT_MONTH:   1,
+	TUNIT_WEEK:    0,
+	TUNIT_DAY:     0,
+	TUNIT_HOUR:    0,
+}
+
+// duration of time units (with fixed durations)
+var unitDuration = map[string]time.Duration{
+	TUNIT_WEEK: 7 * 24 * time.Hour,
+	TUNIT_DAY:  24 * time.Hour,
+	TUNIT_HOUR: time.Hour,
+}
+
+//----------------------------------------------------------------------
+// CALENDAR -- The time unit and start date of a model ('SPEC TUNIT=YEAR,
+// START=1900') is used to label TIME values in print and plot output
+// with calendar dates.
+//----------------------------------------------------------------------
+
+// Calendar maps TIME values to calendar dates.
+type Calendar struct {
+	Unit   string    // time unit (TUNIT_???)
+	Start  time.Time // date at start of run (zero if not defined)
+	Origin float64   // TIME at start of run
+}
+
+// SetUnit sets the time unit of the calendar.
+func (c *Calendar) SetUnit(unit string) *Result {
+	if _, ok := unitMonths[unit]; !ok {
+		return Failure(ErrParseSyntax+": unknown time unit %s", unit)
+	}
+	c.Unit = unit
+	return Success()
+}
+
+// SetStart sets the date at the start of a run: it is either a year (like '1900') or
+// a date in ISO notation ('2020-03-01').
+func (c *Calendar) SetStart(start string) *Result {
+	if strings.Contains(start, "-") {
+		t, err := time.Parse("2006-01-02", start)
+		if err != nil {
+			return Failure(ErrParseSyntax+": %s", err.Error())
+		}
+		c.Start = t
+		return Success()
+	}
+	year, err := strconv.Atoi(start)
+	if err != nil {
+		return Failure(ErrParseNotANumber+": %s", start)
+	}
+	c.Start = time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	return Success()
+}
+
+// Valid returns true if TIME values can be mapped to dates.
+func (c *Calendar) Valid() bool {
+	return c != nil && len(c.Unit) > 0 && !c.Start.IsZero()
+}
+
+// Date returns the calendar date for a TIME value.
+func (c *Calendar) Date(t float64) time.Time {
+	t -= c.Origin
+	if months := unitMonths[c.Unit]; months > 0 {
+		// calendar months (fractions of months are 1/12 of a year)
+		m := t * float64(months)
+		whole := math.Floor(m + 1e-9)
+		d := c.Start.AddDate(0, int(whole), 0)
+		frac := time.Duration((m - whole) * 365.2425 * 24 * float64(time.Hour) / 12)
+		return d.Add(frac)
+	}
+	return c.Start.Add(time.Duration(t * float64(unitDuration[c.Unit])))
+}
+
+// Label returns a (short) calendar label for a TIME value; labels are
+// at most nine characters long. If the calendar is not valid, the TIME
+// value is returned as a string.
+func (c *Calendar) Label(t float64) string {
+	if !c.Valid() {
+		return strconv.FormatFloat(t, 'f', 3, 64)
+	}
+	d := c.Date(t)
+	switch c.Unit {
+	case TUNIT_YEAR:
+		if d.Month() == time.January && d.Day() == 1 {
+			return d.Format("2006")
+		}
+		return d.Format("2006-01")
+	case TUNIT_QUARTER:
+		return d.Format("2006") + "-Q" + strconv.Itoa((int(d.Month())+2)/3)
+	case TUNIT_MONTH:
+		return d.Format("2006-01")
+	case TUNIT_HOUR:
+		return d.Format("Jan02 15h")
+	}
+	return d.Format("02Jan2006")
+}
+
+// DateString returns the calendar date for a TIME value in ISO notation.
+func (c *Calendar) DateString(t float64) string {
+	if c.Unit == TUNIT_HOUR {
+		return c.Date(t).Format("2006-01-02 15:04")
+	}
+	return c.Date(t).Format("2006-01-02")
+}
@@ -0,0 +1,73 @@
+package main
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bfix/dynamo"
+)
+
+// main entry point: generate random models and check them; failing models
+// are written to the output directory.
+func main() {
+	var (
+		count int
+		size  int
+		seed  int64
+		out   string
+	)
+	flag.IntVar(&count, "n", 100, "Number of generated models")
+	flag.IntVar(&size, "size", 10, "Maximum number of levels, auxiliaries and constants")
+	flag.Int64Var(&seed, "seed", 0, "Seed for the model generator (default: 0 = random seed)")
+	flag.StringVar(&out, "o", ".", "Output directory for failing models")
+	flag.Parse()
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	dynamo.SetLogLevel(dynamo.LOG_ERROR)
+	fmt.Printf("Fuzzing %d models (seed %d)...\n", count, seed)
+
+	rng := rand.New(rand.NewSource(seed))
+	failed := 0
+	for i := 1; i <= count; i++ {
+		fm := dynamo.NewFuzzModel(rng, size)
+		res := fm.Check(int64(i))
+		if res.Ok {
+			continue
+		}
+		failed++
+		fname := filepath.Join(out, fmt.Sprintf("fuzz-%d-%d.dynamo", seed, i))
+		fmt.Printf("Model #%d: %s -- written to '%s'\n", i, res.Err.Error(), fname)
+		if err := os.WriteFile(fname, []byte(fm.Source(fm.Eqns)), 0644); err != nil {
+			dynamo.Fatal(err.Error())
+		}
+	}
+	fmt.Printf("%d of %d models failed.\n", failed, count)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,122 @@
+#----------------------------------------------------------------------
+# This file is part of Dynamo.
+# Copyright (C) 2020-2021 Bernd Fix
+#
+# Dynamo is free software: you can redistribute it and/or modify it
+# under the terms of the GNU Affero General Public License as published
+# by the Free Software Foundation, either version 3 of the License,
+# or (at your option) any later version.
+#
+# Dynamo is distributed in the hope that it will be useful, but
+# WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+# Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <http://www.gnu.org/licenses/>.
+#
+# SPDX-License-Identifier: AGPL3.0-or-later
+#----------------------------------------------------------------------
+
+"""Python bindings for the DYNAMO interpreter (ctypes wrapper for the
+shared library built from 'cmd/dynamo-lib'):
+
+    import dynamo
+    mdl = dynamo.Model(open("flu.dynamo").read())
+    mdl.set_constant("CONTACT", 0.4)
+    mdl.run("HIGH")
+    sick = mdl.series("HIGH", "SICK")
+"""
+
+import ctypes
+import ctypes.util
+import os
+
+_lib = None
+
+
+def load_library(path=None):
+    """Load the shared library (default: 'libdynamo.so' next to this file,
+    the DYNAMO_LIB environment variable or the library search path)."""
+    global _lib
+    if path is None:
+        path = os.environ.get("DYNAMO_LIB")
+    if path is None:
+        local = os.path.join(os.path.dirname(os.path.abspath(__file__)), "libdynamo.so")
+        path = local if os.path.exists(local) else ctypes.util.find_library("dynamo")
+    lib = ctypes.CDLL(path)
+    lib.dynamo_load.argtypes = [ctypes.c_char_p]
+    lib.dynamo_load.restype = ctypes.c_int
+    lib.dynamo_set_constant.argtypes = [ctypes.c_int, ctypes.c_char_p, ctypes.c_double]
+    lib.dynamo_set_constant.restype = ctypes.c_int
+    lib.dynamo_set_seed.argtypes = [ctypes.c_int, ctypes.c_longlong]
+    lib.dynamo_set_seed.restype = ctypes.c_int
+    lib.dynamo_run.argtypes = [ctypes.c_int, ctypes.c_char_p]
+    lib.dynamo_run.restype = ctypes.c_int
+    lib.dynamo_series.argtypes = [ctypes.c_int, ctypes.c_char_p, ctypes.c_char_p,
+                                  ctypes.POINTER(ctypes.c_double), ctypes.c_int]
+    lib.dynamo_series.restype = ctypes.c_int
+    lib.dynamo_error.argtypes = [ctypes.c_int]
+    lib.dynamo_error.restype = ctypes.c_char_p
+    lib.dynamo_free.argtypes = [ctypes.c_int]
+    lib.dynamo_free.restype = None
+    lib.dynamo_quiet.argtypes = [ctypes.c_int]
+    lib.dynamo_quiet.restype = None
+    _lib = lib
+    return lib
+
+
+class DynamoError(Exception):
+    """Error reported by the interpreter."""
+
+
+class Model:
+    """A DYNAMO model loaded from source."""
+
+    def __init__(self, source, quiet=True):
+        lib = _lib or load_library()
+        lib.dynamo_quiet(1 if quiet else 0)
+        self._lib = lib
+        self._h = lib.dynamo_load(source.encode())
+        if self._h < 0:
+            raise DynamoError(self._error(-1))
+
+    def _error(self, h=None):
+        msg = self._lib.dynamo_error(self._h if h is None else h)
+        return msg.decode() if msg else "unknown error"
+
+    def _check(self, rc):
+        if rc < 0:
+            raise DynamoError(self._error())
+        return rc
+
+    def set_constant(self, name, value):
+        """Set a constant for all following runs."""
+        self._check(self._lib.dynamo_set_constant(self._h, name.encode(), float(value)))
+
+    def set_seed(self, seed):
+        """Set the seed for random numbers (0 for a random seed)."""
+        self._check(self._lib.dynamo_set_seed(self._h, int(seed)))
+
+    def run(self, run_id="BASE"):
+        """Run the model; runs defined in the source keep their identifiers.
+        Returns the number of runs."""
+        return self._check(self._lib.dynamo_run(self._h, run_id.encode()))
+
+    def series(self, run_id, name):
+        """Return the values of a variable in a run (as a list)."""
+        r, n = run_id.encode(), name.encode()
+        size = self._check(self._lib.dynamo_series(self._h, r, n, None, 0))
+        buf = (ctypes.c_double * size)()
+        self._check(self._lib.dynamo_series(self._h, r, n, buf, size))
+        return list(buf)
+
+    def close(self):
+        """Release the model."""
+        if self._h >= 0:
+            self._lib.dynamo_free(self._h)
+            self._h = -1
+
+    def __del__(self):
+        if self._lib is not None:
+            self.close()
@@ -0,0 +1,242 @@
+package main
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+//======================================================================
+// C API for the DYNAMO interpreter: the package is built as a shared
+// library ('go build -buildmode=c-shared -o libdynamo.so') and used from
+// other languages (like Python with 'dynamo.py'). Models are referenced
+// by handles; each run parses the model source again with the constants
+// set for the handle:
+//
+//     h = dynamo_load(source)
+//     dynamo_set_constant(h, "CONTACT", 0.4)
+//     dynamo_run(h, "HIGH")
+//     n = dynamo_series(h, "HIGH", "SICK", buf, size)
+//     dynamo_free(h)
+//
+// Functions return a negative value on failure; the error message is
+// returned by 'dynamo_error(h)'.
+//======================================================================
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/bfix/dynamo"
+)
+
+// handle of a loaded model
+type handle struct {
+	src       string                     // model source
+	overrides dynamo.State               // constants set for runs
+	seed      int64                      // seed for random numbers (0 = random)
+	results   map[string]*dynamo.Dataset // results of runs
+	err       *C.char                    // last error message (or nil)
+}
+
+// fail records an error message for a handle.
+func (h *handle) fail(msg string) C.int {
+	if h.err != nil {
+		C.free(unsafe.Pointer(h.err))
+	}
+	h.err = C.CString(msg)
+	return -1
+}
+
+// loaded models
+var (
+	lock    sync.Mutex
+	handles = make(map[C.int]*handle)
+	lastID  C.int
+	loadErr *C.char // error of last failed load
+)
+
+// get a handle (or nil if not loaded).
+func get(h C.int) *handle {
+	lock.Lock()
+	defer lock.Unlock()
+	return handles[h]
+}
+
+// dynamo_load parses a model source (without running it) and returns a
+// handle for the model (or -1 on failure).
+//
+//export dynamo_load
+func dynamo_load(src *C.char) C.int {
+	source := C.GoString(src)
+	mdl := dynamo.NewModel("", "")
+	mdl.NoRun = true
+	res := mdl.Parse(strings.NewReader(source))
+	lock.Lock()
+	defer lock.Unlock()
+	if loadErr != nil {
+		C.free(unsafe.Pointer(loadErr))
+		loadErr = nil
+	}
+	if !res.Ok {
+		loadErr = C.CString(res.Error())
+		return -1
+	}
+	lastID++
+	handles[lastID] = &handle{
+		src:       source,
+		overrides: make(dynamo.State),
+		results:   make(map[string]*dynamo.Dataset),
+	}
+	return lastID
+}
+
+// dynamo_set_constant sets a constant for all following runs of a model.
+//
+//export dynamo_set_constant
+func dynamo_set_constant(h C.int, name *C.char, val C.double) C.int {
+	m := get(h)
+	if m == nil {
+		return -1
+	}
+	m.overrides[strings.ToUpper(C.GoString(name))] = dynamo.Variable(val)
+	return 0
+}
+
+// dynamo_set_seed sets the seed for random numbers (0 for a random seed).
+//
+//export dynamo_set_seed
+func dynamo_set_seed(h C.int, seed C.longlong) C.int {
+	m := get(h)
+	if m == nil {
+		return -1
+	}
+	m.seed = int64(seed)
+	return 0
+}
+
+// dynamo_run runs a model; the results are stored with the given run
+// identifier. Runs defined in the model source (RUN statements) are
+// stored with their own identifiers. Returns the number of runs.
+//
+//export dynamo_run
+func dynamo_run(h C.int, run *C.char) C.int {
+	m := get(h)
+	if m == nil {
+		return -1
+	}
+	mdl := dynamo.NewModel("", "")
+	mdl.Seed = m.seed
+	mdl.Overrides = make(dynamo.State)
+	for name, val := range m.overrides {
+		mdl.Overrides[name] = val
+	}
+	res := mdl.Parse(strings.NewReader(m.src))
+	if res.Ok && len(mdl.Results) == 0 {
+		res = mdl.Parse(strings.NewReader("RUN " + C.GoString(run)))
+	}
+	if r := mdl.Quit(); res.Ok {
+		res = r
+	}
+	if !res.Ok {
+		return m.fail(res.Error())
+	}
+	for id, ds := range mdl.Results {
+		m.results[id] = ds
+	}
+	return C.int(len(mdl.Results))
+}
+
+// dynamo_series copies the values of a variable in a run into a buffer
+// and returns the number of values in the run (which can be larger than
+// the size of the buffer; a NULL buffer only returns the number).
+//
+//export dynamo_series
+func dynamo_series(h C.int, run, name *C.char, buf *C.double, size C.int) C.int {
+	m := get(h)
+	if m == nil {
+		return -1
+	}
+	runID, label := C.GoString(run), strings.ToUpper(C.GoString(name))
+	ds, ok := m.results[runID]
+	if !ok {
+		return m.fail(dynamo.ErrModelNotAvailable + ": " + runID)
+	}
+	values, ok := ds.Vars[label]
+	if !ok {
+		return m.fail(dynamo.ErrModelNoVariable + ": " + label)
+	}
+	if buf != nil && size > 0 {
+		n := len(values)
+		if n > int(size) {
+			n = int(size)
+		}
+		out := (*[1 << 28]C.double)(unsafe.Pointer(buf))[:n:n]
+		for i := 0; i < n; i++ {
+			out[i] = C.double(values[i])
+		}
+	}
+	return C.int(len(values))
+}
+
+// dynamo_error returns the last error message of a model (or of the last
+// failed load for an unknown handle); the message is valid until the next
+// failing call.
+//
+//export dynamo_error
+func dynamo_error(h C.int) *C.char {
+	if m := get(h); m != nil {
+		return m.err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	return loadErr
+}
+
+// dynamo_free releases a model.
+//
+//export dynamo_free
+func dynamo_free(h C.int) {
+	lock.Lock()
+	defer lock.Unlock()
+	if m, ok := handles[h]; ok {
+		if m.err != nil {
+			C.free(unsafe.Pointer(m.err))
+		}
+		delete(handles, h)
+	}
+}
+
+// dynamo_quiet disables (or enables) log messages of the interpreter.
+//
+//export dynamo_quiet
+func dynamo_quiet(flag C.int) {
+	if flag != 0 {
+		dynamo.SetLogLevel(dynamo.LOG_ERROR)
+	} else {
+		dynamo.SetLogLevel(dynamo.LOG_INFO)
+	}
+}
+
+// main is required for a c-shared build.
+func main() {}
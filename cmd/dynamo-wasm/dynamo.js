@@ -0,0 +1,74 @@
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+//======================================================================
+// JavaScript wrapper for the DYNAMO interpreter (WebAssembly):
+//
+//     <script src="wasm_exec.js"></script>
+//     <script src="dynamo.js"></script>
+//     <script>
+//         DYNAMO.load("dynamo.wasm").then(dyn => {
+//             const out = dyn.run(source, { print: "model.prt" });
+//             console.log(out.files["model.prt"]);
+//         });
+//     </script>
+//
+// 'wasm_exec.js' is part of the Go distribution ('lib/wasm' or
+// 'misc/wasm' in $GOROOT) and must match the Go version used to build
+// 'dynamo.wasm'.
+//======================================================================
+
+const DYNAMO = {
+    // load the interpreter from an URL and return a handle for it.
+    load: async function (url) {
+        const go = new Go();
+        const resp = await fetch(url);
+        let result;
+        if (WebAssembly.instantiateStreaming) {
+            result = await WebAssembly.instantiateStreaming(resp, go.importObject);
+        } else {
+            result = await WebAssembly.instantiate(await resp.arrayBuffer(), go.importObject);
+        }
+        go.run(result.instance);
+        return DYNAMO.wrap(globalThis.dynamo);
+    },
+
+    // wrap the 'dynamo' object registered by the interpreter.
+    wrap: function (dyn) {
+        return {
+            version: dyn.version,
+            // run a model: options are 'print' and 'plot' (file names
+            // selecting the output format) and 'seed' (random numbers).
+            // Returns { ok, error, line, log, files, results }.
+            run: function (source, options) {
+                return dyn.run(source, options || {});
+            },
+            // series returns the values of a variable in a run.
+            series: function (out, run, name) {
+                const vars = out.results[run];
+                return vars ? vars[name] : undefined;
+            },
+        };
+    },
+};
+
+if (typeof module !== "undefined") {
+    module.exports = DYNAMO;
+}
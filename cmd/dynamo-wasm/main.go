@@ -0,0 +1,132 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"syscall/js"
+
+	"github.com/bfix/dynamo"
+)
+
+//======================================================================
+// DYNAMO in the browser: the interpreter is compiled to WebAssembly
+// (GOOS=js GOARCH=wasm) and registers a global 'dynamo' object with a
+// 'run' function. Print and plot files are kept in memory and returned
+// with the log and the results of all runs (see 'dynamo.js').
+//======================================================================
+
+// memFile is an in-memory print or plot file.
+type memFile struct {
+	bytes.Buffer
+}
+
+// Close an in-memory file (no-op).
+func (f *memFile) Close() error {
+	return nil
+}
+
+// main entry point: register the 'dynamo' object and wait for calls.
+func main() {
+	obj := js.Global().Get("Object").New()
+	obj.Set("version", dynamo.VERSION)
+	obj.Set("run", js.FuncOf(run))
+	js.Global().Set("dynamo", obj)
+	select {}
+}
+
+// run a model: dynamo.run(source, {print: "out.prt", plot: "out.plt",
+// seed: 1}). Returns an object with the outcome ('ok', 'error', 'line'),
+// the log ('log'), the output files ('files') and the results of all runs
+// ('results': run -> variable -> values).
+func run(this js.Value, args []js.Value) interface{} {
+	if len(args) == 0 {
+		return map[string]interface{}{"ok": false, "error": "missing model source"}
+	}
+	var printFile, plotFile string
+	var seed int64
+	if len(args) > 1 && args[1].Type() == js.TypeObject {
+		opts := args[1]
+		if v := opts.Get("print"); v.Type() == js.TypeString {
+			printFile = v.String()
+		}
+		if v := opts.Get("plot"); v.Type() == js.TypeString {
+			plotFile = v.String()
+		}
+		if v := opts.Get("seed"); v.Type() == js.TypeNumber {
+			seed = int64(v.Int())
+		}
+	}
+	// keep log and output files in memory
+	logBuf := new(bytes.Buffer)
+	log.SetOutput(logBuf)
+	log.SetFlags(0)
+	files := make(map[string]*memFile)
+	var names []string
+	dynamo.SetFileCreator(func(name string) (io.WriteCloser, error) {
+		f := new(memFile)
+		files[name] = f
+		names = append(names, name)
+		return f, nil
+	})
+	defer dynamo.SetFileCreator(nil)
+
+	// parse and run model
+	mdl := dynamo.NewModel(printFile, plotFile)
+	mdl.Seed = seed
+	res := mdl.Parse(strings.NewReader(args[0].String()))
+	if r := mdl.Quit(); res.Ok {
+		res = r
+	}
+	out := map[string]interface{}{
+		"ok":  res.Ok,
+		"log": logBuf.String(),
+	}
+	if !res.Ok {
+		out["error"] = res.Err.Error()
+		out["line"] = res.Line
+	}
+	list := make(map[string]interface{})
+	for _, name := range names {
+		list[name] = files[name].String()
+	}
+	out["files"] = list
+	results := make(map[string]interface{})
+	for id, ds := range mdl.Results {
+		vars := make(map[string]interface{})
+		for name, values := range ds.Vars {
+			vals := make([]interface{}, len(values))
+			for i, v := range values {
+				vals[i] = v
+			}
+			vars[name] = vals
+		}
+		results[id] = vars
+	}
+	out["results"] = results
+	return out
+}
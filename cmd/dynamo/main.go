@@ -22,49 +22,495 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/bfix/dynamo"
 )
 
-// main entry point: call DYNAMO interpreter with given arguments
+// main entry point: call DYNAMO interpreter with given arguments and exit
+// with the code for the outcome.
 func main() {
+	os.Exit(run())
+}
+
+// fileList is a list of file names from a repeatable option.
+type fileList []string
+
+// String returns a human-readable list of file names.
+func (l *fileList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set adds a file name to the list.
+func (l *fileList) Set(file string) error {
+	*l = append(*l, file)
+	return nil
+}
+
+// console is the standard output as print or plot file (not closed).
+type console struct {
+	io.Writer
+}
+
+// Close does nothing.
+func (console) Close() error {
+	return nil
+}
+
+// createFile creates a print or plot file; the name "-" is the console.
+func createFile(name string) (io.WriteCloser, error) {
+	if name == "-" {
+		return console{os.Stdout}, nil
+	}
+	return os.Create(name)
+}
+
+// writeMetrics writes the metrics of all model runs to file.
+func writeMetrics(fname string) {
+	f, err := os.Create(fname)
+	if err != nil {
+		dynamo.Logf(dynamo.LOG_ERROR, dynamo.LOG_GENERAL, "Metrics: %s", err.Error())
+		return
+	}
+	defer f.Close()
+	if res := dynamo.WriteMetrics(f); !res.Ok {
+		dynamo.Logf(dynamo.LOG_ERROR, dynamo.LOG_GENERAL, "Metrics: %s", res.Err.Error())
+	}
+}
+
+// run the DYNAMO interpreter and return the exit code.
+func run() int {
 	dynamo.Msg("---------------------------------------")
-	dynamo.Msg("DYNAMO interpreter v0.6    (2021-12-14)")
+	dynamo.Msg("DYNAMO interpreter v" + dynamo.VERSION + "    (2021-12-14)")
 	dynamo.Msg("Copyright (C) 2020,2021 Bernd Fix   >Y<")
 	dynamo.Msg("---------------------------------------")
 
 	var (
-		debugFile string
-		printFile string
-		plotFile  string
-		verbose   bool
+		debugFile  string
+		debugLevel int
+		printFiles fileList
+		plotFiles  fileList
+		defFile    string
+		verbose    string
+		strict     bool
+		relaxed    bool
+		encoding   string
+		docFile    string
+		listFile   string
+		secFile    string
+		fmuFile    string
+		pace       time.Duration
+		stream     bool
+		recFile    string
+		playFile   string
+		lint       bool
+		lintRules  string
+		xrefFile   string
+		repro      bool
+		bounds     string
+		policy     string
+		autoDT     bool
+		seed       int64
+		csvDelim   string
+		csvDec     string
+		csvQuote   bool
+		csvSci     bool
+		sparse     string
+		noScale    bool
+		pageLen    int
+		logLevel   string
+		profFile   string
+		metrics    string
+		rdsFile    string
+		parallel   int
+		internals  bool
+		noCache    bool
 	)
-	flag.StringVar(&debugFile, "d", "", "Debug file name (default: none)")
-	flag.StringVar(&printFile, "p", "", "Printer file name (default: none)")
-	flag.StringVar(&plotFile, "g", "", "Plotter file name (default: none)")
-	flag.BoolVar(&verbose, "v", false, "More log messages (default: false)")
+	flag.StringVar(&debugFile, "d", "", "Debug file name ('-' for console; default: none)")
+	flag.IntVar(&debugLevel, "debug-level", dynamo.DBG_TRACE, "Debug level (1=model, 2=trace)")
+	flag.Var(&printFiles, "p", "Printer file name (repeatable; default: none)")
+	flag.Var(&plotFiles, "g", "Plotter file name (repeatable; default: none)")
+	flag.StringVar(&defFile, "o", "", "Default output (CSV) if model has no PRINT/PLOT (default: none)")
+	flag.StringVar(&verbose, "v", "", "Verbose messages for categories (PARSE,MODEL,RUN,OUTPUT or ALL)")
+	flag.BoolVar(&strict, "strict", false, "Apply strict DYNAMO language rules (default: false)")
+	flag.BoolVar(&strict, "s", false, "Short for -strict")
+	flag.BoolVar(&relaxed, "r", false, "Accept relaxed (modern) syntax (default: false)")
+	flag.StringVar(&encoding, "encoding", "", "Source encoding (UTF-8, LATIN1, EBCDIC; default: auto)")
+	flag.StringVar(&docFile, "doc", "", "Glossary file name (default: none)")
+	flag.StringVar(&listFile, "listing", "", "Documentor listing file name ('.html' for HTML; default: none)")
+	flag.StringVar(&secFile, "sectors", "", "Sector graph file name (default: none)")
+	flag.StringVar(&fmuFile, "fmu", "", "Export model as FMU for co-simulation (default: none)")
+	flag.DurationVar(&pace, "pace", 0, "Wall-clock time per DT (e.g. 100ms; default: no pacing)")
+	flag.BoolVar(&stream, "stream", false, "Stream values to console in paced runs (default: false)")
+	flag.StringVar(&recFile, "record", "", "Record random numbers and inputs to replay file (default: none)")
+	flag.StringVar(&playFile, "replay", "", "Replay random numbers and inputs from file (default: none)")
+	flag.BoolVar(&lint, "lint", false, "Warn about questionable model constructs (default: false)")
+	flag.StringVar(&lintRules, "lint-rules", "", "Enabled lint rules (like '-MAGIC,AUXCHAIN=3'; default: all)")
+	flag.StringVar(&xrefFile, "xref", "", "Cross-reference file of upgraded model (default: console)")
+	flag.BoolVar(&repro, "repro", false, "Reproducible (compensated) level updates (default: false)")
+	flag.StringVar(&bounds, "bounds", "", "Handling of bound violations (WARN, CLAMP, ABORT; default: WARN)")
+	flag.StringVar(&policy, "policy", "", "Handling of numeric anomalies (like 'NAN=ABORT,NEGATIVE=CLAMP')")
+	flag.BoolVar(&autoDT, "autodt", false, "Select a stable DT automatically (default: false)")
+	flag.Int64Var(&seed, "seed", 0, "Seed for random numbers (default: 0 = random seed)")
+	flag.StringVar(&csvDelim, "csv-delim", "", "CSV field delimiter ('tab' for TSV)")
+	flag.StringVar(&csvDec, "csv-decimal", ".", "CSV decimal separator")
+	flag.BoolVar(&csvQuote, "csv-quote", false, "Quote all CSV fields (default: false)")
+	flag.BoolVar(&csvSci, "csv-sci", false, "Scientific notation in CSV (default: false)")
+	flag.StringVar(&sparse, "sparse", "", "Sparse CSV output of variables (like 'INV=0.01,PRICE'; default: none)")
+	flag.BoolVar(&noScale, "noscale", false, "Print raw (unscaled) values (default: false)")
+	flag.IntVar(&pageLen, "page", 0, "Lines per page in prints (default: 0 = no paging)")
+	flag.StringVar(&logLevel, "log-level", "", "Log level (ERROR, WARN, INFO, VERBOSE)")
+	flag.StringVar(&profFile, "profile", "", "Execution profile file name ('-' for console; default: none)")
+	flag.StringVar(&rdsFile, "rds", "", "Results of all runs as R data frame (RDS; default: none)")
+	flag.StringVar(&metrics, "metrics", "", "Run metrics file (Prometheus text format; default: none)")
+	flag.IntVar(&parallel, "parallel", 0, "Goroutines evaluating independent equations (default: 0 = sequential)")
+	flag.BoolVar(&internals, "internals", false, "Record internal variables of functions for print/plot (default: false)")
+	flag.BoolVar(&noCache, "nocache", false, "Evaluate all auxiliaries in every step (default: false)")
 	flag.Parse()
-	if flag.NArg() != 1 {
+	if len(verbose) > 0 {
+		dynamo.SetLogLevel(dynamo.LOG_VERBOSE)
+		if res := dynamo.SetLogCategories(strings.Split(verbose, ",")...); !res.Ok {
+			dynamo.Fatal(res.Err.Error())
+		}
+	}
+	if len(logLevel) > 0 {
+		level, res := dynamo.ParseLogLevel(logLevel)
+		if !res.Ok {
+			dynamo.Fatal(res.Err.Error())
+		}
+		dynamo.SetLogLevel(level)
+	}
+	// lint rules
+	lintCfg := dynamo.NewLintConfig()
+	if res := lintCfg.Set(lintRules); !res.Ok {
+		dynamo.Fatal(res.Err.Error())
+	}
+	// run policy
+	if res := dynamo.NewPolicy().Set(policy); !res.Ok {
+		dynamo.Fatal(res.Err.Error())
+	}
+	// print and plot files ("-" for console)
+	dynamo.SetFileCreator(createFile)
+	// common model settings
+	setup := func(mdl *dynamo.Model) {
+		mdl.SetStrict(strict)
+		if relaxed {
+			mdl.SetRelaxed(true)
+		}
+		mdl.Encoding = encoding
+		mdl.Lint = lint
+		mdl.LintRules = lintCfg
+		mdl.Repro = repro
+		mdl.BoundMode = strings.ToUpper(bounds)
+		if len(policy) > 0 {
+			mdl.Policy = dynamo.NewPolicy()
+			mdl.Policy.Set(policy)
+		}
+		mdl.AutoDT = autoDT
+		mdl.Seed = seed
+		mdl.Parallel = parallel
+		mdl.Internals = internals
+		mdl.NoCache = noCache
+		csv := mdl.Print.CSVFormat()
+		switch csvDelim {
+		case "":
+		case "tab":
+			csv.Delim = "\t"
+		default:
+			csv.Delim = csvDelim
+		}
+		csv.Decimal = csvDec
+		csv.Quote = csvQuote
+		csv.Sci = csvSci
+		mdl.Print.SetScaling(!noScale)
+		mdl.Print.SetPageLength(pageLen)
+		if res := mdl.Print.SetSparse(sparse); !res.Ok {
+			dynamo.Fatal(res.Err.Error())
+		}
+	}
+	if len(metrics) > 0 {
+		defer writeMetrics(metrics)
+	}
+
+	// "batch manifest.json" command
+	if flag.Arg(0) == "batch" {
+		if flag.NArg() != 2 {
+			dynamo.Fatal("Usage: dynamo [options] batch <manifest>")
+		}
+		m, res := dynamo.ReadManifest(flag.Arg(1))
+		if !res.Ok {
+			dynamo.Fatal(res.Err.Error())
+		}
+		list := m.Execute(setup)
+		dynamo.WriteBatchSummary(os.Stdout, list)
+		res, runs, warnings := dynamo.BatchOutcome(list)
+		fmt.Println(dynamo.Summary(res, runs, warnings))
+		dynamo.Msg("Done.")
+		return dynamo.ExitCode(res, warnings)
+	}
+	// "rename OLD NEW model.dynamo" command
+	if flag.Arg(0) == "rename" {
+		if flag.NArg() != 4 {
+			dynamo.Fatal("Usage: dynamo [options] rename <old> <new> <model>")
+		}
+		src, err := os.Open(flag.Arg(3))
+		if err != nil {
+			dynamo.Fatal(err.Error())
+		}
+		defer src.Close()
+		n, res := dynamo.Rename(os.Stdout, src, flag.Arg(1), flag.Arg(2), relaxed, strict)
+		if !res.Ok {
+			dynamo.Log(dynamo.LOG_ERROR, dynamo.LOG_GENERAL, res.Err.Error())
+		} else {
+			dynamo.Msgf("%d occurrences renamed.", n)
+		}
+		return dynamo.ExitCode(res, 0)
+	}
+	// "upgrade mapping.txt model.dynamo" command
+	if flag.Arg(0) == "upgrade" {
+		if flag.NArg() != 3 {
+			dynamo.Fatal("Usage: dynamo [options] upgrade <mapping> <model>")
+		}
+		f, err := os.Open(flag.Arg(1))
+		if err != nil {
+			dynamo.Fatal(err.Error())
+		}
+		names, res := dynamo.ReadNameMap(f)
+		f.Close()
+		if !res.Ok {
+			dynamo.Fatal(res.Err.Error())
+		}
+		src, err := os.Open(flag.Arg(2))
+		if err != nil {
+			dynamo.Fatal(err.Error())
+		}
+		defer src.Close()
+		xref := dynamo.MsgWriter()
+		if len(xrefFile) > 0 {
+			f, err := os.Create(xrefFile)
+			if err != nil {
+				dynamo.Fatal(err.Error())
+			}
+			defer f.Close()
+			xref = f
+		}
+		if res = dynamo.Upgrade(os.Stdout, xref, src, names); !res.Ok {
+			dynamo.Log(dynamo.LOG_ERROR, dynamo.LOG_GENERAL, res.Err.Error())
+		}
+		return dynamo.ExitCode(res, 0)
+	}
+	// "explain VAR model.dynamo" command
+	// "lint model.dynamo" command
+	// "inspect model.dynamo --at TIME" command
+	explain, lintOnly := "", false
+	fname := flag.Arg(flag.NArg() - 1)
+	inspect, inspectAt := false, 0.
+	if flag.Arg(0) == "inspect" {
+		// the time can be given before or after the model file
+		cmd := flag.NewFlagSet("inspect", flag.ExitOnError)
+		cmd.Float64Var(&inspectAt, "at", 0, "Time of inspected model state")
+		fname = ""
+		for args := flag.Args()[1:]; len(args) > 0; {
+			if err := cmd.Parse(args); err != nil {
+				dynamo.Fatal(err.Error())
+			}
+			if args = cmd.Args(); len(args) > 0 {
+				if len(fname) > 0 {
+					dynamo.Fatal("Usage: dynamo [options] inspect <model> --at <time>")
+				}
+				fname, args = args[0], args[1:]
+			}
+		}
+		cmd.Visit(func(f *flag.Flag) {
+			inspect = inspect || f.Name == "at"
+		})
+		if len(fname) == 0 || !inspect {
+			dynamo.Fatal("Usage: dynamo [options] inspect <model> --at <time>")
+		}
+	} else if flag.Arg(0) == "explain" {
+		if flag.NArg() != 3 {
+			dynamo.Fatal("Usage: dynamo [options] explain <variable> <model>")
+		}
+		explain = strings.ToUpper(flag.Arg(1))
+	} else if flag.Arg(0) == "lint" {
+		if flag.NArg() != 2 {
+			dynamo.Fatal("Usage: dynamo [options] lint <model>")
+		}
+		lintOnly = true
+	} else if flag.NArg() != 1 {
 		dynamo.Fatal("No DYNAMO source file provided.")
 	}
 
-	fname := flag.Arg(0)
-	dynamo.Msgf("Reading source file '%s'...\n", fname)
+	dynamo.Logf(dynamo.LOG_INFO, dynamo.LOG_PARSE, "Reading source file '%s'...\n", fname)
 	src, err := os.Open(fname)
 	if err != nil {
 		dynamo.Fatal(err.Error())
 	}
 	defer src.Close()
 
-	dynamo.Msg("Processing system model...")
-	dynamo.SetDebugger(debugFile)
-	mdl := dynamo.NewModel(printFile, plotFile)
-	mdl.Verbose = verbose
-	if res := mdl.Parse(src); !res.Ok {
-		dynamo.Fatalf("Line %d: %s\n", res.Line, res.Err.Error())
+	dynamo.Log(dynamo.LOG_INFO, dynamo.LOG_PARSE, "Processing system model...")
+	mdl := dynamo.NewModel("", "")
+	for _, file := range printFiles {
+		if res := mdl.AddPrintTarget(file); !res.Ok {
+			dynamo.Fatal(res.Err.Error())
+		}
+	}
+	for _, file := range plotFiles {
+		if res := mdl.AddPlotTarget(file); !res.Ok {
+			dynamo.Fatal(res.Err.Error())
+		}
+	}
+	if len(debugFile) > 0 {
+		dbg := os.Stdout
+		if debugFile != "-" {
+			if dbg, err = os.Create(debugFile); err != nil {
+				dynamo.Fatal(err.Error())
+			}
+			defer dbg.Close()
+		}
+		mdl.Dbg = dynamo.NewDebugger(dbg, debugLevel)
+	}
+	setup(mdl)
+	if len(defFile) > 0 {
+		f, err := os.Create(defFile)
+		if err != nil {
+			dynamo.Fatal(err.Error())
+		}
+		defer f.Close()
+		mdl.Default = f
+	}
+	mdl.NoRun = len(explain) > 0 || lintOnly || inspect
+	if len(profFile) > 0 {
+		mdl.Profile = dynamo.NewProfile()
+	}
+	if pace > 0 {
+		mdl.Pacer = dynamo.NewPacer(pace)
+		if stream {
+			mdl.Pacer.Out = os.Stdout
+		}
+	}
+	if len(recFile) > 0 {
+		f, err := os.Create(recFile)
+		if err != nil {
+			dynamo.Fatal(err.Error())
+		}
+		defer f.Close()
+		mdl.Replay = dynamo.NewRecorder(f)
+	} else if len(playFile) > 0 {
+		f, err := os.Open(playFile)
+		if err != nil {
+			dynamo.Fatal(err.Error())
+		}
+		var res *dynamo.Result
+		mdl.Replay, res = dynamo.NewReplay(f)
+		f.Close()
+		if !res.Ok {
+			dynamo.Fatalf("Replay line %d: %s\n", res.Line, res.Err.Error())
+		}
+	}
+	res := mdl.Parse(src)
+	warnings := 0
+	if res.Ok {
+		dynamo.Log(dynamo.LOG_INFO, dynamo.LOG_PARSE, "   Model processing completed.")
+		if len(docFile) > 0 {
+			f, err := os.Create(docFile)
+			if err != nil {
+				dynamo.Fatal(err.Error())
+			}
+			mdl.Documentation(f)
+			f.Close()
+		}
+		if len(listFile) > 0 {
+			f, err := os.Create(listFile)
+			if err != nil {
+				dynamo.Fatal(err.Error())
+			}
+			if strings.ToLower(filepath.Ext(listFile)) == ".html" {
+				mdl.WriteDocumentorHTML(f)
+			} else {
+				mdl.WriteDocumentor(f)
+			}
+			f.Close()
+		}
+		if len(secFile) > 0 {
+			f, err := os.Create(secFile)
+			if err != nil {
+				dynamo.Fatal(err.Error())
+			}
+			mdl.SectorGraph(f)
+			f.Close()
+		}
+		if len(fmuFile) > 0 {
+			f, err := os.Create(fmuFile)
+			if err != nil {
+				dynamo.Fatal(err.Error())
+			}
+			ident := strings.TrimSuffix(filepath.Base(fmuFile), filepath.Ext(fmuFile))
+			if res := mdl.ExportFMU(f, ident); !res.Ok {
+				dynamo.Fatal(res.Err.Error())
+			}
+			f.Close()
+		}
+		if len(explain) > 0 {
+			if res := mdl.Explain(os.Stdout, explain); !res.Ok {
+				dynamo.Fatal(res.Err.Error())
+			}
+		}
+		if inspect {
+			if res := mdl.Inspect(os.Stdout, inspectAt); !res.Ok {
+				dynamo.Fatal(res.Err.Error())
+			}
+		}
+		if lintOnly {
+			list := mdl.LintModel(lintCfg)
+			for _, l := range list {
+				fmt.Printf("%s %s\n", l.Rule, l.String())
+			}
+			warnings += len(list)
+		}
+	} else {
+		dynamo.Logf(dynamo.LOG_ERROR, dynamo.LOG_GENERAL, "Line %d: %s", res.Line, res.Err.Error())
+	}
+	if r := mdl.Quit(); res.Ok {
+		res = r
+	}
+	if len(rdsFile) > 0 && len(mdl.Results) > 0 {
+		ids := make([]string, 0, len(mdl.Results))
+		for id := range mdl.Results {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		list := make([]*dynamo.Dataset, len(ids))
+		for i, id := range ids {
+			list[i] = mdl.Results[id]
+		}
+		f, err := os.Create(rdsFile)
+		if err != nil {
+			dynamo.Fatal(err.Error())
+		}
+		if r := dynamo.WriteRDS(f, list...); !r.Ok {
+			dynamo.Fatal(r.Err.Error())
+		}
+		f.Close()
+	}
+	if mdl.Profile != nil {
+		prof := os.Stdout
+		if profFile != "-" {
+			if prof, err = os.Create(profFile); err != nil {
+				dynamo.Fatal(err.Error())
+			}
+			defer prof.Close()
+		}
+		mdl.Profile.Write(prof)
 	}
-	dynamo.Msg("   Model processing completed.")
-	mdl.Quit()
+	warnings += dynamo.Warnings()
+	fmt.Println(dynamo.Summary(res, len(mdl.Results), warnings))
 	dynamo.Msg("Done.")
+	return dynamo.ExitCode(res, warnings)
 }
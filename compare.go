@@ -0,0 +1,317 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+//======================================================================
+// DATASET -- recorded results of a model run (all variables at every
+// computed epoch). Datasets can be compared to each other to analyze the
+// effects of policy changes or model edits.
+//======================================================================
+
+// Dataset holds the time series of all variables of a model run.
+type Dataset struct {
+	RunID      string               // identifier of model run
+	Vars       map[string][]float64 // time series of variables (including TIME)
+	Provenance *Provenance          // provenance of results (or nil)
+}
+
+// NewDataset creates a new (empty) dataset for a model run.
+func NewDataset(runID string) *Dataset {
+	return &Dataset{
+		RunID: runID,
+		Vars:  make(map[string][]float64),
+	}
+}
+
+// NewDatasetFromFile reads a dataset from a CSV file as generated by the
+// CSV printer. The file must have a header line with variable names and
+// a TIME column.
+func NewDatasetFromFile(fname string) (ds *Dataset, res *Result) {
+	var data *CSVData
+	if data, res = ReadCSV(fname); !res.Ok {
+		return
+	}
+	return newDatasetFromCSV(data, fname)
+}
+
+// NewDatasetFromReader reads a dataset in CSV format from a stream.
+func NewDatasetFromReader(rdr io.Reader, name string) (ds *Dataset, res *Result) {
+	var data *CSVData
+	if data, res = ParseCSV(rdr, name); !res.Ok {
+		return
+	}
+	return newDatasetFromCSV(data, name)
+}
+
+// create dataset from CSV data
+func newDatasetFromCSV(data *CSVData, fname string) (ds *Dataset, res *Result) {
+	res = Success()
+	if data.Labels == nil {
+		res = Failure(ErrModelNoData+": no labels in %s", fname)
+		return
+	}
+	ds = NewDataset(fname)
+	for i, label := range data.Labels {
+		if ds.Vars[label], res = data.Column(i); !res.Ok {
+			// skip calendar dates (from print output)
+			if label == "DATE" {
+				delete(ds.Vars, label)
+				res = Success()
+				continue
+			}
+			return
+		}
+	}
+	if _, ok := ds.Vars["TIME"]; !ok {
+		res = Failure(ErrModelNoData+": no TIME in %s", fname)
+	}
+	return
+}
+
+// Add the current values of all (non-internal) variables in a state to
+// the dataset. The set of variables is defined by the first state added;
+// variables missing in later states are recorded as NaN. Automatic
+// variables are only recorded with derived names (like "_DELAY3$SHIP$L1").
+func (ds *Dataset) Add(state State) {
+	if len(ds.Vars) == 0 {
+		for name := range state {
+			if name[0] != '_' || strings.Contains(name, "$") {
+				ds.Vars[name] = make([]float64, 0)
+			}
+		}
+	}
+	for name, list := range ds.Vars {
+		val, ok := state[name]
+		if !ok {
+			ds.Vars[name] = append(list, math.NaN())
+			continue
+		}
+		ds.Vars[name] = append(list, float64(val))
+	}
+}
+
+// mask the last recorded values of named variables (set to NaN).
+func (ds *Dataset) mask(names []string) {
+	for _, name := range names {
+		if list, ok := ds.Vars[name]; ok && len(list) > 0 {
+			list[len(list)-1] = math.NaN()
+		}
+	}
+}
+
+// Clone a dataset.
+func (ds *Dataset) Clone() *Dataset {
+	clone := NewDataset(ds.RunID)
+	clone.Provenance = ds.Provenance
+	for name, list := range ds.Vars {
+		clone.Vars[name] = append([]float64(nil), list...)
+	}
+	return clone
+}
+
+// Truncate the dataset to n recorded epochs.
+func (ds *Dataset) Truncate(n int) {
+	for name, list := range ds.Vars {
+		if n < len(list) {
+			ds.Vars[name] = list[:n]
+		}
+	}
+}
+
+// Len returns the number of recorded epochs.
+func (ds *Dataset) Len() int {
+	return len(ds.Vars["TIME"])
+}
+
+// Names returns the sorted list of variable names in the dataset.
+func (ds *Dataset) Names() (list []string) {
+	for name := range ds.Vars {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return
+}
+
+// Sample returns a dataset with the named variables recorded every n-th
+// epoch (starting with the first epoch).
+func (ds *Dataset) Sample(names []string, n int) *Dataset {
+	if n < 1 {
+		n = 1
+	}
+	smpl := NewDataset(ds.RunID)
+	smpl.Provenance = ds.Provenance
+	for _, name := range names {
+		list, ok := ds.Vars[name]
+		if !ok {
+			continue
+		}
+		vals := make([]float64, 0, len(list)/n+1)
+		for i := 0; i < len(list); i += n {
+			vals = append(vals, list[i])
+		}
+		smpl.Vars[name] = vals
+	}
+	return smpl
+}
+
+//----------------------------------------------------------------------
+// Comparing datasets
+//----------------------------------------------------------------------
+
+// VarDiff is the difference of a variable between two datasets.
+type VarDiff struct {
+	Name  string  // variable name
+	Max   float64 // max. absolute difference
+	RMS   float64 // root mean square of differences
+	Final float64 // difference of final values
+}
+
+// Compare two datasets: for all variables defined in both datasets the
+// differences are computed for each point in time found in both datasets
+// (like a run recorded every DT and a CSV print with values every PRTPER).
+func (ds *Dataset) Compare(other *Dataset) (diffs []*VarDiff, res *Result) {
+	res = Success()
+	// find matching epochs in both datasets
+	var epochs [][2]int
+	for i, t := range ds.Vars["TIME"] {
+		if j := other.epoch(t); j != -1 {
+			epochs = append(epochs, [2]int{i, j})
+		}
+	}
+	if len(epochs) == 0 {
+		res = Failure(ErrModelRunMismatch+": no common TIME in %s and %s", ds.RunID, other.RunID)
+		return
+	}
+	for _, name := range ds.Names() {
+		if name == "TIME" {
+			continue
+		}
+		v1, ok := other.Vars[name]
+		if !ok {
+			continue
+		}
+		v0 := ds.Vars[name]
+		diff := &VarDiff{Name: name}
+		cnt := 0
+		for _, e := range epochs {
+			// skip unrecorded values (e.g. supplements)
+			d := v1[e[1]] - v0[e[0]]
+			if math.IsNaN(d) {
+				continue
+			}
+			diff.Max = math.Max(diff.Max, math.Abs(d))
+			diff.RMS += d * d
+			cnt++
+		}
+		if cnt > 0 {
+			diff.RMS = math.Sqrt(diff.RMS / float64(cnt))
+		}
+		last := epochs[len(epochs)-1]
+		diff.Final = v1[last[1]] - v0[last[0]]
+		diffs = append(diffs, diff)
+	}
+	return
+}
+
+// epoch returns the index of the recorded epoch for a point in time (or
+// -1 if the time is not found in the dataset).
+func (ds *Dataset) epoch(t float64) int {
+	time := ds.Vars["TIME"]
+	i := sort.Search(len(time), func(i int) bool {
+		return compare(time[i], t) >= 0
+	})
+	if i < len(time) && compare(time[i], t) == 0 {
+		return i
+	}
+	return -1
+}
+
+// WriteComparison writes a human-readable report of differences.
+func WriteComparison(w io.Writer, run1, run2 string, diffs []*VarDiff) {
+	fmt.Fprintf(w, "\n\n")
+	fmt.Fprintf(w, "      COMPARE %s,%s\n", run1, run2)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "  %-8s  %14s  %14s  %14s\n", "NAME", "MAX", "RMS", "FINAL")
+	for _, d := range diffs {
+		fmt.Fprintf(w, "  %-8s  %14.6g  %14.6g  %14.6g\n", d.Name, d.Max, d.RMS, d.Final)
+	}
+}
+
+//----------------------------------------------------------------------
+// Verifying model runs against reference data ("golden runs")
+//----------------------------------------------------------------------
+
+// Mismatch is a difference between a reference value and the value
+// computed in a model run that exceeds the tolerance.
+type Mismatch struct {
+	Name     string  // variable name
+	Time     float64 // point in time
+	Expected float64 // reference value
+	Actual   float64 // computed value (NaN if variable is missing)
+}
+
+// String returns a human-readable mismatch.
+func (m *Mismatch) String() string {
+	return fmt.Sprintf("%s @ TIME=%g: expected %g, got %g", m.Name, m.Time, m.Expected, m.Actual)
+}
+
+// Verify the dataset against reference data. Values are matching if the
+// absolute difference is not larger than 'tolerance * max(1,|expected|)'.
+// The reference data can be sparse (e.g. only holding values for print
+// intervals), but each reference TIME must be found in the dataset.
+func (ds *Dataset) Verify(ref *Dataset, tolerance float64) (list []*Mismatch, res *Result) {
+	res = Success()
+	for i, t := range ref.Vars["TIME"] {
+		// find epoch for reference time
+		epoch := ds.epoch(t)
+		if epoch == -1 {
+			res = Failure(ErrModelRunMismatch+": TIME %f not found", t)
+			return
+		}
+		for _, name := range ref.Names() {
+			if name == "TIME" {
+				continue
+			}
+			expected := ref.Vars[name][i]
+			actual := math.NaN()
+			if vals, ok := ds.Vars[name]; ok {
+				actual = vals[epoch]
+			}
+			if !(math.Abs(actual-expected) <= tolerance*math.Max(1, math.Abs(expected))) {
+				list = append(list, &Mismatch{
+					Name:     name,
+					Time:     t,
+					Expected: expected,
+					Actual:   actual,
+				})
+			}
+		}
+	}
+	return
+}
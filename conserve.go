@@ -0,0 +1,156 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"math"
+	"strings"
+)
+
+// CONSERVE_TOLERANCE is the relative tolerance for conservation checks.
+const CONSERVE_TOLERANCE = 1e-9
+
+//----------------------------------------------------------------------
+// CONSERVATION -- A conserved group of levels only changes by the flows
+// across the boundary of the group (flows between levels of the group
+// must leave one level and enter another):
+//
+//     CONSERVE POP=SUSC,SICK,RECOV/BIRTHS,-DEATHS
+//
+// The (optional) list after the slash names the rates of inflows into
+// the group; outflows are prefixed with '-'. Conservation is verified
+// in every epoch of a run.
+//----------------------------------------------------------------------
+
+// conserved group of levels
+type conserved struct {
+	name   string             // name of group
+	levels []string           // levels in group
+	flows  map[string]float64 // boundary rates (with sign)
+	first  *Violation         // first violation in run
+}
+
+// Violation of conservation in a model run
+type Violation struct {
+	Group    string  // name of conserved group
+	Epoch    int     // epoch of violation
+	Time     float64 // TIME of violation
+	Delta    float64 // change of group total
+	Expected float64 // expected change (from boundary flows)
+}
+
+// addConserve handles a CONSERVE statement:
+// "NAME=L1,L2,.../R1,-R2,..."
+func (mdl *Model) addConserve(stmt string) (res *Result) {
+	grps := strings.Split(stmt, "/")
+	def := strings.Split(grps[0], "=")
+	if len(def) != 2 || len(grps) > 2 || len(def[1]) == 0 {
+		return Failure(ErrParseSyntax+": %s", stmt)
+	}
+	grp := &conserved{
+		name:   def[0],
+		levels: strings.Split(def[1], ","),
+		flows:  make(map[string]float64),
+	}
+	if len(grps) == 2 {
+		for _, r := range strings.Split(grps[1], ",") {
+			sign := 1.
+			if strings.HasPrefix(r, "-") {
+				r, sign = r[1:], -1
+			}
+			grp.flows[r] = sign
+		}
+	}
+	mdl.conserve = append(mdl.conserve, grp)
+	return Success()
+}
+
+// checkConserved verifies that the levels and rates of conserved groups
+// exist in the equations.
+func (mdl *Model) checkConserved(eqns *EqnList) (res *Result) {
+	res = Success()
+	check := func(name, mode string) *Result {
+		for _, eqn := range eqns.index().defs[name] {
+			if eqn.Mode == mode {
+				return Success()
+			}
+		}
+		return Failure(ErrModelEqnBadMode+": %s (CONSERVE)", name)
+	}
+	for _, grp := range mdl.conserve {
+		grp.first = nil
+		for _, name := range grp.levels {
+			if res = check(name, "L"); !res.Ok {
+				return
+			}
+		}
+		for name := range grp.flows {
+			if res = check(name, "R"); !res.Ok {
+				return
+			}
+		}
+	}
+	return
+}
+
+// verifyConserved checks conservation in the current epoch (after the
+// levels have been computed). The first violation of a group is logged.
+func (mdl *Model) verifyConserved() {
+	dt := float64(mdl.Current["DT"])
+	for _, grp := range mdl.conserve {
+		if grp.first != nil {
+			continue
+		}
+		var last, curr, total float64
+		for _, name := range grp.levels {
+			last += float64(mdl.Last[name])
+			curr += float64(mdl.Current[name])
+			total += math.Abs(float64(mdl.Current[name]))
+		}
+		var flow float64
+		for name, sign := range grp.flows {
+			flow += sign * float64(mdl.Last[name])
+		}
+		delta, expect := curr-last, dt*flow
+		if math.Abs(delta-expect) > CONSERVE_TOLERANCE*math.Max(1, total) {
+			grp.first = &Violation{
+				Group:    grp.name,
+				Epoch:    mdl.run.epoch,
+				Time:     float64(mdl.Last["TIME"]),
+				Delta:    delta,
+				Expected: expect,
+			}
+			Logf(LOG_WARN, LOG_RUN, "Conservation of %s violated in epoch %d (TIME %g): change %g, expected %g\n",
+				grp.name, mdl.run.epoch, grp.first.Time, delta, expect)
+		}
+	}
+}
+
+// Violations returns the first violation of each conserved group in the
+// last run.
+func (mdl *Model) Violations() (list []*Violation) {
+	for _, grp := range mdl.conserve {
+		if grp.first != nil {
+			list = append(list, grp.first)
+		}
+	}
+	return
+}
@@ -0,0 +1,317 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bufio"
+	"go/ast"
+	"go/token"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//======================================================================
+// External data (CSV files)
+//
+// Large data sets (like empirical lookup curves) can be kept in external
+// CSV files instead of being hand-typed into DYNAMO source. Fields can be
+// separated by ',', ';' or tabs (as generated by the CSV printer); an
+// optional first line holds column labels.
+//======================================================================
+
+// CSVData holds the content of a CSV file
+type CSVData struct {
+	Labels []string    // column labels (or nil if no header line)
+	Rows   [][]float64 // rows of numerical values
+}
+
+// ReadCSV reads numerical data from a CSV file.
+func ReadCSV(fname string) (data *CSVData, res *Result) {
+	f, err := os.Open(fname)
+	if err != nil {
+		res = Failure(err)
+		return
+	}
+	defer f.Close()
+	return ParseCSV(f, fname)
+}
+
+// ParseCSV reads numerical data in CSV format from a stream. The name of
+// the stream is used in failure messages.
+func ParseCSV(in io.Reader, fname string) (data *CSVData, res *Result) {
+	res = Success()
+	data = new(CSVData)
+	rdr := bufio.NewScanner(in)
+	lineNo := 0
+	var err error
+	for rdr.Scan() {
+		lineNo++
+		line := strings.TrimSpace(rdr.Text())
+		if len(line) == 0 || line[0] == '#' {
+			// skip empty lines and comments (like provenance)
+			continue
+		}
+		// split into fields
+		sep := ","
+		if strings.Contains(line, ";") {
+			sep = ";"
+		} else if strings.Contains(line, "\t") {
+			sep = "\t"
+		}
+		fields := splitCSV(line, sep)
+		// parse numerical values (with decimal point or comma)
+		row := make([]float64, len(fields))
+		for i, fld := range fields {
+			if strings.Count(fld, ",") == 1 && !strings.Contains(fld, ".") {
+				fld = strings.Replace(fld, ",", ".", 1)
+			}
+			if row[i], err = strconv.ParseFloat(fld, 64); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			// only the first line can hold labels
+			if len(data.Rows) > 0 || data.Labels != nil {
+				res = Failure(ErrParseNotANumber+": '%s' [%s:%d]", line, fname, lineNo)
+				return
+			}
+			for i, fld := range fields {
+				fields[i] = strings.ToUpper(fld)
+			}
+			data.Labels = fields
+			continue
+		}
+		data.Rows = append(data.Rows, row)
+	}
+	if err = rdr.Err(); err != nil {
+		res = Failure(err)
+		return
+	}
+	if len(data.Rows) == 0 {
+		res = Failure(ErrModelNoData+": %s", fname)
+	}
+	return
+}
+
+// splitCSV splits a CSV line into (trimmed and unquoted) fields. Quoted
+// fields can contain the separator and escaped quotes ("").
+func splitCSV(line, sep string) (fields []string) {
+	var (
+		fld    strings.Builder
+		quoted bool
+	)
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '"':
+			if quoted && i+1 < len(line) && line[i+1] == '"' {
+				fld.WriteByte('"')
+				i++
+			} else {
+				quoted = !quoted
+			}
+		case !quoted && strings.HasPrefix(line[i:], sep):
+			fields = append(fields, strings.TrimSpace(fld.String()))
+			fld.Reset()
+			i += len(sep) - 1
+		default:
+			fld.WriteByte(line[i])
+		}
+	}
+	return append(fields, strings.TrimSpace(fld.String()))
+}
+
+// Column returns the values of a column in the data set.
+func (d *CSVData) Column(idx int) (vals []float64, res *Result) {
+	res = Success()
+	vals = make([]float64, len(d.Rows))
+	for i, row := range d.Rows {
+		if idx >= len(row) {
+			res = Failure(ErrModelNoData+": column %d in row %d", idx+1, i+1)
+			return
+		}
+		vals[i] = row[idx]
+	}
+	return
+}
+
+//----------------------------------------------------------------------
+// TABLE data from CSV files
+//----------------------------------------------------------------------
+
+// NewTableFromFile creates a new Table from a CSV file. The file either has
+// a single column (y-values) or two columns (x,y). In the latter case the
+// x-values must be increasing; they define the range of the table that is
+// checked when the table is used in a TABLE function call.
+func NewTableFromFile(fname string) (tbl *Table, res *Result) {
+	var data *CSVData
+	if data, res = ReadCSV(fname); !res.Ok {
+		return
+	}
+	// get y-values from last column
+	cols := len(data.Rows[0])
+	if cols > 2 {
+		res = Failure(ErrParseTableFormat+": %d columns", cols)
+		return
+	}
+	var y []float64
+	if y, res = data.Column(cols - 1); !res.Ok {
+		return
+	}
+	if cols == 1 {
+		list := make([]string, len(y))
+		for i, v := range y {
+			list[i] = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		return NewTable(list)
+	}
+	// x-values must be increasing (but not equidistant)
+	var x []float64
+	if x, res = data.Column(0); !res.Ok {
+		return
+	}
+	return NewTableXY(x, y)
+}
+
+//----------------------------------------------------------------------
+// Exogenous data series (DATA statement)
+//----------------------------------------------------------------------
+
+// Series is a time-indexed list of values for an exogenous variable.
+type Series struct {
+	Time   []float64 // (increasing) time values
+	Values []float64 // variable values
+}
+
+// NewSeriesFromFile reads a time series for a named variable from a CSV
+// file. The time values are taken from the column labeled "TIME" (or the
+// first column if not labeled); the values are taken from the column with
+// the variable name as label. If the file has only two columns, labels are
+// optional.
+func NewSeriesFromFile(fname, name string) (s *Series, res *Result) {
+	var data *CSVData
+	if data, res = ReadCSV(fname); !res.Ok {
+		return
+	}
+	// find columns for time and value
+	tc, vc := 0, -1
+	for i, label := range data.Labels {
+		switch label {
+		case "TIME":
+			tc = i
+		case name:
+			vc = i
+		}
+	}
+	if vc == -1 {
+		if len(data.Rows[0]) != 2 {
+			res = Failure(ErrModelNoData+": %s in %s", name, fname)
+			return
+		}
+		vc = 1 - tc
+	}
+	s = new(Series)
+	if s.Time, res = data.Column(tc); !res.Ok {
+		return
+	}
+	if s.Values, res = data.Column(vc); !res.Ok {
+		return
+	}
+	for i := 1; i < len(s.Time); i++ {
+		if compare(s.Time[i], s.Time[i-1]) <= 0 {
+			res = Failure(ErrParseSeriesFormat+": time not increasing in %s", fname)
+			return
+		}
+	}
+	return
+}
+
+// At returns the (linear interpolated) value of the series at given time.
+// Outside the time range of the series the first (or last) value is used.
+func (s *Series) At(t float64) float64 {
+	last := len(s.Time) - 1
+	if t <= s.Time[0] {
+		return s.Values[0]
+	}
+	if t >= s.Time[last] {
+		return s.Values[last]
+	}
+	idx := sort.SearchFloat64s(s.Time, t)
+	if compare(s.Time[idx], t) == 0 {
+		return s.Values[idx]
+	}
+	frac := (t - s.Time[idx-1]) / (s.Time[idx] - s.Time[idx-1])
+	return s.Values[idx-1] + frac*(s.Values[idx]-s.Values[idx-1])
+}
+
+// seriesKey returns the name of a series read from a column in a CSV file
+// (as referenced in an EXTDAT("file","COLUMN") call).
+func seriesKey(file, col string) string {
+	return strings.ToUpper(col) + "@" + file
+}
+
+// checkExtdat checks the arguments of an EXTDAT call: either the name of
+// a series (from a DATA statement) or a file name and column label.
+func checkExtdat(args []ast.Expr) *Result {
+	if len(args) == 1 {
+		switch args[0].(type) {
+		case *ast.Ident, *ast.SelectorExpr:
+			// series name (qualified in sectors and instances)
+			return Success()
+		}
+		return Failure(ErrModelFunctionArg+": EXTDAT(%v)", args[0])
+	}
+	for _, arg := range args {
+		if lit, ok := arg.(*ast.BasicLit); !ok || lit.Kind != token.STRING {
+			return Failure(ErrModelFunctionArg+": EXTDAT(%v)", arg)
+		}
+	}
+	return Success()
+}
+
+// loadSeries reads the data series referenced in EXTDAT("file","COLUMN")
+// calls of an equation.
+func (mdl *Model) loadSeries(eqn *Equation) (res *Result) {
+	res = Success()
+	ast.Inspect(eqn.Formula, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || !res.Ok {
+			return res.Ok
+		}
+		if fcn, ok := call.Fun.(*ast.Ident); !ok || fcn.Name != "EXTDAT" || len(call.Args) != 2 {
+			return true
+		}
+		file, _ := strconv.Unquote(call.Args[0].(*ast.BasicLit).Value)
+		col, _ := strconv.Unquote(call.Args[1].(*ast.BasicLit).Value)
+		key := seriesKey(file, col)
+		if _, ok := mdl.Series[key]; ok {
+			return true
+		}
+		var series *Series
+		if series, res = NewSeriesFromFile(file, strings.ToUpper(col)); res.Ok {
+			mdl.Series[key] = series
+		}
+		return res.Ok
+	})
+	return
+}
@@ -0,0 +1,271 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+//======================================================================
+// Distributions of variables across the runs of a parameter sweep (like
+// a Monte Carlo analysis) at a point in time: the values are rendered as
+// a histogram or as a cumulative distribution (CDF), either as a text
+// plot or as a GNUplot script.
+//======================================================================
+
+// Distribution modes
+const (
+	DIST_HISTOGRAM = iota // histogram of values
+	DIST_CDF              // cumulative distribution of values
+)
+
+// Distribution of a variable across runs at a point in time.
+type Distribution struct {
+	Name   string    // variable name
+	Time   float64   // point in time
+	Values []float64 // sorted values of runs (without NaN)
+	Min    float64   // lower bound of histogram
+	Max    float64   // upper bound of histogram
+	Counts []int     // number of values in bins
+}
+
+// NewDistribution collects the values of a variable from the results of
+// a sweep at a point in time (use +Inf for the final values of runs) and
+// sorts them into a number of bins of equal width.
+func NewDistribution(list []*Dataset, name string, t float64, bins int) (d *Distribution, res *Result) {
+	if bins < 1 {
+		return nil, Failure(ErrPlotRange+": %d bins", bins)
+	}
+	d = &Distribution{
+		Name:   name,
+		Time:   t,
+		Counts: make([]int, bins),
+	}
+	for _, ds := range list {
+		vals, ok := ds.Vars[name]
+		if !ok {
+			return nil, Failure(ErrModelNoVariable+": %s in %s", name, ds.RunID)
+		}
+		epoch := len(vals) - 1
+		if !math.IsInf(t, 1) {
+			epoch = ds.epoch(t)
+		}
+		if epoch < 0 || epoch >= len(vals) {
+			return nil, Failure(ErrModelNoData+": %s at TIME=%g in %s", name, t, ds.RunID)
+		}
+		if val := vals[epoch]; !math.IsNaN(val) {
+			d.Values = append(d.Values, val)
+		}
+	}
+	if len(d.Values) == 0 {
+		return nil, Failure(ErrModelNoData+": %s", name)
+	}
+	sort.Float64s(d.Values)
+	d.Min, d.Max = d.Values[0], d.Values[len(d.Values)-1]
+	if compare(d.Min, d.Max) == 0 {
+		// all values are (nearly) equal: center a unit range
+		d.Min, d.Max = d.Min-0.5, d.Max+0.5
+	}
+	for _, val := range d.Values {
+		d.Counts[d.bin(val)]++
+	}
+	return d, Success()
+}
+
+// bin returns the index of the bin for a value.
+func (d *Distribution) bin(val float64) int {
+	n := len(d.Counts)
+	i := int(float64(n) * (val - d.Min) / (d.Max - d.Min))
+	if i < 0 {
+		i = 0
+	} else if i >= n {
+		i = n - 1
+	}
+	return i
+}
+
+// Bounds returns the lower and upper bound of the i-th bin.
+func (d *Distribution) Bounds(i int) (lo, hi float64) {
+	w := (d.Max - d.Min) / float64(len(d.Counts))
+	return d.Min + float64(i)*w, d.Min + float64(i+1)*w
+}
+
+// CDF returns the fraction of values not larger than x.
+func (d *Distribution) CDF(x float64) float64 {
+	n := sort.Search(len(d.Values), func(i int) bool {
+		return d.Values[i] > x
+	})
+	return float64(n) / float64(len(d.Values))
+}
+
+// Quantile returns the smallest value with a CDF of at least p.
+func (d *Distribution) Quantile(p float64) float64 {
+	i := int(math.Ceil(p*float64(len(d.Values)))) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(d.Values) {
+		i = len(d.Values) - 1
+	}
+	return d.Values[i]
+}
+
+// title of distribution plots
+func (d *Distribution) title() string {
+	when := fmt.Sprintf("TIME=%g", d.Time)
+	if math.IsInf(d.Time, 1) {
+		when = "END"
+	}
+	return fmt.Sprintf("%s AT %s (%d RUNS)", d.Name, when, len(d.Values))
+}
+
+// Plot writes a text plot of the distribution: one line per bin with the
+// bin range, the number of values, the cumulative fraction and a bar of
+// the count (DIST_HISTOGRAM) or the cumulative fraction (DIST_CDF).
+func (d *Distribution) Plot(w io.Writer, mode int) *Result {
+	if mode != DIST_HISTOGRAM && mode != DIST_CDF {
+		return Failure(ErrPlotMode+": %d", mode)
+	}
+	const width = 50
+	most := 0
+	for _, cnt := range d.Counts {
+		if cnt > most {
+			most = cnt
+		}
+	}
+	fmt.Fprintf(w, "\n\n      DISTRIBUTION %s\n\n", d.title())
+	fmt.Fprintf(w, "  %14s  %14s  %6s  %6s\n", "FROM", "TO", "COUNT", "CDF")
+	sum := 0
+	for i, cnt := range d.Counts {
+		sum += cnt
+		cdf := float64(sum) / float64(len(d.Values))
+		bar := width * cnt / most
+		if mode == DIST_CDF {
+			bar = int(math.Round(width * cdf))
+		}
+		lo, hi := d.Bounds(i)
+		fmt.Fprintf(w, "  %14.6g  %14.6g  %6d  %6.3f  %s\n", lo, hi, cnt, cdf, strings.Repeat("*", bar))
+	}
+	return Success()
+}
+
+// Gnuplot writes a GNUplot script for the distribution that renders the
+// plot into the SVG file "<base>.svg".
+func (d *Distribution) Gnuplot(w io.Writer, base string, mode int) *Result {
+	fmt.Fprintln(w, "$dist << EOD")
+	switch mode {
+	case DIST_HISTOGRAM:
+		for i, cnt := range d.Counts {
+			lo, hi := d.Bounds(i)
+			fmt.Fprintf(w, "%f %d\n", (lo+hi)/2, cnt)
+		}
+	case DIST_CDF:
+		for i, val := range d.Values {
+			fmt.Fprintf(w, "%f %f\n", val, float64(i+1)/float64(len(d.Values)))
+		}
+	default:
+		return Failure(ErrPlotMode+": %d", mode)
+	}
+	fmt.Fprintln(w, "EOD")
+	fmt.Fprintf(w, "set title \"%s\"\n", d.title())
+	fmt.Fprintf(w, "set xlabel \"%s\"\n", d.Name)
+	fmt.Fprintf(w, "set xrange [%f:%f]\n", d.Min, d.Max)
+	fmt.Fprintln(w, "set term svg size 700,500")
+	fmt.Fprintf(w, "set output \"%s.svg\"\n", base)
+	if mode == DIST_HISTOGRAM {
+		fmt.Fprintln(w, "set yrange [0:*]")
+		fmt.Fprintln(w, "set style fill solid 0.5")
+		fmt.Fprintf(w, "set boxwidth %f\n", (d.Max-d.Min)/float64(len(d.Counts)))
+		fmt.Fprintln(w, "plot $dist using 1:2 with boxes ls 1 title \"COUNT\"")
+	} else {
+		fmt.Fprintln(w, "set yrange [0:1]")
+		fmt.Fprintln(w, "plot $dist using 1:2 with steps ls 1 title \"CDF\"")
+	}
+	return Success()
+}
+
+//----------------------------------------------------------------------
+// Distribution plot jobs: the distributions of variables are plotted into
+// the plot files after each parameter sweep (Model.Sweep).
+//----------------------------------------------------------------------
+
+// distJob is a distribution plot of a variable.
+type distJob struct {
+	name string  // variable name
+	t    float64 // point in time (+Inf for final values)
+	bins int     // number of bins
+	mode int     // distribution mode (DIST_???)
+}
+
+// AddDistribution adds a distribution plot job to the plotter: after each
+// sweep the distribution of the variable across runs at a point in time
+// (+Inf for the final values) is plotted into all plot files (as a text
+// plot or as a GNUplot script).
+func (plt *Plotter) AddDistribution(name string, t float64, bins, mode int) *Result {
+	if mode != DIST_HISTOGRAM && mode != DIST_CDF {
+		return Failure(ErrPlotMode+": %d", mode)
+	}
+	if bins < 1 {
+		return Failure(ErrPlotRange+": %d bins", bins)
+	}
+	plt.dists = append(plt.dists, &distJob{
+		name: strings.ToUpper(name),
+		t:    t,
+		bins: bins,
+		mode: mode,
+	})
+	return Success()
+}
+
+// plotDistributions plots the distribution jobs for the results of a
+// sweep. Custom renderers are skipped.
+func (plt *Plotter) plotDistributions(list []*Dataset) (res *Result) {
+	res = Success()
+	if len(plt.dists) == 0 || len(plt.renderers) == 0 {
+		return
+	}
+	Log(LOG_INFO, LOG_OUTPUT, "      Generating distribution plot(s)...")
+	for i, dj := range plt.dists {
+		var d *Distribution
+		if d, res = NewDistribution(list, dj.name, dj.t, dj.bins); !res.Ok {
+			return
+		}
+		for _, r := range plt.renderers {
+			switch x := r.(type) {
+			case *dynRenderer:
+				if res = x.open(); res.Ok {
+					res = d.Plot(x.file, dj.mode)
+				}
+			case *gnuRenderer:
+				if res = x.open(); res.Ok {
+					res = d.Gnuplot(x.file, fmt.Sprintf("%s_dist(%d)", x.base, i+1), dj.mode)
+				}
+			}
+			if !res.Ok {
+				return
+			}
+		}
+	}
+	return
+}
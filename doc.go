@@ -0,0 +1,31 @@
+// Package dynamo is an interpreter for DYNAMO models that can be used by
+// other Go programs (module path "github.com/bfix/dynamo"). A model is
+// created with NewModel, its source is read with Model.Parse (which also
+// executes RUN statements) and a model can be run as a whole (Model.Run)
+// or step by step (Model.Start, Model.Step, Model.Finish). The results
+// of runs are available as datasets in Model.Results.
+//
+// The exported API follows semantic versioning: releases are tagged as
+// "v<VERSION>" and exported identifiers are only removed or changed in
+// incompatible ways with a new major version.
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
@@ -0,0 +1,198 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+)
+
+//======================================================================
+// Documentor listing: the classic DYNAMO listing of a model numbers all
+// equations and annotates them with cross references: where the
+// defined variable is used and where the variables on the right side
+// of an equation are defined. The listing is written as text or HTML.
+//======================================================================
+
+// DocRef is a variable referenced in an equation.
+type DocRef struct {
+	Name    string // name of the variable
+	Type    string // type of the variable (LEVEL, RATE, AUX,...)
+	Defs    []int  // numbers of defining equations
+	Comment string // description of the variable
+}
+
+// DocEntry is an annotated equation in the documentor listing.
+type DocEntry struct {
+	Number  int       // number of the equation
+	Mode    string    // mode of the equation
+	Stmt    string    // equation in DYNAMO notation
+	Type    string    // type of the defined variable
+	Comment string    // description of the equation
+	UsedIn  []int     // numbers of equations using the defined variable
+	Refs    []*DocRef // variables used in the equation (sorted by name)
+}
+
+// Documentor returns the annotated equations of a model (in the order of
+// the model equations). Automatic variables are skipped. If the model has
+// been run, the equations of the last run are used.
+func (mdl *Model) Documentor() (list []*DocEntry) {
+	// number the equations and collect defining equations
+	var eqns []*Equation
+	defs := make(map[string][]int)
+	for _, eqn := range mdl.equations().List() {
+		if eqn.Target.Name[0] == '_' {
+			continue
+		}
+		eqns = append(eqns, eqn)
+		defs[eqn.Target.Name] = append(defs[eqn.Target.Name], len(eqns))
+	}
+	// descriptions and types of variables
+	gloss := make(map[string]*GlossaryEntry)
+	for _, entry := range mdl.Glossary() {
+		gloss[entry.Name] = entry
+	}
+	// collect variables used in equations
+	uses := make([][]string, len(eqns))
+	for i, eqn := range eqns {
+		seen := make(map[string]bool)
+		add := func(name string) {
+			if name[0] == '_' || seen[name] {
+				return
+			}
+			seen[name] = true
+			uses[i] = append(uses[i], name)
+		}
+		for _, list := range [][]*Name{eqn.Dependencies, eqn.References} {
+			for _, name := range list {
+				add(name.Name)
+			}
+		}
+		for _, name := range eqn.Tables() {
+			add(name)
+		}
+		sort.Strings(uses[i])
+	}
+	number := make(map[*Equation]int)
+	for i, eqn := range eqns {
+		number[eqn] = i + 1
+	}
+	for i, eqn := range eqns {
+		entry := &DocEntry{
+			Number:  i + 1,
+			Mode:    eqn.Mode,
+			Stmt:    eqn.Statement(),
+			Type:    glossaryTypes[eqn.Mode],
+			Comment: eqn.Comment,
+		}
+		for _, user := range mdl.UsesOf(eqn.Target.Name) {
+			if n, ok := number[user]; ok {
+				entry.UsedIn = append(entry.UsedIn, n)
+			}
+		}
+		for _, use := range uses[i] {
+			ref := &DocRef{
+				Name: use,
+				Defs: defs[use],
+			}
+			if g, ok := gloss[use]; ok {
+				ref.Type, ref.Comment = g.Type, g.Comment
+			} else if mdl.IsSystem(use) {
+				ref.Type = "SYSTEM"
+			}
+			entry.Refs = append(entry.Refs, ref)
+		}
+		list = append(list, entry)
+	}
+	return
+}
+
+// numbers returns a list of equation numbers in human-readable form.
+func numbers(list []int) string {
+	if len(list) == 0 {
+		return "-"
+	}
+	s := make([]string, len(list))
+	for i, n := range list {
+		s[i] = fmt.Sprint(n)
+	}
+	return strings.Join(s, ",")
+}
+
+// WriteDocumentor writes the documentor listing of a model as text.
+func (mdl *Model) WriteDocumentor(w io.Writer) {
+	if len(mdl.Title) > 0 {
+		fmt.Fprintf(w, "%s\n\n", mdl.Title)
+	}
+	fmt.Fprintf(w, "%5s  %-4s  %s\n", "NO.", "MODE", "EQUATION")
+	for _, e := range mdl.Documentor() {
+		fmt.Fprintf(w, "\n%5d  %-4s  %s\n", e.Number, e.Mode, e.Stmt)
+		if len(e.Comment) > 0 {
+			fmt.Fprintf(w, "%13s%s\n", "", e.Comment)
+		}
+		fmt.Fprintf(w, "%13s%-6s  USED IN %s\n", "", e.Type, numbers(e.UsedIn))
+		for _, ref := range e.Refs {
+			line := fmt.Sprintf("%13s%-8s  %-6s  DEFINED IN %-8s  %s", "", mdl.Spelling(ref.Name), ref.Type, numbers(ref.Defs), ref.Comment)
+			fmt.Fprintln(w, strings.TrimRight(line, " "))
+		}
+	}
+}
+
+// WriteDocumentorHTML writes the documentor listing of a model as an
+// HTML page; equation numbers link to the referenced equations.
+func (mdl *Model) WriteDocumentorHTML(w io.Writer) {
+	title := html.EscapeString(mdl.Title)
+	links := func(list []int) string {
+		if len(list) == 0 {
+			return "-"
+		}
+		s := make([]string, len(list))
+		for i, n := range list {
+			s[i] = fmt.Sprintf("<a href=\"#eq%d\">%d</a>", n, n)
+		}
+		return strings.Join(s, ",")
+	}
+	fmt.Fprintln(w, "<!DOCTYPE html>")
+	fmt.Fprintf(w, "<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n", title)
+	if len(title) > 0 {
+		fmt.Fprintf(w, "<h1>%s</h1>\n", title)
+	}
+	fmt.Fprintln(w, "<table>")
+	fmt.Fprintln(w, "<tr><th>NO.</th><th>MODE</th><th>EQUATION</th><th>TYPE</th><th>USED IN</th><th>REFERENCES</th></tr>")
+	for _, e := range mdl.Documentor() {
+		fmt.Fprintf(w, "<tr id=\"eq%d\"><td>%d</td><td>%s</td><td><code>%s</code>", e.Number, e.Number, e.Mode, html.EscapeString(e.Stmt))
+		if len(e.Comment) > 0 {
+			fmt.Fprintf(w, "<br>%s", html.EscapeString(e.Comment))
+		}
+		fmt.Fprintf(w, "</td><td>%s</td><td>%s</td><td>", e.Type, links(e.UsedIn))
+		for i, ref := range e.Refs {
+			if i > 0 {
+				fmt.Fprint(w, "<br>")
+			}
+			fmt.Fprintf(w, "%s (%s) %s", html.EscapeString(mdl.Spelling(ref.Name)), ref.Type, links(ref.Defs))
+		}
+		fmt.Fprintln(w, "</td></tr>")
+	}
+	fmt.Fprintln(w, "</table>\n</body>\n</html>")
+}
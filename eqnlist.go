@@ -21,7 +21,9 @@ package dynamo
 //----------------------------------------------------------------------
 
 import (
+	"container/heap"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -33,6 +35,14 @@ import (
 // EqnList is a list of equations
 type EqnList struct {
 	eqns []*Equation
+	idx  *eqnIndex // where-used index (built on demand)
+}
+
+// eqnIndex maps variable names to equations
+type eqnIndex struct {
+	defs map[string][]*Equation // defining equations
+	deps map[string][]*Equation // equations depending on a variable
+	uses map[string][]*Equation // equations using a variable (dependency or reference)
 }
 
 // NewEqnList returns an empty equation list.
@@ -47,6 +57,7 @@ func (el *EqnList) Clone() *EqnList {
 	out := new(EqnList)
 	out.eqns = make([]*Equation, el.Len())
 	copy(out.eqns, el.eqns)
+	out.idx = el.idx
 	return out
 }
 
@@ -80,19 +91,46 @@ func (el *EqnList) Replace(eqn *Equation) {
 	for i, e := range el.eqns {
 		if el.match(e, eqn) {
 			el.eqns[i] = eqn
+			el.idx = nil
 			break
 		}
 	}
 }
 
-// Find a defining equation for given quantity
-func (el *EqnList) Find(name string) *Equation {
-	var list []*Equation
-	for _, e := range el.eqns {
-		if e.Target.Name == name {
-			list = append(list, e)
+// index returns the where-used index of the list (built if required).
+func (el *EqnList) index() *eqnIndex {
+	if el.idx != nil {
+		return el.idx
+	}
+	idx := &eqnIndex{
+		defs: make(map[string][]*Equation),
+		deps: make(map[string][]*Equation),
+		uses: make(map[string][]*Equation),
+	}
+	for _, eqn := range el.eqns {
+		idx.defs[eqn.Target.Name] = append(idx.defs[eqn.Target.Name], eqn)
+		seen := make(map[string]bool)
+		for _, d := range eqn.Dependencies {
+			if !seen[d.Name] {
+				idx.deps[d.Name] = append(idx.deps[d.Name], eqn)
+				idx.uses[d.Name] = append(idx.uses[d.Name], eqn)
+				seen[d.Name] = true
+			}
+		}
+		for _, r := range eqn.References {
+			if !seen[r.Name] {
+				idx.uses[r.Name] = append(idx.uses[r.Name], eqn)
+				seen[r.Name] = true
+			}
 		}
 	}
+	el.idx = idx
+	return idx
+}
+
+// Find a defining equation for given quantity
+func (el *EqnList) Find(name string) *Equation {
+	list := el.index().defs[name]
 	if len(list) == 0 {
 		return nil
 	}
@@ -104,29 +142,27 @@ func (el *EqnList) Find(name string) *Equation {
 	return list[0]
 }
 
-// Dependents return a list of equations that depend on a given variable.
-func (el *EqnList) Dependent(name string) *EqnList {
-	res := NewEqnList()
-loop:
-	for _, eqn := range el.eqns {
-		for _, dep := range eqn.Dependencies {
-			if dep.Name == name {
-				res.Add(eqn)
-				continue loop
-			}
-		}
-	}
-	return res
+// Dependent returns the equations that depend on a given variable.
+func (el *EqnList) Dependent(name string) []*Equation {
+	return el.index().deps[name]
+}
+
+// Uses returns the equations that use a given variable (as a dependency
+// or a reference).
+func (el *EqnList) Uses(name string) []*Equation {
+	return el.index().uses[name]
 }
 
 // Add an equation to the list.
 func (el *EqnList) Add(eqn *Equation) {
 	el.eqns = append(el.eqns, eqn)
+	el.idx = nil
 }
 
 // AddList appends an equation list.
 func (el *EqnList) AddList(list *EqnList) {
 	el.eqns = append(el.eqns, list.eqns...)
+	el.idx = nil
 }
 
 // List returns iterable equations.
@@ -151,8 +187,8 @@ func (el *EqnList) Len() int {
 }
 
 // Dump logs the current equation list in human-readable form into
-// the log stream.
-func (el *EqnList) Dump(verbose bool) {
+// the log stream (verbose messages).
+func (el *EqnList) Dump() {
 
 	// count equations by type
 	cnt := make(map[string]int)
@@ -166,24 +202,25 @@ func (el *EqnList) Dump(verbose bool) {
 	for _, e := range el.eqns {
 		incr(e.Mode)
 	}
-	Msg("-----------------------------------")
-	Msgf("   Number of equations: %4d\n", el.Len())
-	Msgf("       LEVEL equations: %4d\n", cnt["L"])
-	Msgf("        RATE equations: %4d\n", cnt["R"])
-	Msgf("         AUX equations: %4d\n", cnt["A"])
-	Msgf("       SUPPL equations: %4d\n", cnt["S"])
-	Msgf("       CONST equations: %4d\n", cnt["C"])
-	Msgf("        INIT equations: %4d\n", cnt["N"])
-	Msg("-----------------------------------")
-	if verbose {
-		for i, e := range el.eqns {
-			Msgf("   %5d: %s\n", i+1, e.String())
-			if len(e.Dependencies) > 0 {
-				Msgf("          Deps=%v\n", e.Dependencies)
-			}
-			if len(e.References) > 0 {
-				Msgf("          Refs=%v\n", e.References)
-			}
+	msgf := func(format string, args ...interface{}) {
+		Logf(LOG_VERBOSE, LOG_MODEL, format, args...)
+	}
+	msgf("-----------------------------------")
+	msgf("   Number of equations: %4d\n", el.Len())
+	msgf("       LEVEL equations: %4d\n", cnt["L"])
+	msgf("        RATE equations: %4d\n", cnt["R"])
+	msgf("         AUX equations: %4d\n", cnt["A"])
+	msgf("       SUPPL equations: %4d\n", cnt["S"])
+	msgf("       CONST equations: %4d\n", cnt["C"])
+	msgf("        INIT equations: %4d\n", cnt["N"])
+	msgf("-----------------------------------")
+	for i, e := range el.eqns {
+		msgf("   %5d: %s\n", i+1, e.String())
+		if len(e.Dependencies) > 0 {
+			msgf("          Deps=%v\n", e.Dependencies)
+		}
+		if len(e.References) > 0 {
+			msgf("          Refs=%v\n", e.References)
 		}
 	}
 }
@@ -229,8 +266,10 @@ func newEntry(i int, name string) *eqnEntry {
 func (el *EqnList) Sort(mdl *Model) (eqns *EqnList, res *Result) {
 	eqns = NewEqnList()
 
-	// Kahn's algorithm (1962) is used for sorting.
-	eqnSort := func(list, ref map[string]*eqnEntry) (out []int, res *Result) {
+	// Kahn's algorithm (1962) is used for sorting. If 'cyclic' is set,
+	// equations with cyclic dependencies are appended to the sorted list
+	// (in source order) instead of failing.
+	eqnSort := func(list, ref map[string]*eqnEntry, cyclic bool) (out []int, res *Result) {
 		res = Success()
 		for _, entry := range list {
 			eqn := el.eqns[entry.pos]
@@ -251,8 +290,8 @@ func (el *EqnList) Sort(mdl *Model) (eqns *EqnList, res *Result) {
 					_, ok = ref[d.Name]
 				}
 				if !ok {
-					Dbg.Msgf("Failed in %s:\n", eqn.String())
-					Dbg.Msgf(ErrModelUnknownEqn+": %s\n", d.Name)
+					mdl.Dbg.Msgf("Failed in %s:\n", eqn.String())
+					mdl.Dbg.Msgf(ErrModelUnknownEqn+": %s\n", d.Name)
 					res = Failure(ErrModelUnknownEqn+": %s", d.Name)
 					break
 				}
@@ -263,7 +302,15 @@ func (el *EqnList) Sort(mdl *Model) (eqns *EqnList, res *Result) {
 			S     []*eqnEntry // set of all nodes with no incoming edge
 			graph []*eqnEntry // list of pending nodes in graph
 		)
+		// process entries in source order (fixed evaluation order)
+		entries := make([]*eqnEntry, 0, len(list))
 		for _, entry := range list {
+			entries = append(entries, entry)
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].pos < entries[j].pos
+		})
+		for _, entry := range entries {
 			if len(entry.deps) == 0 {
 				S = append(S, entry)
 			} else {
@@ -285,11 +332,31 @@ func (el *EqnList) Sort(mdl *Model) (eqns *EqnList, res *Result) {
 			}
 			graph = newGraph
 		}
+		if len(graph) > 0 && cyclic {
+			// constants can't be cyclic
+			for _, e := range graph {
+				if el.eqns[e.pos].Mode == "C" {
+					cyclic = false
+					break
+				}
+			}
+		}
+		if len(graph) > 0 && cyclic {
+			sort.Slice(graph, func(i, j int) bool {
+				return graph[i].pos < graph[j].pos
+			})
+			Log(LOG_INFO, LOG_MODEL, "      Cyclic initial values (resolved iteratively):")
+			for _, e := range graph {
+				Logf(LOG_INFO, LOG_MODEL, "         %s\n", el.eqns[e.pos].String())
+			}
+			L = append(L, graph...)
+			graph = nil
+		}
 		if len(graph) > 0 {
-			Msg("Cyclic dependencies detected:")
+			Log(LOG_ERROR, LOG_MODEL, "Cyclic dependencies detected:")
 			for _, e := range graph {
 				eqn := el.eqns[e.pos]
-				Msgf(">> [%d] %s {%v}\n", e.pos, eqn.String(), e.deps)
+				Logf(LOG_ERROR, LOG_MODEL, ">> [%d] %s {%v}\n", e.pos, eqn.String(), e.deps)
 			}
 			res = Failure(ErrModelDependencyLoop)
 		} else {
@@ -301,16 +368,16 @@ func (el *EqnList) Sort(mdl *Model) (eqns *EqnList, res *Result) {
 		return
 	}
 
-	// we build two separate equation lists: one for non-levels ("C", "N", "A"
-	// and "R") and one for levels ("L").
-	Dbg.Msgf("SortEquations: Sorting %d equations...\n", el.Len())
+	// we build three separate equation lists: one for initial values ("C"
+	// and "N"), one for run-time equations ("A", "R" and "L") and one for
+	// supplements ("S").
+	mdl.Dbg.Msgf("SortEquations: Sorting %d equations...\n", el.Len())
 	eqnInit := make(map[string]*eqnEntry)
 	eqnRun := make(map[string]*eqnEntry)
-	var listSuppl []int
 	eqnSuppl := make(map[string]*eqnEntry)
 	for i, eqn := range el.eqns {
 		name := eqn.Target.Name
-		Dbg.Msgf("SortEquations << [%d] %s\n", i, eqn.String())
+		mdl.Dbg.Msgf("SortEquations << [%d] %s\n", i, eqn.String())
 		if strings.Contains("CN", eqn.Mode) {
 			if _, ok := eqnInit[name]; ok {
 				return nil, Failure(ErrModelVariabeExists+": [1] %s", name)
@@ -325,36 +392,205 @@ func (el *EqnList) Sort(mdl *Model) (eqns *EqnList, res *Result) {
 			if _, ok := eqnSuppl[name]; ok {
 				return nil, Failure(ErrModelVariabeExists+": [3] %s", name)
 			}
-			listSuppl = append(listSuppl, i)
+			eqnSuppl[name] = newEntry(i, name)
 		} else {
 			return nil, Failure(ErrModelEqnBadMode)
 		}
 	}
-	// sort both lists
-	var listInit, listRun []int
-	Dbg.Msg("Sorting eqnInit...")
-	if listInit, res = eqnSort(eqnInit, eqnRun); res.Ok {
-		Dbg.Msg("Sorting eqnRun...")
-		if listRun, res = eqnSort(eqnRun, eqnInit); res.Ok {
-			// build re-ordered equation list
-			for _, i := range listInit {
-				eqns.Add(el.eqns[i])
+	// supplements can depend on all other equations
+	eqnOther := make(map[string]*eqnEntry)
+	for name, entry := range eqnInit {
+		eqnOther[name] = entry
+	}
+	for name, entry := range eqnRun {
+		eqnOther[name] = entry
+	}
+	// sort all lists
+	var listInit, listRun, listSuppl []int
+	mdl.Dbg.Msg("Sorting eqnInit...")
+	if listInit, res = eqnSort(eqnInit, eqnRun, true); !res.Ok {
+		return
+	}
+	mdl.Dbg.Msg("Sorting eqnRun...")
+	if listRun, res = eqnSort(eqnRun, eqnInit, false); !res.Ok {
+		return
+	}
+	mdl.Dbg.Msg("Sorting eqnSuppl...")
+	if listSuppl, res = eqnSort(eqnSuppl, eqnOther, false); res.Ok {
+		// build re-ordered equation list
+		for _, i := range listInit {
+			eqns.Add(el.eqns[i])
+		}
+		for _, i := range listRun {
+			eqns.Add(el.eqns[i])
+		}
+		for _, i := range listSuppl {
+			eqns.Add(el.eqns[i])
+		}
+		mdl.Dbg.Msgf("SortEquations: Finishing %d equations...\n", el.Len())
+		for i, eqn := range eqns.List() {
+			mdl.Dbg.Msgf("SortEquations >> [%d] %s\n", i, eqn.String())
+		}
+		// build where-used index of the sorted list
+		eqns.index()
+	}
+	return
+}
+
+// Resort updates the order of a sorted equation list after an edit that
+// replaced or added equations: equations keep their relative order as long
+// as the equations they depend on are computed before them; all other
+// equations are moved behind their dependencies. Returns the re-ordered
+// list and the equations that changed order.
+func (el *EqnList) Resort(mdl *Model) (eqns *EqnList, moved []*Equation, res *Result) {
+	res = Success()
+
+	// split equations into groups (initial values, run-time equations and
+	// supplements) in current order.
+	var groups [3][]int
+	var defs [3]map[string]int
+	for g := range defs {
+		defs[g] = make(map[string]int)
+	}
+	for i, eqn := range el.eqns {
+		var g int
+		switch eqn.Mode {
+		case "C", "N":
+			g = 0
+		case "A", "R", "L":
+			g = 1
+		case "S":
+			g = 2
+		default:
+			return nil, nil, Failure(ErrModelEqnBadMode)
+		}
+		name := eqn.Target.Name
+		if _, ok := defs[g][name]; ok {
+			return nil, nil, Failure(ErrModelVariabeExists+": [%d] %s", g+1, name)
+		}
+		defs[g][name] = len(groups[g])
+		groups[g] = append(groups[g], i)
+	}
+	// references to other groups: initial values and run-time equations
+	// refer to each other, supplements can refer to both.
+	refs := [3][]int{{1}, {0}, {0, 1}}
+
+	// sort each group
+	eqns = NewEqnList()
+	for g, group := range groups {
+		// get dependencies within the group
+		users := make([][]int, len(group))
+		count := make([]int, len(group))
+		valid := true
+		for k, i := range group {
+			eqn := el.eqns[i]
+			seen := make(map[int]bool)
+			for _, d := range eqn.Dependencies {
+				if mdl.IsSystem(d.Name) {
+					continue
+				}
+				if j, ok := defs[g][d.Name]; ok {
+					if j != k && !seen[j] {
+						seen[j] = true
+						users[j] = append(users[j], k)
+						count[k]++
+						valid = valid && j < k
+					}
+					continue
+				}
+				ok := false
+				for _, r := range refs[g] {
+					if _, ok = defs[r][d.Name]; ok {
+						break
+					}
+				}
+				if !ok {
+					mdl.Dbg.Msgf("Failed in %s:\n", eqn.String())
+					return nil, nil, Failure(ErrModelUnknownEqn+": %s", d.Name)
+				}
 			}
-			for _, i := range listRun {
+		}
+		if valid {
+			// current order is still valid
+			for _, i := range group {
 				eqns.Add(el.eqns[i])
 			}
-			for _, i := range listSuppl {
-				eqns.Add(el.eqns[i])
+			continue
+		}
+		// Kahn's algorithm with equations ready for output processed in
+		// current order: the order of independent equations is kept.
+		ready := new(posHeap)
+		for k := range group {
+			if count[k] == 0 {
+				heap.Push(ready, k)
+			}
+		}
+		var order []int
+		for ready.Len() > 0 {
+			k := heap.Pop(ready).(int)
+			order = append(order, k)
+			for _, u := range users[k] {
+				if count[u]--; count[u] == 0 {
+					heap.Push(ready, u)
+				}
 			}
-			Dbg.Msgf("SortEquations: Finishing %d equations...\n", el.Len())
-			for i, eqn := range eqns.List() {
-				Dbg.Msgf("SortEquations >> [%d] %s\n", i, eqn.String())
+		}
+		if len(order) < len(group) {
+			// cyclic dependencies: allowed for initial values only (but
+			// not for constants)
+			var cycle []int
+			cyclic := g == 0
+			for k := range group {
+				if count[k] > 0 {
+					cycle = append(cycle, k)
+					cyclic = cyclic && el.eqns[group[k]].Mode != "C"
+				}
+			}
+			if !cyclic {
+				Log(LOG_ERROR, LOG_MODEL, "Cyclic dependencies detected:")
+				for _, k := range cycle {
+					Logf(LOG_ERROR, LOG_MODEL, ">> [%d] %s\n", group[k], el.eqns[group[k]].String())
+				}
+				return nil, nil, Failure(ErrModelDependencyLoop)
+			}
+			Log(LOG_INFO, LOG_MODEL, "      Cyclic initial values (resolved iteratively):")
+			for _, k := range cycle {
+				Logf(LOG_INFO, LOG_MODEL, "         %s\n", el.eqns[group[k]].String())
 			}
+			order = append(order, cycle...)
+		}
+		// an equation changed order if it is now behind an equation that
+		// was behind it before.
+		last := -1
+		for _, k := range order {
+			if k < last {
+				moved = append(moved, el.eqns[group[k]])
+			} else {
+				last = k
+			}
+			eqns.Add(el.eqns[group[k]])
 		}
 	}
+	// build where-used index of the sorted list
+	eqns.index()
 	return
 }
 
+// posHeap is a min-heap of equation positions.
+type posHeap []int
+
+func (h posHeap) Len() int            { return len(h) }
+func (h posHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h posHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *posHeap) Push(x interface{}) { *h = append(*h, x.(int)) }
+func (h *posHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
 //----------------------------------------------------------------------
 // Validate equations
 //----------------------------------------------------------------------
@@ -375,7 +611,7 @@ func (el *EqnList) Validate(mdl *Model) *Result {
 	for _, eqn := range el.eqns {
 		// check if equation has correct dependencies
 		if res := el.validateEqn(mdl, eqn, list); !res.Ok {
-			Dbg.Msgf("*** %s\n", eqn.String())
+			mdl.Dbg.Msgf("*** %s\n", eqn.String())
 			return res
 		}
 	}
@@ -384,6 +620,10 @@ func (el *EqnList) Validate(mdl *Model) *Result {
 
 // ValidateEqn checks a single equation for correctness.
 func (el *EqnList) validateEqn(mdl *Model, eqn *Equation, list map[string]*Equation) (res *Result) {
+	// system variables are protected
+	if modes, ok := sysModes[eqn.Target.Name]; ok && !strings.Contains(modes, eqn.Mode) {
+		return Failure(ErrModelSystemVar+": %s", eqn.String())
+	}
 
 	// check equation target and dependencies.
 	check := func(target *Class, deps []*Class) *Result {
@@ -404,13 +644,17 @@ func (el *EqnList) validateEqn(mdl *Model, eqn *Equation, list map[string]*Equat
 				if !ok {
 					// if the missing equation is for a constant, check if we
 					// have a matching initializer. If it is for a level, look
-					// for a matching auxilliary.
+					// for a matching auxilliary (or a supplement if referenced
+					// by a supplement).
 					if strings.HasSuffix(name, "/C") {
 						name = d.Name + "/I"
 						ref, ok = list[name]
 					} else if strings.HasSuffix(name, "/L") {
 						name = d.Name + "/A"
-						ref, ok = list[name]
+						if ref, ok = list[name]; !ok && eqn.Mode == "S" {
+							name = d.Name + "/S"
+							ref, ok = list[name]
+						}
 					}
 					if !ok {
 						return Failure(ErrModelUnknownEqn+": %s", name)
@@ -434,10 +678,12 @@ func (el *EqnList) validateEqn(mdl *Model, eqn *Equation, list map[string]*Equat
 	// perform validation
 	switch eqn.Mode {
 	case "C":
-		// Constant eqn.:  C=<number>
+		// Constant eqn.:  C=<number> or C={C}
 		res = check(
 			&Class{NAME_KIND_CONST, NAME_STAGE_NONE},
-			[]*Class{})
+			[]*Class{
+				{NAME_KIND_CONST, NAME_STAGE_NONE}, // constants
+			})
 	case "N":
 		// Initializer eqn.:  N={C, N}; can be used on levels, aux and rates
 		res = check(
@@ -447,7 +693,7 @@ func (el *EqnList) validateEqn(mdl *Model, eqn *Equation, list map[string]*Equat
 				{NAME_KIND_INIT, NAME_STAGE_NONE},  // initializers
 			})
 		if !res.Ok {
-			Msgf("   WARN: %s\n", res.Err.Error())
+			Logf(LOG_WARN, LOG_MODEL, "%s\n", res.Err.Error())
 			res = Success()
 		}
 	case "L":
@@ -473,7 +719,7 @@ func (el *EqnList) validateEqn(mdl *Model, eqn *Equation, list map[string]*Equat
 				{NAME_KIND_RATE, NAME_STAGE_OLD},   // rates
 			})
 		if !res.Ok {
-			Msgf("   WARN: %s\n", res.Err.Error())
+			Logf(LOG_WARN, LOG_MODEL, "%s\n", res.Err.Error())
 			res = Success()
 		}
 	case "A":
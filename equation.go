@@ -22,11 +22,12 @@ package dynamo
 
 import (
 	"go/ast"
-	"go/parser"
 	"go/token"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Dependency handling modes
@@ -45,33 +46,47 @@ const (
 
 // Equation represents a formula; the result is assigned to a variable
 type Equation struct {
-	Target       *Name    // Name of (indexed) variable (left side of equation)
-	Dependencies []*Name  // List of (indexed) dependencies from right side.
-	References   []*Name  // List of references on the right side (non-dependent)
-	Mode         string   // Mode of equation as given in the source
-	Formula      ast.Expr // formula in Go AST
-	stmt         string   // complete equation in DYNAMO notation
+	Target       *Name             // Name of (indexed) variable (left side of equation)
+	Dependencies []*Name           // List of (indexed) dependencies from right side.
+	References   []*Name           // List of references on the right side (non-dependent)
+	Mode         string            // Mode of equation as given in the source
+	Formula      ast.Expr          // formula in Go AST
+	Comment      string            // description of the equation (from source)
+	Meta         map[string]string // metadata (from NOTE lines)
+	Type         int               // type of computed values (TYPE_???)
+	stmt         string            // complete equation in DYNAMO notation
+}
+
+// isMultiConst returns true if a constant statement has multiple
+// assignments (separated by ',' or '/').
+func isMultiConst(stmt string) bool {
+	pos := strings.LastIndex(stmt, "=")
+	return strings.LastIndexAny(stmt[:pos], ",/") != -1
 }
 
 // NewEquation converts a statement into one or more equation instances
-func NewEquation(stmt *Line) (eqns *EqnList, res *Result) {
+// for a model.
+func NewEquation(stmt *Line, mdl *Model) (eqns *EqnList, res *Result) {
 	eqns = NewEqnList()
-	Dbg.Msgf("NewEquation(%s)\n", stmt.String())
+	dbg := mdl.Dbg
+	dbg.Msgf("NewEquation(%s)\n", stmt.String())
 
 	// check for spaces in equation
 	if strings.Contains(stmt.Stmt, " ") {
 		res = Failure(ErrParseInvalidSpace)
 		return
 	}
-	// Const statements can have multiple assignments in one line.
-	if stmt.Mode == "C" && strings.Count(stmt.Stmt, "=") > 1 {
+	// Const statements can have multiple assignments in one line (a
+	// single assignment can contain comparisons like "C F=IF(A=1,2,3)").
+	if stmt.Mode == "C" && strings.Count(stmt.Stmt, "=") > 1 && isMultiConst(stmt.Stmt) {
 		// add new extracted equation
 		addEqn := func(line string) (res *Result) {
 			var list *EqnList
 			if list, res = NewEquation(&Line{
-				Stmt: line,
-				Mode: "C",
-			}); res.Ok {
+				Stmt:    line,
+				Mode:    "C",
+				Comment: stmt.Comment,
+			}, mdl); res.Ok {
 				eqns.AddList(list)
 			}
 			return
@@ -87,7 +102,7 @@ func NewEquation(stmt *Line) (eqns *EqnList, res *Result) {
 					break
 				}
 			}
-			Dbg.Msgf("Delim: %d\n", delim)
+			dbg.Msgf("Delim: %d\n", delim)
 			if res = addEqn(line[delim+1:]); !res.Ok {
 				break
 			}
@@ -95,135 +110,230 @@ func NewEquation(stmt *Line) (eqns *EqnList, res *Result) {
 		}
 		return
 	}
-	// expand multiplication shortcut
-	line := strings.ReplaceAll(stmt.Stmt, ")(", ")*(")
-	// assignment work-around (HACK!)
-	line = strings.ReplaceAll(line, "=", "==")
-	// use Go to parse expression
-	expr, err := parser.ParseExpr(line)
-	if err != nil {
-		res = Failure(err)
+	// parse equation
+	target, formula, res := parseEquation(stmt.Stmt, mdl.Relaxed)
+	if !res.Ok {
 		return
 	}
-	switch x := expr.(type) {
-	case *ast.BinaryExpr:
-		// prepare equation instance
-		eqn := &Equation{
-			stmt:         stmt.Stmt,
-			Mode:         stmt.Mode,
-			Dependencies: make([]*Name, 0),
-			References:   make([]*Name, 0),
+	// prepare equation instance
+	eqn := &Equation{
+		stmt:         stmt.Stmt,
+		Mode:         stmt.Mode,
+		Comment:      stmt.Comment,
+		Dependencies: make([]*Name, 0),
+		References:   make([]*Name, 0),
+		Formula:      formula,
+	}
+	// comparisons compute booleans
+	if isRelation(formula) {
+		eqn.Type = TYPE_BOOLEAN
+	}
+	// Handle LEFT side of equation
+	if eqn.Target, res = NewName(target); !res.Ok {
+		return
+	}
+	switch stmt.Mode {
+	case "N":
+		if eqn.Target.Kind != NAME_KIND_CONST {
+			res = Failure(ErrModelEqnBadTargetKind)
+			return
 		}
-		eqn.Formula = x.Y
-
-		// Handle LEFT side of equation
-		if eqn.Target, res = NewName(x.X); !res.Ok {
+		eqn.Target.Kind = NAME_KIND_INIT
+	case "A":
+		if eqn.Target.Kind != NAME_KIND_LEVEL && eqn.Target.Kind != NAME_KIND_RATE {
+			res = Failure(ErrModelEqnBadTargetKind)
 			return
 		}
-		switch stmt.Mode {
-		case "N":
-			if eqn.Target.Kind != NAME_KIND_CONST {
-				res = Failure(ErrModelEqnBadTargetKind)
-				return
-			}
-			eqn.Target.Kind = NAME_KIND_INIT
-		case "A":
-			if eqn.Target.Kind != NAME_KIND_LEVEL && eqn.Target.Kind != NAME_KIND_RATE {
-				res = Failure(ErrModelEqnBadTargetKind)
-				return
-			}
-			eqn.Target.Kind = NAME_KIND_AUX
-			if eqn.Target.Stage != NAME_STAGE_NEW {
-				res = Failure(ErrModelEqnBadTargetStage)
-				return
-			}
-		case "S":
-			if eqn.Target.Kind != NAME_KIND_LEVEL {
-				res = Failure(ErrModelEqnBadTargetKind)
-				return
-			}
-			eqn.Target.Kind = NAME_KIND_SUPPL
-			if eqn.Target.Stage != NAME_STAGE_NEW {
-				res = Failure(ErrModelEqnBadTargetStage)
-				return
-			}
+		eqn.Target.Kind = NAME_KIND_AUX
+		if eqn.Target.Stage != NAME_STAGE_NEW {
+			res = Failure(ErrModelEqnBadTargetStage)
+			return
+		}
+	case "S":
+		if eqn.Target.Kind != NAME_KIND_LEVEL {
+			res = Failure(ErrModelEqnBadTargetKind)
+			return
+		}
+		eqn.Target.Kind = NAME_KIND_SUPPL
+		if eqn.Target.Stage != NAME_STAGE_NEW {
+			res = Failure(ErrModelEqnBadTargetStage)
+			return
 		}
+	}
 
-		// Handle RIGHT side of equation recursively
-		var check func(ast.Expr, int) *Result
-		check = func(f ast.Expr, mode int) (res *Result) {
-			res = Success()
-			switch x := f.(type) {
-			case *ast.Ident, *ast.SelectorExpr:
-				var name *Name
-				if name, res = NewName(x); res.Ok {
-					if stmt.Mode == "N" {
-						name.Stage = NAME_STAGE_NONE
-					}
-					// add variable as dependency or reference
-					if (mode == DEP_NORMAL && name.Stage != NAME_STAGE_OLD) || mode == DEP_ENFORCE {
-						eqn.Dependencies = append(eqn.Dependencies, name)
-					} else {
-						eqn.References = append(eqn.References, name)
-					}
-				}
-			case *ast.BinaryExpr:
-				if res = check(x.X, mode); res.Ok {
-					res = check(x.Y, mode)
+	// integrators and controllers are replaced by hidden levels
+	if formula, res = mdl.expand(formula, stmt.Mode, eqns); !res.Ok {
+		return
+	}
+	eqn.Formula = formula
+
+	// Handle RIGHT side of equation recursively
+	var check func(ast.Expr, int) *Result
+	check = func(f ast.Expr, mode int) (res *Result) {
+		res = Success()
+		switch x := f.(type) {
+		case *ast.Ident, *ast.SelectorExpr:
+			var name *Name
+			if name, res = NewName(x); res.Ok {
+				if stmt.Mode == "N" {
+					name.Stage = NAME_STAGE_NONE
 				}
-			case *ast.ParenExpr:
-				res = check(x.X, mode)
-			case *ast.BasicLit:
-				// skipped intentionally
-			case *ast.UnaryExpr:
-				res = check(x.X, mode)
-			case *ast.CallExpr:
-				// get function name
-				var name *Name
-				if name, res = NewName(x.Fun); !res.Ok {
-					break
+				// add variable as dependency or reference
+				if (mode == DEP_NORMAL && name.Stage != NAME_STAGE_OLD) || mode == DEP_ENFORCE {
+					eqn.Dependencies = append(eqn.Dependencies, name)
+				} else {
+					eqn.References = append(eqn.References, name)
 				}
-				// check for function availibility
-				Dbg.Msgf("Calling '%s'\n", name.Name)
-				var (
-					intern []ast.Expr
-					modes  []int
-				)
-				if modes, intern, res = HasFunction(name.Name, x.Args); !res.Ok {
+			}
+		case *ast.BinaryExpr:
+			if res = check(x.X, mode); res.Ok {
+				res = check(x.Y, mode)
+			}
+		case *ast.ParenExpr:
+			res = check(x.X, mode)
+		case *ast.BasicLit:
+			// skipped intentionally
+		case *ast.UnaryExpr:
+			res = check(x.X, mode)
+		case *ast.CallExpr:
+			// get function name
+			var name *Name
+			if name, res = NewName(x.Fun); !res.Ok {
+				break
+			}
+			// check for function availibility
+			dbg.Msgf("Calling '%s'\n", name.Name)
+			var (
+				intern []ast.Expr
+				modes  []int
+			)
+			if modes, intern, res = HasFunction(name.Name, x.Args, mdl); !res.Ok {
+				break
+			}
+			// check function arguments
+			for i, arg := range x.Args {
+				if res = check(arg, modes[i]); !res.Ok {
 					break
 				}
-				// check function arguments
-				for i, arg := range x.Args {
-					if res = check(arg, modes[i]); !res.Ok {
-						break
-					}
-				}
-				// add internal variable
-				x.Args = append(x.Args, intern...)
-
-			default:
-				res = Failure(ErrParseSyntax+": %v\n", reflect.TypeOf(x))
 			}
-			return
-		}
+			// add internal variable
+			x.Args = append(x.Args, intern...)
+			// resolve arguments
+			if res.Ok {
+				_, res = mdl.args(x)
+			}
 
-		res = check(x.Y, DEP_NORMAL)
-		if res.Ok {
-			eqns.Add(eqn)
+		default:
+			res = Failure(ErrParseSyntax+": %v\n", reflect.TypeOf(x))
 		}
 		return
+	}
 
-	default:
-		res = Failure(ErrParseSyntax+": %v\n", reflect.TypeOf(x))
+	res = check(formula, DEP_NORMAL)
+	if res.Ok {
+		eqns.Add(eqn)
 	}
 	return
 }
 
+// expand replaces calls of INTEG(RATE,INIT) in a formula with hidden
+// levels: the equations "L _1.K=_1.J+DT*_2.JK", "N _1=INIT" and
+// "R _2.KL=RATE" are added to the list of equations and the call is
+// replaced by "_1.K". Controllers are expanded into their formulas:
+// PCTRL(STOCK,GOAL,KP) is "KP*(GOAL-STOCK)" and PICTRL(STOCK,GOAL,KP,KI)
+// is "KP*(GOAL-STOCK)+KI*INTEG(GOAL-STOCK,0)".
+func (mdl *Model) expand(x ast.Expr, mode string, eqns *EqnList) (ast.Expr, *Result) {
+	res := Success()
+	switch e := x.(type) {
+	case *ast.ParenExpr:
+		e.X, res = mdl.expand(e.X, mode, eqns)
+	case *ast.UnaryExpr:
+		e.X, res = mdl.expand(e.X, mode, eqns)
+	case *ast.BinaryExpr:
+		if e.X, res = mdl.expand(e.X, mode, eqns); res.Ok {
+			e.Y, res = mdl.expand(e.Y, mode, eqns)
+		}
+	case *ast.CallExpr:
+		for i, arg := range e.Args {
+			if e.Args[i], res = mdl.expand(arg, mode, eqns); !res.Ok {
+				return x, res
+			}
+		}
+		fcn, ok := e.Fun.(*ast.Ident)
+		if !ok {
+			break
+		}
+		switch fcn.Name {
+		case "PCTRL", "PICTRL":
+			if n := map[string]int{"PCTRL": 3, "PICTRL": 4}[fcn.Name]; len(e.Args) != n {
+				return x, Failure(ErrParseInvalidNumArgs)
+			}
+			dev := &ast.ParenExpr{X: &ast.BinaryExpr{X: e.Args[1], Op: token.SUB, Y: e.Args[0]}}
+			var ctrl ast.Expr = &ast.BinaryExpr{X: e.Args[2], Op: token.MUL, Y: dev}
+			if fcn.Name == "PICTRL" {
+				integ := &ast.CallExpr{
+					Fun:  &ast.Ident{Name: "INTEG"},
+					Args: []ast.Expr{dev, &ast.BasicLit{Kind: token.INT, Value: "0"}},
+				}
+				ctrl = &ast.BinaryExpr{
+					X:  ctrl,
+					Op: token.ADD,
+					Y:  &ast.BinaryExpr{X: e.Args[3], Op: token.MUL, Y: integ},
+				}
+			}
+			return mdl.expand(&ast.ParenExpr{X: ctrl}, mode, eqns)
+		case "INTEG":
+		default:
+			return x, res
+		}
+		if !strings.Contains("ARS", mode) {
+			return x, Failure(ErrModelFunction+": INTEG in %s equation", mode)
+		}
+		if len(e.Args) != 2 {
+			return x, Failure(ErrParseInvalidNumArgs)
+		}
+		lvl, rate := mdl.NewAutoVar(), mdl.NewAutoVar()
+		for _, stmt := range []*Line{
+			{Mode: "L", Stmt: lvl + ".K=" + lvl + ".J+DT*" + rate + ".JK"},
+			{Mode: "N", Stmt: lvl + "=" + formatExpr(e.Args[1])},
+			{Mode: "R", Stmt: rate + ".KL=" + formatExpr(e.Args[0])},
+		} {
+			list, res := NewEquation(stmt, mdl)
+			if !res.Ok {
+				return x, res
+			}
+			eqns.AddList(list)
+		}
+		return &ast.SelectorExpr{X: &ast.Ident{Name: lvl}, Sel: &ast.Ident{Name: "K"}}, Success()
+	}
+	return x, res
+}
+
 // String returns a human-readable equation formula.
 func (eqn *Equation) String() string {
 	return "'" + eqn.Mode + ":" + eqn.stmt + "'"
 }
 
+// Statement returns the equation in DYNAMO notation.
+func (eqn *Equation) Statement() string {
+	return eqn.stmt
+}
+
+// Tables returns the names of tables used in the formula.
+func (eqn *Equation) Tables() (list []string) {
+	ast.Inspect(eqn.Formula, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && len(call.Args) > 0 {
+			if fcn, ok := call.Fun.(*ast.Ident); ok && tableFcns[fcn.Name] {
+				if name, res := NewName(call.Args[0]); res.Ok {
+					list = append(list, name.Name)
+				}
+			}
+		}
+		return true
+	})
+	return
+}
+
 // DependsOn returns true if a variable is referenced in the formula.
 func (eqn *Equation) DependsOn(v *Name) bool {
 	for _, d := range eqn.Dependencies {
@@ -240,10 +350,14 @@ func (eqn *Equation) DependsOn(v *Name) bool {
 // If the 'ini' flag is set, the initial value is computed by treating all
 // quantity references in "initial value" form.
 func (eqn *Equation) Eval(mdl *Model) (val Variable, res *Result) {
-	Dbg.Msgf("----------------------------\n")
-	Dbg.Msgf("Evaluating: %s\n", eqn.String())
+	mdl.Dbg.Tracef("----------------------------\n")
+	mdl.Dbg.Tracef("Evaluating: %s\n", eqn.String())
+	if mdl.Profile != nil {
+		defer mdl.Profile.eqn(eqn, time.Now())
+	}
 	missing := make(map[string]*Name)
 	if val, res = eval(eqn.Formula, mdl, missing); res.Ok {
+		val = val.Typed(eqn.Type)
 		res = mdl.Set(eqn.Target, val)
 
 		// if we have missing variables, check the terminal equations
@@ -255,8 +369,7 @@ func (eqn *Equation) Eval(mdl *Model) (val Variable, res *Result) {
 			for depth := 0; depth < maxDepth && len(targets) > 0; depth++ {
 				nextTargets := make(map[string]*Equation)
 				for name := range targets {
-					list := mdl.Eqns.Dependent(name)
-					for _, e := range list.eqns {
+					for _, e := range mdl.Eqns.Dependent(name) {
 						if e.Mode != "S" {
 							nextTargets[e.Target.Name] = e
 						}
@@ -265,10 +378,15 @@ func (eqn *Equation) Eval(mdl *Model) (val Variable, res *Result) {
 				targets = nextTargets
 			}
 			// if not all terminal equations are supplementary,
-			// give warnings for missing variables.
-			if len(targets) > 0 {
+			// give warnings for missing variables (or collect them
+			// while initializing the model).
+			if mdl.unresolved != nil {
+				for name, n := range missing {
+					mdl.unresolved[name] = n
+				}
+			} else if len(targets) > 0 {
 				for _, name := range missing {
-					Msgf("WARN: Missing variable %s", name)
+					Logf(LOG_WARN, LOG_RUN, "Missing variable %s", name)
 				}
 			}
 		}
@@ -299,7 +417,11 @@ func eval(expr ast.Expr, mdl *Model, missing map[string]*Name) (val Variable, re
 		case token.QUO:
 			val = left / right
 		default:
-			res = Failure(ErrParseInvalidOp+": %d", x.Op)
+			if v, ok := relation(x.Op, float64(left), float64(right)); ok {
+				val = Variable(v)
+			} else {
+				res = Failure(ErrParseInvalidOp+": %d", x.Op)
+			}
 		}
 		return
 
@@ -332,52 +454,32 @@ func eval(expr ast.Expr, mdl *Model, missing map[string]*Name) (val Variable, re
 		if name, res = NewName(x.Fun); !res.Ok {
 			break
 		}
-		// convert arguments to strings
-		args := make([]string, len(x.Args))
-		for i, arg := range x.Args {
-			switch x := arg.(type) {
-			case *ast.Ident:
-				args[i] = x.Name
-			case *ast.SelectorExpr:
-				var n *Name
-				if n, res = NewName(x); !res.Ok {
-					return
-				}
-				name := n.Name
-				if idx := n.GetIndex(); len(idx) > 0 {
-					name += idx
-				}
-				args[i] = name
-			case *ast.BasicLit:
-				args[i] = x.Value
-			case *ast.BinaryExpr:
-				if val, res = eval(x, mdl, missing); !res.Ok {
-					return
-				}
-				args[i] = val.String()
-			case *ast.ParenExpr:
-				if val, res = eval(x, mdl, missing); !res.Ok {
-					return
-				}
-				args[i] = val.String()
-			case *ast.UnaryExpr:
-				if val, res = eval(x.X, mdl, missing); !res.Ok {
-					break
-				}
-				switch x.Op {
-				case token.SUB:
-					val = -val
-				default:
-					res = Failure(ErrParseInvalidOp+": %d", x.Op)
+		// only the selected branch of a conditional is evaluated
+		if name.Name == "IFTHENELSE" && len(x.Args) == 3 {
+			var cond Variable
+			if cond, res = eval(x.Args[0], mdl, missing); !res.Ok {
+				break
+			}
+			if cond.Compare(0) != 0 {
+				val, res = eval(x.Args[1], mdl, missing)
+			} else {
+				val, res = eval(x.Args[2], mdl, missing)
+			}
+			break
+		}
+		// get resolved arguments (and evaluate expressions)
+		var args []*Arg
+		if args, res = mdl.args(x); !res.Ok {
+			break
+		}
+		for _, arg := range args {
+			if arg.Kind == ARG_EXPR {
+				if arg.val, res = eval(arg.expr, mdl, missing); !res.Ok {
 					return
 				}
-				args[i] = val.String()
-			default:
-				res = Failure(ErrModelFunctionArg+": %s", reflect.TypeOf(x))
-				return
 			}
 		}
-		val, res = CallFunction(name.Name, args, mdl)
+		val, res = callFunction(name.Name, args, mdl)
 
 	case *ast.UnaryExpr:
 		if val, res = eval(x.X, mdl, missing); !res.Ok {
@@ -395,3 +497,33 @@ func eval(expr ast.Expr, mdl *Model, missing map[string]*Name) (val Variable, re
 	}
 	return
 }
+
+// EvalExpr evaluates a standalone DYNAMO expression (like
+// "A*TABLE(T,X,0,1,.2)") for given variable bindings and tables; all
+// quantities in the expression (regardless of their time postfix) are
+// taken from the bindings. This allows the testing of single formulas
+// outside of a model.
+func EvalExpr(expr string, bindings State, tables map[string]*Table) (val Variable, res *Result) {
+	mdl := NewModel("", "")
+	for name, v := range bindings {
+		mdl.Current[name] = v
+		mdl.Last[name] = v
+	}
+	for name, tbl := range tables {
+		mdl.Tables[name] = tbl
+	}
+	var eqns *EqnList
+	if eqns, res = NewEquation(&Line{Stmt: "EXPR=" + expr, Mode: "C"}, mdl); !res.Ok {
+		return
+	}
+	missing := make(map[string]*Name)
+	if val, res = eval(eqns.eqns[0].Formula, mdl, missing); res.Ok && len(missing) > 0 {
+		var names []string
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		res = Failure(ErrModelNoVariable+": %s", strings.Join(names, ","))
+	}
+	return
+}
@@ -0,0 +1,105 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"strings"
+)
+
+//======================================================================
+// EXIT CODES -- The outcome of processing a model is reported with a
+// distinct exit code and a one-line summary, so scripts can branch on
+// the outcome:
+//
+//     DYNAMO status=PARSE exit=2 runs=0 warnings=0 code=206 line=5 error="..."
+//======================================================================
+
+// Exit codes
+const (
+	EXIT_OK       = 0 // model processed without problems
+	EXIT_FAILURE  = 1 // other failures (like usage or file errors)
+	EXIT_PARSE    = 2 // parse error
+	EXIT_MODEL    = 3 // validation error
+	EXIT_RUN      = 4 // runtime error
+	EXIT_WARNINGS = 5 // model processed with warnings
+)
+
+// status names of exit codes (used in summaries)
+var exitNames = []string{"OK", "FAILURE", "PARSE", "MODEL", "RUN", "WARNINGS"}
+
+// runtimeErrors are model errors that occur while running a model; all
+// other model errors are validation errors.
+var runtimeErrors = []string{
+	ErrModelFunctionArg,
+	ErrModelNoVariable,
+	ErrModelMaxRetry,
+	ErrModelNoData,
+	ErrModelFunction,
+	ErrModelRunMismatch,
+	ErrModelNotRunning,
+	ErrModelNoHistory,
+	ErrModelNoSnapshot,
+	ErrModelRunning,
+	ErrModelReplay,
+	ErrModelBounds,
+	ErrModelAnomaly,
+}
+
+// ExitCode returns the exit code for the result of processing a model
+// with given number of warnings.
+func ExitCode(res *Result, warnings int) int {
+	if res == nil || res.Ok {
+		if warnings > 0 {
+			return EXIT_WARNINGS
+		}
+		return EXIT_OK
+	}
+	code := res.Code()
+	switch {
+	case code >= 200 && code < 300:
+		return EXIT_PARSE
+	case code >= 100 && code < 200:
+		for _, msg := range runtimeErrors {
+			if errKinds[msg].Code == code {
+				return EXIT_RUN
+			}
+		}
+		return EXIT_MODEL
+	case code >= 300 && code < 400:
+		// output errors
+		return EXIT_RUN
+	}
+	return EXIT_FAILURE
+}
+
+// Summary returns a machine-parseable one-line summary of processing a
+// model: the status and exit code, the number of completed runs and
+// warnings and the failure (error code, line number and message).
+func Summary(res *Result, runs, warnings int) string {
+	exit := ExitCode(res, warnings)
+	s := fmt.Sprintf("DYNAMO status=%s exit=%d runs=%d warnings=%d", exitNames[exit], exit, runs, warnings)
+	if res != nil && !res.Ok {
+		msg := strings.ReplaceAll(res.Err.Error(), "\n", " ")
+		s += fmt.Sprintf(" code=%d line=%d error=%q", res.Code(), res.Line, msg)
+	}
+	return s
+}
@@ -0,0 +1,124 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Explain writes the dependency trees of a variable: the upstream tree
+// (variables that drive it) and the downstream tree (variables that
+// depend on it) with depths and kinds. Variables that are already listed
+// are not expanded again.
+func (mdl *Model) Explain(w io.Writer, name string) (res *Result) {
+	res = Success()
+	eqns := mdl.equations()
+
+	// collect defining equations and users of variables
+	defs := make(map[string][]*Equation)
+	users := make(map[string]map[string]bool)
+	for _, eqn := range eqns.List() {
+		target := eqn.Target.Name
+		defs[target] = append(defs[target], eqn)
+		for _, list := range [][]*Name{eqn.Dependencies, eqn.References} {
+			for _, n := range list {
+				if users[n.Name] == nil {
+					users[n.Name] = make(map[string]bool)
+				}
+				users[n.Name][target] = true
+			}
+		}
+	}
+	if _, ok := defs[name]; !ok {
+		if _, ok = mdl.Tables[name]; !ok {
+			return Failure(ErrModelNoVariable+": %s", name)
+		}
+	}
+	// kind of a variable
+	kind := func(name string) string {
+		if _, ok := mdl.Tables[name]; ok {
+			return "TABLE"
+		}
+		if mdl.IsSystem(name) {
+			return "SYSTEM"
+		}
+		var kinds []string
+		for _, eqn := range defs[name] {
+			kinds = append(kinds, glossaryTypes[eqn.Mode])
+		}
+		return strings.Join(kinds, "/")
+	}
+	// upstream variables
+	inputs := func(name string) (list []string) {
+		seen := make(map[string]bool)
+		for _, eqn := range defs[name] {
+			for _, deps := range [][]*Name{eqn.Dependencies, eqn.References} {
+				for _, n := range deps {
+					if n.Name[0] == '_' || n.Name == name || seen[n.Name] {
+						continue
+					}
+					seen[n.Name] = true
+					list = append(list, n.Name)
+				}
+			}
+		}
+		sort.Strings(list)
+		return
+	}
+	// downstream variables
+	outputs := func(name string) (list []string) {
+		for user := range users[name] {
+			if user[0] != '_' && user != name {
+				list = append(list, user)
+			}
+		}
+		sort.Strings(list)
+		return
+	}
+	// print tree
+	var tree func(name string, depth int, next func(string) []string, done map[string]bool)
+	tree = func(name string, depth int, next func(string) []string, done map[string]bool) {
+		for _, n := range next(name) {
+			line := fmt.Sprintf("%s[%d] %s (%s)", strings.Repeat("  ", depth), depth, n, kind(n))
+			if done[n] {
+				fmt.Fprintln(w, line+" ...")
+				continue
+			}
+			fmt.Fprintln(w, line)
+			done[n] = true
+			tree(n, depth+1, next, done)
+		}
+	}
+	fmt.Fprintf(w, "%s (%s)\n", name, kind(name))
+	for _, eqn := range defs[name] {
+		fmt.Fprintf(w, "    %s %s\n", eqn.Mode, eqn.Statement())
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Upstream (drives the variable):")
+	tree(name, 1, inputs, map[string]bool{name: true})
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Downstream (depends on the variable):")
+	tree(name, 1, outputs, map[string]bool{name: true})
+	return
+}
@@ -0,0 +1,521 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//======================================================================
+// EXPRESSIONS -- Equations are parsed by a parser for the DYNAMO token
+// set: names (with time postfixes and sector qualifiers), numbers (with
+// exponents), quoted strings, the operators '+', '-', '*' and '/' (and
+// '**' in relaxed mode), comparisons ('<', '<=', '>', '>=', '=', '<>'),
+// parentheses and commas. A closing parenthesis directly followed by an
+// opening one is a multiplication ("(A)(B)"). Conditionals ("IF(C,A,B)"
+// or "C ? A : B" in relaxed mode) are calls of IFTHENELSE.
+// The parse tree uses the expression nodes of the Go AST.
+//======================================================================
+
+// expression token kinds
+const (
+	TOK_EOF    = iota // end of expression
+	TOK_NAME          // name (part)
+	TOK_NUMBER        // number literal
+	TOK_STRING        // quoted string
+	TOK_OP            // operator or delimiter
+)
+
+// exprToken is a token in an expression
+type exprToken struct {
+	kind int    // kind of token (TOK_???)
+	text string // text of token
+	pos  int    // position in expression (starting at 1)
+}
+
+// String returns a human-readable token for error messages.
+func (t *exprToken) String() string {
+	if t.kind == TOK_EOF {
+		return "end of statement"
+	}
+	return "'" + t.text + "'"
+}
+
+// scanExpr splits an expression into tokens.
+func scanExpr(src string) (list []*exprToken, res *Result) {
+	isName := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+	}
+	isDigit := func(i int) bool {
+		return i < len(src) && src[i] >= '0' && src[i] <= '9'
+	}
+	for i := 0; i < len(src); {
+		r, n := utf8.DecodeRuneInString(src[i:])
+		tok := &exprToken{pos: utf8.RuneCountInString(src[:i]) + 1}
+		start := i
+		switch {
+		case r == ' ' || r == '\t':
+			i += n
+			continue
+
+		case unicode.IsLetter(r) || r == '_':
+			tok.kind = TOK_NAME
+			for i < len(src) {
+				if r, n = utf8.DecodeRuneInString(src[i:]); !isName(r) {
+					break
+				}
+				i += n
+			}
+
+		case isDigit(i) || (r == '.' && isDigit(i+1) && !afterName(list)):
+			tok.kind = TOK_NUMBER
+			for isDigit(i) {
+				i++
+			}
+			if i < len(src) && src[i] == '.' {
+				for i++; isDigit(i); i++ {
+				}
+			}
+			if i < len(src) && (src[i] == 'E' || src[i] == 'e') {
+				j := i + 1
+				if j < len(src) && (src[j] == '+' || src[j] == '-') {
+					j++
+				}
+				if isDigit(j) {
+					for i = j; isDigit(i); i++ {
+					}
+				}
+			}
+
+		case r == '"':
+			tok.kind = TOK_STRING
+			end := strings.IndexByte(src[i+1:], '"')
+			if end == -1 {
+				return nil, Failure(ErrParseSyntax+": unterminated string at position %d", tok.pos)
+			}
+			i += end + 2
+
+		case strings.HasPrefix(src[i:], "**") || strings.HasPrefix(src[i:], "<=") ||
+			strings.HasPrefix(src[i:], ">=") || strings.HasPrefix(src[i:], "<>"):
+			tok.kind = TOK_OP
+			i += 2
+
+		case strings.ContainsRune("+-*/(),.=<>?:", r):
+			tok.kind = TOK_OP
+			i++
+
+		default:
+			return nil, Failure(ErrParseSyntax+": invalid character '%c' at position %d", r, tok.pos)
+		}
+		tok.text = src[start:i]
+		list = append(list, tok)
+	}
+	list = append(list, &exprToken{kind: TOK_EOF, pos: utf8.RuneCountInString(src) + 1})
+	return list, Success()
+}
+
+// afterName returns true if the last token is a name (a following '.'
+// separates name parts).
+func afterName(list []*exprToken) bool {
+	return len(list) > 0 && list[len(list)-1].kind == TOK_NAME
+}
+
+// parseNumber returns the value of a number literal with an optional sign
+// (like "-3.5E-2"). Numbers in statements (tables, SPEC, function
+// arguments) follow the same rules as numbers in expressions; Go-only
+// forms like "Inf", "NaN" or "0x1p4" are rejected.
+func parseNumber(s string) (val float64, ok bool) {
+	s = strings.TrimSpace(s)
+	num := s
+	if len(num) > 0 && (num[0] == '+' || num[0] == '-') {
+		num = num[1:]
+	}
+	list, res := scanExpr(num)
+	if !res.Ok || len(list) != 2 || list[0].kind != TOK_NUMBER {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	return v, err == nil
+}
+
+// exprParser is a recursive descent parser for DYNAMO expressions
+type exprParser struct {
+	toks    []*exprToken // list of tokens
+	pos     int          // index of current token
+	relaxed bool         // accept relaxed syntax ('**' and '?:' operators)
+}
+
+// parseEquation parses an equation "target=formula" into the target name
+// and the formula expression.
+func parseEquation(src string, relaxed bool) (target, formula ast.Expr, res *Result) {
+	p := &exprParser{relaxed: relaxed}
+	if p.toks, res = scanExpr(src); !res.Ok {
+		return
+	}
+	if target, res = p.name(); !res.Ok {
+		return
+	}
+	if res = p.expect("="); !res.Ok {
+		return
+	}
+	if formula, res = p.cond(); res.Ok && p.peek().kind != TOK_EOF {
+		res = p.fail()
+	}
+	return
+}
+
+// peek returns the current token.
+func (p *exprParser) peek() *exprToken {
+	return p.toks[p.pos]
+}
+
+// next returns the current token and advances to the next one.
+func (p *exprParser) next() *exprToken {
+	tok := p.toks[p.pos]
+	if tok.kind != TOK_EOF {
+		p.pos++
+	}
+	return tok
+}
+
+// isOp returns true if the current token is the given operator.
+func (p *exprParser) isOp(op string) bool {
+	tok := p.peek()
+	return tok.kind == TOK_OP && tok.text == op
+}
+
+// expect the given operator as current token.
+func (p *exprParser) expect(op string) *Result {
+	if !p.isOp(op) {
+		return p.fail()
+	}
+	p.next()
+	return Success()
+}
+
+// fail with an unexpected current token.
+func (p *exprParser) fail() *Result {
+	tok := p.peek()
+	return Failure(ErrParseSyntax+": unexpected %s at position %d", tok, tok.pos)
+}
+
+// relOps are the comparison operators
+var relOps = map[string]token.Token{
+	"<":  token.LSS,
+	"<=": token.LEQ,
+	">":  token.GTR,
+	">=": token.GEQ,
+	"=":  token.EQL,
+	"<>": token.NEQ,
+}
+
+// cond = expr [ '?' cond ':' cond ] (relaxed syntax only); a conditional
+// is a call of IFTHENELSE.
+func (p *exprParser) cond() (x ast.Expr, res *Result) {
+	if x, res = p.expr(); !res.Ok || !p.isOp("?") {
+		return
+	}
+	if !p.relaxed {
+		return nil, Failure(ErrParseSyntax+": operator '?' in classic syntax at position %d", p.peek().pos)
+	}
+	p.next()
+	var a, b ast.Expr
+	if a, res = p.cond(); !res.Ok {
+		return
+	}
+	if res = p.expect(":"); !res.Ok {
+		return
+	}
+	if b, res = p.cond(); !res.Ok {
+		return
+	}
+	x = &ast.CallExpr{
+		Fun:  &ast.Ident{Name: "IFTHENELSE"},
+		Args: []ast.Expr{x, a, b},
+	}
+	return
+}
+
+// expr = sum [ ('<'|'<='|'>'|'>='|'='|'<>') sum ]; a comparison is 1 if
+// true and 0 otherwise.
+func (p *exprParser) expr() (x ast.Expr, res *Result) {
+	if x, res = p.sum(); !res.Ok {
+		return
+	}
+	if tok := p.peek(); tok.kind == TOK_OP {
+		if op, ok := relOps[tok.text]; ok {
+			p.next()
+			var y ast.Expr
+			if y, res = p.sum(); res.Ok {
+				x = &ast.BinaryExpr{X: x, Op: op, Y: y}
+			}
+		}
+	}
+	return
+}
+
+// relation returns the result of a comparison (1 if true, 0 if false);
+// values are compared within the tolerance of compare(). Returns false
+// if the operator is not a comparison.
+func relation(op token.Token, u, v float64) (val float64, ok bool) {
+	var rel bool
+	c := compare(u, v)
+	switch op {
+	case token.LSS:
+		rel = c < 0
+	case token.LEQ:
+		rel = c <= 0
+	case token.GTR:
+		rel = c > 0
+	case token.GEQ:
+		rel = c >= 0
+	case token.EQL:
+		rel = c == 0
+	case token.NEQ:
+		rel = c != 0
+	default:
+		return 0, false
+	}
+	if rel {
+		val = 1
+	}
+	return val, true
+}
+
+// isRelation returns true if an expression is a comparison.
+func isRelation(x ast.Expr) bool {
+	for {
+		switch e := x.(type) {
+		case *ast.ParenExpr:
+			x = e.X
+			continue
+		case *ast.BinaryExpr:
+			_, ok := relation(e.Op, 0, 0)
+			return ok
+		}
+		return false
+	}
+}
+
+// sum = term { ('+'|'-') term }
+func (p *exprParser) sum() (x ast.Expr, res *Result) {
+	if x, res = p.term(); !res.Ok {
+		return
+	}
+	for p.isOp("+") || p.isOp("-") {
+		op := token.ADD
+		if p.next().text == "-" {
+			op = token.SUB
+		}
+		var y ast.Expr
+		if y, res = p.term(); !res.Ok {
+			return
+		}
+		x = &ast.BinaryExpr{X: x, Op: op, Y: y}
+	}
+	return
+}
+
+// term = unary { ('*'|'/') unary }; "(A)(B)" is a multiplication.
+func (p *exprParser) term() (x ast.Expr, res *Result) {
+	if x, res = p.unary(); !res.Ok {
+		return
+	}
+	for {
+		op := token.MUL
+		switch {
+		case p.isOp("*"):
+			p.next()
+		case p.isOp("/"):
+			p.next()
+			op = token.QUO
+		case p.isOp("(") && p.toks[p.pos-1].text == ")":
+		default:
+			return
+		}
+		var y ast.Expr
+		if y, res = p.unary(); !res.Ok {
+			return
+		}
+		x = &ast.BinaryExpr{X: x, Op: op, Y: y}
+	}
+}
+
+// unary = ('-'|'+') unary | power
+func (p *exprParser) unary() (x ast.Expr, res *Result) {
+	switch {
+	case p.isOp("-"):
+		p.next()
+		if x, res = p.unary(); res.Ok {
+			x = &ast.UnaryExpr{Op: token.SUB, X: x}
+		}
+		return
+	case p.isOp("+"):
+		p.next()
+		return p.unary()
+	}
+	return p.power()
+}
+
+// power = primary { '**' ['-'] primary } (relaxed syntax only); chained
+// operators are evaluated from left to right.
+func (p *exprParser) power() (x ast.Expr, res *Result) {
+	if x, res = p.primary(); !res.Ok {
+		return
+	}
+	for p.isOp("**") {
+		if !p.relaxed {
+			return nil, Failure(ErrParseSyntax+": operator '**' in classic syntax at position %d", p.peek().pos)
+		}
+		p.next()
+		neg := p.isOp("-")
+		if neg {
+			p.next()
+		}
+		var y ast.Expr
+		if y, res = p.primary(); !res.Ok {
+			return
+		}
+		if neg {
+			y = &ast.UnaryExpr{Op: token.SUB, X: y}
+		}
+		x = &ast.CallExpr{
+			Fun:  &ast.Ident{Name: "POW"},
+			Args: []ast.Expr{x, y},
+		}
+	}
+	return
+}
+
+// primary = number | string | '(' cond ')' | name | name '(' [args] ')'
+func (p *exprParser) primary() (x ast.Expr, res *Result) {
+	tok := p.peek()
+	switch tok.kind {
+	case TOK_NUMBER:
+		p.next()
+		kind := token.INT
+		if strings.ContainsAny(tok.text, ".Ee") {
+			kind = token.FLOAT
+		}
+		return &ast.BasicLit{Kind: kind, Value: tok.text}, Success()
+
+	case TOK_STRING:
+		p.next()
+		return &ast.BasicLit{Kind: token.STRING, Value: tok.text}, Success()
+
+	case TOK_NAME:
+		if x, res = p.name(); !res.Ok || !p.isOp("(") {
+			return
+		}
+		fcn, ok := x.(*ast.Ident)
+		if !ok {
+			return nil, p.fail()
+		}
+		p.next()
+		call := &ast.CallExpr{Fun: fcn}
+		for !p.isOp(")") {
+			if len(call.Args) > 0 {
+				if res = p.expect(","); !res.Ok {
+					return
+				}
+			}
+			var arg ast.Expr
+			if arg, res = p.cond(); !res.Ok {
+				return
+			}
+			call.Args = append(call.Args, arg)
+		}
+		p.next()
+		// IF(COND,A,B) is a conditional
+		if fcn.Name == "IF" {
+			if len(call.Args) != 3 {
+				return nil, Failure(ErrParseSyntax+": IF needs 3 arguments at position %d", tok.pos)
+			}
+			call.Fun = &ast.Ident{Name: "IFTHENELSE"}
+		}
+		return call, Success()
+
+	case TOK_OP:
+		if tok.text == "(" {
+			p.next()
+			if x, res = p.cond(); !res.Ok {
+				return
+			}
+			if res = p.expect(")"); res.Ok {
+				x = &ast.ParenExpr{X: x}
+			}
+			return
+		}
+	}
+	return nil, p.fail()
+}
+
+// name = NAME { '.' NAME }
+func (p *exprParser) name() (x ast.Expr, res *Result) {
+	if p.peek().kind != TOK_NAME {
+		return nil, p.fail()
+	}
+	x = &ast.Ident{Name: p.next().text}
+	for p.isOp(".") {
+		p.next()
+		if p.peek().kind != TOK_NAME {
+			return nil, p.fail()
+		}
+		x = &ast.SelectorExpr{X: x, Sel: &ast.Ident{Name: p.next().text}}
+	}
+	return x, Success()
+}
+
+// formatExpr returns an expression in DYNAMO notation.
+func formatExpr(x ast.Expr) string {
+	switch e := x.(type) {
+	case *ast.BasicLit:
+		return e.Value
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return formatExpr(e.X) + "." + e.Sel.Name
+	case *ast.ParenExpr:
+		return "(" + formatExpr(e.X) + ")"
+	case *ast.UnaryExpr:
+		return e.Op.String() + formatExpr(e.X)
+	case *ast.BinaryExpr:
+		op := e.Op.String()
+		for text, tok := range relOps {
+			if tok == e.Op {
+				op = text
+			}
+		}
+		return formatExpr(e.X) + op + formatExpr(e.Y)
+	case *ast.CallExpr:
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = formatExpr(arg)
+		}
+		return formatExpr(e.Fun) + "(" + strings.Join(args, ",") + ")"
+	}
+	return ""
+}
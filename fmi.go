@@ -0,0 +1,632 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+//======================================================================
+// FMI export -- A model can be exported as a FMU (Functional Mock-up
+// Unit) for co-simulation (FMI 2.0). The FMU contains the model
+// description, the model source and a Go shim (sources/fmu.go) that
+// implements the FMI functions on top of the step-wise run API; the
+// shim must be compiled for the target platform as a shared library
+// ("go build -buildmode=c-shared") and added to the 'binaries' folder
+// (the exported FMU only contains a note in 'binaries/README').
+//======================================================================
+
+// FMI model description (FMI 2.0, co-simulation)
+type fmiModelDescription struct {
+	XMLName      xml.Name        `xml:"fmiModelDescription"`
+	FmiVersion   string          `xml:"fmiVersion,attr"`
+	ModelName    string          `xml:"modelName,attr"`
+	GUID         string          `xml:"guid,attr"`
+	Description  string          `xml:"description,attr,omitempty"`
+	Generation   string          `xml:"generationTool,attr"`
+	NamingConv   string          `xml:"variableNamingConvention,attr"`
+	CoSimulation fmiCoSimulation `xml:"CoSimulation"`
+	Experiment   fmiExperiment   `xml:"DefaultExperiment"`
+	Variables    []fmiVariable   `xml:"ModelVariables>ScalarVariable"`
+	Outputs      []fmiUnknown    `xml:"ModelStructure>Outputs>Unknown"`
+}
+
+type fmiCoSimulation struct {
+	ModelIdentifier string `xml:"modelIdentifier,attr"`
+	CanHandleStep   bool   `xml:"canHandleVariableCommunicationStepSize,attr"`
+}
+
+type fmiExperiment struct {
+	StartTime float64 `xml:"startTime,attr"`
+	StopTime  float64 `xml:"stopTime,attr"`
+	StepSize  float64 `xml:"stepSize,attr"`
+}
+
+type fmiVariable struct {
+	Name        string  `xml:"name,attr"`
+	Ref         int     `xml:"valueReference,attr"`
+	Description string  `xml:"description,attr,omitempty"`
+	Causality   string  `xml:"causality,attr"`
+	Variability string  `xml:"variability,attr"`
+	Initial     string  `xml:"initial,attr,omitempty"`
+	Real        fmiReal `xml:"Real"`
+}
+
+type fmiReal struct {
+	Start *float64 `xml:"start,attr,omitempty"`
+}
+
+type fmiUnknown struct {
+	Index int `xml:"index,attr"`
+}
+
+// FMU variable (for the generated shim)
+type fmuVar struct {
+	Name      string
+	Ref       int
+	Causality string
+}
+
+// ExportFMU writes a FMU archive (zip) for the model with given model
+// identifier to a stream.
+func (mdl *Model) ExportFMU(w io.Writer, ident string) (res *Result) {
+	res = Success()
+
+	// model source
+	src := new(bytes.Buffer)
+	mdl.writeSource(src, false)
+	h := sha1.Sum(src.Bytes())
+	guid := fmt.Sprintf("{%x-%x-%x-%x-%x}", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+
+	// get default values from (constant) equations
+	value := func(name string) *float64 {
+		if eqn := mdl.equations().Find(name); eqn != nil && strings.Contains("CN", eqn.Mode) {
+			if val, r := eqn.Eval(mdl); r.Ok {
+				v := float64(val)
+				return &v
+			}
+		}
+		return nil
+	}
+	desc := &fmiModelDescription{
+		FmiVersion:   "2.0",
+		ModelName:    mdl.Title,
+		GUID:         guid,
+		Generation:   "DYNAMO interpreter",
+		NamingConv:   "flat",
+		CoSimulation: fmiCoSimulation{ModelIdentifier: ident},
+	}
+	if val := value("TIME"); val != nil {
+		desc.Experiment.StartTime = *val
+	}
+	if val := value("DT"); val != nil {
+		desc.Experiment.StepSize = *val
+	}
+	if val := value("LENGTH"); val != nil {
+		desc.Experiment.StopTime = *val
+	}
+	// list of variables (inputs, outputs and parameters)
+	var vars []*fmuVar
+	for _, name := range mdl.Inputs {
+		vars = append(vars, &fmuVar{Name: name, Ref: len(vars), Causality: "input"})
+		desc.Variables = append(desc.Variables, fmiVariable{
+			Name:        name,
+			Ref:         len(vars) - 1,
+			Causality:   "input",
+			Variability: "discrete",
+			Real:        fmiReal{Start: value(name)},
+		})
+	}
+	for _, name := range mdl.Outputs {
+		vars = append(vars, &fmuVar{Name: name, Ref: len(vars), Causality: "output"})
+		desc.Variables = append(desc.Variables, fmiVariable{
+			Name:        name,
+			Ref:         len(vars) - 1,
+			Causality:   "output",
+			Variability: "continuous",
+			Initial:     "calculated",
+		})
+		desc.Outputs = append(desc.Outputs, fmiUnknown{Index: len(vars)})
+	}
+	for _, eqn := range mdl.equations().List() {
+		name := eqn.Target.Name
+		if eqn.Mode != "C" || name[0] == '_' || mdl.IsSystem(name) || isPort(mdl.Inputs, name) {
+			continue
+		}
+		vars = append(vars, &fmuVar{Name: name, Ref: len(vars), Causality: "parameter"})
+		desc.Variables = append(desc.Variables, fmiVariable{
+			Name:        name,
+			Ref:         len(vars) - 1,
+			Description: eqn.Comment,
+			Causality:   "parameter",
+			Variability: "fixed",
+			Real:        fmiReal{Start: value(name)},
+		})
+	}
+
+	// write archive
+	zw := zip.NewWriter(w)
+	add := func(name string, write func(io.Writer) error) {
+		if !res.Ok {
+			return
+		}
+		f, err := zw.Create(name)
+		if err == nil {
+			err = write(f)
+		}
+		if err != nil {
+			res = Failure(err)
+		}
+	}
+	add("modelDescription.xml", func(f io.Writer) error {
+		io.WriteString(f, xml.Header)
+		enc := xml.NewEncoder(f)
+		enc.Indent("", "  ")
+		return enc.Encode(desc)
+	})
+	add("resources/model.dynamo", func(f io.Writer) error {
+		_, err := f.Write(src.Bytes())
+		return err
+	})
+	add("binaries/README", func(f io.Writer) error {
+		_, err := io.WriteString(f, fmuBinaries)
+		return err
+	})
+	add("sources/fmu.go", func(f io.Writer) error {
+		return fmuShim.Execute(f, map[string]interface{}{
+			"GUID": guid,
+			"Vars": vars,
+		})
+	})
+	if err := zw.Close(); err != nil && res.Ok {
+		res = Failure(err)
+	}
+	return
+}
+
+// note on the (missing) binaries of the FMU
+const fmuBinaries = `This FMU contains no pre-built binaries. Build the shared library
+from 'sources/fmu.go' for your platform and copy it into this folder:
+
+    go build -buildmode=c-shared -o binaries/linux64/<ident>.so sources/fmu.go
+`
+
+// template for the generated FMU shim
+var fmuShim = template.Must(template.New("fmu").Parse(strings.TrimLeft(`
+// Code generated by the DYNAMO interpreter. DO NOT EDIT.
+//
+// FMI 2.0 co-simulation shim for a DYNAMO model; build with
+//     go build -buildmode=c-shared -o <ident>.so fmu.go
+// and copy the library to the 'binaries/<platform>' folder of the FMU.
+
+package main
+
+/*
+#include <stddef.h>
+#include <stdlib.h>
+typedef void*        fmi2Component;
+typedef void*        fmi2ComponentEnvironment;
+typedef void*        fmi2FMUstate;
+typedef unsigned int fmi2ValueReference;
+typedef double       fmi2Real;
+typedef int          fmi2Integer;
+typedef int          fmi2Boolean;
+typedef char         fmi2Char;
+typedef const char*  fmi2String;
+typedef char         fmi2Byte;
+typedef int          fmi2Status;
+typedef int          fmi2Type;
+typedef int          fmi2StatusKind;
+typedef struct {
+	void* logger;
+	void* allocateMemory;
+	void* freeMemory;
+	void* stepFinished;
+	fmi2ComponentEnvironment componentEnvironment;
+} fmi2CallbackFunctions;
+*/
+import "C"
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"github.com/bfix/dynamo"
+)
+
+const guid = "{{.GUID}}"
+
+// value references
+var (
+	names     = []string{ {{- range .Vars}}"{{.Name}}", {{end -}} }
+	causality = []string{ {{- range .Vars}}"{{.Causality}}", {{end -}} }
+)
+
+// FMI constants
+const (
+	fmi2OK    = 0
+	fmi2Error = 3
+
+	fmi2CoSimulation = 1
+)
+
+// fmu is an instance of the model
+type fmu struct {
+	src     []byte             // model source
+	mdl     *dynamo.Model      // parsed model
+	pending map[string]float64 // constants set before initialization
+	started bool               // model run started?
+}
+
+// reset parses the model source again.
+func (f *fmu) reset() bool {
+	f.mdl = dynamo.NewModel("", "")
+	f.mdl.SetRelaxed(true)
+	f.pending = make(map[string]float64)
+	f.started = false
+	return f.mdl.Parse(bytes.NewReader(f.src)).Ok
+}
+
+// initialize sets the pending constants and starts the model run.
+func (f *fmu) initialize() bool {
+	f.mdl.Edit = true
+	for name, val := range f.pending {
+		if !f.mdl.AddStatement(&dynamo.Line{Mode: "C", Stmt: fmt.Sprintf("%s=%g", name, val)}).Ok {
+			return false
+		}
+	}
+	f.mdl.Edit = false
+	f.started = f.mdl.Start().Ok
+	return f.started
+}
+
+// model instances
+var (
+	lock      sync.Mutex
+	instances = make(map[C.fmi2Component]*fmu)
+	version   = C.CString("2.0")
+	platform  = C.CString("default")
+)
+
+func instance(c C.fmi2Component) *fmu {
+	lock.Lock()
+	defer lock.Unlock()
+	return instances[c]
+}
+
+//export fmi2GetTypesPlatform
+func fmi2GetTypesPlatform() C.fmi2String {
+	return platform
+}
+
+//export fmi2GetVersion
+func fmi2GetVersion() C.fmi2String {
+	return version
+}
+
+//export fmi2SetDebugLogging
+func fmi2SetDebugLogging(c C.fmi2Component, loggingOn C.fmi2Boolean, n C.size_t, categories *C.fmi2String) C.fmi2Status {
+	if instance(c) == nil {
+		return fmi2Error
+	}
+	return fmi2OK
+}
+
+//export fmi2Instantiate
+func fmi2Instantiate(name C.fmi2String, kind C.fmi2Type, token, location C.fmi2String, functions *C.fmi2CallbackFunctions, visible, loggingOn C.fmi2Boolean) C.fmi2Component {
+	if kind != fmi2CoSimulation || C.GoString(token) != guid {
+		return nil
+	}
+	loc, err := url.Parse(C.GoString(location))
+	if err != nil {
+		return nil
+	}
+	f := new(fmu)
+	if f.src, err = os.ReadFile(filepath.Join(filepath.FromSlash(loc.Path), "model.dynamo")); err != nil {
+		return nil
+	}
+	if !f.reset() {
+		return nil
+	}
+	// allocate a unique handle for the instance
+	c := C.fmi2Component(C.malloc(1))
+	lock.Lock()
+	defer lock.Unlock()
+	instances[c] = f
+	return c
+}
+
+//export fmi2FreeInstance
+func fmi2FreeInstance(c C.fmi2Component) {
+	lock.Lock()
+	defer lock.Unlock()
+	if _, ok := instances[c]; ok {
+		delete(instances, c)
+		C.free(unsafe.Pointer(c))
+	}
+}
+
+//export fmi2SetupExperiment
+func fmi2SetupExperiment(c C.fmi2Component, tolDefined C.fmi2Boolean, tol, start C.fmi2Real, stopDefined C.fmi2Boolean, stop C.fmi2Real) C.fmi2Status {
+	f := instance(c)
+	if f == nil || f.started {
+		return fmi2Error
+	}
+	f.pending["TIME"] = float64(start)
+	if stopDefined != 0 {
+		f.pending["LENGTH"] = float64(stop)
+	}
+	return fmi2OK
+}
+
+//export fmi2EnterInitializationMode
+func fmi2EnterInitializationMode(c C.fmi2Component) C.fmi2Status {
+	if instance(c) == nil {
+		return fmi2Error
+	}
+	return fmi2OK
+}
+
+//export fmi2ExitInitializationMode
+func fmi2ExitInitializationMode(c C.fmi2Component) C.fmi2Status {
+	f := instance(c)
+	if f == nil || f.started || !f.initialize() {
+		return fmi2Error
+	}
+	return fmi2OK
+}
+
+//export fmi2Terminate
+func fmi2Terminate(c C.fmi2Component) C.fmi2Status {
+	f := instance(c)
+	if f == nil {
+		return fmi2Error
+	}
+	f.mdl.Finish()
+	return fmi2OK
+}
+
+//export fmi2Reset
+func fmi2Reset(c C.fmi2Component) C.fmi2Status {
+	f := instance(c)
+	if f == nil || !f.reset() {
+		return fmi2Error
+	}
+	return fmi2OK
+}
+
+//export fmi2GetReal
+func fmi2GetReal(c C.fmi2Component, vr *C.fmi2ValueReference, n C.size_t, val *C.fmi2Real) C.fmi2Status {
+	f := instance(c)
+	if f == nil {
+		return fmi2Error
+	}
+	refs := unsafe.Slice(vr, n)
+	vals := unsafe.Slice(val, n)
+	for i, ref := range refs {
+		if int(ref) >= len(names) {
+			return fmi2Error
+		}
+		name := names[ref]
+		var (
+			v  float64
+			ok bool
+		)
+		switch {
+		case !f.started:
+			// only values set before initialization are known
+			v, ok = f.pending[name]
+		case causality[ref] == "output":
+			var x dynamo.Variable
+			var res *dynamo.Result
+			x, res = f.mdl.ReadOutput(name)
+			v, ok = float64(x), res.Ok
+		default:
+			var x dynamo.Variable
+			x, ok = f.mdl.Current[name]
+			v = float64(x)
+		}
+		if !ok {
+			return fmi2Error
+		}
+		vals[i] = C.fmi2Real(v)
+	}
+	return fmi2OK
+}
+
+//export fmi2SetReal
+func fmi2SetReal(c C.fmi2Component, vr *C.fmi2ValueReference, n C.size_t, val *C.fmi2Real) C.fmi2Status {
+	f := instance(c)
+	if f == nil {
+		return fmi2Error
+	}
+	refs := unsafe.Slice(vr, n)
+	vals := unsafe.Slice(val, n)
+	for i, ref := range refs {
+		if int(ref) >= len(names) {
+			return fmi2Error
+		}
+		name := names[ref]
+		switch {
+		case causality[ref] != "input" && (causality[ref] != "parameter" || f.started):
+			// only inputs (and parameters before initialization) are set
+			return fmi2Error
+		case !f.started:
+			f.pending[name] = float64(vals[i])
+		default:
+			if res := f.mdl.SetInput(name, dynamo.Variable(vals[i])); !res.Ok {
+				return fmi2Error
+			}
+		}
+	}
+	return fmi2OK
+}
+
+//export fmi2GetInteger
+func fmi2GetInteger(c C.fmi2Component, vr *C.fmi2ValueReference, n C.size_t, val *C.fmi2Integer) C.fmi2Status {
+	// no integer variables
+	if n > 0 || instance(c) == nil {
+		return fmi2Error
+	}
+	return fmi2OK
+}
+
+//export fmi2SetInteger
+func fmi2SetInteger(c C.fmi2Component, vr *C.fmi2ValueReference, n C.size_t, val *C.fmi2Integer) C.fmi2Status {
+	return fmi2GetInteger(c, vr, n, val)
+}
+
+//export fmi2GetBoolean
+func fmi2GetBoolean(c C.fmi2Component, vr *C.fmi2ValueReference, n C.size_t, val *C.fmi2Boolean) C.fmi2Status {
+	// no boolean variables
+	if n > 0 || instance(c) == nil {
+		return fmi2Error
+	}
+	return fmi2OK
+}
+
+//export fmi2SetBoolean
+func fmi2SetBoolean(c C.fmi2Component, vr *C.fmi2ValueReference, n C.size_t, val *C.fmi2Boolean) C.fmi2Status {
+	return fmi2GetBoolean(c, vr, n, val)
+}
+
+//export fmi2GetString
+func fmi2GetString(c C.fmi2Component, vr *C.fmi2ValueReference, n C.size_t, val *C.fmi2String) C.fmi2Status {
+	// no string variables
+	if n > 0 || instance(c) == nil {
+		return fmi2Error
+	}
+	return fmi2OK
+}
+
+//export fmi2SetString
+func fmi2SetString(c C.fmi2Component, vr *C.fmi2ValueReference, n C.size_t, val *C.fmi2String) C.fmi2Status {
+	return fmi2GetString(c, vr, n, val)
+}
+
+// FMU states and derivatives are not supported (see capability flags in
+// the model description).
+
+//export fmi2GetFMUstate
+func fmi2GetFMUstate(c C.fmi2Component, state *C.fmi2FMUstate) C.fmi2Status {
+	return fmi2Error
+}
+
+//export fmi2SetFMUstate
+func fmi2SetFMUstate(c C.fmi2Component, state C.fmi2FMUstate) C.fmi2Status {
+	return fmi2Error
+}
+
+//export fmi2FreeFMUstate
+func fmi2FreeFMUstate(c C.fmi2Component, state *C.fmi2FMUstate) C.fmi2Status {
+	return fmi2Error
+}
+
+//export fmi2SerializedFMUstateSize
+func fmi2SerializedFMUstateSize(c C.fmi2Component, state C.fmi2FMUstate, size *C.size_t) C.fmi2Status {
+	return fmi2Error
+}
+
+//export fmi2SerializeFMUstate
+func fmi2SerializeFMUstate(c C.fmi2Component, state C.fmi2FMUstate, buf *C.fmi2Byte, size C.size_t) C.fmi2Status {
+	return fmi2Error
+}
+
+//export fmi2DeSerializeFMUstate
+func fmi2DeSerializeFMUstate(c C.fmi2Component, buf *C.fmi2Byte, size C.size_t, state *C.fmi2FMUstate) C.fmi2Status {
+	return fmi2Error
+}
+
+//export fmi2GetDirectionalDerivative
+func fmi2GetDirectionalDerivative(c C.fmi2Component, vu *C.fmi2ValueReference, nu C.size_t, vk *C.fmi2ValueReference, nk C.size_t, dvk *C.fmi2Real, dvu *C.fmi2Real) C.fmi2Status {
+	return fmi2Error
+}
+
+//export fmi2SetRealInputDerivatives
+func fmi2SetRealInputDerivatives(c C.fmi2Component, vr *C.fmi2ValueReference, n C.size_t, order *C.fmi2Integer, val *C.fmi2Real) C.fmi2Status {
+	return fmi2Error
+}
+
+//export fmi2GetRealOutputDerivatives
+func fmi2GetRealOutputDerivatives(c C.fmi2Component, vr *C.fmi2ValueReference, n C.size_t, order *C.fmi2Integer, val *C.fmi2Real) C.fmi2Status {
+	return fmi2Error
+}
+
+//export fmi2DoStep
+func fmi2DoStep(c C.fmi2Component, t, h C.fmi2Real, noPrior C.fmi2Boolean) C.fmi2Status {
+	f := instance(c)
+	if f == nil || !f.started {
+		return fmi2Error
+	}
+	end := dynamo.Variable(t + h)
+	for !f.mdl.Done() && f.mdl.Current["TIME"] < end {
+		if res := f.mdl.Step(1); !res.Ok {
+			return fmi2Error
+		}
+	}
+	return fmi2OK
+}
+
+//export fmi2CancelStep
+func fmi2CancelStep(c C.fmi2Component) C.fmi2Status {
+	// steps are computed synchronously
+	return fmi2Error
+}
+
+//export fmi2GetStatus
+func fmi2GetStatus(c C.fmi2Component, kind C.fmi2StatusKind, val *C.fmi2Status) C.fmi2Status {
+	return fmi2Error
+}
+
+//export fmi2GetRealStatus
+func fmi2GetRealStatus(c C.fmi2Component, kind C.fmi2StatusKind, val *C.fmi2Real) C.fmi2Status {
+	return fmi2Error
+}
+
+//export fmi2GetIntegerStatus
+func fmi2GetIntegerStatus(c C.fmi2Component, kind C.fmi2StatusKind, val *C.fmi2Integer) C.fmi2Status {
+	return fmi2Error
+}
+
+//export fmi2GetBooleanStatus
+func fmi2GetBooleanStatus(c C.fmi2Component, kind C.fmi2StatusKind, val *C.fmi2Boolean) C.fmi2Status {
+	return fmi2Error
+}
+
+//export fmi2GetStringStatus
+func fmi2GetStringStatus(c C.fmi2Component, kind C.fmi2StatusKind, val *C.fmi2String) C.fmi2Status {
+	return fmi2Error
+}
+
+func main() {}
+`, "\n")))
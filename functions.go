@@ -23,8 +23,11 @@ package dynamo
 import (
 	"go/ast"
 	"math"
-	"math/rand"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Function represents a callable entity in the Dynamo framework.
@@ -34,14 +37,19 @@ import (
 // arguments (as requested by the function). Instance arguments are stateful;
 // they refer to automatic variables.
 type Function struct {
-	NumArgs  int   // number of expected (explicit) arguments
-	NumVars  int   // number of requested internal variables
-	DepModes []int // how to handle explicit arguments as dependencies
+	NumArgs  int      // number of expected (explicit) arguments
+	MaxArgs  int      // max. number of explicit arguments (if variable)
+	NumVars  int      // number of requested internal variables
+	Vars     []string // names of internal variables (for derived names)
+	DepModes []int    // how to handle explicit arguments as dependencies (last mode repeats)
 
-	Check func(args []ast.Expr) *Result                       // argument check function
-	Eval  func(args []string, mdl *Model) (Variable, *Result) // evalutae function
+	Check func(args []ast.Expr) *Result                     // argument check function
+	Eval  func(args []*Arg, mdl *Model) (Variable, *Result) // evalutae function
 }
 
+// VAR_ARGS as max. number of arguments accepts any number of arguments
+const VAR_ARGS = -1
+
 var (
 	// fcnList is a collection of available functions
 	fcnList map[string]*Function
@@ -59,8 +67,8 @@ func init() {
 			NumVars:  0,
 			DepModes: []int{DEP_NORMAL},
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
-				if val, res = resolve(args[0], mdl); res.Ok {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				if val, res = args[0].Value(mdl); res.Ok {
 					val = val.Sqrt()
 				}
 				return
@@ -71,8 +79,8 @@ func init() {
 			NumVars:  0,
 			DepModes: []int{DEP_NORMAL},
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
-				if val, res = resolve(args[0], mdl); res.Ok {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				if val, res = args[0].Value(mdl); res.Ok {
 					val = val.Sin()
 				}
 				return
@@ -83,8 +91,8 @@ func init() {
 			NumVars:  0,
 			DepModes: []int{DEP_NORMAL},
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
-				if val, res = resolve(args[0], mdl); res.Ok {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				if val, res = args[0].Value(mdl); res.Ok {
 					val = val.Cos()
 				}
 				return
@@ -95,8 +103,8 @@ func init() {
 			NumVars:  0,
 			DepModes: []int{DEP_NORMAL},
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
-				if val, res = resolve(args[0], mdl); res.Ok {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				if val, res = args[0].Value(mdl); res.Ok {
 					val = val.Exp()
 				}
 				return
@@ -107,47 +115,93 @@ func init() {
 			NumVars:  0,
 			DepModes: []int{DEP_NORMAL},
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
-				if val, res = resolve(args[0], mdl); res.Ok {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				if val, res = args[0].Value(mdl); res.Ok {
 					val = val.Log()
 				}
 				return
 			},
 		},
-		"MAX": {
+		"POW": {
 			NumArgs:  2,
 			NumVars:  0,
 			DepModes: []int{DEP_NORMAL, DEP_NORMAL},
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
-				var a, b Variable
-				if a, res = resolve(args[0], mdl); res.Ok {
-					if b, res = resolve(args[1], mdl); res.Ok {
-						if a.Compare(b) < 0 {
-							val = b
-						} else {
-							val = a
-						}
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				var x Variable
+				if val, res = args[0].Value(mdl); res.Ok {
+					if x, res = args[1].Value(mdl); res.Ok {
+						val = val.Pow(x)
 					}
 				}
 				return
 			},
 		},
+		"MAX": {
+			NumArgs:  2,
+			MaxArgs:  VAR_ARGS,
+			NumVars:  0,
+			DepModes: []int{DEP_NORMAL},
+			Check:    nil,
+			//----------------------------------------------------------
+			// MAX(A,B,...): largest argument
+			//----------------------------------------------------------
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				return fold(args, mdl, func(v, x Variable) Variable {
+					if v.Compare(x) < 0 {
+						return x
+					}
+					return v
+				})
+			},
+		},
 		"MIN": {
 			NumArgs:  2,
+			MaxArgs:  VAR_ARGS,
 			NumVars:  0,
-			DepModes: []int{DEP_NORMAL, DEP_NORMAL},
+			DepModes: []int{DEP_NORMAL},
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
-				var a, b Variable
-				if a, res = resolve(args[0], mdl); res.Ok {
-					if b, res = resolve(args[1], mdl); res.Ok {
-						if a.Compare(b) < 0 {
-							val = a
-						} else {
-							val = b
-						}
+			//----------------------------------------------------------
+			// MIN(A,B,...): smallest argument
+			//----------------------------------------------------------
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				return fold(args, mdl, func(v, x Variable) Variable {
+					if v.Compare(x) < 0 {
+						return v
 					}
+					return x
+				})
+			},
+		},
+		"SUM": {
+			NumArgs:  1,
+			MaxArgs:  VAR_ARGS,
+			NumVars:  0,
+			DepModes: []int{DEP_NORMAL},
+			Check:    nil,
+			//----------------------------------------------------------
+			// SUM(A,B,...): sum of arguments
+			//----------------------------------------------------------
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				return fold(args, mdl, func(v, x Variable) Variable {
+					return v + x
+				})
+			},
+		},
+		"MEAN": {
+			NumArgs:  1,
+			MaxArgs:  VAR_ARGS,
+			NumVars:  0,
+			DepModes: []int{DEP_NORMAL},
+			Check:    nil,
+			//----------------------------------------------------------
+			// MEAN(A,B,...): arithmetic mean of arguments
+			//----------------------------------------------------------
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				if val, res = fold(args, mdl, func(v, x Variable) Variable {
+					return v + x
+				}); res.Ok {
+					val /= Variable(len(args))
 				}
 				return
 			},
@@ -157,12 +211,12 @@ func init() {
 			NumVars:  0,
 			DepModes: []int{DEP_NORMAL, DEP_NORMAL, DEP_NORMAL, DEP_NORMAL},
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
 				var a, b, x, y Variable
-				if a, res = resolve(args[0], mdl); res.Ok {
-					if b, res = resolve(args[1], mdl); res.Ok {
-						if x, res = resolve(args[2], mdl); res.Ok {
-							if y, res = resolve(args[3], mdl); res.Ok {
+				if a, res = args[0].Value(mdl); res.Ok {
+					if b, res = args[1].Value(mdl); res.Ok {
+						if x, res = args[2].Value(mdl); res.Ok {
+							if y, res = args[3].Value(mdl); res.Ok {
 								if x.Compare(y) < 0 {
 									val = b
 								} else {
@@ -180,11 +234,11 @@ func init() {
 			NumVars:  0,
 			DepModes: []int{DEP_NORMAL, DEP_NORMAL, DEP_NORMAL},
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
 				var a, b, x Variable
-				if a, res = resolve(args[0], mdl); res.Ok {
-					if b, res = resolve(args[1], mdl); res.Ok {
-						if x, res = resolve(args[2], mdl); res.Ok {
+				if a, res = args[0].Value(mdl); res.Ok {
+					if b, res = args[1].Value(mdl); res.Ok {
+						if x, res = args[2].Value(mdl); res.Ok {
 							if x.Compare(0) == 0 {
 								val = a
 							} else {
@@ -196,6 +250,27 @@ func init() {
 				return
 			},
 		},
+		"IFTHENELSE": {
+			NumArgs:  3,
+			NumVars:  0,
+			DepModes: []int{DEP_NORMAL, DEP_NORMAL, DEP_NORMAL},
+			Check:    nil,
+			//----------------------------------------------------------
+			// IFTHENELSE(COND,A,B): A if COND is true (not zero), else B
+			// (in formulas only the selected branch is evaluated)
+			//----------------------------------------------------------
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				var cond Variable
+				if cond, res = args[0].Value(mdl); res.Ok {
+					if cond.Compare(0) != 0 {
+						val, res = args[1].Value(mdl)
+					} else {
+						val, res = args[2].Value(mdl)
+					}
+				}
+				return
+			},
+		},
 		//--------------------------------------------------------------
 		// Generating functions
 		//--------------------------------------------------------------
@@ -204,10 +279,10 @@ func init() {
 			NumVars:  0,
 			DepModes: []int{DEP_NORMAL, DEP_NORMAL},
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
 				var a, b Variable
-				if a, res = resolve(args[0], mdl); res.Ok {
-					if b, res = resolve(args[1], mdl); res.Ok {
+				if a, res = args[0].Value(mdl); res.Ok {
+					if b, res = args[1].Value(mdl); res.Ok {
 						if time, ok := mdl.Current["TIME"]; ok {
 							if time.Compare(b) >= 0 {
 								val = a
@@ -225,10 +300,10 @@ func init() {
 			NumVars:  0,
 			DepModes: []int{DEP_NORMAL, DEP_NORMAL},
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
 				var a, b Variable
-				if a, res = resolve(args[0], mdl); res.Ok {
-					if b, res = resolve(args[1], mdl); res.Ok {
+				if a, res = args[0].Value(mdl); res.Ok {
+					if b, res = args[1].Value(mdl); res.Ok {
 						if time, ok := mdl.Current["TIME"]; ok {
 							if time.Compare(b) >= 0 {
 								val = a * (time - b)
@@ -244,11 +319,11 @@ func init() {
 			NumVars:  0,
 			DepModes: []int{DEP_NORMAL, DEP_NORMAL, DEP_NORMAL},
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
 				var a, b, c Variable
-				if a, res = resolve(args[0], mdl); res.Ok {
-					if b, res = resolve(args[1], mdl); res.Ok {
-						if c, res = resolve(args[2], mdl); res.Ok {
+				if a, res = args[0].Value(mdl); res.Ok {
+					if b, res = args[1].Value(mdl); res.Ok {
+						if c, res = args[2].Value(mdl); res.Ok {
 							if time, ok := mdl.Current["TIME"]; ok {
 								x := (time - b) / c
 								if x.Compare(x.Floor()) == 0 {
@@ -261,14 +336,42 @@ func init() {
 				return
 			},
 		},
+		"STRTIM": {
+			NumArgs:  0,
+			NumVars:  0,
+			DepModes: nil,
+			Check:    nil,
+			//----------------------------------------------------------
+			// STRTIM(): start time of the run
+			//----------------------------------------------------------
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				return mdl.startTime()
+			},
+		},
+		"LTIME": {
+			NumArgs:  0,
+			NumVars:  0,
+			DepModes: nil,
+			Check:    nil,
+			//----------------------------------------------------------
+			// LTIME(): time elapsed since the start of the run
+			//----------------------------------------------------------
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				if mdl.run != nil {
+					val = mdl.run.t - mdl.run.start
+				}
+				return val, Success()
+			},
+		},
 		"NOISE": {
 			NumArgs:  0,
 			NumVars:  0,
 			DepModes: nil,
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
-				val = Variable(rand.Float64() - 0.5)
-				res = Success()
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				if val, res = mdl.random(); res.Ok {
+					val -= 0.5
+				}
 				return
 			},
 		},
@@ -278,9 +381,10 @@ func init() {
 		"TABLE": {
 			NumArgs:  5,
 			NumVars:  1,
+			Vars:     []string{"RGN"},
 			DepModes: []int{DEP_SKIP, DEP_NORMAL, DEP_NORMAL, DEP_NORMAL, DEP_NORMAL},
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
 				return table(args, mdl, 0)
 			},
 		},
@@ -289,64 +393,99 @@ func init() {
 			NumVars:  0,
 			DepModes: []int{DEP_SKIP, DEP_NORMAL, DEP_NORMAL, DEP_NORMAL, DEP_NORMAL},
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
 				return table(args, mdl, 0)
 			},
 		},
 		"TABXT": {
 			NumArgs:  5,
 			NumVars:  1,
+			Vars:     []string{"RGN"},
 			DepModes: []int{DEP_SKIP, DEP_NORMAL, DEP_NORMAL, DEP_NORMAL, DEP_NORMAL},
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
 				return table(args, mdl, 1)
 			},
 		},
 		"TABPL": {
 			NumArgs:  5,
 			NumVars:  1,
+			Vars:     []string{"RGN"},
 			DepModes: []int{DEP_SKIP, DEP_NORMAL, DEP_NORMAL, DEP_NORMAL, DEP_NORMAL},
 			Check:    nil,
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
 				return table(args, mdl, 2)
 			},
 		},
+		"TABLE2": {
+			NumArgs: 9,
+			NumVars: 0,
+			DepModes: []int{DEP_SKIP, DEP_NORMAL, DEP_NORMAL, DEP_NORMAL, DEP_NORMAL,
+				DEP_NORMAL, DEP_NORMAL, DEP_NORMAL, DEP_NORMAL},
+			Check: nil,
+			//----------------------------------------------------------
+			// TABLE2(TAB,X.K,Y.K,XMIN,XMAX,XSTEP,YMIN,YMAX,YSTEP)
+			//----------------------------------------------------------
+			Eval: table2,
+		},
+		//--------------------------------------------------------------
+		// Exogenous data
+		//--------------------------------------------------------------
+		"EXTDAT": {
+			NumArgs:  1,
+			MaxArgs:  2,
+			NumVars:  0,
+			DepModes: []int{DEP_SKIP, DEP_SKIP},
+			Check:    checkExtdat,
+			//----------------------------------------------------------
+			// EXTDAT(NAME): value of data series at current TIME
+			// EXTDAT("file","COLUMN"): same for a column in a CSV file
+			//----------------------------------------------------------
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				key := args[0].Text
+				if len(args) == 2 {
+					file, _ := strconv.Unquote(args[0].Text)
+					col, _ := strconv.Unquote(args[1].Text)
+					key = seriesKey(file, col)
+				}
+				series, ok := mdl.Series[key]
+				if !ok {
+					res = Failure(ErrModelNoSuchSeries+": %s", key)
+					return
+				}
+				time, ok := mdl.Current["TIME"]
+				if !ok {
+					res = Failure(ErrModelNoTime)
+					return
+				}
+				return Variable(series.At(float64(time))), Success()
+			},
+		},
 		//--------------------------------------------------------------
 		// DELAY functions
 		//--------------------------------------------------------------
 		"DELAY1": {
 			NumArgs:  2,
 			NumVars:  2,
+			Vars:     []string{"L1", "R1"},
 			DepModes: []int{DEP_ENFORCE, DEP_NORMAL},
-			Check: func(args []ast.Expr) *Result {
-				// the first variable must be of kind RATE from OLD state
-				n, res := NewName(args[0])
-				if !res.Ok {
-					return res
-				}
-				if n.Kind != NAME_KIND_RATE {
-					return Failure(ErrModelFunction+": DELAY1 --  %s not a rate", n.String())
-				}
-				if n.Stage != NAME_STAGE_OLD {
-					return Failure(ErrModelFunction+": DELAY1 --  %s%s not old", n.Name, n.GetIndex())
-				}
-				return Success()
-			},
+			Check:    checkDelayInput("DELAY1"),
 			//----------------------------------------------------------
-			// DELAY1(A.JK,B)
+			// DELAY1(A.JK,B) or DELAY1(A.K,B)
 			//----------------------------------------------------------
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
 				var (
 					a, b   Variable // values for rate and delay
 					l1, r1 Variable // internal values (level, rate)
 					dt     Variable // time-step
+					ok     bool     // internal state available
 				)
 				// get value of first argument
-				if a, res = resolve(args[0], mdl); !res.Ok {
+				if a, res = args[0].Value(mdl); !res.Ok {
 					return
 				}
 				// get value of second argument
-				if b, res = resolve(args[1], mdl); !res.Ok {
+				if b, res = args[1].Value(mdl); !res.Ok {
 					return
 				}
 				// get time step value
@@ -354,22 +493,19 @@ func init() {
 					return
 				}
 				// get old internal state
-				if l1, res = resolve(args[2], mdl); !res.Ok {
+				if l1, ok = mdl.auto.get(args[2].Text); !ok {
 					// no state available: perform initialization
-					mdl.Current[args[2]] = a * b
-					mdl.Current[args[3]] = a
+					mdl.auto.put(args[2].Text, a*b)
+					mdl.auto.put(args[3].Text, a)
 					val = a
-					res = Success()
-					return
-				}
-				if r1, res = resolve(args[3], mdl); !res.Ok {
 					return
 				}
+				r1, _ = mdl.auto.get(args[3].Text)
 				// compute new internal state
 				l1 += dt * (a - r1)
 				r1 = l1 / b
-				mdl.Current[args[2]] = l1
-				mdl.Current[args[3]] = r1
+				mdl.auto.put(args[2].Text, l1)
+				mdl.auto.put(args[3].Text, r1)
 				// return function result
 				return r1, Success()
 			},
@@ -377,38 +513,27 @@ func init() {
 		"DELAY3": {
 			NumArgs:  2,
 			NumVars:  6,
+			Vars:     []string{"L1", "R1", "L2", "R2", "L3", "R3"},
 			DepModes: []int{DEP_ENFORCE, DEP_NORMAL},
-			Check: func(args []ast.Expr) *Result {
-				// the first variable must be of kind RATE from OLD state
-				n, res := NewName(args[0])
-				if !res.Ok {
-					return res
-				}
-				if n.Kind != NAME_KIND_RATE {
-					return Failure(ErrModelFunction+": DELAY3 --  %s not a rate", n.String())
-				}
-				if n.Stage != NAME_STAGE_OLD {
-					return Failure(ErrModelFunction+": DELAY3 --  %s%s not old", n.Name, n.GetIndex())
-				}
-				return Success()
-			},
+			Check:    checkDelayInput("DELAY3"),
 			//----------------------------------------------------------
-			// DELAY3(A.JK,B)
+			// DELAY3(A.JK,B) or DELAY3(A.K,B)
 			//----------------------------------------------------------
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
 				var (
 					a, b   Variable // value of rate and delay (arguments)
 					l1, r1 Variable // internal variables (#1)
 					l2, r2 Variable // internal variables (#2)
 					l3, r3 Variable // internal variables (#3)
 					dl, dt Variable // delay and time-step
+					ok     bool     // internal state available
 				)
 				// get value of first argument
-				if a, res = resolve(args[0], mdl); !res.Ok {
+				if a, res = args[0].Value(mdl); !res.Ok {
 					return
 				}
 				// get value of second argument
-				if b, res = resolve(args[1], mdl); !res.Ok {
+				if b, res = args[1].Value(mdl); !res.Ok {
 					return
 				}
 				// get time step value.
@@ -416,34 +541,23 @@ func init() {
 					return
 				}
 				// get old internal state
-				if l1, res = resolve(args[2], mdl); !res.Ok {
+				if l1, ok = mdl.auto.get(args[2].Text); !ok {
 					// no state available: perform initialization
 					l1 = a * (b / 3.)
-					mdl.Current[args[2]] = l1
-					mdl.Current[args[3]] = a
-					mdl.Current[args[4]] = l1
-					mdl.Current[args[5]] = a
-					mdl.Current[args[6]] = l1
-					mdl.Current[args[7]] = a
+					mdl.auto.put(args[2].Text, l1)
+					mdl.auto.put(args[3].Text, a)
+					mdl.auto.put(args[4].Text, l1)
+					mdl.auto.put(args[5].Text, a)
+					mdl.auto.put(args[6].Text, l1)
+					mdl.auto.put(args[7].Text, a)
 					val = a
-					res = Success()
-					return
-				}
-				if r1, res = resolve(args[3], mdl); !res.Ok {
-					return
-				}
-				if l2, res = resolve(args[4], mdl); !res.Ok {
-					return
-				}
-				if r2, res = resolve(args[5], mdl); !res.Ok {
-					return
-				}
-				if l3, res = resolve(args[6], mdl); !res.Ok {
-					return
-				}
-				if r3, res = resolve(args[7], mdl); !res.Ok {
 					return
 				}
+				r1, _ = mdl.auto.get(args[3].Text)
+				l2, _ = mdl.auto.get(args[4].Text)
+				r2, _ = mdl.auto.get(args[5].Text)
+				l3, _ = mdl.auto.get(args[6].Text)
+				r3, _ = mdl.auto.get(args[7].Text)
 				// compute new internal state
 				dl = b / 3.
 				l3 = l3 + dt*(r2-r3)
@@ -453,12 +567,12 @@ func init() {
 				r1 = l1 / dl
 				val = l3 / dl
 				// save new state
-				mdl.Current[args[2]] = l1
-				mdl.Current[args[3]] = r1
-				mdl.Current[args[4]] = l2
-				mdl.Current[args[5]] = r2
-				mdl.Current[args[6]] = l3
-				mdl.Current[args[7]] = val
+				mdl.auto.put(args[2].Text, l1)
+				mdl.auto.put(args[3].Text, r1)
+				mdl.auto.put(args[4].Text, l2)
+				mdl.auto.put(args[5].Text, r2)
+				mdl.auto.put(args[6].Text, l3)
+				mdl.auto.put(args[7].Text, val)
 
 				// return function result
 				res = Success()
@@ -471,6 +585,7 @@ func init() {
 		"SMOOTH": {
 			NumArgs:  2,
 			NumVars:  1,
+			Vars:     []string{"L1"},
 			DepModes: []int{DEP_SKIP, DEP_NORMAL},
 			Check: func(args []ast.Expr) *Result {
 				// the first variable must be of kind LEVEL,RATE or AUX from NEW state
@@ -488,22 +603,19 @@ func init() {
 			//----------------------------------------------------------
 			// SMOOTH(A.K,B)
 			//----------------------------------------------------------
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
 				var (
-					vname string   // variable name
-					a, b  Variable // values for level and delay
-					v1    Variable // internal value
-					dt    Variable // time-step
+					a, b Variable // values for level and delay
+					v1   Variable // internal value
+					dt   Variable // time-step
+					ok   bool     // internal state available
 				)
 				// get value of first argument
-				if vname, res = asOld(args[0]); !res.Ok {
-					return
-				}
-				if a, res = resolve(vname, mdl); !res.Ok {
+				if a, res = args[0].Old(mdl); !res.Ok {
 					return
 				}
 				// get value of second argument
-				if b, res = resolve(args[1], mdl); !res.Ok {
+				if b, res = args[1].Value(mdl); !res.Ok {
 					return
 				}
 				// get time step value
@@ -511,57 +623,81 @@ func init() {
 					return
 				}
 				// get old internal state
-				if v1, res = resolve(args[2], mdl); !res.Ok {
+				if v1, ok = mdl.auto.get(args[2].Text); !ok {
 					// no internal state: initializing...
-					mdl.Current[args[2]] = a
+					mdl.auto.put(args[2].Text, a)
 					val = a
-					res = Success()
 					return
 				}
 				// compute new internal state
 				v1 += (dt / b) * (a - v1)
-				mdl.Current[args[2]] = v1
+				mdl.auto.put(args[2].Text, v1)
 				// return function result
 				return v1, Success()
 			},
 		},
-		"DLINF3": {
+		"TRND": {
 			NumArgs:  2,
-			NumVars:  4,
-			DepModes: []int{DEP_NORMAL, DEP_NORMAL},
-			Check: func(args []ast.Expr) *Result {
-				// the first variable must be of kind LEVEL from NEW state
-				n, res := NewName(args[0])
-				if !res.Ok {
-					return res
-				}
-				if n.Kind != NAME_KIND_LEVEL {
-					return Failure(ErrModelFunction+": DLINF3 --  %s not a level", n.String())
+			MaxArgs:  3,
+			NumVars:  1,
+			Vars:     []string{"AVG"},
+			DepModes: []int{DEP_SKIP, DEP_NORMAL, DEP_NORMAL},
+			Check:    checkInput("TRND"),
+			//----------------------------------------------------------
+			// TRND(A.K,TA[,TRI]): fractional trend of A per time unit
+			// (averaging time TA, initial trend TRI)
+			//----------------------------------------------------------
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				_, val, res = trend(args, mdl)
+				return
+			},
+		},
+		"FORCST": {
+			NumArgs:  3,
+			MaxArgs:  4,
+			NumVars:  1,
+			Vars:     []string{"AVG"},
+			DepModes: []int{DEP_SKIP, DEP_NORMAL, DEP_NORMAL, DEP_NORMAL},
+			Check:    checkInput("FORCST"),
+			//----------------------------------------------------------
+			// FORCST(A.K,TA,TH[,TRI]): value of A extrapolated by its
+			// trend over the horizon TH
+			//----------------------------------------------------------
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				var a, tr, th Variable
+				// horizon is the third argument: shift arguments
+				list := append([]*Arg{args[0], args[1]}, args[3:]...)
+				if a, tr, res = trend(list, mdl); !res.Ok {
+					return
 				}
-				if n.Stage != NAME_STAGE_NEW {
-					return Failure(ErrModelFunction+": DLINF3 --  %s%s not new", n.Name, n.GetIndex())
+				if th, res = args[2].Value(mdl); res.Ok {
+					val = a * (1 + th*tr)
 				}
-				return Success()
+				return
 			},
+		},
+		"DLINF1": {
+			NumArgs:  2,
+			NumVars:  1,
+			Vars:     []string{"L1"},
+			DepModes: []int{DEP_NORMAL, DEP_NORMAL},
+			Check:    checkInfoInput("DLINF1"),
 			//----------------------------------------------------------
-			// DLINF3(A.K,B)
+			// DLINF1(A.K,B)
 			//----------------------------------------------------------
-			Eval: func(args []string, mdl *Model) (val Variable, res *Result) {
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
 				var (
-					vname          string   // variable name
-					a, b           Variable // values for level and delay
-					v1, v2, v3, v4 Variable // internal values
-					dt             Variable // time-step
+					a, b Variable // values for input and delay
+					v1   Variable // internal value
+					dt   Variable // time-step
+					ok   bool     // internal state available
 				)
 				// get value of first argument
-				if vname, res = asOld(args[0]); !res.Ok {
-					return
-				}
-				if a, res = resolve(vname, mdl); !res.Ok {
+				if a, res = args[0].Value(mdl); !res.Ok {
 					return
 				}
 				// get value of second argument
-				if b, res = resolve(args[1], mdl); !res.Ok {
+				if b, res = args[1].Value(mdl); !res.Ok {
 					return
 				}
 				// get time step value
@@ -569,34 +705,69 @@ func init() {
 					return
 				}
 				// get old internal state
-				if v1, res = resolve(args[2], mdl); !res.Ok {
+				if v1, ok = mdl.auto.get(args[2].Text); !ok {
 					// no internal state: initializing...
-					mdl.Current[args[2]] = a
-					mdl.Current[args[3]] = a
-					mdl.Current[args[4]] = a
-					mdl.Current[args[5]] = b / 3.
+					mdl.auto.put(args[2].Text, a)
 					val = a
-					res = Success()
 					return
 				}
-				if v2, res = resolve(args[3], mdl); !res.Ok {
+				// compute new internal state
+				v1 += dt * (a - v1) / b
+				mdl.auto.put(args[2].Text, v1)
+				// return function result
+				return v1, Success()
+			},
+		},
+		"DLINF3": {
+			NumArgs:  2,
+			NumVars:  4,
+			Vars:     []string{"L1", "L2", "L3", "DL"},
+			DepModes: []int{DEP_NORMAL, DEP_NORMAL},
+			Check:    checkInfoInput("DLINF3"),
+			//----------------------------------------------------------
+			// DLINF3(A.K,B)
+			//----------------------------------------------------------
+			Eval: func(args []*Arg, mdl *Model) (val Variable, res *Result) {
+				var (
+					a, b           Variable // values for level and delay
+					v1, v2, v3, v4 Variable // internal values
+					dt             Variable // time-step
+					ok             bool     // internal state available
+				)
+				// get value of first argument
+				if a, res = args[0].Old(mdl); !res.Ok {
 					return
 				}
-				if v3, res = resolve(args[4], mdl); !res.Ok {
+				// get value of second argument
+				if b, res = args[1].Value(mdl); !res.Ok {
 					return
 				}
-				if v4, res = resolve(args[5], mdl); !res.Ok {
+				// get time step value
+				if dt, res = resolve("DT", mdl); !res.Ok {
+					return
+				}
+				// get old internal state
+				if v1, ok = mdl.auto.get(args[2].Text); !ok {
+					// no internal state: initializing...
+					mdl.auto.put(args[2].Text, a)
+					mdl.auto.put(args[3].Text, a)
+					mdl.auto.put(args[4].Text, a)
+					mdl.auto.put(args[5].Text, b/3.)
+					val = a
 					return
 				}
+				v2, _ = mdl.auto.get(args[3].Text)
+				v3, _ = mdl.auto.get(args[4].Text)
+				v4, _ = mdl.auto.get(args[5].Text)
 				// compute new internal state
 				v3 += dt * (v2 - v3) / v4
 				v2 += dt * (v1 - v2) / v4
 				v1 += dt * (a - v1) / v4
 				v4 = b / 3.
-				mdl.Current[args[2]] = v1
-				mdl.Current[args[3]] = v2
-				mdl.Current[args[4]] = v3
-				mdl.Current[args[5]] = v4
+				mdl.auto.put(args[2].Text, v1)
+				mdl.auto.put(args[3].Text, v2)
+				mdl.auto.put(args[4].Text, v3)
+				mdl.auto.put(args[5].Text, v4)
 				// return function result
 				return v3, Success()
 			},
@@ -605,20 +776,29 @@ func init() {
 }
 
 // HasFunction checks if a named function is available for given number of
-// arguments. It returns the list of automatic variable assigned to the
-// function call instance.
-func HasFunction(name string, args []ast.Expr) ([]int, []ast.Expr, *Result) {
+// arguments. It returns the list of automatic variables (named by the
+// model) assigned to the function call instance.
+func HasFunction(name string, args []ast.Expr, mdl *Model) ([]int, []ast.Expr, *Result) {
 	// check if we have a function of given name in our list
 	if f, ok := fcnList[name]; ok {
 		// check number of explicit arguments
-		if len(args) != f.NumArgs {
+		if len(args) < f.NumArgs || (len(args) > f.NumArgs && len(args) > f.MaxArgs && f.MaxArgs != VAR_ARGS) {
 			return nil, nil, Failure(ErrParseInvalidNumArgs)
 		}
+		// extend dependency modes to all arguments
+		modes := f.DepModes
+		if n := len(modes); n > 0 && len(args) > n {
+			modes = make([]int, len(args))
+			copy(modes, f.DepModes)
+			for i := n; i < len(args); i++ {
+				modes[i] = f.DepModes[n-1]
+			}
+		}
 		// if we have a list of internal variables, create them now
 		intern := make([]ast.Expr, f.NumVars)
 		for i := range intern {
 			intern[i] = &ast.Ident{
-				Name: NewAutoVar(),
+				Name: mdl.NewAutoVar(),
 			}
 		}
 		// use optional check function to validate arguments
@@ -626,13 +806,25 @@ func HasFunction(name string, args []ast.Expr) ([]int, []ast.Expr, *Result) {
 		if f.Check != nil {
 			res = f.Check(args)
 		}
-		return f.DepModes, intern, res
+		return modes, intern, res
 	}
 	return nil, nil, Failure(ErrParseUnknownFunction+": '%s'", name)
 }
 
-// CallFunction executes a function call with given arguments
+// CallFunction executes a function call with given arguments (numbers
+// or names)
 func CallFunction(name string, args []string, mdl *Model) (val Variable, res *Result) {
+	list := make([]*Arg, len(args))
+	for i, arg := range args {
+		if list[i], res = NewArg(arg); !res.Ok {
+			return
+		}
+	}
+	return callFunction(name, list, mdl)
+}
+
+// callFunction executes a function call with resolved arguments
+func callFunction(name string, args []*Arg, mdl *Model) (val Variable, res *Result) {
 	val = 0.0
 
 	// lookup built-in function
@@ -641,6 +833,9 @@ func CallFunction(name string, args []string, mdl *Model) (val Variable, res *Re
 		res = Failure(ErrModelUnknownFunction+": %s\n", name)
 		return
 	}
+	if mdl.Profile != nil {
+		defer mdl.Profile.fcn(name, time.Now())
+	}
 	val, res = f.Eval(args, mdl)
 	return
 }
@@ -649,10 +844,25 @@ func CallFunction(name string, args []string, mdl *Model) (val Variable, res *Re
 // TABLE to model functions of form "Y = TABLE(X)" (TABHL, TABXT, TABPL)
 //----------------------------------------------------------------------
 
+// Kinds of tables (like the types of XMILE graphical functions)
+const (
+	TBL_CONTINUOUS  = iota // interpolation (end values outside unless TABXT)
+	TBL_EXTRAPOLATE        // interpolation (linear extrapolation outside)
+	TBL_DISCRETE           // step function (value at last x-value not above)
+)
+
 // Table is a list of values
 type Table struct {
 	Data []float64
 	A_j  []float64
+	X    []float64 // x-values (optional; used for range checking and lookups)
+	Kind int       // kind of table (TBL_???)
+	Cols int       // number of x-values in a row of a 2-D table (0: 1-D table)
+
+	Comment string            // description of the table (from source)
+	Meta    map[string]string // metadata (from NOTE lines)
+
+	uneven bool // x-values are not equidistant
 }
 
 // NewTable creates a new Table from a given list of (stringed) values.
@@ -668,20 +878,120 @@ func NewTable(list []string) (tbl *Table, res *Result) {
 	tbl = new(Table)
 	tbl.Data = make([]float64, num)
 	for i, v := range list {
-		val, err := strconv.ParseFloat(v, 64)
-		if err != nil {
-			res = Failure(err)
+		val, ok := parseNumber(v)
+		if !ok {
+			res = Failure(ErrParseNotANumber+": %s", v)
 			break
 		}
 		tbl.Data[i] = val
 	}
+	tbl.init()
+	return
+}
+
+// NewTableXY creates a new Table from explicit (increasing) x-values and
+// the corresponding y-values; the x-values don't need to be equidistant.
+func NewTableXY(x, y []float64) (tbl *Table, res *Result) {
+	num := len(y)
+	if num < 2 {
+		return nil, Failure(ErrParseTableTooSmall)
+	}
+	if len(x) != num {
+		return nil, Failure(ErrParseTableFormat+": %d x-values for %d y-values", len(x), num)
+	}
+	tbl = &Table{
+		Data: append([]float64{}, y...),
+		X:    append([]float64{}, x...),
+	}
+	step := x[1] - x[0]
+	for i := 1; i < num; i++ {
+		d := x[i] - x[i-1]
+		if compare(d, 0) <= 0 {
+			return nil, Failure(ErrParseTableFormat + ": x-values not increasing")
+		}
+		if compare(d, step) != 0 {
+			tbl.uneven = true
+		}
+	}
+	tbl.init()
+	return tbl, Success()
+}
+
+// NewTableFromPairs creates a new Table from a list of (x,y) pairs like
+// "(0,0),(1,2),(4,5)"; the x-values must be increasing but don't need to
+// be equidistant.
+func NewTableFromPairs(def string) (tbl *Table, res *Result) {
+	if !strings.HasPrefix(def, "(") || !strings.HasSuffix(def, ")") {
+		return nil, Failure(ErrParseTableFormat+": %s", def)
+	}
+	def = strings.Replace(def[1:len(def)-1], ")/(", "),(", -1)
+	var x, y []float64
+	for _, pair := range strings.Split(def, "),(") {
+		vals := strings.Split(pair, ",")
+		if len(vals) != 2 {
+			return nil, Failure(ErrParseTableFormat+": (%s)", pair)
+		}
+		xv, ok := parseNumber(vals[0])
+		if !ok {
+			return nil, Failure(ErrParseNotANumber+": %s", vals[0])
+		}
+		yv, ok := parseNumber(vals[1])
+		if !ok {
+			return nil, Failure(ErrParseNotANumber+": %s", vals[1])
+		}
+		x, y = append(x, xv), append(y, yv)
+	}
+	return NewTableXY(x, y)
+}
+
+// NewTable2 creates a new 2-D Table from a list of rows: a row holds the
+// (stringed) values for all x-values at one y-value; all rows must have the
+// same number of values.
+func NewTable2(rows []string) (tbl *Table, res *Result) {
+	if len(rows) < 2 {
+		return nil, Failure(ErrParseTableTooSmall)
+	}
+	tbl = new(Table)
+	for _, row := range rows {
+		vals := strings.Split(row, ",")
+		if len(vals) < 2 {
+			return nil, Failure(ErrParseTableTooSmall)
+		}
+		if tbl.Cols == 0 {
+			tbl.Cols = len(vals)
+		} else if len(vals) != tbl.Cols {
+			return nil, Failure(ErrParseTableFormat+": %d values in row (expected %d)", len(vals), tbl.Cols)
+		}
+		for _, v := range vals {
+			val, ok := parseNumber(v)
+			if !ok {
+				return nil, Failure(ErrParseNotANumber+": %s", v)
+			}
+			tbl.Data = append(tbl.Data, val)
+		}
+	}
+	return tbl, Success()
+}
+
+// node returns the normalized [0,1] x-value of the i-th table value.
+func (tbl *Table) node(i int) float64 {
+	if !tbl.uneven {
+		return float64(i) / float64(len(tbl.Data)-1)
+	}
+	n := len(tbl.X) - 1
+	return (tbl.X[i] - tbl.X[0]) / (tbl.X[n] - tbl.X[0])
+}
 
-	// precompute coefficients for Newton polynominal interpolation
+// init precomputes the coefficients for Newton polynominal interpolation.
+func (tbl *Table) init() {
+	num := len(tbl.Data)
 	step := 1. / float64(num-1)
 	var a_mj func(int, int) float64
 	a_mj = func(m, j int) (y float64) {
 		if m == j {
 			y = tbl.Data[m]
+		} else if tbl.uneven {
+			y = (a_mj(m+1, j) - a_mj(m, j-1)) / (tbl.node(j) - tbl.node(m))
 		} else {
 			y = (a_mj(m+1, j) - a_mj(m, j-1)) / (float64(j-m) * step)
 		}
@@ -691,18 +1001,22 @@ func NewTable(list []string) (tbl *Table, res *Result) {
 	for j := 0; j < num; j++ {
 		tbl.A_j[j] = a_mj(0, j)
 	}
-	return
 }
 
 // Newton polynominal interpolation that relies on 'divided differences'.
-// 'x' is normalized [0,1]; points are equidistant with given step size.
+// 'x' is normalized [0,1]; points are equidistant with given step size
+// (or at the normalized x-values of the table).
 func (tbl *Table) Newton(x Variable) Variable {
 	num := len(tbl.A_j)
 	step := 1.0 / float64(num-1)
 	n_j := func(x Variable, j int) (y float64) {
 		y = 1.0
 		for i := 0; i < j; i++ {
-			y *= (float64(x) - float64(i)*step)
+			if tbl.uneven {
+				y *= (float64(x) - tbl.node(i))
+			} else {
+				y *= (float64(x) - float64(i)*step)
+			}
 		}
 		return
 	}
@@ -734,7 +1048,7 @@ func asOld(v string) (string, *Result) {
 
 // resolve returns a value from a number string or variable name
 func resolve(x string, mdl *Model) (val Variable, res *Result) {
-	if v, err := strconv.ParseFloat(x, 64); err == nil {
+	if v, ok := parseNumber(x); ok {
 		// variable is a number
 		val, res = Variable(v), Success()
 	} else {
@@ -751,6 +1065,243 @@ func resolve(x string, mdl *Model) (val Variable, res *Result) {
 	return
 }
 
+//----------------------------------------------------------------------
+// Function arguments are resolved once (when the equation is compiled)
+// and not re-parsed whenever a function is called.
+//----------------------------------------------------------------------
+
+// Kinds of function arguments
+const (
+	ARG_CONST = iota // constant value (number)
+	ARG_VAR          // variable (or name of a table, series, ...)
+	ARG_EXPR         // expression (evaluated before the function call)
+)
+
+// Arg is a resolved function argument
+type Arg struct {
+	Kind int    // kind of argument (ARG_???)
+	Text string // argument in DYNAMO notation (number or name)
+
+	val  Variable // value of constant (or evaluated expression)
+	name *Name    // name of variable
+	old  *Name    // name of variable in old notation (L.J, R.JK)
+	expr ast.Expr // expression
+}
+
+// NewArg returns a resolved argument for a number or name.
+func NewArg(text string) (arg *Arg, res *Result) {
+	arg = &Arg{Kind: ARG_CONST, Text: text}
+	if v, ok := parseNumber(text); ok {
+		arg.val = Variable(v)
+		return arg, Success()
+	}
+	arg.Kind = ARG_VAR
+	if arg.name, res = NewNameFromString(text); !res.Ok {
+		return
+	}
+	var old string
+	if old, res = asOld(text); !res.Ok {
+		return
+	}
+	arg.old, res = NewNameFromString(old)
+	return
+}
+
+// newArg resolves an argument expression.
+func newArg(expr ast.Expr) (arg *Arg, res *Result) {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		return NewArg(x.Name)
+	case *ast.SelectorExpr:
+		var n *Name
+		if n, res = NewName(x); !res.Ok {
+			return
+		}
+		return NewArg(n.Name + n.GetIndex())
+	case *ast.BasicLit:
+		return NewArg(x.Value)
+	case *ast.BinaryExpr, *ast.ParenExpr, *ast.CallExpr, *ast.UnaryExpr:
+		return &Arg{Kind: ARG_EXPR, expr: x}, Success()
+	}
+	return nil, Failure(ErrModelFunctionArg+": %s", reflect.TypeOf(expr))
+}
+
+// String returns the argument in DYNAMO notation (or the value of an
+// evaluated expression).
+func (a *Arg) String() string {
+	if a.Kind == ARG_EXPR {
+		return strconv.FormatFloat(float64(a.val), 'g', -1, 64)
+	}
+	return a.Text
+}
+
+// Value of the argument in the current model state.
+func (a *Arg) Value(mdl *Model) (val Variable, res *Result) {
+	if a.Kind != ARG_VAR {
+		return a.val, Success()
+	}
+	return a.get(a.name, mdl)
+}
+
+// Old returns the value of the argument in old notation (L.J, R.JK).
+func (a *Arg) Old(mdl *Model) (val Variable, res *Result) {
+	if a.Kind != ARG_VAR {
+		return a.val, Success()
+	}
+	return a.get(a.old, mdl)
+}
+
+// get the value of a variable (or its initial value if the variable is
+// not in the model state)
+func (a *Arg) get(name *Name, mdl *Model) (val Variable, res *Result) {
+	if val, res = mdl.Get(name); !res.Ok {
+		if name.Name[0] != '_' {
+			// get initial value for non-internal variables
+			val, res = mdl.Initial(name.Name)
+		}
+	}
+	return
+}
+
+// args returns the resolved arguments of a function call; the arguments
+// are resolved on first use.
+func (mdl *Model) args(call *ast.CallExpr) (args []*Arg, res *Result) {
+	var ok bool
+	if args, ok = mdl.calls[call]; ok {
+		return args, Success()
+	}
+	args = make([]*Arg, len(call.Args))
+	for i, x := range call.Args {
+		if args[i], res = newArg(x); !res.Ok {
+			return
+		}
+	}
+	mdl.calls[call] = args
+	return args, Success()
+}
+
+// startTime returns the start time of a run (or the initial time if the
+// model is initialized).
+func (mdl *Model) startTime() (val Variable, res *Result) {
+	if mdl.run != nil {
+		return mdl.run.start, Success()
+	}
+	if val, res = resolve("TIME", mdl); !res.Ok {
+		// time starts at 0 if not initialized
+		val, res = 0, Success()
+	}
+	return
+}
+
+// checkDelayInput returns a check function for the input (first argument)
+// of a delay: it must be a rate from OLD state (A.JK) or a level or aux
+// from NEW state (A.K).
+func checkDelayInput(fcn string) func(args []ast.Expr) *Result {
+	return func(args []ast.Expr) *Result {
+		n, res := NewName(args[0])
+		if !res.Ok {
+			return res
+		}
+		if n.Kind == NAME_KIND_RATE && n.Stage == NAME_STAGE_OLD {
+			return Success()
+		}
+		if n.Kind == NAME_KIND_LEVEL && n.Stage == NAME_STAGE_NEW {
+			return Success()
+		}
+		return Failure(ErrModelFunction+": %s --  %s%s not a rate (JK) or aux (K)", fcn, n.Name, n.GetIndex())
+	}
+}
+
+// checkInfoInput returns a check function for the input (first argument)
+// of an information delay: it must be a level or aux from NEW state.
+func checkInfoInput(fcn string) func(args []ast.Expr) *Result {
+	return func(args []ast.Expr) *Result {
+		n, res := NewName(args[0])
+		if !res.Ok {
+			return res
+		}
+		if n.Kind != NAME_KIND_LEVEL {
+			return Failure(ErrModelFunction+": %s --  %s not a level or aux", fcn, n.String())
+		}
+		if n.Stage != NAME_STAGE_NEW {
+			return Failure(ErrModelFunction+": %s --  %s%s not new", fcn, n.Name, n.GetIndex())
+		}
+		return Success()
+	}
+}
+
+// checkInput returns a check function for the input (first argument) of
+// a function: it must be a level, rate or aux.
+func checkInput(fcn string) func(args []ast.Expr) *Result {
+	return func(args []ast.Expr) *Result {
+		n, res := NewName(args[0])
+		if !res.Ok {
+			return res
+		}
+		if n.Kind != NAME_KIND_LEVEL &&
+			n.Kind != NAME_KIND_RATE &&
+			n.Kind != NAME_KIND_AUX {
+			return Failure(ErrModelFunction+": %s --  %s not a level, rate or aux", fcn, n.String())
+		}
+		return Success()
+	}
+}
+
+// trend computes the fractional trend of an input from the arguments
+// (A.K,TA[,TRI],AVG): the input is averaged (like SMOOTH) in the internal
+// level AVG (initialized for the initial trend TRI); the trend is the
+// difference between input and average relative to the average and the
+// averaging time. Returns the (old) input value and its trend.
+func trend(args []*Arg, mdl *Model) (a, val Variable, res *Result) {
+	var ta, tri, dt, avg Variable
+	if a, res = args[0].Old(mdl); !res.Ok {
+		return
+	}
+	if ta, res = args[1].Value(mdl); !res.Ok {
+		return
+	}
+	if len(args) > 3 {
+		if tri, res = args[2].Value(mdl); !res.Ok {
+			return
+		}
+	}
+	if dt, res = resolve("DT", mdl); !res.Ok {
+		return
+	}
+	// get old internal state
+	state := args[len(args)-1].Text
+	var ok bool
+	if avg, ok = mdl.auto.get(state); !ok {
+		// no internal state: initializing...
+		avg = a / (1 + tri*ta)
+		mdl.auto.put(state, avg)
+	}
+	if avg != 0 {
+		val = (a - avg) / (ta * Variable(math.Abs(float64(avg))))
+	}
+	// compute new internal state
+	if ok {
+		mdl.auto.put(state, avg+(dt/ta)*(a-avg))
+	}
+	return
+}
+
+// fold combines the values of all arguments from left to right.
+func fold(args []*Arg, mdl *Model, f func(v, x Variable) Variable) (val Variable, res *Result) {
+	var x Variable
+	for i, arg := range args {
+		if x, res = arg.Value(mdl); !res.Ok {
+			return
+		}
+		if i == 0 {
+			val = x
+		} else {
+			val = f(val, x)
+		}
+	}
+	return
+}
+
 // compare a variable to a value
 func compare(v float64, x float64) int {
 	if math.Abs(v-x) < 1e-9 {
@@ -766,75 +1317,210 @@ func compare(v float64, x float64) int {
 // TABLEs
 //----------------------------------------------------------------------
 
+// table functions (first argument is the table name)
+var tableFcns = map[string]bool{
+	"TABLE":  true,
+	"TABHL":  true,
+	"TABXT":  true,
+	"TABPL":  true,
+	"TABLE2": true,
+}
+
 // generic table handling
-func table(args []string, mdl *Model, mode int) (val Variable, res *Result) {
-	Dbg.Msgf("Function TABLE(%d) called with %v\n", mode, args)
+func table(args []*Arg, mdl *Model, mode int) (val Variable, res *Result) {
+	mdl.Dbg.Tracef("Function TABLE(%d) called with %v\n", mode, args)
 
 	// lookup table from name
-	tbl, ok := mdl.Tables[args[0]]
+	tbl, ok := mdl.Tables[args[0].Text]
 	if !ok {
-		res = Failure(ErrModelNoSuchTable+": %s", args[0])
+		res = Failure(ErrModelNoSuchTable+": %s", args[0].Text)
+		return
+	}
+	if tbl.Cols > 0 {
+		res = Failure(ErrModelWrongTableSize+": %s is a 2-D table", args[0].Text)
 		return
 	}
+	if tbl.Kind == TBL_EXTRAPOLATE && mode == 0 {
+		mode = 1
+	}
 	// get table parameters
 	var x, min, max, step Variable
-	if x, res = resolve(args[1], mdl); !res.Ok {
+	if x, res = args[1].Value(mdl); !res.Ok {
 		return
 	}
-	if min, res = resolve(args[2], mdl); !res.Ok {
+	if min, res = args[2].Value(mdl); !res.Ok {
 		return
 	}
-	if max, res = resolve(args[3], mdl); !res.Ok {
+	if max, res = args[3].Value(mdl); !res.Ok {
 		return
 	}
-	if step, res = resolve(args[4], mdl); !res.Ok {
+	if step, res = args[4].Value(mdl); !res.Ok {
 		return
 	}
 	// check if parameters match table data
-	n := Variable(len(tbl.Data) - 1)
-	if (max - min).Compare(n*step) != 0 {
-		res = Failure(ErrModelWrongTableSize)
+	if res = tbl.check(args[0].Text, min, max, step); !res.Ok {
 		return
 	}
-	// get inter-/extrapolation parameters
-	pos := n * (x - min) / (max - min)
-	idx := int(pos.Floor())
-	frac := pos - Variable(idx)
-	Dbg.Msgf("TABLE: x=%f, pos=%f, idx=%d, frac=%f\n", x, pos, idx, frac)
+	// get position in table data
+	n := Variable(len(tbl.Data) - 1)
+	pos := tbl.position(x, min, max)
+	mdl.Dbg.Tracef("TABLE: x=%f, pos=%f\n", x, pos)
 
 	// check for "range check" argument
 	below := (pos.Compare(0) < 0)
 	above := (pos.Compare(n) >= 0)
-	state := 0
+	if below || pos.Compare(n) > 0 {
+		// handle table range exits (run policy)
+		var action string
+		if action, res = mdl.anomaly(ANOMALY_TABLE, args[0].Text, float64(x)); !res.Ok {
+			return
+		}
+		if action == POLICY_CLAMP {
+			// no extrapolation (TABXT)
+			mode = 0
+		}
+	}
 	if len(args) == 6 {
-		if region, ok := mdl.Current[args[5]]; ok {
-			state = int(region)
-		} else {
-			// start with "inside" status
-			state = 0
-			mdl.Current[args[5]] = 0
-		}
-		// range check
-		if (below || above) && state != -1 {
-			to := "below"
-			state = -1
-			if above {
-				to = "above"
-				state = 1
+		// range check (a new region variable starts "inside")
+		state, _ := mdl.auto.get(args[5].Text)
+		mdl.auto.put(args[5].Text, Variable(tableRegion(args[0].Text, int(state), below, above)))
+	}
+	val = tbl.value(pos, n, mode)
+	res = Success()
+	return
+}
+
+// 2-D table lookup with bilinear interpolation; arguments outside the
+// table range are clamped to the border of the table.
+func table2(args []*Arg, mdl *Model) (val Variable, res *Result) {
+	mdl.Dbg.Tracef("Function TABLE2 called with %v\n", args)
+
+	// lookup table from name
+	name := args[0].Text
+	tbl, ok := mdl.Tables[name]
+	if !ok {
+		res = Failure(ErrModelNoSuchTable+": %s", name)
+		return
+	}
+	if tbl.Cols == 0 {
+		res = Failure(ErrModelWrongTableSize+": %s is not a 2-D table", name)
+		return
+	}
+	// get table parameters (x, y, x-range and y-range)
+	var p [8]Variable
+	for i := range p {
+		if p[i], res = args[i+1].Value(mdl); !res.Ok {
+			return
+		}
+	}
+	// check if parameters match table data
+	cols, rows := tbl.Cols, len(tbl.Data)/tbl.Cols
+	if (p[3]-p[2]).Compare(Variable(cols-1)*p[4]) != 0 ||
+		(p[6]-p[5]).Compare(Variable(rows-1)*p[7]) != 0 {
+		res = Failure(ErrModelWrongTableSize)
+		return
+	}
+	// get (clamped) cell and fractions in table data
+	cell := func(v, min, max Variable, n int) (idx int, frac Variable) {
+		last := Variable(n - 1)
+		pos := last * (v - min) / (max - min)
+		if pos.Compare(0) < 0 || pos.Compare(last) > 0 {
+			// handle table range exits (run policy)
+			if _, res = mdl.anomaly(ANOMALY_TABLE, name, float64(v)); !res.Ok {
+				return
 			}
-			Msgf("WARN: Leaving table range '%s' to %s...\n", args[0], to)
-		} else if !(below || above) && state != 0 {
-			from := "below"
-			if state == 1 {
-				from = "above"
+			if pos = last; v < min {
+				pos = 0
 			}
-			state = 0
-			Msgf("WARN: Entering table range '%s'from %s...\n", args[0], from)
 		}
-		mdl.Current[args[5]] = Variable(state)
+		if idx = int(pos.Floor()); idx == n-1 {
+			idx--
+		}
+		return idx, pos - Variable(idx)
+	}
+	i, fx := cell(p[0], p[2], p[3], cols)
+	if !res.Ok {
+		return
 	}
-	// handle region (below, inside, above) of position relative to table data.
-	if below {
+	j, fy := cell(p[1], p[5], p[6], rows)
+	if !res.Ok {
+		return
+	}
+	mdl.Dbg.Tracef("TABLE2: x=%f, y=%f, cell=(%d,%d)\n", p[0], p[1], i, j)
+
+	// bilinear interpolation
+	at := func(i, j int) Variable {
+		return Variable(tbl.Data[j*cols+i])
+	}
+	lo := at(i, j) + (at(i+1, j)-at(i, j))*fx
+	hi := at(i, j+1) + (at(i+1, j+1)-at(i, j+1))*fx
+	return lo + (hi-lo)*fy, Success()
+}
+
+// check if the table parameters match the table data.
+func (tbl *Table) check(name string, min, max, step Variable) *Result {
+	n := Variable(len(tbl.Data) - 1)
+	if !tbl.uneven && (max-min).Compare(n*step) != 0 {
+		// (the step size of tables with non-equidistant x-values is not
+		// checked)
+		return Failure(ErrModelWrongTableSize)
+	}
+	// check if parameters match the x-values of the table (if defined)
+	if tbl.X != nil {
+		if min.Compare(Variable(tbl.X[0])) != 0 || max.Compare(Variable(tbl.X[len(tbl.X)-1])) != 0 {
+			return Failure(ErrModelWrongTableRange+": %s", name)
+		}
+	}
+	return Success()
+}
+
+// tableRegion returns the new region of a table argument (-1: below,
+// 0: inside, 1: above) and logs when the table range is left or entered.
+func tableRegion(name string, state int, below, above bool) int {
+	if (below || above) && state != -1 {
+		to := "below"
+		state = -1
+		if above {
+			to = "above"
+			state = 1
+		}
+		Logf(LOG_WARN, LOG_RUN, "Leaving table range '%s' to %s...\n", name, to)
+	} else if !(below || above) && state != 0 {
+		from := "below"
+		if state == 1 {
+			from = "above"
+		}
+		state = 0
+		Logf(LOG_WARN, LOG_RUN, "Entering table range '%s'from %s...\n", name, from)
+	}
+	return state
+}
+
+// position returns the (fractional) index of x in the table data for the
+// table range [min,max]. The position of tables with non-equidistant
+// x-values is interpolated between the neighbouring x-values (or
+// extrapolated from the first and last interval).
+func (tbl *Table) position(x, min, max Variable) Variable {
+	n := len(tbl.Data) - 1
+	if !tbl.uneven {
+		return Variable(n) * (x - min) / (max - min)
+	}
+	xs := tbl.X
+	i := sort.SearchFloat64s(xs, float64(x)) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= n {
+		i = n - 1
+	}
+	return Variable(i) + (x-Variable(xs[i]))/Variable(xs[i+1]-xs[i])
+}
+
+// value returns the table value at a position (below, inside or above the
+// table data) with given inter-/extrapolation mode.
+func (tbl *Table) value(pos, n Variable, mode int) (val Variable) {
+	idx := int(pos.Floor())
+	frac := pos - Variable(idx)
+	if pos.Compare(0) < 0 {
 		// outside left
 		if mode == 1 {
 			// linear extrapolation
@@ -843,7 +1529,7 @@ func table(args []string, mdl *Model, mode int) (val Variable, res *Result) {
 			// first table value
 			val = Variable(tbl.Data[0])
 		}
-	} else if above {
+	} else if pos.Compare(n) >= 0 {
 		// outside right
 		last := len(tbl.Data) - 1
 		if mode == 1 {
@@ -853,13 +1539,23 @@ func table(args []string, mdl *Model, mode int) (val Variable, res *Result) {
 			// last table value
 			val = Variable(tbl.Data[last])
 		}
+	} else if tbl.Kind == TBL_DISCRETE {
+		// inside discrete table: step function
+		if frac.Compare(1) == 0 {
+			idx++
+		}
+		val = Variable(tbl.Data[idx])
 	} else if mode == 2 {
 		// inside TABPL: polynominal approximation
-		val = tbl.Newton(pos / n)
+		if tbl.uneven {
+			lo, hi := Variable(tbl.node(idx)), Variable(tbl.node(idx+1))
+			val = tbl.Newton(lo + frac*(hi-lo))
+		} else {
+			val = tbl.Newton(pos / n)
+		}
 	} else {
 		// inside TABLE,TABHL,TABXT: linear interpolation
 		val = Variable(tbl.Data[idx+1]-tbl.Data[idx])*frac + Variable(tbl.Data[idx])
 	}
-	res = Success()
 	return
 }
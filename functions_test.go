@@ -1,7 +1,12 @@
 package dynamo
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"go/ast"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -100,6 +105,96 @@ func TestFcnTable(t *testing.T) {
 	}
 }
 
+func TestFcnTableXY(t *testing.T) {
+
+	mdl := NewModel("", "")
+	stmt := &Line{
+		Mode: "T",
+		Stmt: "TEST=(0,0),(1,2),(4,5),(10,8)",
+	}
+	res := mdl.AddStatement(stmt)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	for _, c := range []struct {
+		fcn  string
+		x, y float64
+	}{
+		{"TABLE", 0.5, 1},
+		{"TABLE", 2.5, 3.5},
+		{"TABLE", 7, 6.5},
+		{"TABHL", -1, 0},
+		{"TABHL", 12, 8},
+		{"TABXT", 12, 9},
+		{"TABPL", 4, 5},
+	} {
+		xs := fmt.Sprintf("%f", c.x)
+		val, res := CallFunction(c.fcn, []string{"TEST", xs, "0", "10", "1"}, mdl)
+		if !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if compare(float64(val), c.y) != 0 {
+			t.Fatalf("%s(%f): %f != %f", c.fcn, c.x, val, c.y)
+		}
+	}
+	// x-values must be increasing
+	stmt.Stmt = "BAD=(0,0),(2,1),(1,2)"
+	if res = mdl.AddStatement(stmt); res.Ok {
+		t.Fatal("non-increasing x-values accepted")
+	}
+	// pairs are kept in the model source
+	for _, line := range mdl.Statements() {
+		if line.Mode == "T" && line.Stmt != "TEST=(0,0),(1,2),(4,5),(10,8)" {
+			t.Fatalf("source mismatch: %s", line.Stmt)
+		}
+	}
+}
+
+func TestFcnTable2(t *testing.T) {
+
+	mdl := NewModel("", "")
+	stmt := &Line{
+		Mode: "T2",
+		Stmt: "TEST=0,1,2/10,11,12",
+	}
+	res := mdl.AddStatement(stmt)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	for _, c := range []struct {
+		x, y string
+		val  float64
+	}{
+		{"0", "0", 0},
+		{"1", "5", 6},
+		{"0.5", "2", 2.5},
+		{"-1", "12", 10},
+		{"3", "-1", 2},
+	} {
+		args := []string{"TEST", c.x, c.y, "0", "2", "1", "0", "10", "10"}
+		val, res := CallFunction("TABLE2", args, mdl)
+		if !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if compare(float64(val), c.val) != 0 {
+			t.Fatalf("TABLE2(%s,%s): %f != %f", c.x, c.y, val, c.val)
+		}
+	}
+	// range mismatch
+	if _, res = CallFunction("TABLE2", []string{"TEST", "0", "0", "0", "3", "1", "0", "10", "10"}, mdl); res.Ok {
+		t.Fatal("range mismatch not detected")
+	}
+	// 1-D table functions don't accept 2-D tables
+	if _, res = CallFunction("TABLE", []string{"TEST", "0", "0", "2", "1"}, mdl); res.Ok {
+		t.Fatal("2-D table accepted in TABLE")
+	}
+	// rows must have the same length
+	stmt.Stmt = "BAD=0,1,2/10,11"
+	if res = mdl.AddStatement(stmt); res.Ok {
+		t.Fatal("ragged table accepted")
+	}
+}
+
 func TestFcnTabpl(t *testing.T) {
 	pnts := []string{"0", "2.8", "5.5", "8", "9.5", "10"}
 	tbl, res := NewTable(pnts)
@@ -113,3 +208,186 @@ func TestFcnTabpl(t *testing.T) {
 		}
 	}
 }
+
+func TestFcnTableFile(t *testing.T) {
+
+	// write two-column table data to file
+	fname := filepath.Join(t.TempDir(), "Table.csv")
+	data := "X;Y\n0;0\n0.2;2.8\n0.4;5.5\n0.6;8\n0.8;9.5\n1.0;10\n"
+	if err := os.WriteFile(fname, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mdl := NewModel("", "")
+	buf := bytes.NewBufferString("T     TEST=@" + fname + "\n")
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	val, res := CallFunction("TABLE", []string{"TEST", "0.5", "0", "1", "0.2"}, mdl)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if compare(float64(val), 6.75) != 0 {
+		t.Fatalf("Value mismatch: %f != %f", val, 6.75)
+	}
+	// range check
+	_, res = CallFunction("TABLE", []string{"TEST", "0.5", "1", "2", "0.2"}, mdl)
+	if res.Ok || !errors.Is(res, ErrorKind(ErrModelWrongTableRange)) {
+		t.Fatal("range check failed")
+	}
+}
+
+func TestFcnTableXMILE(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "model.stmx")
+	data := `<xmile version="1.0"><model><variables>
+	<aux name="effect of price"><eqn>price</eqn>
+		<gf><xscale min="0" max="10"/><xpts>0,1,4,10</xpts><ypts>0,2,5,8</ypts></gf>
+	</aux>
+	<gf name="steps" type="discrete"><xscale min="0" max="2"/><ypts sep=";">1;2;3</ypts></gf>
+	<gf name="trend" type="extrapolate"><xscale min="0" max="1"/><ypts>0,1</ypts></gf>
+	</variables></model></xmile>`
+	if err := os.WriteFile(fname, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mdl := NewModel("", "")
+	src := "T EFFECT_OF_PRICE=@" + fname + "\nT STEPS=@" + fname + "\nT TREND=@" + fname + "\n"
+	if res := mdl.Parse(bytes.NewBufferString(src)); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	for _, tc := range []struct {
+		fcn  string
+		args []string
+		val  float64
+	}{
+		{"TABLE", []string{"EFFECT_OF_PRICE", "2", "0", "10", "0"}, 3},
+		{"TABLE", []string{"EFFECT_OF_PRICE", "7", "0", "10", "0"}, 6.5},
+		{"TABHL", []string{"EFFECT_OF_PRICE", "12", "0", "10", "0"}, 8},
+		{"TABXT", []string{"EFFECT_OF_PRICE", "-1", "0", "10", "0"}, -2},
+		{"TABLE", []string{"STEPS", "1.5", "0", "2", "1"}, 2},
+		{"TABLE", []string{"STEPS", "2", "0", "2", "1"}, 3},
+		{"TABLE", []string{"TREND", "2", "0", "1", "1"}, 2},
+	} {
+		val, res := CallFunction(tc.fcn, tc.args, mdl)
+		if !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if compare(float64(val), tc.val) != 0 {
+			t.Fatalf("%s%v: %f != %f", tc.fcn, tc.args, val, tc.val)
+		}
+	}
+	// unknown graphical function
+	if res := mdl.Parse(bytes.NewBufferString("T PRICE=@" + fname + "\n")); !errors.Is(res, ErrorKind(ErrModelNoSuchTable)) {
+		t.Fatal("unknown graphical function imported")
+	}
+}
+
+func TestFcnExtdatFile(t *testing.T) {
+
+	// write data series to file
+	fname := filepath.Join(t.TempDir(), "Data.csv")
+	data := "TIME,POP,GDP\n0,100,10\n10,200,30\n"
+	if err := os.WriteFile(fname, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mdl := NewModel("", "")
+	src := "A GDP.K=EXTDAT(\"" + fname + "\",\"gdp\")\n"
+	if res := mdl.Parse(bytes.NewBufferString(src)); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	mdl.Current["TIME"] = 5
+	val, res := CallFunction("EXTDAT", []string{`"` + fname + `"`, `"GDP"`}, mdl)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if compare(float64(val), 20) != 0 {
+		t.Fatalf("Value mismatch: %f != %f", val, 20.)
+	}
+	// unknown column
+	mdl = NewModel("", "")
+	src = "A GDP.K=EXTDAT(\"" + fname + "\",\"NONE\")\n"
+	if res := mdl.Parse(bytes.NewBufferString(src)); res.Ok {
+		t.Fatal("unknown column accepted")
+	}
+}
+
+func TestFcnArgs(t *testing.T) {
+	mdl := NewModel("", "")
+	src := []string{
+		"A Y.K=MAX(-X.K,-1)+SMOOTH(Z.K,2)",
+		"A X.K=0.123456789",
+		"A Z.K=X.K",
+	}
+	for _, line := range src {
+		if res := mdl.AddStatement(&Line{Mode: "A", Stmt: line[2:]}); !res.Ok {
+			t.Fatal(res.Err)
+		}
+	}
+	// arguments are resolved once
+	eqn := mdl.Eqns.Find("Y")
+	var calls []*ast.CallExpr
+	ast.Inspect(eqn.Formula, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			calls = append(calls, call)
+		}
+		return true
+	})
+	if len(calls) != 2 || len(mdl.calls) != 2 {
+		t.Fatalf("%d calls resolved", len(mdl.calls))
+	}
+	kinds := []int{ARG_EXPR, ARG_EXPR, ARG_VAR, ARG_CONST, ARG_VAR}
+	args := append(mdl.calls[calls[0]], mdl.calls[calls[1]]...)
+	if len(args) != len(kinds) {
+		t.Fatalf("%d arguments", len(args))
+	}
+	for i, arg := range args {
+		if arg.Kind != kinds[i] {
+			t.Fatalf("argument %d: kind %d", i, arg.Kind)
+		}
+	}
+	if args[2].old.Stage != NAME_STAGE_OLD {
+		t.Fatal("no old notation of argument")
+	}
+	// values of expressions are passed with full precision
+	x := Variable(0.123456789)
+	mdl.Current["X"], mdl.Current["DT"], mdl.Last["Z"] = x, 1, 1
+	val, res := eqn.Eval(mdl)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if val != -x+1 {
+		t.Fatalf("Value mismatch: %.9f", val)
+	}
+}
+
+func TestFcnVarArgs(t *testing.T) {
+	mdl := NewModel("", "")
+	for _, tc := range []struct {
+		fcn  string
+		args []string
+		val  float64
+	}{
+		{"MAX", []string{"1", "3"}, 3},
+		{"MAX", []string{"1", "-2", "5", "4"}, 5},
+		{"MIN", []string{"1", "-2", "5", "4"}, -2},
+		{"SUM", []string{"1.5"}, 1.5},
+		{"SUM", []string{"1", "-2", "5", "4"}, 8},
+		{"MEAN", []string{"1", "-2", "5", "4"}, 2},
+	} {
+		val, res := CallFunction(tc.fcn, tc.args, mdl)
+		if !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if compare(float64(val), tc.val) != 0 {
+			t.Fatalf("%s%v: %f != %f", tc.fcn, tc.args, val, tc.val)
+		}
+	}
+	// number of arguments and dependencies
+	if res := mdl.AddStatement(&Line{Mode: "A", Stmt: "Y.K=MAX(A.K)"}); res.Ok {
+		t.Fatal("MAX with one argument accepted")
+	}
+	if res := mdl.AddStatement(&Line{Mode: "A", Stmt: "Y.K=SUM(A.K,B.K,C.K)"}); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if deps := mdl.Eqns.Find("Y").Dependencies; len(deps) != 3 {
+		t.Fatalf("%d dependencies", len(deps))
+	}
+}
@@ -0,0 +1,253 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+//======================================================================
+// FUZZ -- Random (syntactically valid) DYNAMO models are generated and
+// run through parsing, sorting, validation and model runs to find panics
+// and inconsistencies in the interpreter (and its extensions). Generated
+// models have levels with bounded inflows and stable outflows, auxiliaries
+// and rates using functions and tables; the equations are listed in random
+// order. A model run is checked for:
+//
+//   * panics and failures (like numeric anomalies),
+//   * deterministic results (repeated runs with the same seed), and
+//   * independence of the source order of equations (for models without
+//     random numbers).
+//======================================================================
+
+// FuzzModel is a randomly generated DYNAMO model
+type FuzzModel struct {
+	Eqns       []string // equations and tables (in random order)
+	Spec       string   // SPEC statement
+	Stochastic bool     // model uses random numbers (NOISE)
+}
+
+// fuzz generator state
+type fuzzGen struct {
+	rng  *rand.Rand
+	fm   *FuzzModel
+	nl   int  // number of levels
+	na   int  // number of auxiliaries
+	nc   int  // number of constants
+	nt   int  // number of tables
+	aux  int  // auxiliaries usable in expressions (1..aux)
+	rand bool // random numbers allowed in expressions
+	eqns []string
+}
+
+// NewFuzzModel generates a random model; the size limits the number of
+// levels, auxiliaries and constants in the model.
+func NewFuzzModel(rng *rand.Rand, size int) *FuzzModel {
+	if size < 1 {
+		size = 1
+	}
+	g := &fuzzGen{
+		rng:  rng,
+		fm:   new(FuzzModel),
+		nl:   1 + rng.Intn(size),
+		na:   rng.Intn(size + 1),
+		nc:   1 + rng.Intn(size),
+		nt:   rng.Intn(3),
+		rand: rng.Intn(4) == 0,
+	}
+	// constants (in the range 1..10) and tables
+	for i := 1; i <= g.nc; i++ {
+		if i > 1 && rng.Intn(4) == 0 {
+			g.add("C CON%d=CON%d+%.1f", i, 1+rng.Intn(i-1), rng.Float64())
+		} else {
+			g.add("C CON%d=%.2f", i, 1+9*rng.Float64())
+		}
+	}
+	for i := 1; i <= g.nt; i++ {
+		vals := make([]string, 6)
+		for j := range vals {
+			vals[j] = fmt.Sprintf("%.1f", 10*rng.Float64())
+		}
+		g.add("T TAB%d=%s", i, strings.Join(vals, "/"))
+	}
+	// auxiliaries only use auxiliaries with lower index (no loops)
+	for i := 1; i <= g.na; i++ {
+		g.aux = i - 1
+		g.add("A AUX%d.K=%s", i, g.expr(3))
+	}
+	// levels with bounded inflows and outflows
+	g.aux = g.na
+	for i := 1; i <= g.nl; i++ {
+		g.add("L LEV%d.K=LEV%d.J+DT*(RIN%d.JK-ROUT%d.JK)", i, i, i, i)
+		if rng.Intn(2) == 0 {
+			g.add("N LEV%d=%.1f", i, 10*rng.Float64())
+		} else {
+			g.add("N LEV%d=%s", i, g.con())
+		}
+		g.add("R RIN%d.KL=MIN(MAX(%s,0),%s)", i, g.expr(3), g.con())
+		g.add("R ROUT%d.KL=LEV%d.K/%s", i, i, g.con())
+	}
+	rng.Shuffle(len(g.eqns), func(i, j int) {
+		g.eqns[i], g.eqns[j] = g.eqns[j], g.eqns[i]
+	})
+	g.fm.Eqns = g.eqns
+	g.fm.Spec = fmt.Sprintf("SPEC DT=0.25/LENGTH=%d/PRTPER=0/PLTPER=0", 10+rng.Intn(41))
+	return g.fm
+}
+
+// add a statement to the generated model
+func (g *fuzzGen) add(format string, args ...interface{}) {
+	g.eqns = append(g.eqns, fmt.Sprintf(format, args...))
+}
+
+// con returns the name of a random constant.
+func (g *fuzzGen) con() string {
+	return fmt.Sprintf("CON%d", 1+g.rng.Intn(g.nc))
+}
+
+// term returns a random operand: level, auxiliary, constant or number.
+func (g *fuzzGen) term() string {
+	switch g.rng.Intn(4) {
+	case 0:
+		return fmt.Sprintf("LEV%d.K", 1+g.rng.Intn(g.nl))
+	case 1:
+		if g.aux > 0 {
+			return fmt.Sprintf("AUX%d.K", 1+g.rng.Intn(g.aux))
+		}
+	case 2:
+		return g.con()
+	}
+	return fmt.Sprintf("%.1f", 10*g.rng.Float64())
+}
+
+// expr returns a random expression of given maximum depth.
+func (g *fuzzGen) expr(depth int) string {
+	if depth == 0 {
+		return g.term()
+	}
+	depth--
+	switch g.rng.Intn(10) {
+	case 0, 1, 2:
+		op := []string{"+", "-", "*"}[g.rng.Intn(3)]
+		return g.expr(depth) + op + g.expr(depth)
+	case 3:
+		return "(" + g.expr(depth) + ")/" + g.con()
+	case 4:
+		fcn := []string{"MIN", "MAX"}[g.rng.Intn(2)]
+		return fmt.Sprintf("%s(%s,%s)", fcn, g.expr(depth), g.expr(depth))
+	case 5:
+		return fmt.Sprintf("CLIP(%s,%s,%s,%s)", g.expr(depth), g.expr(depth), g.term(), g.term())
+	case 6:
+		if g.nt > 0 {
+			return fmt.Sprintf("TABHL(TAB%d,%s,0,10,2)", 1+g.rng.Intn(g.nt), g.expr(depth))
+		}
+	case 7:
+		return fmt.Sprintf("SMOOTH(LEV%d.K,%s)", 1+g.rng.Intn(g.nl), g.con())
+	case 8:
+		return fmt.Sprintf("STEP(%s,%d)", g.con(), 1+g.rng.Intn(10))
+	case 9:
+		if g.rand {
+			g.fm.Stochastic = true
+			return g.con() + "*NOISE()"
+		}
+	}
+	return g.term()
+}
+
+// Source returns the model source (with the equations in given order).
+func (fm *FuzzModel) Source(eqns []string) string {
+	lines := append(append([]string{}, eqns...), fm.Spec, "RUN FUZZ", "")
+	return strings.Join(lines, "\n")
+}
+
+// Check runs the generated model and reports panics and inconsistencies.
+func (fm *FuzzModel) Check(seed int64) (res *Result) {
+	src := fm.Source(fm.Eqns)
+	var ds, other *Dataset
+	if ds, res = FuzzRun(src, seed); !res.Ok {
+		return
+	}
+	// repeated runs have the same result
+	if other, res = FuzzRun(src, seed); !res.Ok {
+		return
+	}
+	if name := fuzzDiff(ds, other); len(name) > 0 {
+		return Failure(ErrFuzz+": non-deterministic value of %s", name)
+	}
+	// results don't depend on the source order of equations
+	if !fm.Stochastic {
+		eqns := make([]string, len(fm.Eqns))
+		for i, eqn := range fm.Eqns {
+			eqns[len(eqns)-1-i] = eqn
+		}
+		if other, res = FuzzRun(fm.Source(eqns), seed); !res.Ok {
+			return
+		}
+		if name := fuzzDiff(ds, other); len(name) > 0 {
+			return Failure(ErrFuzz+": value of %s depends on equation order", name)
+		}
+	}
+	return
+}
+
+// FuzzRun parses and runs a model source (with RUN statements); numeric
+// anomalies (NaN and overflow) abort the run. Panics are reported as
+// failures. Returns the result of the last run.
+func FuzzRun(src string, seed int64) (ds *Dataset, res *Result) {
+	defer func() {
+		if r := recover(); r != nil {
+			ds, res = nil, Failure(ErrFuzz+": panic: %v", r)
+		}
+	}()
+	mdl := NewModel("", "")
+	mdl.Seed = seed
+	mdl.Policy = NewPolicy()
+	if res = mdl.Policy.Set("NAN=ABORT,OVERFLOW=ABORT"); !res.Ok {
+		return
+	}
+	if res = mdl.Parse(strings.NewReader(src)); !res.Ok {
+		return
+	}
+	if ds = mdl.Results[mdl.RunID]; ds == nil {
+		res = Failure(ErrFuzz + ": no model run")
+	}
+	return
+}
+
+// fuzzDiff returns the name of the first variable with different values
+// in two datasets (or an empty string).
+func fuzzDiff(ds, other *Dataset) string {
+	for _, name := range ds.Names() {
+		v0, v1 := ds.Vars[name], other.Vars[name]
+		if len(v0) != len(v1) {
+			return name
+		}
+		for i, x := range v0 {
+			if math.Float64bits(x) != math.Float64bits(v1[i]) {
+				return name
+			}
+		}
+	}
+	return ""
+}
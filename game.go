@@ -0,0 +1,173 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//======================================================================
+// GAMING -- In gaming mode the simulation pauses every N time units,
+// shows the current state and asks the player for new values of
+// decision variables (constants) before continuing:
+//
+//     GAME 5/PRICE,HIRE/INV,BACKLG
+//
+// pauses every 5 time units, shows INV and BACKLG and asks for new
+// values for PRICE and HIRE.
+//======================================================================
+
+// input stream for console decisions
+var stdin io.Reader = os.Stdin
+
+// DecisionFunc is called in gaming mode: 'show' holds the values of the
+// variables shown to the player, 'decide' holds the current values of the
+// decision variables. New values are set in 'decide'.
+type DecisionFunc func(time Variable, show, decide State) *Result
+
+// Game defines the gaming parameters of a model run
+type Game struct {
+	Interval float64  // interval between decisions
+	Decide   []string // names of decision variables
+	Show     []string // names of variables shown
+
+	next     float64      // time of next decision
+	decision DecisionFunc // decision function for the run
+}
+
+// NewGame creates a new game from a GAME statement.
+func NewGame(stmt string) (game *Game, res *Result) {
+	res = Success()
+	grps := strings.Split(stmt, "/")
+	if len(grps) < 2 || len(grps) > 3 {
+		res = Failure(ErrParseSyntax+": %s", stmt)
+		return
+	}
+	game = new(Game)
+	var err error
+	if game.Interval, err = strconv.ParseFloat(grps[0], 64); err != nil || game.Interval <= 0 {
+		res = Failure(ErrModelGame+": invalid interval '%s'", grps[0])
+		return
+	}
+	game.Decide = strings.Split(grps[1], ",")
+	if len(grps) == 3 {
+		game.Show = strings.Split(grps[2], ",")
+	}
+	return
+}
+
+// start a game: check decision variables
+func (game *Game) start(mdl *Model) (res *Result) {
+	res = Success()
+	for _, name := range game.Decide {
+		if eqn := mdl.Eqns.Find(name); eqn == nil || eqn.Mode != "C" {
+			return Failure(ErrModelGame+": %s is not a constant", name)
+		}
+	}
+	for _, name := range game.Show {
+		if eqn := mdl.Eqns.Find(name); eqn == nil {
+			return Failure(ErrModelNoVariable+": %s", name)
+		}
+	}
+	game.next = float64(mdl.Current["TIME"]) + game.Interval
+	if game.decision = mdl.Decision; game.decision == nil {
+		game.decision = ConsoleDecision(stdin, MsgWriter())
+	}
+	return
+}
+
+// step is called for every epoch of a model run; if a decision is due,
+// the decision function is called.
+func (game *Game) step(mdl *Model) (res *Result) {
+	res = Success()
+	t := mdl.Current["TIME"]
+	if compare(float64(t), game.next) < 0 {
+		return
+	}
+	game.next += game.Interval
+	show := make(State)
+	for _, name := range game.Show {
+		show[name] = mdl.Current[name]
+	}
+	decide := make(State)
+	for _, name := range game.Decide {
+		decide[name] = mdl.Current[name]
+	}
+	// replay recorded decisions
+	rl := mdl.Replay
+	if rl != nil && rl.Replaying() {
+		for _, name := range game.Decide {
+			if mdl.Current[name], res = rl.next(REPLAY_DECIDE, name); !res.Ok {
+				break
+			}
+		}
+		return
+	}
+	if res = game.decision(t, show, decide); !res.Ok {
+		return
+	}
+	for _, name := range game.Decide {
+		mdl.Current[name] = decide[name]
+		if rl != nil {
+			if res = rl.record(mdl.epoch(), REPLAY_DECIDE, name, decide[name]); !res.Ok {
+				break
+			}
+		}
+	}
+	return
+}
+
+// ConsoleDecision returns a decision function that shows the state on a
+// stream and reads new values for decision variables from another stream.
+// An empty input keeps the current value.
+func ConsoleDecision(in io.Reader, out io.Writer) DecisionFunc {
+	rdr := bufio.NewReader(in)
+	return func(time Variable, show, decide State) *Result {
+		fmt.Fprintf(out, "\n   GAME  TIME %.3f\n", time)
+		for _, name := range show.Names() {
+			fmt.Fprintf(out, "      %-8s = %g\n", name, show[name])
+		}
+		for _, name := range decide.Names() {
+			for {
+				fmt.Fprintf(out, "      %-8s = %g ? ", name, decide[name])
+				line, err := rdr.ReadString('\n')
+				if line = strings.TrimSpace(line); len(line) > 0 {
+					val, err := strconv.ParseFloat(line, 64)
+					if err != nil {
+						fmt.Fprintln(out, "         "+ErrParseNotANumber)
+						continue
+					}
+					decide[name] = Variable(val)
+				}
+				if err != nil && err != io.EOF {
+					return Failure(err)
+				}
+				break
+			}
+		}
+		return Success()
+	}
+}
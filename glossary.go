@@ -0,0 +1,158 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+//======================================================================
+// Model documentation: a glossary of all variables (and tables) in a
+// model, generated from the equations and their comments in the source.
+// Structured metadata in NOTE lines (like "NOTE @UNITS WIDGETS") is
+// added to the glossary entries.
+//======================================================================
+
+// Keys for structured metadata (NOTE @key value)
+const (
+	META_SECTOR = "sector" // sector of definitions
+	META_UNITS  = "units"  // units of a variable
+	META_MIN    = "min"    // lower bound of a level
+	META_MAX    = "max"    // upper bound of a level
+	META_BOUND  = "bound"  // handling of bound violations (BOUND_???)
+	META_TYPE   = "type"   // type of a variable (REAL, INTEGER, BOOLEAN)
+)
+
+// Variable types in the glossary (by equation mode)
+var glossaryTypes = map[string]string{
+	"L": "LEVEL",
+	"R": "RATE",
+	"A": "AUX",
+	"S": "SUPPL",
+	"C": "CONST",
+	"N": "INIT",
+	"T": "TABLE",
+}
+
+// equations returns the model equations; if the model has been run, the
+// equations of the last run are returned.
+func (mdl *Model) equations() *EqnList {
+	if mdl.Eqns != nil {
+		return mdl.Eqns
+	}
+	if eqns, ok := mdl.Stack[mdl.RunID]; ok {
+		return eqns
+	}
+	return NewEqnList()
+}
+
+// GlossaryEntry describes a model variable
+type GlossaryEntry struct {
+	Name    string   // name of the variable
+	Type    string   // type of variable (LEVEL, RATE, AUX,...)
+	Eqns    []string // defining equations (in DYNAMO notation)
+	Comment string   // description of the variable
+	Sector  string   // sector of the variable (or empty)
+	Units   string   // units of the variable (or empty)
+}
+
+// Glossary returns a list of entries (sorted by name) for all variables
+// and tables in the model. Automatic and system variables are skipped.
+// If the model has been run, the equations of the last run are used.
+func (mdl *Model) Glossary() (list []*GlossaryEntry) {
+	entries := make(map[string]*GlossaryEntry)
+	for _, eqn := range mdl.equations().List() {
+		name := eqn.Target.Name
+		if strings.HasPrefix(name, "_") || mdl.IsSystem(name) {
+			continue
+		}
+		entry, ok := entries[name]
+		if !ok {
+			entry = &GlossaryEntry{Name: name}
+			entries[name] = entry
+		}
+		// initial values don't define the type of a variable
+		if len(entry.Type) == 0 || entry.Type == glossaryTypes["N"] {
+			entry.Type = glossaryTypes[eqn.Mode]
+		}
+		entry.Eqns = append(entry.Eqns, eqn.Mode+" "+eqn.Statement())
+		if len(entry.Comment) == 0 {
+			entry.Comment = eqn.Comment
+		}
+		entry.setMeta(eqn.Meta)
+	}
+	for name, tbl := range mdl.Tables {
+		entry := &GlossaryEntry{
+			Name:    name,
+			Type:    glossaryTypes["T"],
+			Comment: tbl.Comment,
+		}
+		entry.setMeta(tbl.Meta)
+		entries[name] = entry
+	}
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Sector != list[j].Sector {
+			return list[i].Sector < list[j].Sector
+		}
+		return list[i].Name < list[j].Name
+	})
+	return
+}
+
+// set sector and units from metadata (if not already defined)
+func (e *GlossaryEntry) setMeta(meta map[string]string) {
+	if val := meta[META_SECTOR]; len(e.Sector) == 0 {
+		e.Sector = val
+	}
+	if val := meta[META_UNITS]; len(e.Units) == 0 {
+		e.Units = val
+	}
+}
+
+// Documentation writes the glossary of model variables in human-readable
+// form to a stream. Variables are grouped by sector.
+func (mdl *Model) Documentation(w io.Writer) {
+	if len(mdl.Title) > 0 {
+		fmt.Fprintf(w, "%s\n\n", mdl.Title)
+	}
+	fmt.Fprintf(w, "%-8s  %-6s  %s\n", "NAME", "TYPE", "DESCRIPTION")
+	sector := ""
+	for _, entry := range mdl.Glossary() {
+		if entry.Sector != sector {
+			sector = entry.Sector
+			fmt.Fprintf(w, "\nSECTOR %s\n", sector)
+		}
+		desc := entry.Comment
+		if len(entry.Units) > 0 {
+			desc = strings.TrimSpace(desc + " [" + entry.Units + "]")
+		}
+		fmt.Fprintf(w, "%-8s  %-6s  %s\n", mdl.Spelling(entry.Name), entry.Type, desc)
+		for _, eqn := range entry.Eqns {
+			fmt.Fprintf(w, "%18s%s\n", "", eqn)
+		}
+	}
+}
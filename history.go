@@ -0,0 +1,160 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"time"
+)
+
+//======================================================================
+// ROLLBACK -- A step-wise model run keeps the states before the last
+// steps in a ring buffer (the size is defined by Model.History); the run
+// can be rolled back to one of these states.
+//======================================================================
+
+// snapshot of a running model (taken before a step)
+type snapshot struct {
+	current, last State        // model state
+	auto          arena        // automatic variables
+	epoch         int          // epoch of run
+	t             Variable     // time of epoch
+	prtNum        int          // number of printed lines
+	pltNum        int          // number of plotted points
+	dsNum         int          // number of recorded epochs
+	gameNext      float64      // time of next decision in gaming mode
+	comp          State        // compensations (reproducible mode)
+	bounds        []int        // number of bound violations
+	conserve      []*Violation // first violations of conserved groups
+	replay        int          // number of recorded or replayed events
+}
+
+// history is a ring buffer of snapshots
+type history struct {
+	list []*snapshot // list of snapshots
+	pos  int         // position of next snapshot
+	num  int         // number of snapshots in buffer
+}
+
+// create a new history of given size
+func newHistory(size int) *history {
+	return &history{
+		list: make([]*snapshot, size),
+	}
+}
+
+// push a snapshot to the history (the oldest snapshot is replaced if the
+// buffer is full).
+func (h *history) push(s *snapshot) {
+	if len(h.list) == 0 {
+		return
+	}
+	h.list[h.pos] = s
+	h.pos = (h.pos + 1) % len(h.list)
+	if h.num < len(h.list) {
+		h.num++
+	}
+}
+
+// pop the snapshot taken k steps ago (and all snapshots after it).
+func (h *history) pop(k int) *snapshot {
+	if k < 1 || k > h.num {
+		return nil
+	}
+	h.pos = (h.pos - k + len(h.list)) % len(h.list)
+	h.num -= k
+	s := h.list[h.pos]
+	h.list[h.pos] = nil
+	return s
+}
+
+// snapshot of the current model run
+func (mdl *Model) snapshot() *snapshot {
+	s := &snapshot{
+		current: mdl.Current.Clone(),
+		last:    mdl.Last.Clone(),
+		auto:    mdl.auto.clone(),
+		epoch:   mdl.run.epoch,
+		t:       mdl.run.t,
+		prtNum:  mdl.Print.run.Num,
+		pltNum:  mdl.Plot.run.Num,
+		dsNum:   mdl.run.ds.Len(),
+	}
+	if mdl.Game != nil {
+		s.gameNext = mdl.Game.next
+	}
+	if mdl.run.comp != nil {
+		s.comp = mdl.run.comp.Clone()
+	}
+	for _, b := range mdl.run.bounds {
+		s.bounds = append(s.bounds, b.count)
+	}
+	for _, grp := range mdl.conserve {
+		s.conserve = append(s.conserve, grp.first)
+	}
+	if rl := mdl.Replay; rl != nil {
+		s.replay = rl.count()
+	}
+	return s
+}
+
+// Rollback a running model by k steps. The number of steps that can be
+// rolled back is limited by the size of the history (Model.History).
+func (mdl *Model) Rollback(k int) (res *Result) {
+	if mdl.run == nil {
+		return Failure(ErrModelNotRunning)
+	}
+	s := mdl.run.hist.pop(k)
+	if s == nil {
+		return Failure(ErrModelNoHistory+": %d steps", k)
+	}
+	// restore state
+	mdl.Current, mdl.Last, mdl.auto = s.current, s.last, s.auto
+	mdl.run.epoch, mdl.run.t = s.epoch, s.t
+	// inputs recorded for cached auxiliaries are from rolled back steps
+	for _, ce := range mdl.run.cache {
+		ce.valid = false
+	}
+	if mdl.Game != nil {
+		mdl.Game.next = s.gameNext
+	}
+	if s.comp != nil {
+		mdl.run.comp = s.comp
+	}
+	for i, b := range mdl.run.bounds {
+		b.count = s.bounds[i]
+	}
+	for i, grp := range mdl.conserve {
+		grp.first = s.conserve[i]
+	}
+	if rl := mdl.Replay; rl != nil {
+		if res = rl.rewind(mdl.epoch(), s.replay); !res.Ok {
+			return
+		}
+	}
+	if p := mdl.Pacer; p != nil {
+		p.start = time.Now().Add(-time.Duration(s.epoch-1) * p.Step)
+	}
+	// discard output and results of rolled back steps
+	mdl.Print.truncate(s.prtNum)
+	mdl.Plot.truncate(s.pltNum)
+	mdl.run.ds.Truncate(s.dsNum)
+	return Success()
+}
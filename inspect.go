@@ -0,0 +1,114 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+//======================================================================
+// INSPECT -- The state of a model run at a given time is written as a
+// sorted list of all variables, including the internal state of function
+// instances (like the stages of a DELAY3). This helps to find the cause
+// of diverging results between implementations.
+//======================================================================
+
+// Inspect runs the model (as defined by the last RUN statement or the
+// current equations) up to TIME=t and writes the state at that time: all
+// variables including the automatic variables of functions (with derived
+// names) sorted by name. Auxiliaries, rates and supplements are computed
+// for TIME=t. No print or plot output is generated and the model results are
+// not changed.
+func (mdl *Model) Inspect(w io.Writer, t float64) (res *Result) {
+	if mdl.run != nil {
+		return Failure(ErrModelRunning)
+	}
+	eqns := mdl.Eqns
+	if eqns == nil {
+		stacked, ok := mdl.Stack[mdl.RunID]
+		if !ok {
+			return Failure(ErrModelNotAvailable+": %s", mdl.RunID)
+		}
+		eqns = stacked
+	}
+	// restore model after inspection
+	orig, sorted, edited := mdl.Eqns, mdl.sorted, mdl.edited
+	current, last, auto := mdl.Current, mdl.Last, mdl.auto
+	prt, plt, game, pacer, replay := mdl.Print, mdl.Plot, mdl.Game, mdl.Pacer, mdl.Replay
+	defer func() {
+		mdl.Eqns, mdl.sorted, mdl.edited = orig, sorted, edited
+		mdl.Current, mdl.Last, mdl.auto = current, last, auto
+		mdl.Print, mdl.Plot, mdl.Game, mdl.Pacer, mdl.Replay = prt, plt, game, pacer, replay
+		mdl.run = nil
+	}()
+	mdl.Print, mdl.Plot = NewPrinter("", mdl), NewPlotter("", mdl)
+	mdl.Game, mdl.Pacer, mdl.Replay = nil, nil, nil
+	mdl.Eqns, mdl.sorted, mdl.edited = eqns.Clone(), false, false
+	mdl.Current, mdl.Last = make(State), make(State)
+	mdl.auto.reset()
+
+	// run model up to requested time
+	Logf(LOG_INFO, LOG_RUN, "   Inspecting system model '%s' at TIME=%g...", mdl.RunID, t)
+	if res = mdl.Start(); !res.Ok {
+		return
+	}
+	for !mdl.Done() && compare(float64(mdl.Current["TIME"]), t) < 0 {
+		if res = mdl.step(); !res.Ok {
+			return
+		}
+	}
+	if mdl.Done() {
+		return Failure(ErrModelNoData+": TIME=%g", t)
+	}
+	if res = mdl.computeStep("ARS"); !res.Ok {
+		return
+	}
+	// write state (automatic variables with derived names)
+	state := mdl.Current.Clone()
+	intern := mdl.internNames()
+	for i, set := range mdl.auto.set {
+		if set {
+			auto := "_" + strconv.Itoa(i)
+			name, ok := intern[auto]
+			if !ok {
+				name = auto
+			}
+			state[name] = mdl.auto.vals[i]
+		}
+	}
+	var names []string
+	width := 0
+	for name := range state {
+		names = append(names, name)
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "# State of run '%s' at TIME=%g\n", mdl.RunID, float64(mdl.Current["TIME"]))
+	for _, name := range names {
+		fmt.Fprintf(w, "%-*s %s\n", width, name, strconv.FormatFloat(float64(state[name]), 'g', -1, 64))
+	}
+	return Success()
+}
@@ -0,0 +1,465 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//----------------------------------------------------------------------
+// LINT -- Checks for model constructs that are valid, but probably not
+// what the modeller intended. Lint checks only produce warnings. The
+// checks are grouped into rules that can be enabled or disabled:
+//
+//     dynamo -lint-rules=-MAGIC,AUXCHAIN=3 lint model.dynamo
+//----------------------------------------------------------------------
+
+// Lint rules
+const (
+	LINT_TABLES   = "TABLES"   // questionable table data and table use
+	LINT_NAMING   = "NAMING"   // cryptic variable names
+	LINT_UNUSED   = "UNUSED"   // variables not used, printed or plotted
+	LINT_CONSTANT = "CONSTANT" // constants never varied between runs
+	LINT_AUXCHAIN = "AUXCHAIN" // long chains of auxiliaries
+	LINT_MAGIC    = "MAGIC"    // numbers in equations (not constants)
+)
+
+// lintRules lists all lint rules (in order of checking)
+var lintRules = []string{LINT_TABLES, LINT_NAMING, LINT_UNUSED, LINT_CONSTANT, LINT_AUXCHAIN, LINT_MAGIC}
+
+// LintConfig selects the lint rules to check.
+type LintConfig struct {
+	Rules    map[string]bool // enabled rules
+	MaxChain int             // max. length of auxiliary chains
+}
+
+// NewLintConfig returns a configuration with all rules enabled.
+func NewLintConfig() *LintConfig {
+	cfg := &LintConfig{
+		Rules:    make(map[string]bool),
+		MaxChain: 5,
+	}
+	for _, rule := range lintRules {
+		cfg.Rules[rule] = true
+	}
+	return cfg
+}
+
+// Set changes the configuration from a comma-separated list: "RULE"
+// enables, "-RULE" disables a rule; "ALL" and "NONE" switch all rules.
+// "AUXCHAIN=N" sets the max. length of auxiliary chains.
+func (cfg *LintConfig) Set(spec string) *Result {
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.ToUpper(strings.TrimSpace(item))
+		if len(item) == 0 {
+			continue
+		}
+		on := !strings.HasPrefix(item, "-")
+		rule := strings.TrimPrefix(item, "-")
+		if x := strings.SplitN(rule, "=", 2); len(x) == 2 && x[0] == LINT_AUXCHAIN {
+			n, err := strconv.Atoi(x[1])
+			if err != nil || n < 1 {
+				return Failure(ErrLintRule+": %s", item)
+			}
+			rule, cfg.MaxChain = x[0], n
+		}
+		switch rule {
+		case "ALL", "NONE":
+			for _, r := range lintRules {
+				cfg.Rules[r] = on && rule == "ALL"
+			}
+		default:
+			if _, ok := cfg.Rules[rule]; !ok {
+				return Failure(ErrLintRule+": %s", rule)
+			}
+			cfg.Rules[rule] = on
+		}
+	}
+	return Success()
+}
+
+// Lint is a warning about a questionable model construct.
+type Lint struct {
+	Rule string // lint rule (LINT_???)
+	Name string // name of variable or table
+	Msg  string // description of the problem (and suggestion)
+}
+
+// String returns a human-readable lint warning.
+func (l *Lint) String() string {
+	return l.Name + ": " + l.Msg
+}
+
+// LintModel checks the model for all enabled rules (all rules if the
+// configuration is nil). Lint checks of table ranges need an initialized
+// model; all other checks work on the parsed model.
+func (mdl *Model) LintModel(cfg *LintConfig) (list []*Lint) {
+	if cfg == nil {
+		cfg = NewLintConfig()
+	}
+	checks := map[string]func() []*Lint{
+		LINT_TABLES:   mdl.LintTables,
+		LINT_NAMING:   mdl.lintNaming,
+		LINT_UNUSED:   mdl.lintUnused,
+		LINT_CONSTANT: mdl.lintConstants,
+		LINT_AUXCHAIN: func() []*Lint { return mdl.lintAuxChains(cfg.MaxChain) },
+		LINT_MAGIC:    mdl.lintMagic,
+	}
+	for _, rule := range lintRules {
+		if cfg.Rules[rule] {
+			list = append(list, checks[rule]()...)
+		}
+	}
+	return
+}
+
+// lintEquations returns the (user-defined) equations of the model in
+// order of their targets.
+func (mdl *Model) lintEquations() (list []*Equation) {
+	for _, eqn := range mdl.equations().List() {
+		if eqn.Target.Name[0] != '_' {
+			list = append(list, eqn)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Target.Name < list[j].Target.Name
+	})
+	return
+}
+
+// lintNaming checks for cryptic names: names with a single character and
+// numbered names (like X1, X2).
+func (mdl *Model) lintNaming() (list []*Lint) {
+	seen := make(map[string]bool)
+	for _, eqn := range mdl.lintEquations() {
+		name := eqn.Target.Name
+		if seen[name] || mdl.IsSystem(name) {
+			continue
+		}
+		seen[name] = true
+		base := name[strings.LastIndex(name, ".")+1:]
+		if len(base) == 1 {
+			list = append(list, &Lint{Rule: LINT_NAMING, Name: name, Msg: "single-character name; use a descriptive name"})
+		} else if c := base[len(base)-1]; c >= '0' && c <= '9' {
+			list = append(list, &Lint{Rule: LINT_NAMING, Name: name, Msg: "numbered name; use a descriptive name"})
+		}
+	}
+	return
+}
+
+// lintUnused checks for variables that are neither used in equations nor
+// printed or plotted.
+func (mdl *Model) lintUnused() (list []*Lint) {
+	used := make(map[string]bool)
+	eqns := mdl.lintEquations()
+	for _, eqn := range mdl.equations().List() {
+		for _, l := range [][]*Name{eqn.Dependencies, eqn.References} {
+			for _, n := range l {
+				used[n.Name] = true
+			}
+		}
+	}
+	for name := range mdl.Print.vars {
+		used[name] = true
+	}
+	for name := range mdl.Plot.vars {
+		used[name] = true
+	}
+	for _, name := range mdl.Outputs {
+		used[name] = true
+	}
+	for _, eqn := range eqns {
+		name := eqn.Target.Name
+		if eqn.Mode != "N" && !used[name] && !mdl.IsSystem(name) {
+			used[name] = true
+			list = append(list, &Lint{Rule: LINT_UNUSED, Name: name, Msg: "not used in equations, prints or plots"})
+		}
+	}
+	return
+}
+
+// lintConstants checks for constants that have the same value in all
+// runs of a model with multiple runs.
+func (mdl *Model) lintConstants() (list []*Lint) {
+	if len(mdl.Stack) < 2 {
+		return
+	}
+	values := make(map[string]map[string]bool)
+	for _, eqns := range mdl.Stack {
+		for _, eqn := range eqns.List() {
+			name := eqn.Target.Name
+			if eqn.Mode != "C" || name[0] == '_' || mdl.IsSystem(name) {
+				continue
+			}
+			if values[name] == nil {
+				values[name] = make(map[string]bool)
+			}
+			values[name][eqn.Statement()] = true
+		}
+	}
+	names := make([]string, 0, len(values))
+	for name, vals := range values {
+		if len(vals) == 1 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		list = append(list, &Lint{Rule: LINT_CONSTANT, Name: name, Msg: fmt.Sprintf("never varied in %d runs", len(mdl.Stack))})
+	}
+	return
+}
+
+// lintAuxChains checks for chains of auxiliaries longer than max; the
+// chains are reported at their last auxiliary.
+func (mdl *Model) lintAuxChains(max int) (list []*Lint) {
+	aux := make(map[string]*Equation)
+	eqns := mdl.lintEquations()
+	for _, eqn := range eqns {
+		if eqn.Mode == "A" {
+			aux[eqn.Target.Name] = eqn
+		}
+	}
+	// longest chain ending in an auxiliary (memoized)
+	chains := make(map[string][]string)
+	var chain func(name string, visiting map[string]bool) []string
+	chain = func(name string, visiting map[string]bool) []string {
+		if c, ok := chains[name]; ok {
+			return c
+		}
+		var longest []string
+		if !visiting[name] {
+			visiting[name] = true
+			for _, dep := range aux[name].Dependencies {
+				if _, ok := aux[dep.Name]; ok {
+					if c := chain(dep.Name, visiting); len(c) > len(longest) {
+						longest = c
+					}
+				}
+			}
+			delete(visiting, name)
+		}
+		c := append(append([]string(nil), longest...), name)
+		chains[name] = c
+		return c
+	}
+	// chains that are continued by another auxiliary are not reported
+	continued := make(map[string]bool)
+	for name := range aux {
+		if c := chain(name, make(map[string]bool)); len(c) > 1 {
+			continued[c[len(c)-2]] = true
+		}
+	}
+	for _, eqn := range eqns {
+		name := eqn.Target.Name
+		if c := chains[name]; eqn.Mode == "A" && len(c) > max && !continued[name] {
+			msg := fmt.Sprintf("chain of %d auxiliaries (%s)", len(c), strings.Join(c, " -> "))
+			list = append(list, &Lint{Rule: LINT_AUXCHAIN, Name: name, Msg: msg})
+		}
+	}
+	return
+}
+
+// lintMagic checks for numbers (other than 0 and 1) in equations other
+// than constants and initial values; the range arguments of table
+// functions are not checked.
+func (mdl *Model) lintMagic() (list []*Lint) {
+	for _, eqn := range mdl.lintEquations() {
+		if eqn.Mode == "C" || eqn.Mode == "N" {
+			continue
+		}
+		var nums []string
+		skip := make(map[ast.Expr]bool)
+		ast.Inspect(eqn.Formula, func(n ast.Node) bool {
+			switch x := n.(type) {
+			case *ast.CallExpr:
+				if fcn, ok := x.Fun.(*ast.Ident); ok && tableFcns[fcn.Name] && len(x.Args) > 2 {
+					for _, arg := range x.Args[2:] {
+						skip[arg] = true
+					}
+				}
+			case ast.Expr:
+				if skip[x] {
+					return false
+				}
+				if lit, ok := x.(*ast.BasicLit); ok && (lit.Kind == token.INT || lit.Kind == token.FLOAT) {
+					if val, err := strconv.ParseFloat(lit.Value, 64); err == nil && val != 0 && val != 1 {
+						nums = append(nums, lit.Value)
+					}
+				}
+			}
+			return true
+		})
+		if len(nums) > 0 {
+			msg := fmt.Sprintf("number(s) %s in equation; use named constants", strings.Join(nums, ","))
+			list = append(list, &Lint{Rule: LINT_MAGIC, Name: eqn.Target.Name, Msg: msg})
+		}
+	}
+	return
+}
+
+// tableUse is a call of a table function in an equation
+type tableUse struct {
+	fcn            string    // name of table function
+	eqn            *Equation // calling equation
+	min, max, step float64   // range arguments (NaN if not constant)
+}
+
+// LintTables checks the tables of a model and their use in table
+// functions. The model must be initialized (to resolve constant range
+// arguments).
+func (mdl *Model) LintTables() (list []*Lint) {
+	add := func(name, format string, args ...interface{}) {
+		list = append(list, &Lint{Rule: LINT_TABLES, Name: name, Msg: fmt.Sprintf(format, args...)})
+	}
+	// collect table uses
+	uses := make(map[string][]*tableUse)
+	for _, eqn := range mdl.equations().List() {
+		ast.Inspect(eqn.Formula, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) < 5 {
+				return true
+			}
+			fcn, ok := call.Fun.(*ast.Ident)
+			if !ok || !tableFcns[fcn.Name] || fcn.Name == "TABLE2" {
+				return true
+			}
+			name, res := NewName(call.Args[0])
+			if !res.Ok {
+				return true
+			}
+			use := &tableUse{fcn: fcn.Name, eqn: eqn}
+			for i, v := range []*float64{&use.min, &use.max, &use.step} {
+				*v = math.NaN()
+				missing := make(map[string]*Name)
+				if val, res := eval(call.Args[i+2], mdl, missing); res.Ok && len(missing) == 0 {
+					*v = float64(val)
+				}
+			}
+			uses[name.Name] = append(uses[name.Name], use)
+			return true
+		})
+	}
+	// check tables (in sorted order)
+	var names []string
+	for name := range mdl.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tbl := mdl.Tables[name]
+		if tbl.Cols > 0 {
+			// 2-D tables are not checked
+			continue
+		}
+		num := len(tbl.Data)
+		var first *tableUse
+		for _, use := range uses[name] {
+			if math.IsNaN(use.min) || math.IsNaN(use.max) || math.IsNaN(use.step) {
+				continue
+			}
+			// check implied x-range
+			if use.step <= 0 || use.min >= use.max {
+				add(name, "invalid x-range %g..%g (step %g) in %s", use.min, use.max, use.step, use.eqn.String())
+				continue
+			}
+			if pts := int(math.Round((use.max-use.min)/use.step)) + 1; pts != num {
+				add(name, "x-range %g..%g (step %g) implies %d values, table has %d (%s)",
+					use.min, use.max, use.step, pts, num, use.eqn.String())
+			}
+			if first == nil {
+				first = use
+			} else if compare(first.min, use.min) != 0 || compare(first.max, use.max) != 0 {
+				add(name, "used with different x-ranges %g..%g and %g..%g",
+					first.min, first.max, use.min, use.max)
+			}
+		}
+		// check for a single point that breaks monotonicity
+		if i := spike(tbl.Data); i > 0 {
+			add(name, "value #%d (%g) breaks monotonicity of table (typo?)", i+1, tbl.Data[i])
+		}
+		// check for overshooting polynominal interpolation
+		for _, use := range uses[name] {
+			if use.fcn != "TABPL" {
+				continue
+			}
+			if over := overshoot(tbl); over > 0 {
+				add(name, "polynominal interpolation (TABPL) overshoots data range by %g; use TABLE (linear interpolation) instead", over)
+			}
+			break
+		}
+	}
+	return
+}
+
+// monotonic returns true if the data is monotonic (increasing or decreasing).
+func monotonic(data []float64) bool {
+	inc, dec := true, true
+	for i := 1; i < len(data); i++ {
+		inc = inc && data[i] >= data[i-1]
+		dec = dec && data[i] <= data[i-1]
+	}
+	return inc || dec
+}
+
+// spike returns the index of an inner data point that is the only reason
+// for the data to be non-monotonic (or 0 if no such point exists).
+func spike(data []float64) int {
+	if len(data) < 4 || monotonic(data) {
+		return 0
+	}
+	for i := 1; i < len(data)-1; i++ {
+		// only local extrema can be spikes
+		if (data[i]-data[i-1])*(data[i+1]-data[i]) >= 0 {
+			continue
+		}
+		rest := append(append([]float64(nil), data[:i]...), data[i+1:]...)
+		if monotonic(rest) && rest[0] != rest[len(rest)-1] {
+			return i
+		}
+	}
+	return 0
+}
+
+// overshoot returns how far the polynominal interpolation of a table
+// exceeds the range of the table data.
+func overshoot(tbl *Table) (over float64) {
+	lo, hi := tbl.Data[0], tbl.Data[0]
+	for _, y := range tbl.Data {
+		lo, hi = math.Min(lo, y), math.Max(hi, y)
+	}
+	samples := 10 * (len(tbl.Data) - 1)
+	for i := 0; i <= samples; i++ {
+		y := float64(tbl.Newton(Variable(float64(i) / float64(samples))))
+		over = math.Max(over, math.Max(y-hi, lo-y))
+	}
+	// ignore numerical noise
+	if over < 1e-6*math.Max(1, hi-lo) {
+		over = 0
+	}
+	return
+}
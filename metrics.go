@@ -0,0 +1,110 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//======================================================================
+// METRICS -- Counters for model runs (since program start) are exposed in
+// the Prometheus text format, so runs can be monitored like any other
+// backend component (e.g. with the "textfile" collector of the Prometheus
+// node exporter):
+//
+//     dynamo_runs_total{status="ok"} 3
+//     dynamo_run_duration_seconds_sum 0.0213
+//     dynamo_run_equations{run="BASE"} 42
+//======================================================================
+
+// runMetrics holds counters for model runs.
+type runMetrics struct {
+	sync.Mutex
+	ok, failed uint64         // number of (un-)successful runs
+	seconds    float64        // accumulated duration of runs
+	epochs     uint64         // accumulated number of computed epochs
+	eqns       map[string]int // number of equations (by run identifier)
+}
+
+// metrics of all model runs
+var metrics = &runMetrics{
+	eqns: make(map[string]int),
+}
+
+// record a model run.
+func (m *runMetrics) record(runID string, ok bool, d time.Duration, epochs, eqns int) {
+	m.Lock()
+	defer m.Unlock()
+	if ok {
+		m.ok++
+	} else {
+		m.failed++
+	}
+	m.seconds += d.Seconds()
+	m.epochs += uint64(epochs)
+	if eqns > 0 {
+		m.eqns[runID] = eqns
+	}
+}
+
+// WriteMetrics writes the counters of all model runs in the Prometheus
+// text format.
+func WriteMetrics(w io.Writer) *Result {
+	m := metrics
+	m.Lock()
+	defer m.Unlock()
+	var buf strings.Builder
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, kind)
+	}
+	metric("dynamo_runs_total", "counter", "Number of model runs.")
+	fmt.Fprintf(&buf, "dynamo_runs_total{status=\"ok\"} %d\n", m.ok)
+	fmt.Fprintf(&buf, "dynamo_runs_total{status=\"failed\"} %d\n", m.failed)
+	metric("dynamo_run_duration_seconds", "summary", "Duration of model runs.")
+	fmt.Fprintf(&buf, "dynamo_run_duration_seconds_sum %g\n", m.seconds)
+	fmt.Fprintf(&buf, "dynamo_run_duration_seconds_count %d\n", m.ok+m.failed)
+	metric("dynamo_run_epochs_total", "counter", "Number of computed epochs.")
+	fmt.Fprintf(&buf, "dynamo_run_epochs_total %d\n", m.epochs)
+	metric("dynamo_run_equations", "gauge", "Number of run-time equations (by run).")
+	ids := make([]string, 0, len(m.eqns))
+	for id := range m.eqns {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		fmt.Fprintf(&buf, "dynamo_run_equations{run=\"%s\"} %d\n", metricLabel(id), m.eqns[id])
+	}
+	if _, err := io.WriteString(w, buf.String()); err != nil {
+		return Failure(err)
+	}
+	return Success()
+}
+
+// metricLabel escapes a label value in the Prometheus text format.
+func metricLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -21,13 +21,17 @@ package dynamo
 //----------------------------------------------------------------------
 
 import (
+	"crypto/sha256"
+	"fmt"
+	"go/ast"
+	"hash"
+	"io"
+	"math"
+	"math/rand"
 	"sort"
-	"strconv"
 	"strings"
-)
-
-var (
-	strict = false // apply strict DYNAMO language rules
+	"sync/atomic"
+	"time"
 )
 
 //======================================================================
@@ -56,6 +60,16 @@ func (s State) Clone() State {
 	return clone
 }
 
+// Names returns the sorted list of variable names in the state.
+func (s State) Names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 //----------------------------------------------------------------------
 // MODEL as defined in a DYNAMO source
 //
@@ -65,19 +79,72 @@ func (s State) Clone() State {
 // The model keeps two states (LAST, CURRENT).
 //----------------------------------------------------------------------
 
-// Model represents a DYNAMO model that can be executed
+// Model represents a DYNAMO model that can be executed. A model instance
+// must not be used concurrently, but separate instances can be processed
+// and run in parallel.
 type Model struct {
-	Title   string              // title of the model as defined by mode "*"
-	RunID   string              // identifier for model run
-	Eqns    *EqnList            // list of equations
-	Tables  map[string]*Table   // list of tables
-	Last    State               // previous state (J)
-	Current State               // current state (K)
-	Print   *Printer            // printer instance
-	Plot    *Plotter            // plotter instance
-	Verbose bool                // verbose messaging
-	Stack   map[string]*EqnList // stacked run models
-	Edit    bool                // editing model?
+	Title     string              // title of the model as defined by mode "*"
+	RunID     string              // identifier for model run
+	Eqns      *EqnList            // list of equations
+	Tables    map[string]*Table   // list of tables
+	Series    map[string]*Series  // list of exogenous data series
+	Last      State               // previous state (J)
+	Current   State               // current state (K)
+	Print     *Printer            // printer instance
+	Plot      *Plotter            // plotter instance
+	Stack     map[string]*EqnList // stacked run models
+	Results   map[string]*Dataset // recorded results of model runs
+	Dbg       *Debugger           // debugger instance (can be nil)
+	Strict    bool                // apply strict DYNAMO language rules
+	Relaxed   bool                // accept relaxed (modern) syntax
+	Encoding  string              // encoding of source (ENC_???)
+	Edit      bool                // editing model?
+	Game      *Game               // gaming parameters (or nil)
+	Decision  DecisionFunc        // decision function in gaming mode
+	Pacer     *Pacer              // real-time pacing of runs (or nil)
+	Inputs    []string            // names of input ports
+	Outputs   []string            // names of output ports
+	History   int                 // number of past states kept for rollback
+	Replay    *ReplayLog          // replay log for recording/replaying runs
+	Lint      bool                // check for questionable constructs before runs
+	LintRules *LintConfig         // enabled lint rules (nil = all rules)
+	Calendar  *Calendar           // time unit and start date (or nil)
+	Repro     bool                // reproducible (compensated) level updates
+	BoundMode string              // default handling of bound violations (BOUND_???)
+	Policy    *Policy             // handling of numeric anomalies (nil = ignore)
+	AutoDT    bool                // select a stable DT automatically
+	Seed      int64               // seed for random numbers (0 = random seed)
+	Overrides State               // constants overridden in all runs
+	NoRun     bool                // parse only: RUN statements don't run the model
+	Default   io.Writer           // default output (CSV) if no PRINT/PLOT is given
+	Lines     []*SourceLine       // source lines as read by the parser
+	Profile   *Profile            // execution profile of runs (or nil)
+	Parallel  int                 // max. goroutines evaluating equations (< 2: sequential)
+	Internals bool                // record internal variables of functions (derived names)
+	NoCache   bool                // evaluate all auxiliaries in every step
+
+	meta   map[string]string        // pending metadata (from NOTE lines)
+	sector string                   // current sector (from NOTE lines)
+	block  *sectorBlock             // pending SECTOR block
+	scope  string                   // sector of statements being added
+	known  map[string]bool          // known sectors (and instances)
+	run    *runState                // state of current run (or nil)
+	sorted bool                     // equations are sorted and validated
+	edited bool                     // sorted equations were edited
+	source hash.Hash                // hash of parsed model source
+	rng    *rand.Rand               // random number generator of run
+	autoID int                      // last automatic variable identifier
+	auto   arena                    // values of automatic variables
+	calls  map[*ast.CallExpr][]*Arg // resolved arguments of function calls
+	casing map[string]string        // original spelling of (folded) names
+	cancel int32                    // current run is canceled (atomic)
+
+	resolving  map[string]bool  // variables with initial values being resolved
+	unresolved map[string]*Name // missing variables in initialization
+
+	conserve []*conserved            // conserved groups of levels
+	branches map[string]*branchPoint // named snapshots of runs
+	snapAt   map[string]float64      // requested snapshots (time)
 }
 
 // NewModel returns a new (empty) model instance.
@@ -85,24 +152,56 @@ func NewModel(printer, plotter string) *Model {
 	mdl := &Model{
 		Eqns:    NewEqnList(),
 		Tables:  make(map[string]*Table),
+		Series:  make(map[string]*Series),
 		Last:    make(State),
 		Current: make(State),
-		Verbose: false,
 		Stack:   make(map[string]*EqnList),
-		Edit:    false,
+		Results: make(map[string]*Dataset),
+
+		branches: make(map[string]*branchPoint),
+		snapAt:   make(map[string]float64),
+		known:    make(map[string]bool),
+		calls:    make(map[*ast.CallExpr][]*Arg),
+		casing:   make(map[string]string),
+		source:   sha256.New(),
+		Edit:     false,
 	}
 	mdl.Print = NewPrinter(printer, mdl)
 	mdl.Plot = NewPlotter(plotter, mdl)
 	return mdl
 }
 
-// Set strict mode (globally)
+// NewAutoVar generates a new (unique) automatic variable name
+func (mdl *Model) NewAutoVar() string {
+	mdl.autoID++
+	return fmt.Sprintf("_%d", mdl.autoID)
+}
+
+// SetStrict sets strict mode for the model: in strict mode violations of
+// the DYNAMO language rules (line length, name length, spaces) are failures
+// instead of warnings.
 func (mdl *Model) SetStrict(flag bool) {
-	strict = flag
+	mdl.Strict = flag
+	if flag {
+		mdl.Relaxed = false
+	}
+}
+
+// SetRelaxed sets relaxed mode for the model: in relaxed mode, equations
+// can contain spaces, comments start with '#' and names of any length are
+// accepted without warning. Relaxed mode disables strict mode.
+func (mdl *Model) SetRelaxed(flag bool) {
+	mdl.Relaxed = flag
+	if flag {
+		mdl.Strict = false
+	}
 }
 
 // Output is called after a model is run to generate prints and plots.
 func (mdl *Model) Output() (res *Result) {
+	if len(mdl.Print.jobs) == 0 && len(mdl.Plot.jobs) == 0 {
+		return mdl.defaultOutput()
+	}
 	if res = mdl.Print.Generate(); !res.Ok {
 		return
 	}
@@ -113,9 +212,6 @@ func (mdl *Model) Output() (res *Result) {
 // Quit is called when done with a model.
 func (mdl *Model) Quit() (res *Result) {
 	// close all outputs
-	if res = Dbg.Close(); !res.Ok {
-		return
-	}
 	if res = mdl.Print.Close(); !res.Ok {
 		return
 	}
@@ -124,12 +220,12 @@ func (mdl *Model) Quit() (res *Result) {
 }
 
 // Dump logs the current model state in human-readable form into
-// the log stream.
+// the log stream (verbose messages).
 func (mdl *Model) Dump() {
 
-	mdl.Eqns.Dump(mdl.Verbose)
-	Msg("-----------------------------------")
-	Msgf(" Number of TABLE def's: %4d\n", len(mdl.Tables))
+	mdl.Eqns.Dump()
+	Log(LOG_VERBOSE, LOG_MODEL, "-----------------------------------")
+	Logf(LOG_VERBOSE, LOG_MODEL, " Number of TABLE def's: %4d\n", len(mdl.Tables))
 	// sort list of table names
 	var tblNames []string
 	for tname := range mdl.Tables {
@@ -139,9 +235,9 @@ func (mdl *Model) Dump() {
 	// print tables (in sorted order)
 	for _, tname := range tblNames {
 		tbl := mdl.Tables[tname]
-		Msgf("   %s: %v\n", tname, tbl.Data)
+		Logf(LOG_VERBOSE, LOG_MODEL, "   %s: %v\n", tname, tbl.Data)
 	}
-	Msg("-----------------------------------")
+	Log(LOG_VERBOSE, LOG_MODEL, "-----------------------------------")
 }
 
 // AddStatement inserts a new source statement to the model.
@@ -155,13 +251,17 @@ func (mdl *Model) AddStatement(stmt *Line) (res *Result) {
 	if stmt == nil {
 		return
 	}
+	// statements in a SECTOR block are added at the end of the block
+	if mdl.block != nil || stmt.Mode == "ENDSECTOR" {
+		return mdl.addSectorStatement(stmt)
+	}
 	line := stmt.Stmt
 	if len(line) == 0 {
 		return
 	}
 	prepLine := func() *Result {
 		if strings.Contains(line, " ") {
-			if strict {
+			if mdl.Strict {
 				return Failure(ErrParseInvalidSpace)
 			} else {
 				line = strings.Replace(line, " ", "", -1)
@@ -169,7 +269,7 @@ func (mdl *Model) AddStatement(stmt *Line) (res *Result) {
 		}
 		return Success()
 	}
-	Dbg.Msgf("AddStmt: [%s] %s\n", stmt.Mode, stmt.Stmt)
+	mdl.Dbg.Msgf("AddStmt: [%s] %s\n", stmt.Mode, stmt.Stmt)
 
 	// handle statement based on its mode
 	switch stmt.Mode {
@@ -180,16 +280,52 @@ func (mdl *Model) AddStatement(stmt *Line) (res *Result) {
 
 	case "NOTE":
 		//--------------------------------------------------------------
-		// skip over comments
+		// skip over comments; structured metadata is attached to the
+		// following definitions.
+		mdl.addMeta(stmt.Stmt)
 
 	case "L", "R", "C", "N", "A", "S":
 		//--------------------------------------------------------------
 		// Level and rate equations
-		var eqns *EqnList
-		if eqns, res = NewEquation(stmt); !res.Ok {
+		var (
+			eqns  *EqnList
+			attrs map[string]string
+		)
+		if stmt, attrs, res = splitAttributes(stmt); !res.Ok {
 			break
 		}
+		if eqns, res = NewEquation(stmt, mdl); !res.Ok {
+			break
+		}
+		meta := mdl.takeMeta()
+		for key, val := range attrs {
+			meta[key] = val
+		}
 		for _, eqn := range eqns.List() {
+			eqn.Meta = meta
+			// declared type of computed values
+			if typ, ok := meta[META_TYPE]; ok && eqn.Target.Name[0] != '_' {
+				if eqn.Type, res = parseType(typ); !res.Ok {
+					break
+				}
+			}
+			// only variables in a sector have qualified names
+			if eqn.Target.Qualifier() != mdl.scope && eqn.Target.Name[0] != '_' {
+				if mdl.known[eqn.Target.Qualifier()] {
+					res = Failure(ErrParseSector+": %s outside of sector", eqn.Target.Name)
+				} else {
+					res = Failure(ErrParseInvalidIndex+": %s", eqn.Target.Name)
+				}
+				break
+			}
+			// check names used in the equation
+			if res = mdl.checkNames(eqn); !res.Ok {
+				break
+			}
+			// load external data series used in the equation
+			if res = mdl.loadSeries(eqn); !res.Ok {
+				break
+			}
 			// check if equation has correct temporality and kind
 			// (don't check dependencies at this stage)
 			if res = eqns.validateEqn(mdl, eqn, nil); !res.Ok {
@@ -200,13 +336,21 @@ func (mdl *Model) AddStatement(stmt *Line) (res *Result) {
 				if !mdl.Edit {
 					res = Failure(ErrModelEqnOverwrite)
 				}
-				Dbg.Msgf("ReplaceEquation: %s\n", eqn.String())
+				// the equations stay sorted if a constant is replaced by
+				// a number
+				old := mdl.Eqns.Find(eqn.Target.Name)
+				mdl.edited = mdl.edited || mdl.sorted
+				mdl.sorted = mdl.sorted && old.Mode == "C" && eqn.Mode == "C" && len(eqn.Dependencies) == 0
+				mdl.Dbg.Msgf("ReplaceEquation: %s\n", eqn.String())
 				mdl.Eqns.Replace(eqn)
 			} else {
 				// unsorted append to list of equations
-				Dbg.Msgf("AddEquation: %s\n", eqn.String())
+				mdl.Dbg.Msgf("AddEquation: %s\n", eqn.String())
 				mdl.Eqns.Add(eqn)
+				mdl.edited = mdl.edited || mdl.sorted
+				mdl.sorted = false
 			}
+			Logf(LOG_VERBOSE, LOG_PARSE, "      Equation %s", eqn.String())
 		}
 
 	case "T":
@@ -217,11 +361,152 @@ func (mdl *Model) AddStatement(stmt *Line) (res *Result) {
 		}
 		var tbl *Table
 		tab := strings.Split(line, "=")
-		vals := strings.Replace(tab[1], "/", ",", -1)
-		if tbl, res = NewTable(strings.Split(vals, ",")); !res.Ok {
+		if len(tab) != 2 {
+			res = Failure(ErrParseSyntax+": %s", line)
 			break
 		}
+		if strings.HasPrefix(tab[1], "@") {
+			// table data from external CSV file (or graphical function
+			// of a XMILE model)
+			if isXMILE(tab[1]) {
+				tbl, res = NewTableFromXMILE(tab[1][1:], tab[0])
+			} else {
+				tbl, res = NewTableFromFile(tab[1][1:])
+			}
+			if !res.Ok {
+				break
+			}
+		} else if strings.HasPrefix(tab[1], "(") {
+			// explicit (x,y) pairs
+			if tbl, res = NewTableFromPairs(tab[1]); !res.Ok {
+				break
+			}
+		} else {
+			vals := strings.Replace(tab[1], "/", ",", -1)
+			if tbl, res = NewTable(strings.Split(vals, ",")); !res.Ok {
+				break
+			}
+		}
+		tbl.Comment = stmt.Comment
+		tbl.Meta = mdl.takeMeta()
 		mdl.Tables[tab[0]] = tbl
+		Logf(LOG_VERBOSE, LOG_PARSE, "      Table %s (%d values)", tab[0], len(tbl.Data))
+
+	case "T2":
+		//--------------------------------------------------------------
+		// 2-D table definitions (rows separated by '/')
+		if res = prepLine(); !res.Ok {
+			break
+		}
+		var tbl *Table
+		tab := strings.Split(line, "=")
+		if len(tab) != 2 {
+			res = Failure(ErrParseSyntax+": %s", line)
+			break
+		}
+		if tbl, res = NewTable2(strings.Split(tab[1], "/")); !res.Ok {
+			break
+		}
+		tbl.Comment = stmt.Comment
+		tbl.Meta = mdl.takeMeta()
+		mdl.Tables[tab[0]] = tbl
+		Logf(LOG_VERBOSE, LOG_PARSE, "      Table %s (%dx%d values)", tab[0], len(tbl.Data)/tbl.Cols, tbl.Cols)
+
+	case "SECTOR":
+		//--------------------------------------------------------------
+		// Start of a sector (namespace for variables)
+		if res = prepLine(); !res.Ok {
+			break
+		}
+		res = mdl.startSector(line)
+
+	case "INPUT":
+		//--------------------------------------------------------------
+		// Input ports (co-simulation)
+		if res = prepLine(); !res.Ok {
+			break
+		}
+		res = mdl.addInputs(line, stmt.Comment)
+
+	case "OUTPUT":
+		//--------------------------------------------------------------
+		// Output ports (co-simulation)
+		if res = prepLine(); !res.Ok {
+			break
+		}
+		res = mdl.addOutputs(line)
+
+	case "SNAPSHOT":
+		//--------------------------------------------------------------
+		// Named snapshot at given time in the next run
+		if res = prepLine(); !res.Ok {
+			break
+		}
+		def := strings.Split(line, "=")
+		if len(def) != 2 {
+			res = Failure(ErrParseSyntax+": %s", line)
+			break
+		}
+		t, ok := parseNumber(def[1])
+		if !ok {
+			res = Failure(ErrParseNotANumber+": %s", def[1])
+			break
+		}
+		mdl.SnapshotAt(def[0], t)
+
+	case "CONSERVE":
+		//--------------------------------------------------------------
+		// Conserved group of levels
+		if res = prepLine(); !res.Ok {
+			break
+		}
+		res = mdl.addConserve(line)
+
+	case "BRANCH":
+		//--------------------------------------------------------------
+		// Branch a new run from a snapshot
+		if res = prepLine(); !res.Ok || mdl.NoRun {
+			break
+		}
+		res = mdl.addBranch(line)
+
+	case "GAME":
+		//--------------------------------------------------------------
+		// Gaming mode: ask for decisions during the run
+		if res = prepLine(); !res.Ok {
+			break
+		}
+		mdl.Game, res = NewGame(line)
+
+	case "INSTANCE":
+		//--------------------------------------------------------------
+		// Instance of a submodel (from file)
+		if res = prepLine(); !res.Ok {
+			break
+		}
+		res = mdl.addInstance(line)
+
+	case "DATA":
+		//--------------------------------------------------------------
+		// Exogenous data series: the variable is defined by an auxiliary
+		// equation that interpolates the series at the current TIME.
+		if res = prepLine(); !res.Ok {
+			break
+		}
+		def := strings.Split(line, "=")
+		if len(def) != 2 || !strings.HasPrefix(def[1], "@") {
+			res = Failure(ErrParseSyntax+": %s", line)
+			break
+		}
+		var series *Series
+		if series, res = NewSeriesFromFile(def[1][1:], def[0]); !res.Ok {
+			break
+		}
+		mdl.Series[def[0]] = series
+		res = mdl.AddStatement(&Line{
+			Stmt: fmt.Sprintf("%s.K=EXTDAT(%s)", def[0], def[0]),
+			Mode: "A",
+		})
 
 	case "SPEC":
 		//--------------------------------------------------------------
@@ -232,33 +517,72 @@ func (mdl *Model) AddStatement(stmt *Line) (res *Result) {
 			break
 		}
 		// model simulation specification
-		if mdl.Verbose {
-			Msg("   Runtime specification:")
-		}
+		Log(LOG_VERBOSE, LOG_PARSE, "   Runtime specification:")
 		for _, def := range strings.Split(strings.Replace(line, "/", ",", -1), ",") {
-			var eqns *EqnList
-			stmt := &Line{
-				Stmt: def,
-				Mode: "C",
+			// run policy for numeric anomalies
+			if x := strings.SplitN(def, "=", 2); len(x) == 2 && isAnomaly(x[0]) {
+				if mdl.Policy == nil {
+					mdl.Policy = NewPolicy()
+				}
+				if res = mdl.Policy.SetAction(x[0], x[1]); !res.Ok {
+					break
+				}
+				continue
 			}
-			if eqns, res = NewEquation(stmt); !res.Ok {
-				break
+			// time unit and start date
+			if x := strings.SplitN(def, "=", 2); len(x) == 2 && (x[0] == "TUNIT" || x[0] == "START") {
+				if mdl.Calendar == nil {
+					mdl.Calendar = new(Calendar)
+				}
+				if x[0] == "TUNIT" {
+					res = mdl.Calendar.SetUnit(x[1])
+				} else {
+					res = mdl.Calendar.SetStart(x[1])
+				}
+				if !res.Ok {
+					break
+				}
+				continue
 			}
-			mdl.Eqns.AddList(eqns)
-
+			// run-time parameters (constants)
 			x := strings.Split(def, "=")
 			if len(x) != 2 {
-				res = Failure(ErrParseSyntax)
+				res = Failure(ErrParseSpec+": %s", def)
 				break
 			}
-			val, err := strconv.ParseFloat(x[1], 64)
-			if err != nil {
-				res = Failure(err)
+			val, ok := parseNumber(x[1])
+			if !ok {
+				res = Failure(ErrParseSpec+": %s", def)
 				break
 			}
-			if mdl.Verbose {
-				Msgf("        %s = %f\n", x[0], val)
+			if res = checkSpec(x[0], val); !res.Ok {
+				break
 			}
+			var eqns *EqnList
+			stmt := &Line{
+				Stmt: def,
+				Mode: "C",
+			}
+			if eqns, res = NewEquation(stmt, mdl); !res.Ok {
+				break
+			}
+			eqn := eqns.List()[0]
+			if mdl.Eqns.Contains(eqn) {
+				if !mdl.Edit {
+					res = Failure(ErrModelEqnOverwrite)
+					break
+				}
+				// the equations stay sorted if a constant is replaced
+				old := mdl.Eqns.Find(eqn.Target.Name)
+				mdl.edited = mdl.edited || mdl.sorted
+				mdl.sorted = mdl.sorted && old.Mode == "C"
+				mdl.Eqns.Replace(eqn)
+			} else {
+				mdl.Eqns.Add(eqn)
+				mdl.edited = mdl.edited || mdl.sorted
+				mdl.sorted = false
+			}
+			Logf(LOG_VERBOSE, LOG_PARSE, "        %s = %f\n", x[0], val)
 		}
 
 	case "PRINT":
@@ -284,15 +608,52 @@ func (mdl *Model) AddStatement(stmt *Line) (res *Result) {
 		// Run model
 		mdl.Edit = false
 		mdl.RunID = stmt.Stmt
-		Msgf("   Running system model '%s'...", mdl.RunID)
+		if mdl.NoRun {
+			// only stack the model equations
+			Logf(LOG_VERBOSE, LOG_RUN, "   Skipping run of system model '%s'", mdl.RunID)
+			mdl.Stack[mdl.RunID] = mdl.Eqns.Clone()
+			mdl.Eqns = nil
+			break
+		}
+		Logf(LOG_INFO, LOG_RUN, "   Running system model '%s'...", mdl.RunID)
 		if res = mdl.Run(); res.Ok {
 			res = mdl.Output()
 			// Stack model equations for later use
-			Msgf("      Stacking system model '%s'...", mdl.RunID)
+			Logf(LOG_INFO, LOG_RUN, "      Stacking system model '%s'...", mdl.RunID)
 			mdl.Stack[mdl.RunID] = mdl.Eqns.Clone()
 			mdl.Eqns = nil
 		}
-		Msg("      Done.")
+		Log(LOG_INFO, LOG_RUN, "      Done.")
+
+	case "COMPARE":
+		//--------------------------------------------------------------
+		// Compare results of two runs (or datasets from CSV files)
+		if res = prepLine(); !res.Ok || mdl.NoRun {
+			break
+		}
+		runs := strings.Split(line, ",")
+		if len(runs) != 2 {
+			res = Failure(ErrParseSyntax+": %s", line)
+			break
+		}
+		var ds [2]*Dataset
+		for i, run := range runs {
+			if ds[i], res = mdl.Dataset(run); !res.Ok {
+				break
+			}
+		}
+		if !res.Ok {
+			break
+		}
+		var diffs []*VarDiff
+		if diffs, res = ds[0].Compare(ds[1]); !res.Ok {
+			break
+		}
+		out := mdl.Print.Writer()
+		if out == nil {
+			out = MsgWriter()
+		}
+		WriteComparison(out, runs[0], runs[1], diffs)
 
 	case "EDIT":
 		//--------------------------------------------------------------
@@ -303,8 +664,9 @@ func (mdl *Model) AddStatement(stmt *Line) (res *Result) {
 			res = Failure(ErrModelNotAvailable+": %s", stmt.Stmt)
 			break
 		}
-		Msgf("   Editing system model '%s':", stmt.Stmt)
+		Logf(LOG_INFO, LOG_PARSE, "   Editing system model '%s':", stmt.Stmt)
 		mdl.Eqns = eqns.Clone()
+		mdl.sorted, mdl.edited = true, false
 		mdl.Edit = true
 		// reset output
 		mdl.Print.Reset()
@@ -314,14 +676,92 @@ func (mdl *Model) AddStatement(stmt *Line) (res *Result) {
 		// reset states
 		mdl.Last = make(State)
 		mdl.Current = make(State)
+		mdl.auto.reset()
 
 	default:
-		Dbg.Msgf("Unknown mode '%s'\n", stmt.Mode)
+		mdl.Dbg.Msgf("Unknown mode '%s'\n", stmt.Mode)
 		res = Failure(ErrParseInvalidMode+": %s", stmt.Mode)
 	}
 	return
 }
 
+// addMeta handles structured metadata in a NOTE line ("@key value"). The
+// sector is valid for all following definitions (until the next sector is
+// defined); other metadata is attached to the next definition only.
+func (mdl *Model) addMeta(note string) {
+	if !strings.HasPrefix(note, "@") {
+		return
+	}
+	key, val := note[1:], ""
+	if pos := strings.IndexAny(key, " \t"); pos != -1 {
+		key, val = key[:pos], strings.TrimSpace(key[pos:])
+	}
+	key = strings.ToLower(key)
+	if key == META_SECTOR {
+		mdl.sector = val
+		return
+	}
+	if mdl.meta == nil {
+		mdl.meta = make(map[string]string)
+	}
+	mdl.meta[key] = val
+}
+
+// checkSpec checks the value of a run-time parameter in a SPEC statement:
+// the time step (DT), the length of a run (LENGTH) and its upper limit
+// (MAXLEN), print and plot intervals (PRTPER, PLTPER) and the relative
+// error for iteratively resolved initial values (RELERR).
+func checkSpec(key string, val float64) *Result {
+	var ok bool
+	switch key {
+	case "DT", "MAXLEN", "RELERR":
+		ok = val > 0
+	case "PRTPER", "PLTPER":
+		ok = val >= 0
+	case "LENGTH":
+		ok = true
+	default:
+		return Failure(ErrParseSpec+": unknown keyword %s", key)
+	}
+	if !ok {
+		return Failure(ErrParseSpec+": %s=%g", key, val)
+	}
+	return Success()
+}
+
+// takeMeta returns the metadata for a new definition and resets the
+// pending metadata.
+func (mdl *Model) takeMeta() (meta map[string]string) {
+	meta = make(map[string]string)
+	for key, val := range mdl.meta {
+		meta[key] = val
+	}
+	if len(mdl.sector) > 0 {
+		meta[META_SECTOR] = mdl.sector
+	}
+	mdl.meta = nil
+	return
+}
+
+// checkNames checks all variable names in an equation for compliance with
+// the DYNAMO naming rules.
+func (mdl *Model) checkNames(eqn *Equation) (res *Result) {
+	if mdl.Relaxed {
+		return Success()
+	}
+	if res = eqn.Target.Check(mdl.Strict); !res.Ok {
+		return
+	}
+	for _, list := range [][]*Name{eqn.Dependencies, eqn.References} {
+		for _, name := range list {
+			if res = name.Check(mdl.Strict); !res.Ok {
+				return
+			}
+		}
+	}
+	return
+}
+
 //----------------------------------------------------------------------
 // Getter/Setter methods for DYNAMO variables (levels, rates, constants)
 //----------------------------------------------------------------------
@@ -333,9 +773,9 @@ func (mdl *Model) Get(name *Name) (val Variable, res *Result) {
 	res = Success()
 	defer func() {
 		if res.Ok {
-			Dbg.Msgf("<   %s = %f (%d)\n", name, val, name.Stage)
+			mdl.Dbg.Tracef("<   %s = %f (%d)\n", name, val, name.Stage)
 		} else {
-			Dbg.Msgf("<   %s = FAILED\n", name)
+			mdl.Dbg.Tracef("<   %s = FAILED\n", name)
 		}
 	}()
 
@@ -360,10 +800,34 @@ func (mdl *Model) Get(name *Name) (val Variable, res *Result) {
 func (mdl *Model) Set(name *Name, val Variable) (res *Result) {
 	res = Success()
 	mdl.Current[name.Name] = val
-	Dbg.Msgf(">   %s = %f (%d)\n", name, val, name.Stage)
+	mdl.Dbg.Tracef(">   %s = %f (%d)\n", name, val, name.Stage)
 	return
 }
 
+// VarType returns the type of a variable (TYPE_???) as declared by (or
+// inferred from) its equation.
+func (mdl *Model) VarType(name string) int {
+	if mdl.Eqns != nil {
+		for _, eqn := range mdl.Eqns.index().defs[name] {
+			if eqn.Mode != "N" {
+				return eqn.Type
+			}
+		}
+	}
+	return TYPE_REAL
+}
+
+// sysModes are the modes of equations that can define system variables:
+// the time is only initialized, run parameters are constants (or
+// auxiliaries like "A LENGTH.K=...").
+var sysModes = map[string]string{
+	"TIME":   "CN",
+	"DT":     "C",
+	"LENGTH": "CA",
+	"PRTPER": "CA",
+	"PLTPER": "CA",
+}
+
 // IsSystem returns true for pre-defined system variables.
 func (mdl *Model) IsSystem(name string) bool {
 	// check for pre-defined variable names
@@ -379,49 +843,348 @@ func (mdl *Model) IsSystem(name string) bool {
 	return false
 }
 
+// DefinitionOf returns the equation defining a variable (or nil if no
+// such equation exists): the level, rate or auxiliary equation of a
+// variable or the constant or initial value. If the model has been run,
+// the equations of the last run are used.
+func (mdl *Model) DefinitionOf(name string) *Equation {
+	list := mdl.equations().index().defs[name]
+	for _, eqn := range list {
+		if eqn.Mode != "N" {
+			return eqn
+		}
+	}
+	if len(list) > 0 {
+		return list[0]
+	}
+	return nil
+}
+
+// UsesOf returns the equations that use a variable on the right side (in
+// order of the model equations). If the model has been run, the equations
+// of the last run are used.
+func (mdl *Model) UsesOf(name string) []*Equation {
+	return mdl.equations().Uses(name)
+}
+
 // Initial returns an initial value for a quantity as calculated by the model.
 func (mdl *Model) Initial(name string) (val Variable, res *Result) {
 	// find equation for quantity
-	Dbg.Msgf("Find initial value for %s\n", name)
+	mdl.Dbg.Tracef("Find initial value for %s\n", name)
+	if mdl.resolving[name] {
+		return 0, Failure(ErrModelDependencyLoop+": %s", name)
+	}
 	if eqn := mdl.Eqns.Find(name); eqn != nil {
+		if mdl.resolving == nil {
+			mdl.resolving = make(map[string]bool)
+		}
+		mdl.resolving[name] = true
 		val, res = eqn.Eval(mdl)
+		delete(mdl.resolving, name)
 	} else {
 		res = Failure(ErrModelNoInitial+": %s", name)
 	}
 	return
 }
 
+// Dataset returns the recorded results of a model run. If the name starts
+// with '@', the dataset is read from the named CSV file.
+func (mdl *Model) Dataset(name string) (ds *Dataset, res *Result) {
+	res = Success()
+	if strings.HasPrefix(name, "@") {
+		return NewDatasetFromFile(name[1:])
+	}
+	var ok bool
+	if ds, ok = mdl.Results[name]; !ok {
+		res = Failure(ErrModelNotAvailable+": %s", name)
+	}
+	return
+}
+
+// VerifyAgainst re-runs the model (as defined by the last RUN statement or
+// the current equations) and compares the results against reference data
+// in CSV format (as generated by the CSV printer). It returns the list of
+// values that don't match within the given (relative) tolerance.
+func (mdl *Model) VerifyAgainst(reference io.Reader, tolerance float64) (list []*Mismatch, res *Result) {
+	// read reference data
+	var ref *Dataset
+	if ref, res = NewDatasetFromReader(reference, "reference"); !res.Ok {
+		return
+	}
+	// get model equations to run
+	eqns := mdl.Eqns
+	if eqns == nil {
+		stacked, ok := mdl.Stack[mdl.RunID]
+		if !ok {
+			res = Failure(ErrModelNotAvailable+": %s", mdl.RunID)
+			return
+		}
+		mdl.Eqns = stacked.Clone()
+	}
+	sorted, edited := mdl.sorted, mdl.edited
+	mdl.sorted = eqns == nil || sorted
+	defer func() {
+		mdl.Eqns, mdl.sorted, mdl.edited = eqns, sorted, edited
+	}()
+	// re-run model from scratch
+	mdl.Print.Reset()
+	mdl.Plot.Reset()
+	mdl.Last = make(State)
+	mdl.Current = make(State)
+	mdl.auto.reset()
+	if res = mdl.Run(); !res.Ok {
+		return
+	}
+	return mdl.Results[mdl.RunID].Verify(ref, tolerance)
+}
+
 //----------------------------------------------------------------------
 // DYNAMO model runtime
 //----------------------------------------------------------------------
 
 // Run a DYNAMO model.
 func (mdl *Model) Run() (res *Result) {
-	// sort equations "topologically" after parsing
-	if mdl.Eqns, res = mdl.Eqns.Sort(mdl); !res.Ok {
+	t0 := time.Now()
+	if res = mdl.Start(); !res.Ok {
+		metrics.record(mdl.RunID, false, time.Since(t0), 0, 0)
 		return
 	}
-	// perform equation validation
-	if res = mdl.Eqns.Validate(mdl); !res.Ok {
-		return
+	for !mdl.Done() {
+		if res = mdl.step(); !res.Ok {
+			break
+		}
 	}
-	if mdl.Verbose {
-		mdl.Dump()
+	epochs, eqns := mdl.run.epoch-1, mdl.run.eqns.Len()
+	mdl.Finish()
+	metrics.record(mdl.RunID, res.Ok, time.Since(t0), epochs, eqns)
+	return
+}
+
+// runState holds the state of a running model
+type runState struct {
+	eqns   *EqnList // run-time equations
+	ds     *Dataset // recorded results
+	epoch  int      // current epoch
+	t      Variable // time of current epoch
+	start  Variable // start time of run
+	dt     Variable // time step
+	hist   *history // past states (for rollback)
+	suppl  []string // names of supplementary variables
+	comp   State    // compensations for level updates (reproducible mode)
+	bounds []*bound // bounds of levels
+
+	strata map[string][]*stratum     // strata of equations (by modes)
+	intern map[string]string         // derived names of recorded automatic variables
+	cache  map[*Equation]*cacheEntry // inputs of cached auxiliaries (or nil)
+	hits   int                       // skipped evaluations of cached auxiliaries
+}
+
+// supplements returns the names of supplementary variables in a list of
+// equations.
+func supplements(eqns *EqnList) (names []string) {
+	for _, eqn := range eqns.List() {
+		if eqn.Mode == "S" {
+			names = append(names, eqn.Target.Name)
+		}
 	}
+	return
+}
 
-	// compute all equations with specified mode
-	compute := func(modes string, eqns *EqnList) (res *Result) {
-		res = Success()
-		for _, eqn := range eqns.List() {
-			if strings.Contains(modes, eqn.Mode) {
-				if _, res = eqn.Eval(mdl); !res.Ok {
-					Dbg.Msg(eqn.String())
+// output returns true if the state of the current run is output in the
+// current epoch (print, plot, pacing or final epoch); supplements are only
+// computed in output epochs.
+func (mdl *Model) output() bool {
+	return mdl.Print.output() || mdl.Plot.output() ||
+		mdl.Pacer != nil || mdl.run.t+mdl.run.dt > mdl.Current["LENGTH"]
+}
+
+// outputDue returns true if output with given period (PRTPER or PLTPER) is
+// due at the current time. The period can change during a run (if defined
+// by an equation); a period of zero disables the output.
+func (mdl *Model) outputDue(period string, next float64) bool {
+	if mdl.Current[period] <= 0 {
+		return false
+	}
+	return float64(mdl.Current["TIME"]) >= next-float64(mdl.Current["DT"])/2
+}
+
+// checkOutputPeriod checks the output period (PRTPER or PLTPER) at the
+// start of a run.
+func (mdl *Model) checkOutputPeriod(period string) *Result {
+	pp, ok := mdl.Current[period]
+	if !ok {
+		return Failure(ErrModelMissingDef+": %s", period)
+	}
+	dt, ok := mdl.Current["DT"]
+	if !ok {
+		return Failure(ErrModelMissingDef + ": DT")
+	}
+	if pp == 0 {
+		Logf(LOG_VERBOSE, LOG_OUTPUT, "      %s=0: output disabled", period)
+	} else if steps := math.Round(float64(pp / dt)); compare(float64(pp), steps*float64(dt)) != 0 {
+		Logf(LOG_WARN, LOG_OUTPUT, "%s != n * DT", period)
+	}
+	return Success()
+}
+
+// checkOutputVars checks that all variables in PRINT and PLOT statements
+// are defined (at the start of a run); all missing variables are reported.
+func (mdl *Model) checkOutputVars() *Result {
+	// recorded internal variables of functions
+	intern := make(map[string]bool)
+	if mdl.Internals {
+		for _, name := range mdl.internNames() {
+			intern[name] = true
+		}
+	}
+	var missing []string
+	check := func(name, stmt string) {
+		if _, ok := sysModes[name]; !ok && !intern[name] && mdl.Eqns.Find(name) == nil {
+			missing = append(missing, mdl.Spelling(name)+" ["+stmt+"]")
+		}
+	}
+	for name := range mdl.Print.run.Vars {
+		check(name, "PRINT")
+	}
+	for name := range mdl.Plot.run.Vars {
+		check(name, "PLOT")
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return Failure(ErrModelNoVariable+": %s", strings.Join(missing, ", "))
+	}
+	return Success()
+}
+
+// compute all equations with specified mode
+func (mdl *Model) compute(modes string, eqns *EqnList) (res *Result) {
+	res = Success()
+	for _, eqn := range eqns.List() {
+		if strings.Contains(modes, eqn.Mode) {
+			if mdl.cached(eqn) {
+				// inputs unchanged: keep value
+				continue
+			}
+			if _, res = eqn.Eval(mdl); !res.Ok {
+				mdl.Dbg.Msg(eqn.String())
+				break
+			}
+		}
+	}
+	return
+}
+
+// MAX_INIT_PASSES is the max. number of passes to resolve initial values.
+const MAX_INIT_PASSES = 100
+
+// initialize computes the initial values from the init equations. Initial
+// values that depend on variables not yet known (e.g. auxiliaries that use
+// levels or cyclic references between N equations) are resolved by
+// repeating the computation until all values are known and stable.
+func (mdl *Model) initialize(initEqns, runEqns *EqnList) (res *Result) {
+	defer func() {
+		mdl.unresolved = nil
+	}()
+	for pass := 1; pass <= MAX_INIT_PASSES; pass++ {
+		prev := mdl.Current.Clone()
+		if pass > 1 {
+			// forget run-time values computed from missing variables
+			for _, eqn := range runEqns.List() {
+				if eqn.Mode != "L" {
+					delete(mdl.Current, eqn.Target.Name)
+				}
+			}
+		}
+		mdl.unresolved = make(map[string]*Name)
+		if res = mdl.compute("CN", initEqns); !res.Ok {
+			return
+		}
+		// check for stable initial values (within the relative error
+		// RELERR if defined)
+		stable := pass > 1 || len(mdl.unresolved) == 0
+		relErr, rel := mdl.Current["RELERR"]
+		if pass > 1 {
+			for _, eqn := range initEqns.List() {
+				name := eqn.Target.Name
+				val, old := mdl.Current[name], prev[name]
+				if rel {
+					stable = math.Abs(float64(val-old)) <= float64(relErr)*math.Max(1, math.Abs(float64(old)))
+				} else {
+					stable = val.Compare(old) == 0
+				}
+				if !stable {
 					break
 				}
 			}
 		}
+		if stable {
+			// variables that are still missing are undefined
+			for name := range mdl.unresolved {
+				Logf(LOG_WARN, LOG_RUN, "Missing variable %s", name)
+			}
+			mdl.Dbg.Msgf("Initial values resolved in %d passes\n", pass)
+			return
+		}
+	}
+	for name := range mdl.unresolved {
+		Logf(LOG_ERROR, LOG_RUN, "Unresolved initial value: %s", name)
+	}
+	return Failure(ErrModelMaxRetry+": initialization (%d passes)", MAX_INIT_PASSES)
+}
+
+// Start a model run: the equations are sorted and validated and the
+// initial state is computed. The model can then be run step by step.
+func (mdl *Model) Start() (res *Result) {
+	atomic.StoreInt32(&mdl.cancel, 0)
+	// set overridden constants
+	if res = mdl.applyOverrides(); !res.Ok {
+		return
+	}
+	if mdl.sorted {
+		// only constants changed since the last run: the equations are
+		// still sorted and valid.
+		Log(LOG_VERBOSE, LOG_RUN, "      Re-using sorted equations")
+	} else {
+		// check qualified names
+		if res = mdl.checkQualifiers(mdl.Eqns); !res.Ok {
+			return
+		}
+		if mdl.edited {
+			// equations were sorted before the edit: only equations out
+			// of order are moved.
+			var moved []*Equation
+			if mdl.Eqns, moved, res = mdl.Eqns.Resort(mdl); !res.Ok {
+				return
+			}
+			Logf(LOG_INFO, LOG_MODEL, "      Re-sorted edited equations (%d changed order)", len(moved))
+			for _, eqn := range moved {
+				Logf(LOG_INFO, LOG_MODEL, "         %s", eqn.String())
+			}
+		} else {
+			// sort equations "topologically" after parsing
+			if mdl.Eqns, res = mdl.Eqns.Sort(mdl); !res.Ok {
+				return
+			}
+		}
+		// perform equation validation
+		if res = mdl.Eqns.Validate(mdl); !res.Ok {
+			return
+		}
+		mdl.sorted, mdl.edited = true, false
+	}
+	// check conserved groups
+	if res = mdl.checkConserved(mdl.Eqns); !res.Ok {
 		return
 	}
+	// check output ports
+	for _, name := range mdl.Outputs {
+		if mdl.Eqns.Find(name) == nil {
+			return Failure(ErrModelNoPort+": %s", name)
+		}
+	}
+	if logged(LOG_VERBOSE, LOG_MODEL) {
+		mdl.Dump()
+	}
 
 	// compute split in equation list between "init" and "run"
 	split := 0
@@ -430,24 +1193,39 @@ func (mdl *Model) Run() (res *Result) {
 			split = i + 1
 		}
 	}
-	if mdl.Verbose {
-		Msgf("      INFO: Splitting equations: INIT=[1..%d], RUN=[%d..%d]\n", split, split+1, mdl.Eqns.Len())
-	}
+	Logf(LOG_VERBOSE, LOG_RUN, "      Splitting equations: INIT=[1..%d], RUN=[%d..%d]\n", split, split+1, mdl.Eqns.Len())
 	initEqns, runEqns := mdl.Eqns.Split(split)
 
 	//------------------------------------------------------------------
 	// Initialize state:
 	//------------------------------------------------------------------
-	Msg("      Initializing state...")
+	Log(LOG_INFO, LOG_RUN, "      Initializing state...")
+
+	// random numbers (with given or random seed)
+	seed := mdl.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	mdl.rng = rand.New(rand.NewSource(seed))
 
+	// check DT against time constants (before initialization as initial
+	// values can depend on DT)
+	if res = mdl.compute("C", initEqns); !res.Ok {
+		return
+	}
+	if dt := mdl.checkDT(); dt > 0 {
+		if res = mdl.setDT(dt); !res.Ok {
+			return
+		}
+	}
 	// initialize from equations
-	if res = compute("CNRA", initEqns); !res.Ok {
+	if res = mdl.initialize(initEqns, runEqns); !res.Ok {
 		return
 	}
 	// set predefined (system) variables if not defined
 	setDef := func(name string, val Variable) {
 		if _, ok := mdl.Current[name]; !ok {
-			Msgf("         INFO: Setting '%s' to %f\n", name, val)
+			Logf(LOG_INFO, LOG_RUN, "         INFO: Setting '%s' to %f\n", name, val)
 			mdl.Current[name] = val
 		}
 	}
@@ -481,14 +1259,14 @@ loop:
 		}
 		// evaluate equation
 		if _, res = eqn.Eval(mdl); !res.Ok {
-			Dbg.Msgf("Failed runtime eqn in init: %s\n", eqn.String())
+			mdl.Dbg.Msgf("Failed runtime eqn in init: %s\n", eqn.String())
 		}
 	}
 
 	//------------------------------------------------------------------
 	// Checking state:
 	//------------------------------------------------------------------
-	Msg("      Checking state...")
+	Log(LOG_INFO, LOG_MODEL, "      Checking state...")
 
 	// Check if all levels have level equations
 	check := make(map[string]bool)
@@ -511,12 +1289,16 @@ loop:
 			check[eqn.Target.Name] = true
 			continue
 		}
+		// hidden levels (of integrators) are always initialized
 		level := eqn.Target.Name
+		if level[0] == '_' {
+			continue
+		}
 		if _, ok := check[level]; ok {
 			check[level] = true
 		} else {
 			if eqn.Mode != "S" {
-				Msgf("         %s not initialized\n", level)
+				Logf(LOG_WARN, LOG_MODEL, "%s not initialized\n", mdl.Spelling(level))
 			}
 			ok = false
 		}
@@ -526,15 +1308,37 @@ loop:
 			continue
 		}
 		if !val {
-			Msgf("         %s has no equation\n", level)
+			Logf(LOG_WARN, LOG_MODEL, "%s has no equation\n", mdl.Spelling(level))
 			ok = false
 		} else if _, inuse := used[level]; !inuse {
-			Msgf("         %s not used\n", level)
+			Logf(LOG_WARN, LOG_MODEL, "%s not used\n", mdl.Spelling(level))
+			ok = false
+		}
+	}
+	// Check if referenced tables are defined and defined tables are used
+	usedTbl := make(map[string]bool)
+	for _, eqn := range mdl.Eqns.List() {
+		for _, name := range eqn.Tables() {
+			if _, defined := mdl.Tables[name]; !defined {
+				return Failure(ErrModelNoSuchTable+": %s", name)
+			}
+			usedTbl[name] = true
+		}
+	}
+	for name := range mdl.Tables {
+		if !usedTbl[name] {
+			Logf(LOG_WARN, LOG_MODEL, "Table %s not used\n", name)
+			ok = false
+		}
+	}
+	if mdl.Lint {
+		for _, l := range mdl.LintModel(mdl.LintRules) {
+			Logf(LOG_WARN, LOG_MODEL, "%s\n", l.String())
 			ok = false
 		}
 	}
 	if ok {
-		Msg("         No problems detected.")
+		Log(LOG_INFO, LOG_MODEL, "         No problems detected.")
 	}
 	// get targets of rate equations
 	for _, eqn := range mdl.Eqns.List() {
@@ -543,6 +1347,10 @@ loop:
 		}
 	}
 
+	// TIME at start date of calendar
+	if mdl.Calendar != nil {
+		mdl.Calendar.Origin = float64(mdl.Current["TIME"])
+	}
 	// Start printer and plotter
 	if res = mdl.Print.Start(); !res.Ok {
 		return
@@ -550,43 +1358,197 @@ loop:
 	if res = mdl.Plot.Start(); !res.Ok {
 		return
 	}
+	if res = mdl.checkOutputVars(); !res.Ok {
+		return
+	}
 
 	//------------------------------------------------------------------
 	// Running the model
 	//------------------------------------------------------------------
 
 	// Running the model
-	Msg("      Iterating epochs...")
-	dt := mdl.Current["DT"]
-	time, ok := mdl.Current["TIME"]
+	Log(LOG_INFO, LOG_RUN, "      Iterating epochs...")
+	t, ok := mdl.Current["TIME"]
 	if !ok {
-		time = 0.0
-		mdl.Current["TIME"] = time
+		t = 0.0
+		mdl.Current["TIME"] = t
+	}
+	if mdl.Game != nil {
+		if res = mdl.Game.start(mdl); !res.Ok {
+			return
+		}
+	}
+	if mdl.Pacer != nil {
+		if res = mdl.Pacer.begin(mdl); !res.Ok {
+			return
+		}
 	}
+	mdl.run = &runState{
+		eqns:  runEqns,
+		ds:    NewDataset(mdl.RunID),
+		epoch: 1,
+		t:     t,
+		start: t,
+		dt:    mdl.Current["DT"],
+		hist:  newHistory(mdl.History),
+		suppl: supplements(runEqns),
+	}
+	mdl.run.ds.Provenance = mdl.provenance(seed)
+	if mdl.Internals {
+		mdl.run.intern = mdl.internNames()
+	}
+	if mdl.caching() {
+		mdl.run.cache = make(map[*Equation]*cacheEntry)
+	}
+	if mdl.Repro {
+		mdl.run.comp = make(State)
+	}
+	mdl.Policy.reset()
+	if mdl.run.bounds, res = mdl.bounds(mdl.Eqns); !res.Ok {
+		mdl.run = nil
+	} else if res = mdl.checkAnomalies("L"); !res.Ok {
+		// initial values of levels are anomalous
+		mdl.run = nil
+	} else if res = mdl.checkBounds(); !res.Ok {
+		// initial values violate bounds
+		mdl.run = nil
+	}
+	return
+}
 
-	epoch := 1
-	for t := time; t <= mdl.Current["LENGTH"]; epoch, t = epoch+1, t+dt {
-		// compute auxiliaries, rates and supplements
-		if res = compute("ARS", runEqns); !res.Ok {
+// Done returns true if the model run has completed (or is not started).
+// A run never exceeds the maximum length (MAXLEN) if it is defined.
+func (mdl *Model) Done() bool {
+	if mdl.run == nil {
+		return true
+	}
+	if maxLen, ok := mdl.Current["MAXLEN"]; ok && mdl.run.t > maxLen {
+		return true
+	}
+	return mdl.run.t > mdl.Current["LENGTH"]
+}
+
+// Step computes the next n epochs of a started model run (or less if the
+// run completes before).
+func (mdl *Model) Step(n int) (res *Result) {
+	if mdl.Done() {
+		return Failure(ErrModelNotRunning)
+	}
+	res = Success()
+	for i := 0; i < n && !mdl.Done(); i++ {
+		if res = mdl.step(); !res.Ok {
 			break
 		}
-		// emit current values for plot and print
-		if res = mdl.Print.Add(epoch); !res.Ok {
-			break
+	}
+	return
+}
+
+// Cancel a running model: the run stops with an error before the next
+// epoch is computed. Cancel can be called from other goroutines.
+func (mdl *Model) Cancel() {
+	atomic.StoreInt32(&mdl.cancel, 1)
+}
+
+// step computes the next epoch of a model run.
+func (mdl *Model) step() (res *Result) {
+	if atomic.LoadInt32(&mdl.cancel) != 0 {
+		return Failure(ErrModelCanceled)
+	}
+	run := mdl.run
+	// replay inputs
+	if res = mdl.replayInputs(); !res.Ok {
+		return
+	}
+	// take requested snapshots
+	if res = mdl.takeSnapshots(); !res.Ok {
+		return
+	}
+	// keep state for rollback
+	if len(run.hist.list) > 0 {
+		run.hist.push(mdl.snapshot())
+	}
+	// ask for decisions in gaming mode
+	if mdl.Game != nil {
+		if res = mdl.Game.step(mdl); !res.Ok {
+			return
 		}
-		if res = mdl.Plot.Add(epoch); !res.Ok {
-			break
+	}
+	// compute auxiliaries and rates (and supplements in output epochs)
+	modes := "AR"
+	out := mdl.output()
+	if out {
+		modes += "S"
+	}
+	if res = mdl.computeStep(modes); !res.Ok {
+		return
+	}
+	if res = mdl.checkAnomalies(modes); !res.Ok {
+		return
+	}
+	// add internal variables of functions (if recorded)
+	for auto, name := range run.intern {
+		if val, ok := mdl.auto.get(auto); ok {
+			mdl.Current[name] = val
+		}
+	}
+	// record current state
+	run.ds.Add(mdl.Current)
+	if !out {
+		run.ds.mask(run.suppl)
+	}
+	// emit current values for plot and print
+	if res = mdl.Print.Add(run.epoch); !res.Ok {
+		return
+	}
+	if res = mdl.Plot.Add(run.epoch); !res.Ok {
+		return
+	}
+	// real-time pacing
+	if mdl.Pacer != nil {
+		if res = mdl.Pacer.step(mdl, run.epoch); !res.Ok {
+			return
+		}
+	}
+	// propagate state
+	mdl.Last = mdl.Current.Clone()
+	// propagate in time
+	if run.comp != nil {
+		// compensated updates of time and levels
+		mdl.Current["TIME"] = run.add("TIME", mdl.Current["TIME"], mdl.Current["DT"])
+		if res = mdl.computeLevels(run.eqns); !res.Ok {
+			return
 		}
-		// propagate state
-		mdl.Last = mdl.Current.Clone()
-		// propagate in time
+	} else {
 		mdl.Current["TIME"] = mdl.Current["TIME"] + mdl.Current["DT"]
 
 		// compute new levels
-		if res = compute("L", runEqns); !res.Ok {
-			break
+		if res = mdl.computeStep("L"); !res.Ok {
+			return
 		}
 	}
-	Msgf("         %d epochs computed.", epoch-1)
+	if res = mdl.checkAnomalies("L"); !res.Ok {
+		return
+	}
+	if res = mdl.checkBounds(); !res.Ok {
+		return
+	}
+	mdl.verifyConserved()
+	run.epoch++
+	run.t += run.dt
 	return
 }
+
+// Finish a model run: the recorded results are stored in the model.
+func (mdl *Model) Finish() {
+	if mdl.run == nil {
+		return
+	}
+	Logf(LOG_INFO, LOG_RUN, "         %d epochs computed.", mdl.run.epoch-1)
+	if mdl.run.hits > 0 {
+		Logf(LOG_VERBOSE, LOG_RUN, "         %d evaluations of unchanged auxiliaries skipped.", mdl.run.hits)
+	}
+	mdl.reportBounds()
+	mdl.reportAnomalies()
+	mdl.Results[mdl.RunID] = mdl.run.ds
+	mdl.run = nil
+}
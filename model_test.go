@@ -21,8 +21,24 @@ package dynamo
 //----------------------------------------------------------------------
 
 import (
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 // testData is a data structure for a test case
@@ -31,9 +47,17 @@ type testData struct {
 	src    []string // DYNAMO source code
 	lineno int      // lines processed
 	err    string   // parse error
+	reject bool     // source must be rejected (with parse error)
 	run    bool     // run model?
 }
 
+// quiet discards log messages until the end of a test.
+func quiet(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(out) })
+}
+
 // testSet is a collection of all test cases
 var testSet = []*testData{
 	//------------------------------------------------------------------
@@ -158,6 +182,149 @@ var testSet = []*testData{
 		lineno: 1,
 		err:    ErrModelEqnBadTargetKind,
 	},
+	//------------------------------------------------------------------
+	// Invalid type
+	{
+		name: "eqn: invalid type",
+		src: []string{
+			"A Y.K=X.K;TYPE=STRING",
+		},
+		lineno: 1,
+		err:    ErrParseSyntax,
+		reject: true,
+	},
+	//------------------------------------------------------------------
+	// Chained comparison
+	{
+		name: "eqn: chained comparison",
+		src: []string{
+			"A Y.K=X.K<1<2",
+		},
+		lineno: 1,
+		err:    ErrParseSyntax,
+		reject: true,
+	},
+	//------------------------------------------------------------------
+	// INTEG in initial value
+	{
+		name: "fcn: INTEG in N equation",
+		src: []string{
+			"N X=INTEG(1,0)",
+		},
+		lineno: 1,
+		err:    ErrModelFunction,
+		reject: true,
+	},
+	//------------------------------------------------------------------
+	// INTEG without initial value
+	{
+		name: "fcn: INTEG arguments",
+		src: []string{
+			"A Y.K=INTEG(1)",
+		},
+		lineno: 1,
+		err:    ErrParseInvalidNumArgs,
+		reject: true,
+	},
+	//------------------------------------------------------------------
+	// Constant input of TRND
+	{
+		name: "fcn: TRND input",
+		src: []string{
+			"A Y.K=TRND(2,4)",
+		},
+		lineno: 1,
+		err:    ErrParseInvalidName,
+		reject: true,
+	},
+	//------------------------------------------------------------------
+	// Missing argument of PICTRL
+	{
+		name: "fcn: PICTRL arguments",
+		src: []string{
+			"R ORD.KL=PICTRL(INV.K,GOAL,0.5)",
+		},
+		lineno: 1,
+		err:    ErrParseInvalidNumArgs,
+		reject: true,
+	},
+	//------------------------------------------------------------------
+	// Delay input from old state
+	{
+		name: "fcn: DELAY1 input",
+		src: []string{
+			"R OUT.KL=DELAY1(IN.J,2)",
+		},
+		lineno: 1,
+		err:    ErrModelFunction,
+		reject: true,
+	},
+	//------------------------------------------------------------------
+	// Rate in new state as delay input
+	{
+		name: "fcn: DELAY3 input",
+		src: []string{
+			"R OUT.KL=DELAY3(IN.KL,2)",
+		},
+		lineno: 1,
+		err:    ErrModelFunction,
+		reject: true,
+	},
+	//------------------------------------------------------------------
+	// Rate as input of DLINF1
+	{
+		name: "fcn: DLINF1 input",
+		src: []string{
+			"A OUT.K=DLINF1(IN.JK,2)",
+		},
+		lineno: 1,
+		err:    ErrModelFunction,
+		reject: true,
+	},
+	//------------------------------------------------------------------
+	// Level equation for TIME
+	{
+		name: "sys: TIME level",
+		src: []string{
+			"L TIME.K=TIME.J+DT",
+		},
+		lineno: 1,
+		err:    ErrModelSystemVar,
+		reject: true,
+	},
+	//------------------------------------------------------------------
+	// Auxiliary equation for TIME
+	{
+		name: "sys: TIME aux",
+		src: []string{
+			"A TIME.K=2",
+		},
+		lineno: 1,
+		err:    ErrModelSystemVar,
+		reject: true,
+	},
+	//------------------------------------------------------------------
+	// Auxiliary equation for DT
+	{
+		name: "sys: DT aux",
+		src: []string{
+			"A DT.K=0.1",
+		},
+		lineno: 1,
+		err:    ErrModelSystemVar,
+		reject: true,
+	},
+	//------------------------------------------------------------------
+	// Level equation for LENGTH
+	{
+		name: "sys: LENGTH level",
+		src: []string{
+			"L LENGTH.K=LENGTH.J",
+		},
+		lineno: 1,
+		err:    ErrModelSystemVar,
+		reject: true,
+	},
 }
 
 func TestModel(t *testing.T) {
@@ -169,16 +336,20 @@ func TestModel(t *testing.T) {
 			buf.WriteString(line + "\n")
 		}
 		res := mdl.Parse(bytes.NewReader(buf.Bytes()))
-		if !res.Ok && !res.IsA(td.err) {
+		if !res.Ok && !errors.Is(res, ErrorKind(td.err)) {
 			t.Logf("[%s] Error mismtach: %s != %s\n", td.name, res.Err.Error(), td.err)
 			failed++
 		}
+		if res.Ok && td.reject {
+			t.Logf("[%s] Invalid source accepted\n", td.name)
+			failed++
+		}
 		if res.Line != td.lineno {
 			t.Logf("[%s] Line mismtach: %d != %d\n", td.name, res.Line, td.lineno)
 			failed++
 		}
 		if res.Ok && td.run {
-			if res = mdl.Run(); !res.IsA(td.err) {
+			if res = mdl.Run(); !errors.Is(res, ErrorKind(td.err)) {
 				t.Logf("[%s] Status mismtach: %s != %s\n", td.name, res.Err.Error(), td.err)
 				failed++
 			}
@@ -188,3 +359,3212 @@ func TestModel(t *testing.T) {
 		t.Fatalf("%d test cases failed", failed)
 	}
 }
+
+func TestVerifyAgainst(t *testing.T) {
+	src := []string{
+		"L LVL.K=LVL.J+DT*IN.JK",
+		"N LVL=10",
+		"R IN.KL=RATE",
+		"C RATE=2",
+		"SPEC DT=0.5,LENGTH=2,PRTPER=1,PLTPER=0",
+		"RUN TEST",
+	}
+	mdl := NewModel("", "")
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	// matching reference data
+	ref := "TIME;LVL;IN\n0;10;2\n1;12;2\n2;14;2\n"
+	list, res := mdl.VerifyAgainst(bytes.NewBufferString(ref), 1e-6)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("unexpected mismatches: %v", list)
+	}
+	// mismatching reference data
+	ref = "TIME;LVL;IN\n0;10;2\n1;12.5;2\n2;14;2\n"
+	if list, res = mdl.VerifyAgainst(bytes.NewBufferString(ref), 1e-6); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if len(list) != 1 || list[0].Name != "LVL" || list[0].Time != 1 {
+		t.Fatalf("wrong mismatches: %v", list)
+	}
+}
+
+func TestCompareFile(t *testing.T) {
+	src := []string{
+		"L LVL.K=LVL.J+DT*IN.JK",
+		"N LVL=1000",
+		"R IN.KL=RATE",
+		"C RATE=2",
+		"SPEC DT=0.5,LENGTH=4,PRTPER=1,PLTPER=0",
+		"PRINT LVL,IN",
+		"RUN TEST",
+	}
+	// write CSV print (with decimal comma and quoted fields)
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "test.csv")
+	mdl := NewModel(csvFile, "")
+	csv := mdl.Print.CSVFormat()
+	csv.Decimal = ","
+	csv.Quote = true
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	mdl.Quit()
+
+	// compare run (recorded every DT) with print (every PRTPER)
+	prtFile := filepath.Join(dir, "test.prt")
+	mdl = NewModel(prtFile, "")
+	src = append(src, "COMPARE TEST,@"+csvFile)
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	mdl.Quit()
+	data, err := os.ReadFile(prtFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := string(data)
+	if !strings.Contains(report, "COMPARE TEST,@") || !strings.Contains(report, "  LVL ") {
+		t.Fatalf("missing comparison:\n%s", report)
+	}
+	for _, line := range strings.Split(report, "\n") {
+		if f := strings.Fields(line); len(f) == 4 && (f[0] == "LVL" || f[0] == "IN") {
+			for _, v := range f[1:] {
+				if x, _ := strconv.ParseFloat(v, 64); math.Abs(x) > 1e-6 {
+					t.Fatalf("unexpected difference: %s", line)
+				}
+			}
+		}
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	mdl := NewModel("", "")
+	check := func(res *Result) {
+		if !res.Ok {
+			t.Fatal(res.Err)
+		}
+	}
+	check(mdl.AddLevel("INV", "INV.J+DT*(PROD.JK-SHIP.JK)", "100"))
+	check(mdl.AddRate("PROD", "TABLE(TPROD,INV.K,0,200,100)"))
+	check(mdl.AddRate("SHIP", "DEMAND"))
+	check(mdl.AddTable("TPROD", []float64{20, 10, 0}))
+	check(mdl.AddConstant("DEMAND", 10))
+	check(mdl.AddConstant("LENGTH", 5))
+	check(mdl.AddConstant("DT", 1))
+	check(mdl.Run())
+	if val := mdl.Current["INV"]; val.Compare(100) != 0 {
+		t.Fatalf("Value mismatch: %f != 100", val)
+	}
+	// syntax errors are rejected
+	if res := mdl.AddRate("X", "INV.K*/2"); res.Ok {
+		t.Fatal("invalid rate accepted")
+	}
+	// exponentiation in relaxed mode
+	mdl.SetRelaxed(true)
+	check(mdl.AddRate("X", "INV.K**2"))
+}
+
+func TestConcurrentModels(t *testing.T) {
+	src := []string{
+		"L LVL.K=LVL.J+DT*OUT.JK",
+		"N LVL=10",
+		"R OUT.KL=DELAY3(IN.JK,3)",
+		"R IN.KL=SMOOTH(LVL.K,2)",
+		"SPEC DT=0.5,LENGTH=20,PRTPER=1,PLTPER=0",
+		"RUN TEST",
+	}
+	num := 8
+	done := make(chan *Result, num)
+	for i := 0; i < num; i++ {
+		go func() {
+			mdl := NewModel("", "")
+			buf := new(bytes.Buffer)
+			for _, line := range src {
+				buf.WriteString(line + "\n")
+			}
+			res := mdl.Parse(buf)
+			// automatic variables are numbered per model
+			if _, ok := mdl.auto.get("_1"); res.Ok && !ok {
+				res = Failure(ErrModelNoVariable + ": _1")
+			}
+			done <- res
+		}()
+	}
+	for i := 0; i < num; i++ {
+		if res := <-done; !res.Ok {
+			t.Fatal(res.Err)
+		}
+	}
+}
+
+func TestDebugger(t *testing.T) {
+	src := "L LVL.K=LVL.J+DT*IN.JK\nN LVL=10\nR IN.KL=2\nSPEC DT=1,LENGTH=2,PRTPER=0,PLTPER=0\nRUN TEST\n"
+	for _, tc := range []struct {
+		level       int
+		model, eval bool
+	}{
+		{DBG_OFF, false, false},
+		{DBG_MODEL, true, false},
+		{DBG_TRACE, true, true},
+	} {
+		out := new(bytes.Buffer)
+		mdl := NewModel("", "")
+		mdl.Dbg = NewDebugger(out, tc.level)
+		if res := mdl.Parse(strings.NewReader(src)); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if strings.Contains(out.String(), "AddStmt:") != tc.model ||
+			strings.Contains(out.String(), "Evaluating:") != tc.eval {
+			t.Fatalf("level %d: wrong debug output", tc.level)
+		}
+	}
+}
+
+func TestLogFilter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+		SetLogLevel(LOG_INFO)
+		SetLogCategories()
+	}()
+	SetLogLevel(LOG_VERBOSE)
+	if res := SetLogCategories("parse", "Run"); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := SetLogCategories("nosuch"); res.Ok {
+		t.Fatal("unknown category accepted")
+	}
+	Log(LOG_VERBOSE, LOG_PARSE, "verbose parse")
+	Log(LOG_VERBOSE, LOG_RUN, "verbose run")
+	Log(LOG_VERBOSE, LOG_MODEL, "verbose model")
+	Log(LOG_INFO, LOG_MODEL, "info model")
+	Log(LOG_WARN, LOG_OUTPUT, "warn output")
+	SetLogLevel(LOG_WARN)
+	Log(LOG_INFO, LOG_RUN, "info run")
+	want := "verbose parse\nverbose run\ninfo model\nWARN: warn output\n"
+	if buf.String() != want {
+		t.Fatalf("log mismatch:\n%s", buf.String())
+	}
+}
+
+func TestResultErrors(t *testing.T) {
+	res := Failure(ErrModelNoSuchTable+": %s", "TAB")
+	if !errors.Is(res, ErrorKind(ErrModelNoSuchTable)) {
+		t.Fatal("errors.Is failed on result")
+	}
+	if errors.Is(res, ErrorKind(ErrModelNoVariable)) {
+		t.Fatal("errors.Is matched wrong kind")
+	}
+	// kinds are fixed sentinels with stable codes
+	if ErrorKind(ErrModelNoSuchTable) != ErrorKind(ErrModelNoSuchTable) {
+		t.Fatal("error kind is not a sentinel")
+	}
+	if res.Code() != 112 || Success().Code() != 0 {
+		t.Fatalf("wrong error code %d", res.Code())
+	}
+	var kind *Kind
+	if !errors.As(ErrorKind(ErrParseSyntax), &kind) || kind.Code != 206 {
+		t.Fatal("wrong kind for syntax errors")
+	}
+	_, err := os.Open("/nonexistent/file")
+	res = Failure(ErrModelNoData+": %w", err)
+	if !errors.Is(res, os.ErrNotExist) {
+		t.Fatal("wrapped error not found")
+	}
+	var pe *os.PathError
+	if !errors.As(res, &pe) {
+		t.Fatal("errors.As failed on result")
+	}
+}
+
+func TestStrictModel(t *testing.T) {
+	src := "L INVENTARLISTE.K=INVENTARLISTE.J+DT*CHNG.JK\n"
+	relaxed := NewModel("", "")
+	if res := relaxed.Parse(bytes.NewBufferString(src)); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	strict := NewModel("", "")
+	strict.SetStrict(true)
+	if res := strict.Parse(bytes.NewBufferString(src)); !errors.Is(res, ErrorKind(ErrParseNameLength)) {
+		t.Fatal("long name accepted in strict mode")
+	}
+}
+
+func TestRelaxedModel(t *testing.T) {
+	src := []string{
+		"# relaxed syntax",
+		"L inventory.K = inventory.J + DT * (prod.JK - ship.JK)  # stock",
+		"N inventory = 100",
+		"R prod.KL = 12",
+		"R ship.KL = 10",
+		"C p1 = 2*3**2   # exponentiation",
+		"C p2 = -2**2",
+		"C p3 = 2**3**2",
+		"C p4 = (1+1)**3/2",
+		"SPEC DT=1, LENGTH=5, PRTPER=1, PLTPER=0",
+		"RUN base",
+	}
+	mdl := NewModel("", "")
+	mdl.SetRelaxed(true)
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if val := mdl.Current["INVENTORY"]; val.Compare(112) != 0 {
+		t.Fatalf("Value mismatch: %f != 112", val)
+	}
+	for name, v := range map[string]Variable{"P1": 18, "P2": -4, "P3": 64, "P4": 4} {
+		if val := mdl.Current[name]; val.Compare(v) != 0 {
+			t.Fatalf("Value mismatch for %s: %f != %f", name, val, v)
+		}
+	}
+}
+
+func TestSourceEncoding(t *testing.T) {
+	// BOM and Latin-1 encoded comment (legacy source)
+	src := "\xEF\xBB\xBF* ENCODING\nC X=3 Gr\xf6\xdfe\nN Y=2*X\n"
+	mdl := NewModel("", "")
+	if res := mdl.Parse(bytes.NewBufferString(src)); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if mdl.Title != "ENCODING" {
+		t.Fatalf("Title mismatch: '%s'", mdl.Title)
+	}
+	// forced UTF-8 must reject Latin-1 input
+	mdl = NewModel("", "")
+	mdl.Encoding = ENC_UTF8
+	if res := mdl.Parse(bytes.NewBufferString(src)); res.Ok {
+		t.Fatal("invalid UTF-8 accepted")
+	}
+	// non-breaking spaces separate equations and comments
+	ebc := make(map[rune]byte)
+	for b, r := range cp037 {
+		ebc[r] = byte(b)
+	}
+	toEBCDIC := func(s string) []byte {
+		var out []byte
+		for _, r := range s {
+			out = append(out, ebc[r])
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		enc string
+		src []byte
+	}{
+		{ENC_UTF8, []byte("* ENCODING\nC X=3\u00a0Größe\nN Y=2*X\n")},
+		{ENC_LATIN1, []byte("* ENCODING\nC X=3\xa0Gr\xf6\xdfe\nN Y=2*X\n")},
+		{ENC_AUTO, []byte("* ENCODING\nC X=3\xa0Gr\xf6\xdfe\nN Y=2*X\n")},
+		{ENC_EBCDIC, append(toEBCDIC("* ENCODING\nC X=3 Größe"), append([]byte{0x15}, toEBCDIC("N Y=2*X\n")...)...)},
+	} {
+		mdl = NewModel("", "")
+		mdl.Encoding = tc.enc
+		if res := mdl.Parse(bytes.NewBuffer(tc.src)); !res.Ok {
+			t.Fatalf("%s: %s", tc.enc, res.Err)
+		}
+		eqn := mdl.Eqns.Find("X")
+		if mdl.Title != "ENCODING" || eqn == nil || eqn.Statement() != "X=3" || mdl.Eqns.Find("Y") == nil {
+			t.Fatalf("%s: source not decoded", tc.enc)
+		}
+	}
+}
+
+func TestWhitespace(t *testing.T) {
+	// tabs, non-breaking spaces and carriage returns (Windows editors)
+	src := "* WHITESPACE\r\n" +
+		"L\tLEV.K=LEV.J+DT*IN.JK\t\tLEVEL\r\n" +
+		"N LEV=10\r\r\n" +
+		"R IN.KL=2*\r\n" +
+		"X\tFAC\tINFLOW\r\n" +
+		"C FAC=1.5\t\r\n" +
+		"SPEC\tDT=1,LENGTH=4,PRTPER=0,PLTPER=0\r\n" +
+		"RUN\tBASE\r\n"
+	mdl := NewModel("", "")
+	if res := mdl.Parse(strings.NewReader(src)); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if mdl.Title != "WHITESPACE" {
+		t.Fatalf("title: '%s'", mdl.Title)
+	}
+	eqn := mdl.DefinitionOf("IN")
+	if eqn == nil || eqn.Statement() != "IN.KL=2*FAC" || eqn.Comment != "INFLOW" {
+		t.Fatalf("equation: %v", eqn)
+	}
+	ds, ok := mdl.Results["BASE"]
+	if !ok {
+		t.Fatal("no run")
+	}
+	if vals := ds.Vars["LEV"]; vals[len(vals)-1] != 22 {
+		t.Fatalf("LEV=%v", vals)
+	}
+	// tabs in relaxed equations
+	mdl = NewModel("", "")
+	mdl.SetRelaxed(true)
+	if res := mdl.Parse(strings.NewReader("C X=1\t+\t2\t# sum\r\n")); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if eqn := mdl.DefinitionOf("X"); eqn == nil || eqn.Statement() != "X=1+2" || eqn.Comment != "sum" {
+		t.Fatalf("relaxed equation: %v", eqn)
+	}
+}
+
+func TestGlossary(t *testing.T) {
+	src := []string{
+		"* GLOSSARY",
+		"NOTE @sector Inventory",
+		"NOTE @units WIDGETS",
+		"L STOCK.K=STOCK.J+DT*(IN.JK-",
+		"X OUT.JK)                       STOCK LEVEL",
+		"N STOCK=100",
+		"R IN.KL=5                       INFLOW",
+		"NOTE @sector Flows",
+		"R OUT.KL=STOCK.K/DUR            OUTFLOW",
+		"X                               (UNITS/YEAR)",
+		"C DUR=10                        DURATION",
+	}
+	mdl := NewModel("", "")
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	list := mdl.Glossary()
+	if len(list) != 4 {
+		t.Fatalf("Glossary size mismatch: %d", len(list))
+	}
+	for _, entry := range list {
+		switch entry.Name {
+		case "OUT":
+			if entry.Comment != "OUTFLOW (UNITS/YEAR)" || entry.Sector != "Flows" {
+				t.Fatalf("Entry mismatch: %v", entry)
+			}
+		case "STOCK":
+			if entry.Type != "LEVEL" || len(entry.Eqns) != 2 {
+				t.Fatalf("Entry mismatch: %v", entry)
+			}
+			if entry.Units != "WIDGETS" || entry.Sector != "Inventory" {
+				t.Fatalf("Metadata mismatch: %v", entry)
+			}
+		case "IN":
+			if entry.Units != "" || entry.Sector != "Inventory" {
+				t.Fatalf("Metadata mismatch: %v", entry)
+			}
+		}
+	}
+}
+
+func TestDocumentor(t *testing.T) {
+	src := []string{
+		"* DOCUMENTOR",
+		"L STOCK.K=STOCK.J+DT*(IN.JK-OUT.JK)  STOCK",
+		"N STOCK=100",
+		"R IN.KL=TABLE(TIN,TIME.K,0,10,5)    INFLOW",
+		"T TIN=1/2/3",
+		"R OUT.KL=STOCK.K/DUR                 OUTFLOW",
+		"C DUR=10                             DURATION",
+	}
+	mdl := NewModel("", "")
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	list := mdl.Documentor()
+	if len(list) != 5 {
+		t.Fatalf("listing size mismatch: %d", len(list))
+	}
+	num := make(map[string]int)
+	for _, e := range list {
+		num[e.Stmt] = e.Number
+	}
+	lvl, out := list[num["STOCK.K=STOCK.J+DT*(IN.JK-OUT.JK)"]-1], list[num["OUT.KL=STOCK.K/DUR"]-1]
+	if lvl.Type != "LEVEL" || len(lvl.UsedIn) != 2 {
+		t.Fatalf("level entry mismatch: %v", lvl)
+	}
+	for _, ref := range out.Refs {
+		switch ref.Name {
+		case "DUR":
+			if ref.Type != "CONST" || ref.Comment != "DURATION" || len(ref.Defs) != 1 {
+				t.Fatalf("reference mismatch: %v", ref)
+			}
+		case "STOCK":
+			if ref.Type != "LEVEL" || len(ref.Defs) != 2 {
+				t.Fatalf("reference mismatch: %v", ref)
+			}
+		default:
+			t.Fatalf("unexpected reference: %v", ref)
+		}
+	}
+	buf := new(bytes.Buffer)
+	mdl.WriteDocumentor(buf)
+	if !strings.Contains(buf.String(), "TIN       TABLE   DEFINED IN -") {
+		t.Fatalf("listing:\n%s", buf.String())
+	}
+	buf.Reset()
+	mdl.WriteDocumentorHTML(buf)
+	if !strings.Contains(buf.String(), fmt.Sprintf("<a href=\"#eq%d\">", num["DUR=10"])) {
+		t.Fatalf("HTML listing:\n%s", buf.String())
+	}
+}
+
+func TestWhereUsed(t *testing.T) {
+	src := []string{
+		"L STOCK.K=STOCK.J+DT*(IN.JK-OUT.JK)",
+		"N STOCK=100",
+		"R IN.KL=5",
+		"R OUT.KL=STOCK.K/DUR",
+		"A HALF.K=STOCK.K/2",
+		"C DUR=10",
+	}
+	mdl := NewModel("", "")
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if eqn := mdl.DefinitionOf("STOCK"); eqn == nil || eqn.Mode != "L" {
+		t.Fatalf("definition of STOCK: %v", eqn)
+	}
+	if eqn := mdl.DefinitionOf("DUR"); eqn == nil || eqn.Mode != "C" {
+		t.Fatalf("definition of DUR: %v", eqn)
+	}
+	if eqn := mdl.DefinitionOf("FOO"); eqn != nil {
+		t.Fatalf("definition of FOO: %v", eqn)
+	}
+	users := make(map[string]bool)
+	for _, eqn := range mdl.UsesOf("STOCK") {
+		users[eqn.Mode+" "+eqn.Target.Name] = true
+	}
+	if len(users) != 3 || !users["A HALF"] || !users["L STOCK"] || !users["R OUT"] {
+		t.Fatalf("uses of STOCK: %v", users)
+	}
+	// the index is updated if equations are added
+	list, res := NewEquation(&Line{Mode: "A", Stmt: "TWICE.K=HALF.K*4"}, mdl)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	mdl.Eqns.AddList(list)
+	eqn := list.List()[0]
+	if list := mdl.UsesOf("HALF"); len(list) != 1 || list[0] != eqn {
+		t.Fatalf("uses of HALF: %v", list)
+	}
+	if mdl.DefinitionOf("TWICE") != eqn {
+		t.Fatal("definition of TWICE not indexed")
+	}
+}
+
+func TestSectors(t *testing.T) {
+	src := []string{
+		"* SECTORS",
+		"SECTOR PROD",
+		"L INV.K=INV.J+DT*(PR.JK-SALES.SHIP.JK)",
+		"N INV=100",
+		"R PR.KL=DELAY1(SALES.SHIP.JK,DEL)",
+		"C DEL=2",
+		"ENDSECTOR",
+		"SECTOR SALES",
+		"R SHIP.KL=TABLE(TSHIP,TIME.K,0,10,5)",
+		"T TSHIP=10/20/30",
+		"ENDSECTOR",
+		"SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+	}
+	mdl := NewModel("", "")
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	for _, name := range []string{"PROD.INV", "PROD.PR", "SALES.SHIP"} {
+		if _, ok := mdl.Current[name]; !ok {
+			t.Fatalf("Missing variable %s", name)
+		}
+	}
+	graph := new(bytes.Buffer)
+	mdl.SectorGraph(graph)
+	if !strings.Contains(graph.String(), "\"SALES\" -> \"PROD\"") {
+		t.Fatalf("Missing sector dependency:\n%s", graph.String())
+	}
+	// unterminated sector
+	mdl = NewModel("", "")
+	if res := mdl.Parse(bytes.NewBufferString("SECTOR PROD\nC DEL=2\n")); !errors.Is(res, ErrorKind(ErrParseSector)) {
+		t.Fatal("missing ENDSECTOR not detected")
+	}
+	// qualified names: known sector vs. invalid index
+	for _, tc := range []struct {
+		src string
+		err string
+	}{
+		{"SECTOR PROD\nC DEL=2\nENDSECTOR\nC PROD.X=1\n", ErrParseSector},
+		{"C INV.KK=1\n", ErrParseInvalidIndex},
+		{"C X=INV.KK\n", ErrParseInvalidIndex},
+	} {
+		mdl = NewModel("", "")
+		if res := mdl.Parse(bytes.NewBufferString(tc.src)); !errors.Is(res, ErrorKind(tc.err)) {
+			t.Fatalf("%q: expected '%s', got %v", tc.src, tc.err, res.Err)
+		}
+	}
+}
+
+func TestInstance(t *testing.T) {
+	// submodel: stock of a market driven by an external demand
+	dir := t.TempDir()
+	sub := []string{
+		"* MARKET",
+		"L STOCK.K=STOCK.J+DT*(SUPPLY.JK-DEMAND.JK)",
+		"N STOCK=INIT",
+		"C INIT=50",
+		"R SUPPLY.KL=STOCK.K/ADJ",
+		"C ADJ=5",
+		"A PRICE.K=TABLE(TPRICE,STOCK.K,0,100,50)",
+		"T TPRICE=@" + filepath.Join(dir, "price.csv"),
+		"DATA COST=@" + filepath.Join(dir, "cost.csv"),
+		"A MARGIN.K=PRICE.K-COST.K",
+		"SPEC DT=0.5,LENGTH=5,PRTPER=0,PLTPER=0",
+	}
+	files := map[string]string{
+		"price.csv":     "X,Y\n0,1\n50,2\n100,3\n",
+		"cost.csv":      "TIME,COST\n0,1\n10,2\n",
+		"market.dynamo": strings.Join(sub, "\n"),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fname := filepath.Join(dir, "market.dynamo")
+	src := []string{
+		"* TWO MARKETS",
+		"INSTANCE MKT1(DEMAND=DEM1)=@" + fname,
+		"INSTANCE MKT2(DEMAND=MKT1.SUPPLY)=@" + fname,
+		"R DEM1.KL=10",
+		"SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+	}
+	mdl := NewModel("", "")
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatalf("line %d: %s", res.Line, res.Err)
+	}
+	for _, name := range []string{"MKT1.STOCK", "MKT2.STOCK", "MKT1.SUPPLY", "MKT2.SUPPLY"} {
+		if _, ok := mdl.Current[name]; !ok {
+			t.Fatalf("Missing variable %s", name)
+		}
+	}
+	if val := mdl.Current["DT"]; val.Compare(1) != 0 {
+		t.Fatalf("DT mismatch: %f", val)
+	}
+	// instantiated tables and data series
+	for _, name := range []string{"MKT1.TPRICE", "MKT2.TPRICE"} {
+		if tbl, ok := mdl.Tables[name]; !ok || len(tbl.X) != 3 {
+			t.Fatalf("Table %s without x-values", name)
+		}
+	}
+	for _, name := range []string{"MKT1.COST", "MKT2.COST"} {
+		if _, ok := mdl.Series[name]; !ok {
+			t.Fatalf("Missing data series %s", name)
+		}
+	}
+	// wiring: MKT1 is driven by DEM1, MKT2 by the supply of MKT1
+	for name, input := range map[string]string{"MKT1.STOCK": "DEM1", "MKT2.STOCK": "MKT1.SUPPLY"} {
+		found := false
+		for _, eqn := range mdl.equations().List() {
+			if eqn.Target.Name != name || eqn.Mode != "L" {
+				continue
+			}
+			for _, ref := range append(eqn.Dependencies, eqn.References...) {
+				found = found || ref.Name == input
+			}
+		}
+		if !found {
+			t.Fatalf("%s not wired to %s", name, input)
+		}
+	}
+	// instantiated values: both markets are in equilibrium (STOCK=50);
+	// at TIME=10 the price (from table) and the cost (from series) are 2.
+	for _, pfx := range []string{"MKT1.", "MKT2."} {
+		for name, exp := range map[string]Variable{"STOCK": 50, "PRICE": 2, "COST": 2, "MARGIN": 0} {
+			if val := mdl.Current[pfx+name]; val.Compare(exp) != 0 {
+				t.Fatalf("%s%s mismatch: %f != %f", pfx, name, val, exp)
+			}
+		}
+	}
+	// wires to unknown inputs are rejected
+	mdl = NewModel("", "")
+	if res := mdl.Parse(bytes.NewBufferString("INSTANCE MKT(DEMND=DEM1)=@" + fname + "\n")); !errors.Is(res, ErrorKind(ErrParseSector)) {
+		t.Fatalf("Unknown wire target accepted: %v", res.Err)
+	}
+}
+
+func TestGame(t *testing.T) {
+	src := []string{
+		"L STOCK.K=STOCK.J+DT*IN.JK",
+		"N STOCK=0",
+		"R IN.KL=RATE",
+		"C RATE=1",
+		"GAME 5/RATE/STOCK",
+		"SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+	}
+	mdl := NewModel("", "")
+	// console decision with scripted input: double the rate at TIME 5,
+	// keep it at TIME 10.
+	out := new(bytes.Buffer)
+	mdl.Decision = ConsoleDecision(bytes.NewBufferString("2\n\n"), out)
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	// STOCK = 5*1 + 6*2
+	if val := mdl.Current["STOCK"]; val.Compare(17) != 0 {
+		t.Fatalf("Value mismatch: %f != 17\n%s", val, out.String())
+	}
+	if !strings.Contains(out.String(), "STOCK") {
+		t.Fatalf("State not shown:\n%s", out.String())
+	}
+}
+
+func TestPacer(t *testing.T) {
+	src := []string{
+		"L STOCK.K=STOCK.J+DT*IN.JK",
+		"N STOCK=0",
+		"R IN.KL=1",
+		"SPEC DT=1,LENGTH=9,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+	}
+	mdl := NewModel("", "")
+	out := new(bytes.Buffer)
+	steps := 0
+	mdl.Pacer = NewPacer(2 * time.Millisecond)
+	mdl.Pacer.Vars = []string{"STOCK"}
+	mdl.Pacer.Out = out
+	mdl.Pacer.Callback = func(epoch int, state State) *Result {
+		steps++
+		return Success()
+	}
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	start := time.Now()
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("Run not paced: %v", elapsed)
+	}
+	if steps != 10 {
+		t.Fatalf("Step mismatch: %d != 10", steps)
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != 11 {
+		t.Fatalf("Streamed lines mismatch: %d != 11", lines)
+	}
+}
+
+func TestCoSimulation(t *testing.T) {
+	src := []string{
+		"INPUT DEMAND=1",
+		"OUTPUT STOCK",
+		"L STOCK.K=STOCK.J+DT*(PROD.JK-SHIP.JK)",
+		"N STOCK=10",
+		"R PROD.KL=2",
+		"R SHIP.KL=DEMAND",
+		"SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0",
+	}
+	mdl := NewModel("", "")
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.SetInput("DEMAND", 3); !errors.Is(res, ErrorKind(ErrModelNotRunning)) {
+		t.Fatal("input set in stopped model")
+	}
+	if res := mdl.Start(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	for i := 0; !mdl.Done(); i++ {
+		// increase demand after 5 steps
+		if i == 5 {
+			if res := mdl.SetInput("DEMAND", 3); !res.Ok {
+				t.Fatal(res.Err)
+			}
+		}
+		if res := mdl.Step(1); !res.Ok {
+			t.Fatal(res.Err)
+		}
+	}
+	// STOCK = 10 + 5*(2-1) + 5*(2-3) at TIME=10
+	val, res := mdl.ReadOutput("STOCK")
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if val.Compare(10) != 0 {
+		t.Fatalf("Value mismatch: %f != 10", val)
+	}
+	if _, res = mdl.ReadOutput("PROD"); !errors.Is(res, ErrorKind(ErrModelNoPort)) {
+		t.Fatal("undeclared output read")
+	}
+	mdl.Finish()
+}
+
+func TestExportFMU(t *testing.T) {
+	src := []string{
+		"* FMU",
+		"INPUT DEMAND=1",
+		"OUTPUT STOCK",
+		"L STOCK.K=STOCK.J+DT*(CAP-SHIP.JK)",
+		"N STOCK=10",
+		"C CAP=2",
+		"R SHIP.KL=DEMAND",
+		"SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0",
+	}
+	mdl := NewModel("", "")
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	fmu := new(bytes.Buffer)
+	if res := mdl.ExportFMU(fmu, "test"); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(fmu.Bytes()), int64(fmu.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	for _, name := range []string{"modelDescription.xml", "resources/model.dynamo", "sources/fmu.go"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("Missing file '%s' in FMU", name)
+		}
+	}
+	// model source must be parsable
+	rdr, err := files["resources/model.dynamo"].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+	sub := NewModel("", "")
+	sub.SetRelaxed(true)
+	if res := sub.Parse(rdr); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if len(sub.Inputs) != 1 || len(sub.Outputs) != 1 {
+		t.Fatalf("Port mismatch: %v, %v", sub.Inputs, sub.Outputs)
+	}
+	// GUID format
+	desc := new(bytes.Buffer)
+	if rdr, err = files["modelDescription.xml"].Open(); err != nil {
+		t.Fatal(err)
+	}
+	desc.ReadFrom(rdr)
+	rdr.Close()
+	if !regexp.MustCompile(`guid="\{[0-9a-f]{8}(-[0-9a-f]{4}){3}-[0-9a-f]{12}\}"`).Match(desc.Bytes()) {
+		t.Fatalf("Invalid GUID:\n%s", desc.String())
+	}
+	// the shim must compile as a shared library
+	if testing.Short() {
+		t.Skip("skipping shim build in short mode")
+	}
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("skipping shim build: no C compiler")
+	}
+	dir, err := os.MkdirTemp(".", "fmu-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	shim, err := files["sources/fmu.go"].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := new(bytes.Buffer)
+	code.ReadFrom(shim)
+	shim.Close()
+	if err = os.WriteFile(filepath.Join(dir, "fmu.go"), code.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("go", "build", "-buildmode=c-shared", "-o", "test.so", "fmu.go")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("shim build failed: %s\n%s", err, out)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	src := []string{
+		"L STOCK.K=STOCK.J+DT*IN.JK",
+		"N STOCK=0",
+		"R IN.KL=RATE",
+		"C RATE=1",
+		"SPEC DT=1,LENGTH=20,PRTPER=0,PLTPER=0",
+	}
+	mdl := NewModel("", "")
+	mdl.History = 5
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Start(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Step(10); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if val := mdl.Current["STOCK"]; val.Compare(10) != 0 {
+		t.Fatalf("Value mismatch: %f != 10", val)
+	}
+	if res := mdl.Rollback(6); !errors.Is(res, ErrorKind(ErrModelNoHistory)) {
+		t.Fatal("rollback beyond history")
+	}
+	if res := mdl.Rollback(3); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if val := mdl.Current["STOCK"]; val.Compare(7) != 0 {
+		t.Fatalf("Value mismatch after rollback: %f != 7", val)
+	}
+	// continue with changed rate
+	mdl.Current["RATE"] = 2
+	if res := mdl.Step(3); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if val := mdl.Current["STOCK"]; val.Compare(13) != 0 {
+		t.Fatalf("Value mismatch: %f != 13", val)
+	}
+	mdl.Finish()
+	if n := mdl.Results[mdl.RunID].Len(); n != 10 {
+		t.Fatalf("Recorded epochs mismatch: %d != 10", n)
+	}
+
+	// rollback of a recorded run with bounds and compensated updates
+	src = []string{
+		"NOTE @max 2",
+		"L STOCK.K=STOCK.J+DT*IN.JK",
+		"N STOCK=0",
+		"R IN.KL=NOISE()",
+		"SPEC DT=0.1,LENGTH=20,PRTPER=0,PLTPER=0",
+	}
+	run := func(rl *ReplayLog, rollback bool) (val Variable, count int) {
+		mdl := NewModel("", "")
+		mdl.History = 10
+		mdl.Repro = true
+		mdl.Replay = rl
+		if res := mdl.Parse(bytes.NewBufferString(strings.Join(src, "\n") + "\n")); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if res := mdl.Start(); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if res := mdl.Step(50); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if rollback {
+			count, comp := mdl.run.bounds[0].count, mdl.run.comp.Clone()
+			if res := mdl.Step(10); !res.Ok {
+				t.Fatal(res.Err)
+			}
+			if res := mdl.Rollback(10); !res.Ok {
+				t.Fatal(res.Err)
+			}
+			if mdl.run.bounds[0].count != count {
+				t.Fatalf("Bound violations not rolled back: %d != %d", mdl.run.bounds[0].count, count)
+			}
+			if mdl.run.comp["STOCK"] != comp["STOCK"] {
+				t.Fatal("Compensation not rolled back")
+			}
+		}
+		if res := mdl.Step(10); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		val, count = mdl.Current["STOCK"], mdl.run.bounds[0].count
+		mdl.Finish()
+		return
+	}
+	log := new(bytes.Buffer)
+	val, count := run(NewRecorder(log), true)
+	rl, res := NewReplay(bytes.NewReader(log.Bytes()))
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if val2, count2 := run(rl, false); val2 != val || count2 != count {
+		t.Fatalf("Replay after rollback mismatch: %f/%d != %f/%d", val2, count2, val, count)
+	}
+}
+
+func TestBranch(t *testing.T) {
+	src := []string{
+		"L STOCK.K=STOCK.J+DT*IN.JK",
+		"N STOCK=0",
+		"R IN.KL=RATE",
+		"C RATE=1",
+		"SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0",
+		"SNAPSHOT Y5=5",
+		"RUN BASE",
+		"BRANCH POLICY=Y5/RATE=3",
+	}
+	mdl := NewModel("", "")
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	base, branch := mdl.Results["BASE"], mdl.Results["POLICY"]
+	if base == nil || branch == nil || base.Len() != branch.Len() {
+		t.Fatal("Missing or incomplete results")
+	}
+	// identical up to branch point, diverging afterwards
+	last := base.Len() - 1
+	if base.Vars["STOCK"][5] != branch.Vars["STOCK"][5] {
+		t.Fatal("Results differ before branch point")
+	}
+	if val := branch.Vars["STOCK"][last]; compare(val, 20) != 0 {
+		t.Fatalf("Value mismatch: %f != 20", val)
+	}
+	if val := base.Vars["STOCK"][last]; compare(val, 10) != 0 {
+		t.Fatalf("Value mismatch: %f != 10", val)
+	}
+	if res := mdl.Branch("Y1", "X", nil); !errors.Is(res, ErrorKind(ErrModelNoSnapshot)) {
+		t.Fatal("branch from unknown snapshot")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	src := []string{
+		"L STOCK.K=STOCK.J+DT*IN.JK",
+		"N STOCK=0",
+		"R IN.KL=RATE+NOISE()",
+		"C RATE=1",
+		"GAME 5/RATE/STOCK",
+		"SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+	}
+	run := func(rl *ReplayLog, decide DecisionFunc) Variable {
+		mdl := NewModel("", "")
+		mdl.Replay = rl
+		mdl.Decision = decide
+		buf := new(bytes.Buffer)
+		for _, line := range src {
+			buf.WriteString(line + "\n")
+		}
+		if res := mdl.Parse(buf); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		return mdl.Current["STOCK"]
+	}
+	// record a run
+	log := new(bytes.Buffer)
+	out := new(bytes.Buffer)
+	val := run(NewRecorder(log), ConsoleDecision(bytes.NewBufferString("2\n\n"), out))
+
+	// replay the run (without console input)
+	rl, res := NewReplay(bytes.NewReader(log.Bytes()))
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if val2 := run(rl, nil); val2 != val {
+		t.Fatalf("Replay mismatch: %f != %f\n%s", val2, val, log.String())
+	}
+}
+
+func TestSupplements(t *testing.T) {
+	src := []string{
+		"L STOCK.K=STOCK.J+DT*IN.JK",
+		"N STOCK=0",
+		"R IN.KL=1",
+		"S DBL.K=2*HALF.K",
+		"S HALF.K=STOCK.K/2",
+		"SPEC DT=1,LENGTH=5,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+	}
+	mdl := NewModel("", "")
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	// supplements are sorted and computed in the final epoch
+	if val := mdl.Current["DBL"]; val.Compare(5) != 0 {
+		t.Fatalf("Value mismatch: %f != 5", val)
+	}
+	// ... but not recorded in non-output epochs
+	list := mdl.Results["BASE"].Vars["DBL"]
+	if !math.IsNaN(list[1]) || list[len(list)-1] != 5 {
+		t.Fatalf("Wrong recording: %v", list)
+	}
+	// supplements can only be referenced by supplements
+	src[2] = "R IN.KL=HALF.K"
+	mdl = NewModel("", "")
+	if res := mdl.Parse(bytes.NewBufferString(strings.Join(src, "\n") + "\n")); !errors.Is(res, ErrorKind(ErrModelUnknownEqn)) {
+		t.Fatalf("Reference to supplement accepted: %v", res.Err)
+	}
+}
+
+func TestInitialValues(t *testing.T) {
+	src := []string{
+		"L X.K=X.J",
+		"N X=0.5*Y+1",
+		"L Y.K=Y.J",
+		"N Y=0.5*X+1",
+		"L Z.K=Z.J",
+		"N Z=AUX",
+		"A AUX.K=2*X.K",
+		"SPEC DT=1,LENGTH=1,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+	}
+	mdl := NewModel("", "")
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	for name, val := range map[string]Variable{"X": 2, "Y": 2, "Z": 4} {
+		if mdl.Current[name].Compare(val) != 0 {
+			t.Fatalf("Value mismatch %s: %f != %f", name, mdl.Current[name], val)
+		}
+	}
+}
+
+func TestConstantExpressions(t *testing.T) {
+	src := []string{
+		"L STOCK.K=STOCK.J+DT*AREA",
+		"N STOCK=0",
+		"C AREA=SIDE*WIDTH/2",
+		"C SIDE=2*WIDTH",
+		"C WIDTH=3",
+		"SPEC DT=1,LENGTH=2,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+	}
+	mdl := NewModel("", "")
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if val := mdl.Current["AREA"]; val.Compare(9) != 0 {
+		t.Fatalf("Value mismatch: %f != 9", val)
+	}
+	// cyclic constants are not allowed
+	mdl = NewModel("", "")
+	buf = bytes.NewBufferString("L X.K=X.J+DT*A\nN X=0\nC A=B\nC B=A\nRUN BASE\n")
+	if res := mdl.Parse(buf); res.Ok {
+		t.Fatal("Cyclic constants accepted")
+	}
+}
+
+func TestUndefinedTable(t *testing.T) {
+	src := []string{
+		"L X.K=X.J+DT*Y.JK",
+		"N X=0",
+		"R Y.KL=TABLE(TAB,X.K,0,10,5)",
+		"T TBA=0/1/2",
+		"SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0",
+	}
+	mdl := NewModel("", "")
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	res := mdl.Start()
+	if res.Ok || !strings.Contains(res.Err.Error(), "TAB") {
+		t.Fatal("Undefined table not detected")
+	}
+	if mdl.Done() != true {
+		t.Fatal("Model is running")
+	}
+}
+
+func TestLintTables(t *testing.T) {
+	src := []string{
+		"L X.K=X.J+DT*Y.JK",
+		"N X=0",
+		"R Y.KL=TABPL(TAB,X.K,0,4,1)+TABLE(TAB,X.K,0,8,2)",
+		"T TAB=0/0/0/10/10",
+		"R Z.KL=TABLE(LIN,X.K,0,3,1)",
+		"T LIN=0/1/5/3",
+		"SPEC DT=1,LENGTH=2,PRTPER=0,PLTPER=0",
+	}
+	mdl := NewModel("", "")
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Start(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	defer mdl.Finish()
+	var msgs []string
+	for _, l := range mdl.LintTables() {
+		msgs = append(msgs, l.String())
+	}
+	all := strings.Join(msgs, "\n")
+	for _, s := range []string{"different x-ranges", "LIN: value #3", "overshoots"} {
+		if !strings.Contains(all, s) {
+			t.Fatalf("Missing lint '%s':\n%s", s, all)
+		}
+	}
+}
+
+func TestCalendar(t *testing.T) {
+	mdl := NewModel("", "")
+	if res := mdl.AddStatement(&Line{Mode: "SPEC", Stmt: "TUNIT=MONTH,START=2020,DT=1"}); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	cal := mdl.Calendar
+	for time, label := range map[float64]string{0: "2020-01", 14: "2021-03"} {
+		if l := cal.Label(time); l != label {
+			t.Fatalf("Label mismatch: %s != %s", l, label)
+		}
+	}
+	cal.Unit = TUNIT_QUARTER
+	if l := cal.Label(5); l != "2021-Q2" {
+		t.Fatalf("Label mismatch: %s != 2021-Q2", l)
+	}
+	if res := mdl.AddStatement(&Line{Mode: "SPEC", Stmt: "TUNIT=EON"}); res.Ok {
+		t.Fatal("Unknown time unit accepted")
+	}
+}
+
+func TestReproducible(t *testing.T) {
+	run := func(repro bool) Variable {
+		mdl := NewModel("", "")
+		mdl.Repro = repro
+		buf := bytes.NewBufferString("L X.K=X.J+DT*0.1\nN X=0\nSPEC DT=0.1,LENGTH=1000,PRTPER=0,PLTPER=0\nRUN BASE\n")
+		if res := mdl.Parse(buf); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		return mdl.Current["X"]
+	}
+	// compensated summation is (almost) exact
+	inc := 0.1 * 0.1
+	exact := 10000 * inc
+	err0 := math.Abs(float64(run(false)) - exact)
+	err1 := math.Abs(float64(run(true)) - exact)
+	if err1 > 1e-12 || err1 >= err0 {
+		t.Fatalf("Compensation failed: %g >= %g", err1, err0)
+	}
+	// an unchanged branch continues with the compensations of the snapshot
+	mdl := NewModel("", "")
+	mdl.Repro = true
+	buf := bytes.NewBufferString("L X.K=X.J+DT*0.1\nN X=0\nSPEC DT=0.1,LENGTH=1000,PRTPER=0,PLTPER=0\nSNAPSHOT Y=500\nRUN BASE\n")
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Branch("Y", "SAME", nil); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	base, same := mdl.Results["BASE"].Vars["X"], mdl.Results["SAME"].Vars["X"]
+	if base[len(base)-1] != same[len(same)-1] {
+		t.Fatalf("Branch mismatch: %v != %v", same[len(same)-1], base[len(base)-1])
+	}
+	// (a pending compensation is applied in the branch)
+	mdl.branches["Y"].comp["X"] = 0.5
+	if res := mdl.Branch("Y", "COMP", nil); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	comp := mdl.Results["COMP"].Vars["X"]
+	if compare(comp[len(comp)-1], base[len(base)-1]-0.5) != 0 {
+		t.Fatalf("Compensation not restored: %v != %v", comp[len(comp)-1], base[len(base)-1]-0.5)
+	}
+}
+
+func TestBounds(t *testing.T) {
+	src := []string{
+		"NOTE @min 0",
+		"L INV.K=INV.J-DT*SHIP.JK",
+		"N INV=5",
+		"R SHIP.KL=1",
+		"SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+	}
+	run := func(mode string) (*Model, *Result) {
+		mdl := NewModel("", "")
+		mdl.BoundMode = mode
+		buf := new(bytes.Buffer)
+		for _, line := range src {
+			buf.WriteString(line + "\n")
+		}
+		return mdl, mdl.Parse(buf)
+	}
+	mdl, res := run(BOUND_CLAMP)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if val := mdl.Current["INV"]; val.Compare(0) != 0 {
+		t.Fatalf("Level not clamped: %f", val)
+	}
+	if _, res = run(BOUND_ABORT); res.Ok || !strings.Contains(res.Err.Error(), ErrModelBounds) {
+		t.Fatal("Run not aborted")
+	}
+	// inline attributes
+	src[0], src[1] = "NOTE bounds inline", "L INV.K=INV.J-DT*SHIP.JK;MIN=0;BOUND=CLAMP"
+	if mdl, res = run(""); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if val := mdl.Current["INV"]; val.Compare(0) != 0 {
+		t.Fatalf("Level not clamped (inline): %f", val)
+	}
+	src[1] = "L INV.K=INV.J-DT*SHIP.JK;LIMIT=0"
+	if _, res = run(""); !errors.Is(res, ErrorKind(ErrParseSyntax)) {
+		t.Fatal("Unknown attribute accepted")
+	}
+	// initial values are checked
+	src[1], src[2] = "L INV.K=INV.J-DT*SHIP.JK;MIN=0", "N INV=-1"
+	if _, res = run(BOUND_ABORT); !errors.Is(res, ErrorKind(ErrModelBounds)) || !strings.Contains(res.Err.Error(), "TIME 0") {
+		t.Fatalf("Initial value not checked: %v", res.Err)
+	}
+}
+
+func TestConservation(t *testing.T) {
+	src := []string{
+		"L A.K=A.J+DT*(IN.JK-FLOW.JK)",
+		"N A=10",
+		"L B.K=B.J+DT*(FLOW.JK-LEAK.JK)",
+		"N B=0",
+		"R IN.KL=1",
+		"R FLOW.KL=A.K/10",
+		"R LEAK.KL=B.K/10",
+		"CONSERVE OK=A,B/IN,-LEAK",
+		"CONSERVE BAD=A,B/IN",
+		"SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+	}
+	mdl := NewModel("", "")
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	list := mdl.Violations()
+	if len(list) != 1 || list[0].Group != "BAD" {
+		t.Fatalf("Wrong violations: %v", list)
+	}
+	// B is empty in the first epoch
+	if list[0].Epoch != 2 {
+		t.Fatalf("Wrong epoch of violation: %d", list[0].Epoch)
+	}
+}
+
+func TestStableDT(t *testing.T) {
+	src := []string{
+		"L STOCK.K=STOCK.J+DT*(IN.JK-OUT.JK)",
+		"N STOCK=10",
+		"R OUT.KL=STOCK.K/DUR",
+		"C DUR=4",
+		"R IN.KL=DELAY3(OUT.JK,3)",
+		"L STEP.K=STEP.J+DT*0",
+		"N STEP=DT",
+		"SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0",
+	}
+	mdl := NewModel("", "")
+	mdl.AutoDT = true
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Start(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	defer mdl.Finish()
+	list := mdl.TimeConstants()
+	if len(list) != 2 || list[0].Tau != 1 || list[1].Tau != 4 {
+		t.Fatalf("Wrong time constants: %v", list)
+	}
+	if dt := mdl.Current["DT"]; dt != 0.25 {
+		t.Fatalf("Wrong DT: %f", dt)
+	}
+	// initial values are computed with the selected DT
+	if val := mdl.Current["STEP"]; val != 0.25 {
+		t.Fatalf("Initial value with wrong DT: %f", val)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	src := []string{
+		"L STOCK.K=STOCK.J+DT*(IN.JK-OUT.JK)",
+		"N STOCK=10",
+		"R OUT.KL=STOCK.K/DUR",
+		"C DUR=4",
+		"R IN.KL=2",
+		"SPEC DT=1,LENGTH=1,PRTPER=0,PLTPER=0",
+	}
+	mdl := NewModel("", "")
+	buf := new(bytes.Buffer)
+	for _, line := range src {
+		buf.WriteString(line + "\n")
+	}
+	if res := mdl.Parse(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	out := new(bytes.Buffer)
+	if res := mdl.Explain(out, "OUT"); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	for _, s := range []string{"[1] DUR (CONST)", "[1] STOCK (LEVEL/INIT)"} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("Missing '%s':\n%s", s, out.String())
+		}
+	}
+	if res := mdl.Explain(out, "NONE"); res.Ok {
+		t.Fatal("Unknown variable explained")
+	}
+	// explain a model without running it
+	mdl = NewModel("", "")
+	mdl.NoRun = true
+	if res := mdl.Parse(bytes.NewBufferString(strings.Join(src, "\n") + "\nRUN BASE\n")); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if _, ok := mdl.Results["BASE"]; ok {
+		t.Fatal("Model run executed")
+	}
+	out.Reset()
+	if res := mdl.Explain(out, "OUT"); !res.Ok || !strings.Contains(out.String(), "[1] DUR (CONST)") {
+		t.Fatalf("Explain failed: %v\n%s", res.Err, out.String())
+	}
+}
+
+func TestEditRerun(t *testing.T) {
+	src := []string{
+		"L STOCK.K=STOCK.J+DT*IN.JK",
+		"N STOCK=0",
+		"R IN.KL=RATE",
+		"C RATE=1",
+		"SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+		"EDIT BASE",
+		"C RATE=2",
+		"RUN FAST",
+	}
+	buf := new(bytes.Buffer)
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(buf)
+	log.SetFlags(0)
+	SetLogLevel(LOG_VERBOSE)
+	mdl := NewModel("", "")
+	res := mdl.Parse(bytes.NewBufferString(strings.Join(src, "\n") + "\n"))
+	log.SetOutput(out)
+	log.SetFlags(flags)
+	SetLogLevel(LOG_INFO)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if n := strings.Count(buf.String(), "Re-using sorted equations"); n != 1 {
+		t.Fatalf("Sorted equations re-used in %d runs", n)
+	}
+	for run, val := range map[string]float64{"BASE": 10, "FAST": 20} {
+		list := mdl.Results[run].Vars["STOCK"]
+		if compare(list[len(list)-1], val) != 0 {
+			t.Fatalf("Value mismatch in %s: %f != %f", run, list[len(list)-1], val)
+		}
+	}
+	// structural changes require sorting
+	src = append(src, "EDIT FAST", "C RATE=DOUBLE*2", "C DOUBLE=2", "RUN FOUR")
+	mdl = NewModel("", "")
+	if res := mdl.Parse(bytes.NewBufferString(strings.Join(src, "\n") + "\n")); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	list := mdl.Results["FOUR"].Vars["STOCK"]
+	if compare(list[len(list)-1], 40) != 0 {
+		t.Fatalf("Value mismatch in FOUR: %f != 40", list[len(list)-1])
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	src := []string{
+		"L STOCK.K=STOCK.J+DT*IN.JK",
+		"N STOCK=0",
+		"R IN.KL=RATE*NOISE()",
+		"C RATE=2",
+		"SPEC DT=1,LENGTH=10,PRTPER=1,PLTPER=0",
+		"PRINT STOCK",
+		"RUN BASE",
+	}
+	run := func(seed int64) *Dataset {
+		fname := filepath.Join(t.TempDir(), "test.csv")
+		mdl := NewModel(fname, "")
+		mdl.Seed = seed
+		if res := mdl.Parse(bytes.NewBufferString(strings.Join(src, "\n") + "\n")); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if res := mdl.Quit(); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		// provenance in CSV print (as comments)
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "# seed: "+strconv.FormatInt(seed, 10)+"\n") {
+			t.Fatalf("Provenance missing in print:\n%s", string(data))
+		}
+		if _, res := ReadCSV(fname); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		return mdl.Results["BASE"]
+	}
+	ds1, ds2 := run(42), run(42)
+	p := ds1.Provenance
+	if p == nil {
+		t.Fatal("No provenance")
+	}
+	if p.Seed != 42 || p.Version != VERSION || len(p.Source) != 64 || p.Constants["RATE"] != 2 {
+		t.Fatalf("Provenance mismatch: %v", p)
+	}
+	if p.Source != ds2.Provenance.Source {
+		t.Fatal("Source hash mismatch")
+	}
+	// same seed draws the same random numbers
+	v1, v2 := ds1.Vars["STOCK"], ds2.Vars["STOCK"]
+	for i := range v1 {
+		if v1[i] != v2[i] {
+			t.Fatalf("Value mismatch in epoch %d: %f != %f", i, v1[i], v2[i])
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	dir := t.TempDir()
+	src := []string{
+		"L STOCK.K=STOCK.J+DT*IN.JK",
+		"N STOCK=0",
+		"R IN.KL=RATE*NOISE()",
+		"C RATE=1",
+		"SPEC DT=1,LENGTH=10,PRTPER=1,PLTPER=0",
+		"PRINT STOCK",
+		"RUN BASE",
+	}
+	if err := os.WriteFile(filepath.Join(dir, "test.dynamo"), []byte(strings.Join(src, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := `{
+		"runs": [
+			{ "id": "base", "model": "test.dynamo", "seed": 7 },
+			{ "id": "double", "model": "test.dynamo", "seed": 7, "overrides": { "RATE": 2 }, "print": "double.csv" },
+			{ "id": "bad", "model": "test.dynamo", "overrides": { "STOCK": 2 } },
+			{ "model": "missing.dynamo" }
+		]
+	}`
+	fname := filepath.Join(dir, "batch.json")
+	if err := os.WriteFile(fname, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, res := ReadManifest(fname)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	list := m.Execute(nil)
+	if len(list) != 4 {
+		t.Fatalf("Batch results mismatch: %d != 4", len(list))
+	}
+	for i, ok := range []bool{true, true, false, false} {
+		if list[i].Res.Ok != ok {
+			t.Fatalf("Result mismatch for '%s': %v", list[i].Run.ID, list[i].Res.Err)
+		}
+	}
+	if !errors.Is(list[2].Res, ErrorKind(ErrModelEqnBadMode)) || list[3].Run.ID != "#4" {
+		t.Fatalf("Failed entries mismatch: %v, %s", list[2].Res.Err, list[3].Run.ID)
+	}
+	// overridden constant doubles the results (same random numbers)
+	v1, v2 := list[0].Results["BASE"].Vars["STOCK"], list[1].Results["BASE"].Vars["STOCK"]
+	for i := range v1 {
+		if compare(2*v1[i], v2[i]) != 0 {
+			t.Fatalf("Value mismatch in epoch %d: %f != %f", i, 2*v1[i], v2[i])
+		}
+	}
+	if list[1].Seed != 7 {
+		t.Fatalf("Seed mismatch: %d != 7", list[1].Seed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "double.csv")); err != nil {
+		t.Fatal(err)
+	}
+	// summary table
+	buf := new(bytes.Buffer)
+	WriteBatchSummary(buf, list)
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 6 || !strings.Contains(lines[3], "BASE") {
+		t.Fatalf("Summary mismatch:\n%s", buf.String())
+	}
+	// invalid manifests
+	for _, m := range []string{`{"runs":[]}`, `{"runs":[{"id":"x"}]}`, `{"jobs":[]}`, `{"runs":[{"id":"a","model":"m"},{"id":"a","model":"m"}]}`} {
+		if _, res := ParseManifest(strings.NewReader(m)); !errors.Is(res, ErrorKind(ErrBatchManifest)) {
+			t.Fatalf("Invalid manifest accepted: %s", m)
+		}
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	model := func(lines ...string) []string {
+		return append([]string{
+			"L STOCK.K=STOCK.J+DT*IN.JK",
+			"N STOCK=0",
+		}, append(lines, "SPEC DT=1,LENGTH=5,PRTPER=0,PLTPER=0", "RUN TEST")...)
+	}
+	for _, tc := range []struct {
+		src  []string
+		exit int
+	}{
+		{model("R IN.KL=1"), EXIT_OK},
+		{model("R IN.KL=(1"), EXIT_PARSE},
+		{model("R IN.KL=RATE"), EXIT_MODEL},
+		{append([]string{"NOTE @max 2", "NOTE @bound ABORT"}, model("R IN.KL=1")...), EXIT_RUN},
+		{append([]string{"NOTE @max 2"}, model("R IN.KL=1")...), EXIT_WARNINGS},
+	} {
+		warnings := Warnings()
+		mdl := NewModel("", "")
+		res := mdl.Parse(bytes.NewBufferString(strings.Join(tc.src, "\n") + "\n"))
+		warnings = Warnings() - warnings
+		if exit := ExitCode(res, warnings); exit != tc.exit {
+			t.Fatalf("Exit code mismatch: %d != %d (%s)", exit, tc.exit, res.Error())
+		}
+		summary := Summary(res, len(mdl.Results), warnings)
+		if !strings.HasPrefix(summary, "DYNAMO status=") || !strings.Contains(summary, " exit="+strconv.Itoa(tc.exit)+" ") {
+			t.Fatalf("Summary mismatch: %s", summary)
+		}
+	}
+}
+
+func TestLintRules(t *testing.T) {
+	src := []string{
+		"L STOCK.K=STOCK.J+DT*IN.JK",
+		"N STOCK=0",
+		"R IN.KL=A5.K*0.5+TABLE(TAB,STOCK.K,0,4,1)",
+		"T TAB=0/1/2/3/4",
+		"A A1.K=STOCK.K",
+		"A A2.K=A1.K",
+		"A A3.K=A2.K",
+		"A A4.K=A3.K",
+		"A A5.K=A4.K",
+		"A X.K=A5.K+RATE",
+		"C RATE=1",
+		"C ADJ=2",
+		"SPEC DT=1,LENGTH=5,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+		"EDIT BASE",
+		"C RATE=2",
+		"RUN FAST",
+	}
+	mdl := NewModel("", "")
+	mdl.NoRun = true
+	if res := mdl.Parse(bytes.NewBufferString(strings.Join(src, "\n") + "\n")); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	lints := func(cfg *LintConfig) map[string]bool {
+		found := make(map[string]bool)
+		for _, l := range mdl.LintModel(cfg) {
+			found[l.Rule+" "+l.Name] = true
+		}
+		return found
+	}
+	found := lints(nil)
+	for _, s := range []string{
+		"NAMING X", "NAMING A1", "UNUSED X", "UNUSED ADJ", "CONSTANT ADJ",
+		"AUXCHAIN X", "MAGIC IN",
+	} {
+		if !found[s] {
+			t.Fatalf("Missing lint '%s': %v", s, found)
+		}
+	}
+	for _, s := range []string{"CONSTANT RATE", "AUXCHAIN A5", "UNUSED STOCK", "MAGIC X"} {
+		if found[s] {
+			t.Fatalf("Unexpected lint '%s'", s)
+		}
+	}
+	// configure rules
+	cfg := NewLintConfig()
+	if res := cfg.Set("NONE,MAGIC,AUXCHAIN=6"); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if found = lints(cfg); len(found) != 1 || !found["MAGIC IN"] {
+		t.Fatalf("Lint mismatch: %v", found)
+	}
+	if res := cfg.Set("-NAMING,UNKNOWN"); !errors.Is(res, ErrorKind(ErrLintRule)) {
+		t.Fatal("Unknown rule accepted")
+	}
+}
+
+func TestRename(t *testing.T) {
+	src := []string{
+		"* RENAME TEST",
+		"L     STOCK.K=STOCK.J+DT*(IN.JK-OUT.JK)   STOCK (UNITS)",
+		"N     STOCK=TABLE(TAB,0,0,2,1)",
+		"R     IN.KL=MAX(STOCK.K,1)  INFLOW OF STOCK",
+		"R     OUT.KL=STOCK.K/",
+		"X     DUR  DURATION (STOCK.K)",
+		"C     DUR=2",
+		"NOTE  STOCK IS NOT RENAMED IN NOTES",
+		"T     TAB=1/2/3",
+		"SPEC  DT=1,LENGTH=5,PRTPER=1,PLTPER=1",
+		"PRINT STOCK(2),IN",
+		"PLOT  STOCK=S(0,10)/IN=I",
+		"RUN   BASE",
+	}
+	rename := func(from, to string) (string, int, *Result) {
+		buf := new(bytes.Buffer)
+		n, res := Rename(buf, strings.NewReader(strings.Join(src, "\n")+"\n"), from, to, false, false)
+		return buf.String(), n, res
+	}
+	out, n, res := rename("stock", "INV")
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	expect := []string{
+		"* RENAME TEST",
+		"L     INV.K=INV.J+DT*(IN.JK-OUT.JK)   STOCK (UNITS)",
+		"N     INV=TABLE(TAB,0,0,2,1)",
+		"R     IN.KL=MAX(INV.K,1)  INFLOW OF STOCK",
+		"R     OUT.KL=INV.K/",
+		"X     DUR  DURATION (STOCK.K)",
+		"C     DUR=2",
+		"NOTE  STOCK IS NOT RENAMED IN NOTES",
+		"T     TAB=1/2/3",
+		"SPEC  DT=1,LENGTH=5,PRTPER=1,PLTPER=1",
+		"PRINT INV(2),IN",
+		"PLOT  INV=S(0,10)/IN=I",
+		"RUN   BASE",
+	}
+	if n != 7 || out != strings.Join(expect, "\n")+"\n" {
+		t.Fatalf("Rename mismatch (%d):\n%s", n, out)
+	}
+	// renamed model runs
+	mdl := NewModel("", "")
+	if res := mdl.Parse(strings.NewReader(out)); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	// rename table
+	if out, n, res = rename("TAB", "TABINV"); !res.Ok || n != 2 || !strings.Contains(out, "T     TABINV=1/2/3") {
+		t.Fatalf("Table rename failed (%d): %v\n%s", n, res.Err, out)
+	}
+	// invalid renames
+	if _, _, res = rename("FLOW", "X1"); !errors.Is(res, ErrorKind(ErrModelNoVariable)) {
+		t.Fatal("Unknown variable renamed")
+	}
+	if _, _, res = rename("STOCK", "DUR"); !errors.Is(res, ErrorKind(ErrModelVariabeExists)) {
+		t.Fatal("Rename to existing variable")
+	}
+	if _, _, res = rename("STOCK", "1X"); !errors.Is(res, ErrorKind(ErrParseInvalidName)) {
+		t.Fatal("Rename to invalid name")
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	src := []string{
+		"* UPGRADE TEST",
+		"L     STK.K=STK.J+DT*(IN.JK-OUT.JK)   STOCK (UNITS)",
+		"N     STK=10",
+		"R     IN.KL=1  INFLOW",
+		"R     OUT.KL=STK.K/",
+		"X     DUR  OUTFLOW",
+		"X     (UNITS PER TIME)",
+		"C     DUR=2",
+		"SPEC  DT=1,LENGTH=5,PRTPER=0,PLTPER=0",
+		"PRINT STK",
+		"RUN   BASE",
+	}
+	mapping := "# mapping\nSTK = STOCK\nDUR,DURATION\n"
+	names, res := ReadNameMap(strings.NewReader(mapping))
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	out, xref := new(bytes.Buffer), new(bytes.Buffer)
+	if res = Upgrade(out, xref, strings.NewReader(strings.Join(src, "\n")+"\n"), names); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	for _, s := range []string{
+		"L     STOCK.K=STOCK.J+DT*(IN.JK-OUT.JK)   # STOCK (UNITS)\n",
+		"X     DURATION  # OUTFLOW\n",
+		"X     # (UNITS PER TIME)\n",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("Missing line '%s':\n%s", s, out.String())
+		}
+	}
+	if !strings.Contains(xref.String(), "STOCK                    STK      2,3,5,10") {
+		t.Fatalf("Cross-reference mismatch:\n%s", xref.String())
+	}
+	// upgraded model gives the same results (in relaxed mode)
+	run := func(src string, relaxed bool) []float64 {
+		mdl := NewModel("", "")
+		mdl.SetRelaxed(relaxed)
+		if res := mdl.Parse(strings.NewReader(src)); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if mdl.Stack["BASE"].Find("STOCK") == nil && relaxed {
+			t.Fatal("Variable not renamed")
+		}
+		ds := mdl.Results["BASE"]
+		if relaxed {
+			return ds.Vars["STOCK"]
+		}
+		return ds.Vars["STK"]
+	}
+	v1, v2 := run(strings.Join(src, "\n")+"\n", false), run(out.String(), true)
+	for i := range v1 {
+		if v1[i] != v2[i] {
+			t.Fatalf("Value mismatch in epoch %d: %f != %f", i, v1[i], v2[i])
+		}
+	}
+	// invalid mappings
+	if _, res = ReadNameMap(strings.NewReader("STK\n")); !errors.Is(res, ErrorKind(ErrParseSyntax)) {
+		t.Fatal("Invalid mapping accepted")
+	}
+	if res = Upgrade(out, nil, strings.NewReader(strings.Join(src, "\n")), map[string]string{"STK": "IN"}); !errors.Is(res, ErrorKind(ErrModelVariabeExists)) {
+		t.Fatal("Mapping to existing name accepted")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	src := []string{
+		"L INV.K=INV.J-DT*SHIP.JK",
+		"N INV=5",
+		"R SHIP.KL=1",
+		"A RATIO.K=(INV.K-5)/(INV.K-5)",
+		"A LOOK.K=TABXT(LT,TIME.K,0,5,5)",
+		"T LT=0,10",
+		"SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+	}
+	run := func(spec string) (*Model, *Result) {
+		mdl := NewModel("", "")
+		mdl.Policy = NewPolicy()
+		if res := mdl.Policy.Set(spec); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		return mdl, mdl.Parse(strings.NewReader(strings.Join(src, "\n")))
+	}
+	// anomalies are ignored by default
+	mdl, res := run("")
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if val := mdl.Current["INV"]; val.Compare(-6) != 0 {
+		t.Fatalf("Level changed: %f", val)
+	}
+	if val := mdl.Current["LOOK"]; val.Compare(20) != 0 {
+		t.Fatalf("Table not extrapolated: %f", val)
+	}
+	// clamped values
+	if mdl, res = run("ALL=CLAMP"); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if val := mdl.Current["INV"]; val.Compare(0) != 0 {
+		t.Fatalf("Level not clamped: %f", val)
+	}
+	if val := mdl.Results["BASE"].Vars["RATIO"][0]; val != 0 {
+		t.Fatalf("NaN not clamped: %f", val)
+	}
+	if val := mdl.Current["LOOK"]; val.Compare(10) != 0 {
+		t.Fatalf("Table argument not clamped: %f", val)
+	}
+	// aborted runs
+	for kind, at := range map[string]string{
+		ANOMALY_NAN:      "TIME 0",
+		ANOMALY_NEGATIVE: "TIME 6",
+		ANOMALY_TABLE:    "TIME 6",
+	} {
+		if _, res = run(kind + "=ABORT"); !errors.Is(res, ErrorKind(ErrModelAnomaly)) || !strings.Contains(res.Err.Error(), at) {
+			t.Fatalf("%s: run not aborted: %v", kind, res.Err)
+		}
+	}
+	// policy in SPEC statement
+	src[6] = "SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0,NEGATIVE=ABORT"
+	if _, res = run(""); !errors.Is(res, ErrorKind(ErrModelAnomaly)) {
+		t.Fatalf("SPEC policy not applied: %v", res.Err)
+	}
+	for _, spec := range []string{"NAN=IGNORE", "UNDERFLOW=WARN", "NAN"} {
+		if res = NewPolicy().Set(spec); !errors.Is(res, ErrorKind(ErrModelPolicy)) {
+			t.Fatalf("invalid policy '%s' accepted", spec)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	src := []string{
+		"L POP.K=POP.J+DT*(BIRTHS.JK-DEATHS.JK)",
+		"N POP=100",
+		"R BIRTHS.KL=POP.K*BR*EFF.K",
+		"A EFF.K=TABHL(TEFF,POP.K/CAP,0,2,0.5)",
+		"T TEFF=1.2,1,0.8,0.5,0.2",
+		"R DEATHS.KL=MAX(POP.K*DR,STEP(5,10))+PULSE(2,5,5)",
+		"S DENS.K=POP.K/CAP",
+		"C BR=0.05",
+		"C DR=0.02",
+		"C CAP=1000",
+		"SPEC DT=0.5,LENGTH=40,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+	}
+	params := []State{
+		{"BR": 0.04},
+		{"BR": 0.06, "DR": 0.01},
+		{"CAP": 200},
+	}
+	// compare a sweep to runs with overridden constants
+	check := func(extra string, vectorized bool) {
+		mdl := NewModel("", "")
+		mdl.Seed = 1
+		model := strings.Join(src, "\n") + "\n" + extra
+		if res := mdl.Parse(strings.NewReader(model)); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		list, vec, res := mdl.sweep(params, true)
+		if !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if vec != vectorized {
+			t.Fatalf("vectorized=%v", vec)
+		}
+		if _, ok := mdl.Results["BASE#1"]; ok || len(mdl.Results) != 1 {
+			t.Fatal("sweep changed model results")
+		}
+		for i, ds := range list {
+			ref := NewModel("", "")
+			ref.Seed = 1
+			ref.Overrides = params[i]
+			if res := ref.Parse(strings.NewReader(model)); !res.Ok {
+				t.Fatal(res.Err)
+			}
+			exp := ref.Results["BASE"]
+			if ds.RunID != "BASE#"+strconv.Itoa(i+1) {
+				t.Fatalf("run %d: id '%s'", i, ds.RunID)
+			}
+			if _, ok := ds.Vars["DENS"]; ok {
+				t.Fatalf("run %d: supplement recorded", i)
+			}
+			if len(ds.Vars) != len(exp.Vars)-1 {
+				t.Fatalf("run %d: %d variables recorded", i, len(ds.Vars))
+			}
+			for name, vals := range ds.Vars {
+				ref := exp.Vars[name]
+				if len(vals) != len(ref) {
+					t.Fatalf("run %d: %s has %d values", i, name, len(vals))
+				}
+				for k, val := range vals {
+					if val != ref[k] {
+						t.Fatalf("run %d: %s[%d]=%f (expected %f)", i, name, k, val, ref[k])
+					}
+				}
+			}
+		}
+	}
+	check("", true)
+	// models with internal state are run one after the other
+	src[5] = "R DEATHS.KL=SMOOTH(POP.K,4)*DR+NOISE()"
+	check("", false)
+	src[5] = "R DEATHS.KL=POP.K*DR"
+	check("SPEC NEGATIVE=WARN", false)
+}
+
+func TestDistribution(t *testing.T) {
+	src := []string{
+		"L POP.K=POP.J+DT*GROWTH.JK",
+		"N POP=100",
+		"R GROWTH.KL=RATE",
+		"C RATE=1",
+		"SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+	}
+	mdl := NewModel("", "")
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	var params []State
+	for i := 1; i <= 10; i++ {
+		params = append(params, State{"RATE": Variable(i)})
+	}
+	list, res := mdl.Sweep(params)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	// final values are 110, 120, ..., 200
+	d, res := NewDistribution(list, "POP", math.Inf(1), 4)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if d.Min != 110 || d.Max != 200 || len(d.Values) != 10 {
+		t.Fatalf("range [%f,%f] with %d values", d.Min, d.Max, len(d.Values))
+	}
+	for i, cnt := range []int{3, 2, 2, 3} {
+		if d.Counts[i] != cnt {
+			t.Fatalf("bin %d: count %d (expected %d)", i, d.Counts[i], cnt)
+		}
+	}
+	if cdf := d.CDF(150); cdf != 0.5 {
+		t.Fatalf("CDF(150)=%f", cdf)
+	}
+	if q := d.Quantile(0.9); q != 190 {
+		t.Fatalf("Quantile(0.9)=%f", q)
+	}
+	// values at a point in time
+	if d, res = NewDistribution(list, "POP", 5, 2); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if d.Min != 105 || d.Max != 150 {
+		t.Fatalf("range [%f,%f] at TIME=5", d.Min, d.Max)
+	}
+	buf := new(bytes.Buffer)
+	if res = d.Plot(buf, DIST_CDF); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if !strings.Contains(buf.String(), "POP AT TIME=5 (10 RUNS)") {
+		t.Fatalf("plot:\n%s", buf.String())
+	}
+	buf.Reset()
+	if res = d.Gnuplot(buf, "pop", DIST_HISTOGRAM); !res.Ok || !strings.Contains(buf.String(), "with boxes") {
+		t.Fatalf("gnuplot:\n%s", buf.String())
+	}
+	// unknown variables and points in time
+	if _, res = NewDistribution(list, "FOO", 5, 2); res.Ok {
+		t.Fatal("unknown variable accepted")
+	}
+	if _, res = NewDistribution(list, "POP", 5.5, 2); res.Ok {
+		t.Fatal("unknown time accepted")
+	}
+	// distribution plot jobs are plotted after a sweep
+	dir := t.TempDir()
+	plt, gnu := filepath.Join(dir, "dist.plt"), filepath.Join(dir, "dist.gnuplot")
+	mdl = NewModel("", plt)
+	if res = mdl.AddPlotTarget(gnu); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res = mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res = mdl.Plot.AddDistribution("pop", math.Inf(1), 4, DIST_HISTOGRAM); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res = mdl.Plot.AddDistribution("POP", 5, 4, 7); res.Ok {
+		t.Fatal("unknown distribution mode accepted")
+	}
+	if _, res = mdl.Sweep(params); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res = mdl.Plot.Close(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	for file, exp := range map[string]string{plt: "DISTRIBUTION POP AT END (10 RUNS)", gnu: "dist_dist(1).svg"} {
+		body, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(body), exp) {
+			t.Fatalf("distribution plot in %s:\n%s", file, body)
+		}
+	}
+}
+
+func TestScatter(t *testing.T) {
+	src := []string{
+		"L POP.K=POP.J+DT*GROWTH.JK",
+		"N POP=100",
+		"R GROWTH.KL=RATE-LOSS",
+		"C RATE=1",
+		"C LOSS=0",
+		"SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+	}
+	mdl := NewModel("", "")
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	var params []State
+	for i := 1; i <= 5; i++ {
+		params = append(params, State{"RATE": Variable(i), "LOSS": Variable(i % 2)})
+	}
+	list, res := mdl.Sweep(params)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	sc, res := NewScatter(list, []string{"RATE", "LOSS"}, []string{"POP"}, math.Inf(1))
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if len(sc.Points) != 5 || sc.Points[2][0] != 3 || sc.Points[2][1] != 1 || sc.Points[2][2] != 120 {
+		t.Fatalf("points: %v", sc.Points)
+	}
+	if c := sc.Correlation(0, 0); math.Abs(c-1) > 1e-12 {
+		t.Fatalf("correlation(RATE,RATE)=%f", c)
+	}
+	if c := sc.Correlation(2, 0); c < 0.9 {
+		t.Fatalf("correlation(POP,RATE)=%f", c)
+	}
+	buf := new(bytes.Buffer)
+	sc.Write(buf)
+	if !strings.Contains(buf.String(), "SENSITIVITY (5 RUNS)") {
+		t.Fatalf("table:\n%s", buf.String())
+	}
+	buf.Reset()
+	if res = sc.Gnuplot(buf, "sweep"); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if out := buf.String(); !strings.Contains(out, "layout 3,2") || !strings.Contains(out, "using 1:3") {
+		t.Fatalf("gnuplot:\n%s", out)
+	}
+	if _, res = NewScatter(list, []string{"FOO"}, []string{"POP"}, 5); res.Ok {
+		t.Fatal("unknown parameter accepted")
+	}
+}
+
+func TestAutoVars(t *testing.T) {
+	src := []string{
+		"L STOCK.K=STOCK.J+DT*(IN.JK-OUT.JK)",
+		"N STOCK=10",
+		"R IN.KL=2",
+		"R OUT.KL=DELAY3(IN.JK,6)+SMOOTH(STOCK.K,4)/10",
+		"SPEC DT=0.5,LENGTH=20,PRTPER=0,PLTPER=0",
+	}
+	run := func() *Model {
+		mdl := NewModel("", "")
+		mdl.History = 4
+		if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if res := mdl.Start(); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		return mdl
+	}
+	// automatic variables are kept outside the model state
+	ref := run()
+	if res := ref.Step(12); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	for name := range ref.Current {
+		if name[0] == '_' {
+			t.Fatalf("automatic variable %s in state", name)
+		}
+	}
+	if _, ok := ref.auto.get("_7"); !ok {
+		t.Fatal("no internal state of SMOOTH")
+	}
+	// rollback restores the internal state of functions
+	mdl := run()
+	if res := mdl.Step(10); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Rollback(3); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Step(5); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if val := mdl.Current["STOCK"]; val != ref.Current["STOCK"] {
+		t.Fatalf("Value mismatch after rollback: %f != %f", val, ref.Current["STOCK"])
+	}
+}
+
+func TestEditResort(t *testing.T) {
+	src := []string{
+		"L STOCK.K=STOCK.J+DT*IN.JK",
+		"N STOCK=0",
+		"R IN.KL=Y.K",
+		"A Y.K=2*X.K",
+		"A X.K=1",
+		"A Z.K=STOCK.K/10",
+		"SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=0",
+		"RUN BASE",
+		"EDIT BASE",
+		"A Y.K=2*W.K",
+		"A W.K=X.K+Z.K",
+		"RUN EDIT",
+	}
+	buf := new(bytes.Buffer)
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(buf)
+	log.SetFlags(0)
+	mdl := NewModel("", "")
+	res := mdl.Parse(strings.NewReader(strings.Join(src, "\n")))
+	log.SetOutput(out)
+	log.SetFlags(flags)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	// only the equations depending on the added equation changed order
+	if !strings.Contains(buf.String(), "Re-sorted edited equations (2 changed order)") {
+		t.Fatalf("No incremental sort:\n%s", buf.String())
+	}
+	var kept, deps [2][]string
+	for i, run := range []string{"BASE", "EDIT"} {
+		for _, eqn := range mdl.Stack[run].List() {
+			switch eqn.Target.Name {
+			case "W", "Y", "IN":
+				deps[i] = append(deps[i], eqn.Target.Name)
+			default:
+				kept[i] = append(kept[i], eqn.String())
+			}
+		}
+	}
+	if strings.Join(kept[0], ",") != strings.Join(kept[1], ",") {
+		t.Fatalf("Order changed: %v != %v", kept[0], kept[1])
+	}
+	if list := strings.Join(deps[1], ","); list != "W,Y,IN" {
+		t.Fatalf("Dependencies out of order: %s", list)
+	}
+	// results match a model sorted from scratch
+	ref := NewModel("", "")
+	edited := append([]string{src[0], src[1], src[2]}, src[9:11]...)
+	edited = append(edited, src[4:8]...)
+	if res := ref.Parse(strings.NewReader(strings.Join(edited, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	diffs, res := ref.Results["BASE"].Compare(mdl.Results["EDIT"])
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	for _, diff := range diffs {
+		if diff.Max != 0 {
+			t.Fatalf("Results differ for %s: %f", diff.Name, diff.Max)
+		}
+	}
+}
+
+func TestEvalExpr(t *testing.T) {
+	tbl, res := NewTable([]string{"0", "1", "2", "3", "4", "5"})
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	tables := map[string]*Table{"T": tbl}
+	val, res := EvalExpr("A*TABLE(T,X,0,1,.2)", State{"A": 2, "X": 0.5}, tables)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if compare(float64(val), 5) != 0 {
+		t.Fatalf("Value mismatch: %f != 5", val)
+	}
+	// time postfixes are ignored
+	if val, res = EvalExpr("MAX(A.K,B.J)-(-C)", State{"A": 1, "B": 3, "C": 1}, nil); !res.Ok {
+		t.Fatal(res.Err)
+	} else if val != 4 {
+		t.Fatalf("Value mismatch: %f != 4", val)
+	}
+	// unbound variables and invalid expressions fail
+	if _, res = EvalExpr("A*B", State{"A": 1}, nil); res.Ok {
+		t.Fatal("Unbound variable accepted")
+	}
+	if _, res = EvalExpr("A*(B", State{"A": 1, "B": 1}, nil); res.Ok {
+		t.Fatal("Invalid expression accepted")
+	}
+}
+
+func TestFuzz(t *testing.T) {
+	quiet(t)
+	rng := rand.New(rand.NewSource(19))
+	for i := 0; i < 50; i++ {
+		fm := NewFuzzModel(rng, 8)
+		if res := fm.Check(int64(i + 1)); !res.Ok {
+			t.Fatalf("%s\n%s", res.Err, fm.Source(fm.Eqns))
+		}
+	}
+	// invalid models fail
+	if _, res := FuzzRun("A X.K=TABHL(T,1,0,1,1)\nRUN FUZZ\n", 1); res.Ok {
+		t.Fatal("Invalid model accepted")
+	}
+}
+
+func TestReferences(t *testing.T) {
+	quiet(t)
+	names := References()
+	if strings.Join(names, ",") != "coffee,inventory,world2" {
+		t.Fatalf("Wrong reference models: %v", names)
+	}
+	for _, name := range names {
+		list, res := RunReference(name, 1e-6)
+		if !res.Ok {
+			t.Fatalf("%s: %s", name, res.Err)
+		}
+		if len(list) > 0 {
+			t.Fatalf("%s: %d mismatches (%s at %f)", name, len(list), list[0].Name, list[0].Time)
+		}
+	}
+	if _, res := RunReference("unknown", 1e-6); res.Ok {
+		t.Fatal("Unknown reference model")
+	}
+}
+
+func TestSpec(t *testing.T) {
+	quiet(t)
+	parse := func(src ...string) (*Model, *Result) {
+		mdl := NewModel("", "")
+		return mdl, mdl.Parse(strings.NewReader(strings.Join(src, "\n")))
+	}
+	// invalid SPEC statements
+	for _, spec := range []string{"DT=1,FOO=2", "DT=0", "DT=X", "PRTPER=-1", "MAXLEN"} {
+		if _, res := parse("SPEC " + spec); !errors.Is(res, ErrorKind(ErrParseSpec)) {
+			t.Fatalf("SPEC %s: %v", spec, res.Err)
+		}
+	}
+	// maximum length of a run
+	mdl, res := parse(
+		"L X.K=X.J+DT*1",
+		"N X=0",
+		"SPEC DT=1/LENGTH=100/PRTPER=0/PLTPER=0/MAXLEN=10",
+		"RUN BASE",
+		"EDIT BASE",
+		"SPEC LENGTH=5",
+		"RUN SHORT",
+	)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	for run, n := range map[string]int{"BASE": 11, "SHORT": 6} {
+		if ds := mdl.Results[run]; ds.Len() != n {
+			t.Fatalf("Wrong number of epochs in %s: %d != %d", run, ds.Len(), n)
+		}
+	}
+	// relative error for cyclic initial values
+	for _, relErr := range []float64{0, 0.01} {
+		src := []string{
+			"N X=Y/2+1",
+			"N Y=X/2+1",
+			"L Z.K=Z.J+DT*X",
+			"N Z=0",
+			"SPEC DT=1/LENGTH=1/PRTPER=0/PLTPER=0",
+			"RUN BASE",
+		}
+		if relErr > 0 {
+			src[4] += "/RELERR=" + strconv.FormatFloat(relErr, 'g', -1, 64)
+		}
+		if mdl, res = parse(src...); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		x := mdl.Results["BASE"].Vars["X"][0]
+		if d := math.Abs(x - 2); d > 2*relErr+1e-8 || (relErr > 0 && d < 1e-6) {
+			t.Fatalf("Wrong initial value for RELERR=%g: %f", relErr, x)
+		}
+	}
+}
+
+func TestContinuationLines(t *testing.T) {
+	quiet(t)
+	src := []string{
+		"L     STK.K=STK.J+DT*(IN.JK-OUT.JK)",
+		"N     STK=10",
+		"R     IN.KL=MAX(STK.K*RATE,MIN(STK.K,CAP))+STK.K/DUR-STK.K/CAP  INFLOW",
+		"X     OF THE STOCK (DEPENDS ON THE CAPACITY AND THE RATE OF THE STOCK;",
+		"X     UNITS/WEEK)",
+		"R     OUT.KL=STK.K/DUR  OUTFLOW",
+		"C     RATE=0.1,CAP=20,DUR=5",
+		"SPEC  DT=1,LENGTH=5,PRTPER=1,PLTPER=0",
+	}
+	check := func(text string) *Model {
+		for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+			if len(line) > MAX_LINE_LENGTH {
+				t.Fatalf("Line too long: %s", line)
+			}
+		}
+		mdl := NewModel("", "")
+		mdl.SetStrict(true)
+		if res := mdl.Parse(strings.NewReader(text)); !res.Ok {
+			t.Fatalf("%s:\n%s", res.Err, text)
+		}
+		return mdl
+	}
+	// strict models are written in classic syntax
+	mdl := check(strings.Join(src, "\n"))
+	buf := new(bytes.Buffer)
+	mdl.WriteSource(buf)
+	other := check(buf.String())
+	stmts, list := mdl.Statements(), other.Statements()
+	if len(stmts) != len(list) {
+		t.Fatalf("Statements mismatch: %d != %d", len(stmts), len(list))
+	}
+	for i, stmt := range stmts {
+		if *stmt != *list[i] {
+			t.Fatalf("Statement mismatch: %v != %v", stmt, list[i])
+		}
+	}
+	// renamed lines are split in strict mode
+	buf.Reset()
+	if _, res := Rename(buf, strings.NewReader(strings.Join(src, "\n")), "STK", "INVNTR", false, true); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	mdl = check(buf.String())
+	if eqn := mdl.Eqns.Find("IN"); eqn == nil || eqn.Statement() != "IN.KL=MAX(INVNTR.K*RATE,MIN(INVNTR.K,CAP))+INVNTR.K/DUR-INVNTR.K/CAP" {
+		t.Fatalf("Wrong renamed equation:\n%s", buf.String())
+	}
+}
+
+func TestExprParser(t *testing.T) {
+	quiet(t)
+	// keyword names, implicit multiplication and exponents
+	list := []struct {
+		expr string
+		vars State
+		val  float64
+	}{
+		{"IF+VAR*FOR", State{"IF": 1, "VAR": 2, "FOR": 3}, 7},
+		{"(A+1)(B-1)", State{"A": 1, "B": 4}, 6},
+		{"1.5E2/-1E+1", nil, -15},
+		{"A*.5E-1", State{"A": 20}, 1},
+	}
+	for _, e := range list {
+		val, res := EvalExpr(e.expr, e.vars, nil)
+		if !res.Ok {
+			t.Fatalf("%s: %s", e.expr, res.Err)
+		}
+		if compare(float64(val), e.val) != 0 {
+			t.Fatalf("%s: %f != %f", e.expr, val, e.val)
+		}
+	}
+	// syntax errors report the position
+	if _, res := EvalExpr("A+*B", State{"A": 1, "B": 1}, nil); res.Ok {
+		t.Fatal("Invalid expression accepted")
+	} else if !strings.Contains(res.Err.Error(), "unexpected '*' at position") {
+		t.Fatalf("Unexpected error: %s", res.Err)
+	}
+	// '**' is only allowed in relaxed mode (left-associative, binds
+	// stronger than unary minus)
+	src := "C A=2\nC B=3\nA X.K=-A**B**2\nA Y.K=A**B\nSPEC DT=1/LENGTH=1\nRUN TEST\n"
+	mdl := NewModel("", "")
+	if res := mdl.Parse(strings.NewReader(src)); res.Ok {
+		t.Fatal("'**' accepted in strict mode")
+	}
+	mdl = NewModel("", "")
+	mdl.Relaxed = true
+	if res := mdl.Parse(strings.NewReader(src)); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	ds := mdl.Results["TEST"]
+	if x := ds.Vars["X"][0]; compare(x, -64) != 0 {
+		t.Fatalf("X mismatch: %f != -64", x)
+	}
+	if y := ds.Vars["Y"][0]; compare(y, 8) != 0 {
+		t.Fatalf("Y mismatch: %f != 8", y)
+	}
+}
+
+func TestKeywordNames(t *testing.T) {
+	quiet(t)
+	src := []string{
+		"L GO.K=GO.J+DT*RANGE.JK",
+		"N GO=TYPE",
+		"R RANGE.KL=FUNC.K*R2D2",
+		"A FUNC.K=MAX(IF,GO.K/SWITCH)",
+		"C TYPE=10",
+		"C IF=1",
+		"C SWITCH=5",
+		"C R2D2=0.5",
+		"SPEC DT=1/LENGTH=4/PRTPER=0/PLTPER=0",
+		"RUN KEYWDS",
+	}
+	mdl := NewModel("", "")
+	mdl.Strict = true
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	// GO grows by MAX(1,GO/5)/2 per epoch: 10, 11, 12.1, 13.31, 14.641
+	ds := mdl.Results["KEYWDS"]
+	if v := ds.Vars["GO"][ds.Len()-1]; compare(v, 14.641) != 0 {
+		t.Fatalf("GO mismatch: %f != 14.641", v)
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	quiet(t)
+	parse := func(src ...string) (*Model, *Result) {
+		mdl := NewModel("", "")
+		return mdl, mdl.Parse(strings.NewReader(strings.Join(src, "\n")))
+	}
+	// negative and scientific numbers in all statements
+	mdl, res := parse(
+		"C K1=-3.5E-2",
+		"C K2=+2.5e1",
+		"T TAB=-1E1/0/1.5E+1",
+		"A X.K=TABLE(TAB,TIME.K,0,2E0,1)+STEP(-2.5E-1,1E0)+K1+K2",
+		"SPEC DT=5E-1/LENGTH=.1E1/PRTPER=0/PLTPER=0",
+		"RUN SCI",
+	)
+	if !res.Ok {
+		t.Fatal(res.Err)
+	}
+	x := mdl.Results["SCI"].Vars["X"]
+	for i, v := range []float64{14.965, 19.965, 24.715} {
+		if compare(x[i], v) != 0 {
+			t.Fatalf("X[%d] mismatch: %f != %f", i, x[i], v)
+		}
+	}
+	// Go-only number formats are rejected
+	for _, src := range []string{"T TAB=1/Inf/2", "T TAB=1/0x10", "SPEC DT=NaN"} {
+		if _, res = parse(src); res.Ok {
+			t.Fatalf("Invalid number accepted: %s", src)
+		}
+	}
+	for _, s := range []string{"1", "-.5", "+1.5E-3", "2e10"} {
+		if _, ok := parseNumber(s); !ok {
+			t.Fatalf("Number rejected: %s", s)
+		}
+	}
+	for _, s := range []string{"", "-", "1E", "--1", "1.5.2", "1_000"} {
+		if _, ok := parseNumber(s); ok {
+			t.Fatalf("Invalid number accepted: %s", s)
+		}
+	}
+}
+
+func TestSourceLines(t *testing.T) {
+	quiet(t)
+	card := func(s, seq string) string {
+		return s + strings.Repeat(" ", MAX_LINE_LENGTH-len(s)) + seq
+	}
+	src := strings.Join([]string{
+		"* LINES",
+		"NOTE",
+		"NOTE   a simple model",
+		"",
+		"L X.K=X.J+DT*(",
+		"X     RATE)",
+		card("C RATE=1", "00000030") + "\r",
+		"   ",
+		"SPEC DT=1/LENGTH=1/PRTPER=0/PLTPER=0",
+	}, "\n")
+	mdl := NewModel("", "")
+	mdl.NoRun = true
+	if res := mdl.Parse(strings.NewReader(src)); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	// the source is reproduced exactly
+	buf := new(bytes.Buffer)
+	if res := mdl.WriteLines(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if buf.String() != src {
+		t.Fatalf("Source mismatch:\n%q\n%q", buf.String(), src)
+	}
+	// kinds of lines and card sequence numbers
+	kinds := []int{SRC_STMT, SRC_STMT, SRC_STMT, SRC_BLANK, SRC_STMT, SRC_CONT, SRC_STMT, SRC_BLANK, SRC_STMT}
+	if len(mdl.Lines) != len(kinds) {
+		t.Fatalf("Wrong number of lines: %d != %d", len(mdl.Lines), len(kinds))
+	}
+	for i, line := range mdl.Lines {
+		if line.Kind != kinds[i] {
+			t.Fatalf("Line %d: kind %d != %d", line.No, line.Kind, kinds[i])
+		}
+	}
+	if stmt := mdl.StatementLines(5); len(stmt) != 2 || stmt[0].Mode != "L" || stmt[1].No != 6 {
+		t.Fatalf("Wrong statement lines: %v", stmt)
+	}
+	if seq := mdl.Lines[6].Seq; seq != "00000030" {
+		t.Fatalf("Wrong sequence number: '%s'", seq)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	quiet(t)
+	src := []string{
+		"L X.K=X.J+DT*IN.JK",
+		"N X=0",
+		"R IN.KL=MAX(1,X.K/10)",
+		"SPEC DT=1/LENGTH=10/PRTPER=0/PLTPER=0",
+		"RUN PROF",
+	}
+	mdl := NewModel("", "")
+	mdl.Profile = NewProfile()
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	calls := make(map[string]int)
+	for _, e := range append(mdl.Profile.Equations(), mdl.Profile.Functions()...) {
+		calls[e.Name] = e.Calls
+	}
+	for name, n := range map[string]int{"L X": 11, "R IN": 12} {
+		if calls[name] != n {
+			t.Fatalf("Wrong number of calls for %s: %d != %d", name, calls[name], n)
+		}
+	}
+	// functions are also called during initialization
+	if calls["MAX"] < calls["R IN"] {
+		t.Fatalf("Wrong number of calls for MAX: %d", calls["MAX"])
+	}
+	buf := new(bytes.Buffer)
+	mdl.Profile.Write(buf)
+	if !strings.Contains(buf.String(), "MAX") {
+		t.Fatalf("Function missing in report:\n%s", buf.String())
+	}
+}
+
+func TestParallel(t *testing.T) {
+	quiet(t)
+	// large model with independent sub-models
+	src := []string{"T TAB=0/1/4/9/16", "C SCALE=0.01"}
+	for i := 0; i < 2*PARALLEL_THRESHOLD; i++ {
+		src = append(src,
+			fmt.Sprintf("L S%d.K=S%d.J+DT*(IN%d.JK-OUT%d.JK)", i, i, i, i),
+			fmt.Sprintf("N S%d=%d", i, i),
+			fmt.Sprintf("R IN%d.KL=TABLE(TAB,X%d.K,0,4,1)+NOISE()", i, i),
+			fmt.Sprintf("R OUT%d.KL=DELAY3(IN%d.JK,3)+SMOOTH(S%d.K,2)*SCALE", i, i, i),
+			fmt.Sprintf("A X%d.K=CLIP(4,Y%d.K,Y%d.K,4)", i, i, i),
+			fmt.Sprintf("A Y%d.K=SQRT(S%d.K*S%d.K+1)", i, i, i),
+		)
+	}
+	src = append(src, "SPEC DT=0.25/LENGTH=20/PRTPER=0/PLTPER=0", "RUN PAR")
+	run := func(parallel int) *Dataset {
+		mdl := NewModel("", "")
+		mdl.Seed = 17
+		mdl.Parallel = parallel
+		if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		return mdl.Results["PAR"]
+	}
+	seq, par := run(0), run(4)
+	for name, vals := range seq.Vars {
+		for i, v := range vals {
+			if math.Float64bits(par.Vars[name][i]) != math.Float64bits(v) {
+				t.Fatalf("%s[%d] mismatch: %g != %g", name, i, par.Vars[name][i], v)
+			}
+		}
+	}
+}
+
+func TestTypedVariables(t *testing.T) {
+	quiet(t)
+	src := strings.Join([]string{
+		"* TYPES",
+		"L X.K=X.J+DT*0.4",
+		"N X=0",
+		"A CNT.K=X.K;TYPE=INTEGER",
+		"A BIG.K=X.K>=0.8",
+		"A NE.K=CNT.K<>1",
+		"A SEL.K=IFTHENELSE(BIG.K,10,-10)",
+		"SPEC DT=1/LENGTH=4/PRTPER=0/PLTPER=0",
+		"RUN TEST",
+	}, "\n")
+	mdl := NewModel("", "")
+	if res := mdl.Parse(strings.NewReader(src)); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	vars := mdl.Results["TEST"].Vars
+	for name, vals := range map[string][]float64{
+		"CNT": {0, 0, 1, 1, 2},
+		"BIG": {0, 0, 1, 1, 1},
+		"NE":  {1, 1, 0, 0, 1},
+		"SEL": {-10, -10, 10, 10, 10},
+	} {
+		for i, v := range vals {
+			if vars[name][i] != v {
+				t.Fatalf("%s[%d] mismatch: %f != %f", name, i, vars[name][i], v)
+			}
+		}
+	}
+}
+
+func TestConditionals(t *testing.T) {
+	quiet(t)
+	list := []struct {
+		expr string
+		val  float64
+	}{
+		{"IF(A>1,A,-A)", 2},
+		{"IF(A<1,A,IF(IF>0,IF,0))+1", 4},
+		{"2*IF(A=2,A,0)", 4},
+	}
+	for _, e := range list {
+		val, res := EvalExpr(e.expr, State{"A": 2, "IF": 3}, nil)
+		if !res.Ok {
+			t.Fatalf("%s: %s", e.expr, res.Err)
+		}
+		if compare(float64(val), e.val) != 0 {
+			t.Fatalf("%s: %f != %f", e.expr, val, e.val)
+		}
+	}
+	for _, expr := range []string{"IF(A>1,A)", "A>1?A:0"} {
+		if _, res := EvalExpr(expr, State{"A": 2}, nil); res.Ok {
+			t.Fatalf("Invalid expression accepted: %s", expr)
+		}
+	}
+	// '?:' in relaxed mode (right-associative, lowest precedence)
+	src := strings.Join([]string{
+		"L X.K=X.J+DT*1",
+		"N X=0",
+		"A Y.K=X.K<1?10:X.K<2?20:X.K+30",
+		"A Z.K=MAX(X.K=2?-1:1,0)",
+		"SPEC DT=1/LENGTH=3/PRTPER=0/PLTPER=0",
+		"RUN TEST",
+	}, "\n")
+	mdl := NewModel("", "")
+	mdl.Relaxed = true
+	if res := mdl.Parse(strings.NewReader(src)); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	vars := mdl.Results["TEST"].Vars
+	for i, v := range []float64{10, 20, 32, 33} {
+		if y := vars["Y"][i]; compare(y, v) != 0 {
+			t.Fatalf("Y[%d] mismatch: %f != %f", i, y, v)
+		}
+	}
+	for i, v := range []float64{1, 1, 0, 1} {
+		if z := vars["Z"][i]; compare(z, v) != 0 {
+			t.Fatalf("Z[%d] mismatch: %f != %f", i, z, v)
+		}
+	}
+	// only the selected branch is evaluated: a guarded table lookup or
+	// division is no anomaly
+	guarded := []string{
+		"C X=20",
+		"C D=0",
+		"T TB=0/1/2/3/4/5/6/7/8/9/10",
+		"A Y.K=IF(X<10,TABLE(TB,X,0,10,1),-1)",
+		"A Z.K=IF(D=0,0,X/D)+IF(D<>0,X/D,1)",
+		"SPEC DT=1/LENGTH=3/PRTPER=0/PLTPER=0",
+		"RUN TEST",
+	}
+	mdl = NewModel("", "")
+	mdl.Policy = NewPolicy()
+	if res := mdl.Policy.Set("TABLE=ABORT,OVERFLOW=ABORT,NAN=ABORT"); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Parse(strings.NewReader(strings.Join(guarded, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	vars = mdl.Results["TEST"].Vars
+	if y, z := vars["Y"][3], vars["Z"][3]; compare(y, -1) != 0 || compare(z, 1) != 0 {
+		t.Fatalf("guarded values: Y=%f, Z=%f", y, z)
+	}
+	// ... in vectorized runs only for the runs selecting the branch
+	buf := new(strings.Builder)
+	log.SetOutput(buf)
+	mdl = NewModel("", "")
+	if res := mdl.Parse(strings.NewReader(strings.Join(guarded, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	buf.Reset()
+	runs, vec, res := mdl.sweep([]State{{"X": 4}, {"X": 20}}, true)
+	if !res.Ok || !vec {
+		t.Fatalf("sweep failed (vectorized=%v): %v", vec, res.Err)
+	}
+	if y1, y2 := runs[0].Vars["Y"][3], runs[1].Vars["Y"][3]; compare(y1, 4) != 0 || compare(y2, -1) != 0 {
+		t.Fatalf("guarded values in sweep: Y=%f,%f", y1, y2)
+	}
+	if strings.Contains(buf.String(), "table range") {
+		t.Fatalf("table accessed in branch not taken:\n%s", buf.String())
+	}
+}
+
+func TestInteg(t *testing.T) {
+	quiet(t)
+	src := strings.Join([]string{
+		"* INTEG",
+		"A Y.K=INTEG(IN.K-Y.K/4,100)",
+		"L X.K=X.J+DT*RX.JK",
+		"N X=100",
+		"R RX.KL=IN.K-X.K/4",
+		"A IN.K=STEP(10,2)+5",
+		"A Z.K=1+INTEG(INTEG(1,0),X)",
+		"SPEC DT=0.5/LENGTH=10/PRTPER=0/PLTPER=0",
+		"RUN TEST",
+	}, "\n")
+	mdl := NewModel("", "")
+	if res := mdl.Parse(strings.NewReader(src)); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	vars := mdl.Results["TEST"].Vars
+	for i, x := range vars["X"] {
+		if compare(vars["Y"][i], x) != 0 {
+			t.Fatalf("Y[%d] mismatch: %f != %f", i, vars["Y"][i], x)
+		}
+	}
+	// nested integrators: Z = 101 + TIME^2/2 (Euler)
+	for i, v := range []float64{101, 101, 101.25, 101.75} {
+		if z := vars["Z"][i]; compare(z, v) != 0 {
+			t.Fatalf("Z[%d] mismatch: %f != %f", i, z, v)
+		}
+	}
+	// hidden levels are not part of the source
+	for _, stmt := range mdl.Statements() {
+		if strings.Contains(stmt.Stmt, "_") {
+			t.Fatalf("Hidden equation in source: %s", stmt.Stmt)
+		}
+	}
+}
+
+func TestTrend(t *testing.T) {
+	quiet(t)
+	// exponential growth with 5% per time unit
+	src := strings.Join([]string{
+		"* TREND",
+		"L X.K=X.J+DT*G*X.J",
+		"N X=100",
+		"C G=0.05",
+		"A TR.K=TRND(X.K,4,G)",
+		"A TR0.K=TRND(X.K,4)",
+		"A FC.K=FORCST(X.K,4,10,G)",
+		"SPEC DT=0.5/LENGTH=20/PRTPER=0/PLTPER=0",
+		"RUN TEST",
+	}, "\n")
+	mdl := NewModel("", "")
+	if res := mdl.Parse(strings.NewReader(src)); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	vars := mdl.Results["TEST"].Vars
+	x, tr, fc := vars["X"], vars["TR"], vars["FC"]
+	n := len(x) - 1
+	if compare(tr[0], 0.05) != 0 || math.Abs(tr[n]-0.05) > 0.002 {
+		t.Fatalf("TR mismatch: %f .. %f", tr[0], tr[n])
+	}
+	// the forecast extrapolates the previous value
+	if compare(fc[n], x[n-1]*(1+10*tr[n])) != 0 {
+		t.Fatalf("FC mismatch: %f != %f", fc[n], x[n-1]*(1+10*tr[n]))
+	}
+	// without initial trend the trend approaches the growth rate
+	tr0 := vars["TR0"]
+	if tr0[0] != 0 || tr0[n] < 0.04 || tr0[n] > 0.05 {
+		t.Fatalf("TR0 mismatch: %f .. %f", tr0[0], tr0[n])
+	}
+}
+
+func TestControllers(t *testing.T) {
+	quiet(t)
+	src := strings.Join([]string{
+		"* CONTROL",
+		"C GOAL=100",
+		"L INV.K=INV.J+DT*ORD.JK",
+		"N INV=0",
+		"R ORD.KL=PICTRL(INV.K,GOAL,0.5,0.1)",
+		"L INV2.K=INV2.J+DT*ORD2.JK",
+		"N INV2=0",
+		"L ERR2.K=ERR2.J+DT*DEV2.JK",
+		"N ERR2=0",
+		"R DEV2.KL=GOAL-INV2.K",
+		"R ORD2.KL=0.5*(GOAL-INV2.K)+0.1*ERR2.K",
+		"L INV3.K=INV3.J+DT*ORD3.JK",
+		"N INV3=0",
+		"R ORD3.KL=PCTRL(INV3.K,GOAL,0.5)",
+		"SPEC DT=0.5/LENGTH=20/PRTPER=0/PLTPER=0",
+		"RUN TEST",
+	}, "\n")
+	mdl := NewModel("", "")
+	if res := mdl.Parse(strings.NewReader(src)); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	vars := mdl.Results["TEST"].Vars
+	for i, v := range vars["INV2"] {
+		if compare(vars["INV"][i], v) != 0 {
+			t.Fatalf("INV[%d] mismatch: %f != %f", i, vars["INV"][i], v)
+		}
+		if p := 100 * (1 - math.Pow(0.75, float64(i))); compare(vars["INV3"][i], p) != 0 {
+			t.Fatalf("INV3[%d] mismatch: %f != %f", i, vars["INV3"][i], p)
+		}
+	}
+}
+
+func TestDelayInputs(t *testing.T) {
+	quiet(t)
+	src := strings.Join([]string{
+		"* DELAYS",
+		"A IN.K=5+STEP(5,2)",
+		"R OUT1.KL=DELAY1(IN.K,2)",
+		"R OUT3.KL=DELAY3(IN.K,3)",
+		"A INF1.K=DLINF1(IN.K,2)",
+		"A INF3.K=DLINF3(IN.K,3)",
+		"A SUM.K=OUT1.JK+OUT3.JK+INF1.K+INF3.K",
+		"SPEC DT=0.25/LENGTH=60/PRTPER=0/PLTPER=0",
+		"RUN TEST",
+	}, "\n")
+	mdl := NewModel("", "")
+	if res := mdl.Parse(strings.NewReader(src)); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	vars := mdl.Results["TEST"].Vars
+	n := len(vars["SUM"]) - 1
+	for _, name := range []string{"OUT1", "OUT3", "INF1", "INF3"} {
+		if v := vars[name]; compare(v[0], 5) != 0 || math.Abs(v[n]-10) > 1e-3 {
+			t.Fatalf("%s mismatch: %f .. %f", name, v[0], v[n])
+		}
+	}
+}
+
+func TestSystemVariables(t *testing.T) {
+	quiet(t)
+	src := strings.Join([]string{
+		"* CLOCK",
+		"N TIME=1900",
+		"C LENGTH=1902",
+		"C DT=0.5",
+		"A START.K=STRTIM()",
+		"A ELAPSED.K=LTIME()",
+		"N X=STRTIM()",
+		"L X.K=X.J+DT*0",
+		"RUN TEST",
+	}, "\n")
+	mdl := NewModel("", "")
+	if res := mdl.Parse(strings.NewReader(src)); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	vars := mdl.Results["TEST"].Vars
+	for i, e := range []float64{0, 0.5, 1, 1.5, 2} {
+		if vars["START"][i] != 1900 || vars["X"][i] != 1900 || compare(vars["ELAPSED"][i], e) != 0 {
+			t.Fatalf("Epoch %d mismatch: %f, %f, %f", i, vars["START"][i], vars["X"][i], vars["ELAPSED"][i])
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	metrics.Lock()
+	ok, epochs := metrics.ok, metrics.epochs
+	metrics.Unlock()
+	mdl := NewModel("", "")
+	src := []string{
+		"L POS.K=POS.J+DT*RATE.JK",
+		"N POS=900",
+		"R RATE.KL=50",
+		"SPEC DT=1,LENGTH=10",
+		"RUN METRICS \"1\"",
+	}
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	metrics.Lock()
+	runs, computed := metrics.ok-ok, metrics.epochs-epochs
+	metrics.Unlock()
+	if runs != 1 || computed != 11 {
+		t.Fatalf("metrics: %d runs, %d epochs", runs, computed)
+	}
+	buf := new(bytes.Buffer)
+	if res := WriteMetrics(buf); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	for _, line := range []string{
+		"# TYPE dynamo_runs_total counter",
+		`dynamo_run_equations{run="METRICS \"1\""} `,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Fatalf("missing '%s':\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	src := []string{
+		"L POS.K=POS.J+DT*RATE.JK",
+		"N POS=900",
+		"R RATE.KL=50",
+		"SPEC DT=1,LENGTH=10",
+	}
+	mdl := NewModel("", "")
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Start(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Step(2); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	mdl.Cancel()
+	if res := mdl.Step(1); !errors.Is(res, ErrorKind(ErrModelCanceled)) {
+		t.Fatal("canceled run continued")
+	}
+	mdl.Finish()
+	// a new run is not canceled
+	if res := mdl.Run(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+}
+
+func TestWriteRDS(t *testing.T) {
+	ds1, ds2 := NewDataset("A"), NewDataset("B")
+	ds1.Add(State{"TIME": 0, "X": 1})
+	ds1.Add(State{"TIME": 1, "X": 2})
+	ds2.Add(State{"TIME": 0, "Y": 3})
+	buf := new(bytes.Buffer)
+	if res := WriteRDS(buf, ds1, ds2); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	zr, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// decode the serialized data frame
+	pos := 0
+	next := func() int32 {
+		v := int32(binary.BigEndian.Uint32(data[pos:]))
+		pos += 4
+		return v
+	}
+	str := func() string {
+		if flags := next(); flags&0xff != rdsCHAR {
+			t.Fatalf("not a string: %x", flags)
+		}
+		n := int(next())
+		pos += n
+		return string(data[pos-n : pos])
+	}
+	strs := func() (list []string) {
+		if flags := next(); flags != rdsSTR {
+			t.Fatalf("not a character vector: %x", flags)
+		}
+		for n := next(); n > 0; n-- {
+			list = append(list, str())
+		}
+		return
+	}
+	if string(data[:2]) != "X\n" {
+		t.Fatal("not in XDR format")
+	}
+	pos = 2
+	if next() != rdsVersion {
+		t.Fatal("wrong version")
+	}
+	next()
+	next()
+	if flags := next(); flags != rdsVEC|rdsOBJECT|rdsATTR {
+		t.Fatalf("not a data frame: %x", flags)
+	}
+	if n := next(); n != 4 {
+		t.Fatalf("%d columns", n)
+	}
+	if runs := strings.Join(strs(), ","); runs != "A,A,B" {
+		t.Fatalf("runs: %s", runs)
+	}
+	var cols [][]float64
+	for i := 0; i < 3; i++ {
+		if flags := next(); flags != rdsREAL {
+			t.Fatalf("not a numeric vector: %x", flags)
+		}
+		var col []float64
+		for n := next(); n > 0; n-- {
+			col = append(col, math.Float64frombits(binary.BigEndian.Uint64(data[pos:])))
+			pos += 8
+		}
+		cols = append(cols, col)
+	}
+	if cols[1][1] != 2 || !math.IsNaN(cols[1][2]) || cols[2][2] != 3 {
+		t.Fatalf("columns: %v", cols)
+	}
+	attrs := make(map[string]string)
+	for next() == rdsLIST|rdsTAG {
+		next()
+		name := str()
+		if name == "row.names" {
+			next()
+			next()
+			next()
+			attrs[name] = strconv.Itoa(int(-next()))
+			continue
+		}
+		attrs[name] = strings.Join(strs(), ",")
+	}
+	if pos != len(data) {
+		t.Fatalf("trailing data (%d bytes)", len(data)-pos)
+	}
+	if attrs["names"] != "RUN,TIME,X,Y" || attrs["class"] != "data.frame" || attrs["row.names"] != "3" {
+		t.Fatalf("attributes: %v", attrs)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	src := []string{
+		"L POS.K=POS.J+DT*RATE.JK",
+		"N POS=900",
+		"R RATE.KL=50",
+		"A OUT.K=DELAY3(RATE.JK,3)",
+		"SPEC DT=1,LENGTH=10",
+		"RUN BASE",
+	}
+	mdl := NewModel("", "")
+	mdl.NoRun = true
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	buf := new(bytes.Buffer)
+	if res := mdl.Inspect(buf, 4); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if lines[0] != "# State of run 'BASE' at TIME=4" {
+		t.Fatalf("header mismatch: %s", lines[0])
+	}
+	state := make(map[string]string)
+	var autos int
+	for _, line := range lines[1:] {
+		f := strings.Fields(line)
+		if state[f[0]] = f[1]; f[0][0] == '_' {
+			autos++
+		}
+	}
+	if state["POS"] != "1100" || state["OUT"] != "50" || state["TIME"] != "4" {
+		t.Fatalf("state mismatch: %v", state)
+	}
+	if autos != 6 {
+		t.Fatalf("%d internal variables of DELAY3", autos)
+	}
+	// inspection doesn't change the model results
+	if len(mdl.Results) != 0 {
+		t.Fatal("results changed")
+	}
+	// time outside of run
+	if res := mdl.Inspect(buf, 20); res.Ok {
+		t.Fatal("inspected time outside of run")
+	}
+}
+
+func TestInternals(t *testing.T) {
+	src := []string{
+		"L POS.K=POS.J+DT*RATE.JK",
+		"N POS=900",
+		"R RATE.KL=50",
+		"A OUT.K=DELAY3(RATE.JK,3)+DELAY1(RATE.JK,2)",
+		"A AVG.K=SMOOTH(OUT.K,5)+SMOOTH(POS.K,5)",
+		"SPEC DT=1,LENGTH=10",
+		"PRINT OUT,_DELAY1$OUT$L1",
+		"RUN BASE",
+	}
+	mdl := NewModel("", "")
+	mdl.Internals = true
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	// derived names of internal variables
+	names := mdl.selectNames("*INTERN")
+	exp := []string{
+		"_DELAY1$OUT$L1", "_DELAY1$OUT$R1",
+		"_DELAY3$OUT$L1", "_DELAY3$OUT$L2", "_DELAY3$OUT$L3",
+		"_DELAY3$OUT$R1", "_DELAY3$OUT$R2", "_DELAY3$OUT$R3",
+		"_SMOOTH$AVG$2$L1", "_SMOOTH$AVG$L1",
+	}
+	if strings.Join(names, ",") != strings.Join(exp, ",") {
+		t.Fatalf("names mismatch: %v", names)
+	}
+	// internal variables are recorded
+	ds := mdl.Results["BASE"]
+	for _, name := range exp {
+		if len(ds.Vars[name]) != 11 {
+			t.Fatalf("%s: %d values recorded", name, len(ds.Vars[name]))
+		}
+	}
+	if v := ds.Vars["_DELAY1$OUT$L1"][10]; compare(v, 100) != 0 {
+		t.Fatalf("DELAY1 level: %f", v)
+	}
+}
+
+func TestCache(t *testing.T) {
+	src := []string{
+		"L POS.K=POS.J+DT*RATE.JK",
+		"N POS=900",
+		"R RATE.KL=BASE.K+POS.K*0.01",
+		"A BASE.K=SQRT(C1)*STEP(H,5)+C2",
+		"A CUR.K=POS.K/C1",
+		"C C1=4",
+		"C C2=1",
+		"C H=3",
+		"SPEC DT=1,LENGTH=10",
+	}
+	run := func(noCache bool) (*Dataset, int) {
+		mdl := NewModel("", "")
+		mdl.NoCache = noCache
+		if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if res := mdl.Start(); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if res := mdl.Step(11); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		hits := mdl.run.hits
+		mdl.Finish()
+		return mdl.Results[mdl.RunID], hits
+	}
+	ds1, hits := run(false)
+	ds2, none := run(true)
+	// BASE is evaluated in the first epoch and when the step time is
+	// reached; CUR is evaluated in every epoch.
+	if hits != 9 || none != 0 {
+		t.Fatalf("skipped evaluations: %d (cached), %d (not cached)", hits, none)
+	}
+	for name, vals := range ds2.Vars {
+		for i, v := range vals {
+			if compare(v, ds1.Vars[name][i]) != 0 {
+				t.Fatalf("%s[%d]: %f != %f", name, i, ds1.Vars[name][i], v)
+			}
+		}
+	}
+	if v := ds1.Vars["BASE"][6]; compare(v, 7) != 0 {
+		t.Fatalf("BASE after step: %f", v)
+	}
+	// rolled back steps don't leave stale cached values
+	src = []string{
+		"A Y.K=STEP(10,3)",
+		"R R.KL=Y.K",
+		"L X.K=X.J+DT*R.JK",
+		"N X=0",
+		"SPEC DT=1,LENGTH=20",
+	}
+	for _, noCache := range []bool{false, true} {
+		mdl := NewModel("", "")
+		mdl.NoCache = noCache
+		mdl.History = 10
+		if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if res := mdl.Start(); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if res := mdl.Step(6); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if res := mdl.Rollback(3); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		for i := 1; i <= 4; i++ {
+			if res := mdl.Step(1); !res.Ok {
+				t.Fatal(res.Err)
+			}
+			if y, x := mdl.Current["Y"], mdl.Current["X"]; y.Compare(10) != 0 || x.Compare(Variable(10*i)) != 0 {
+				t.Fatalf("after rollback (nocache=%v): Y=%f, X=%f", noCache, y, x)
+			}
+		}
+		mdl.Finish()
+	}
+}
@@ -22,22 +22,153 @@ package dynamo
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
+	"sync"
 )
 
+//======================================================================
+// Leveled messages with categories
+//======================================================================
+
+// Log levels
+const (
+	LOG_ERROR   = iota // errors
+	LOG_WARN           // warnings
+	LOG_INFO           // normal program messages
+	LOG_VERBOSE        // detailed messages
+)
+
+// Log categories
+const (
+	LOG_GENERAL = ""       // uncategorized messages
+	LOG_PARSE   = "PARSE"  // parsing DYNAMO source
+	LOG_MODEL   = "MODEL"  // model checks and validation
+	LOG_RUN     = "RUN"    // running a model
+	LOG_OUTPUT  = "OUTPUT" // print and plot output
+)
+
+var (
+	logLock  sync.RWMutex               // lock for log settings
+	logLevel                 = LOG_INFO // max. level of logged messages
+	logCats  map[string]bool = nil      // enabled categories (nil for all)
+	logNames                 = []string{"ERROR", "WARN", "INFO", "VERBOSE"}
+	logWarns int             // number of warnings (logged or not)
+)
+
+// SetLogLevel sets the maximum level of logged messages.
+func SetLogLevel(level int) {
+	logLock.Lock()
+	defer logLock.Unlock()
+	logLevel = level
+}
+
+// ParseLogLevel returns the log level for a level name (like "WARN").
+func ParseLogLevel(name string) (int, *Result) {
+	for level, n := range logNames {
+		if strings.EqualFold(n, name) {
+			return level, Success()
+		}
+	}
+	return LOG_INFO, Failure(ErrLogLevel+": %s", name)
+}
+
+// SetLogCategories restricts verbose messages to given categories (like
+// "PARSE" or "RUN"); other messages are always logged. Calling the function
+// without arguments (or with "ALL") enables all categories.
+func SetLogCategories(cats ...string) *Result {
+	list := make(map[string]bool)
+	for _, cat := range cats {
+		switch cat = strings.ToUpper(strings.TrimSpace(cat)); cat {
+		case "ALL":
+			list = nil
+		case LOG_PARSE, LOG_MODEL, LOG_RUN, LOG_OUTPUT:
+			if list != nil {
+				list[cat] = true
+			}
+		default:
+			return Failure(ErrLogCategory+": %s", cat)
+		}
+	}
+	if len(list) == 0 {
+		list = nil
+	}
+	logLock.Lock()
+	defer logLock.Unlock()
+	logCats = list
+	return Success()
+}
+
+// Warnings returns the number of warnings issued so far.
+func Warnings() int {
+	logLock.RLock()
+	defer logLock.RUnlock()
+	return logWarns
+}
+
+// count warnings (even if not logged)
+func countWarning(level int) {
+	if level == LOG_WARN {
+		logLock.Lock()
+		defer logLock.Unlock()
+		logWarns++
+	}
+}
+
+// check if a message with given level and category is logged.
+func logged(level int, cat string) bool {
+	logLock.RLock()
+	defer logLock.RUnlock()
+	if level > logLevel {
+		return false
+	}
+	if level == LOG_VERBOSE && cat != LOG_GENERAL && logCats != nil {
+		return logCats[cat]
+	}
+	return true
+}
+
+// Log a plain message with given level and category
+func Log(level int, cat string, msg string) {
+	countWarning(level)
+	if logged(level, cat) {
+		if level < LOG_INFO {
+			msg = logNames[level] + ": " + msg
+		}
+		log.Println(msg)
+	}
+}
+
+// Logf logs a formatted message with given level and category
+func Logf(level int, cat string, format string, args ...interface{}) {
+	countWarning(level)
+	if logged(level, cat) {
+		if level < LOG_INFO {
+			format = logNames[level] + ": " + format
+		}
+		log.Printf(format, args...)
+	}
+}
+
 //======================================================================
 // Normal program messages
 //======================================================================
 
 // Msg (plain message)
 func Msg(msg string) {
-	log.Println(msg)
+	Log(LOG_INFO, LOG_GENERAL, msg)
 }
 
 // Msgf (formatted message)
 func Msgf(format string, args ...interface{}) {
-	log.Printf(format, args...)
+	Logf(LOG_INFO, LOG_GENERAL, format, args...)
+}
+
+// MsgWriter returns the writer for the log stream
+func MsgWriter() io.Writer {
+	return log.Writer()
 }
 
 // Fatal terminates the application with plain message
@@ -50,56 +181,91 @@ func Fatalf(format string, args ...interface{}) {
 	log.Fatalf(format, args...)
 }
 
+//======================================================================
+// Output files
+//======================================================================
+
+var (
+	fileLock    sync.RWMutex                         // lock for file creator
+	fileCreator func(string) (io.WriteCloser, error) // creator of output files (nil for default)
+)
+
+// SetFileCreator sets the function that creates print and plot files
+// (like in-memory files in environments without a file system). Setting
+// nil restores the default (files in the file system).
+func SetFileCreator(create func(name string) (io.WriteCloser, error)) {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+	fileCreator = create
+}
+
+// createFile creates a print or plot file.
+func createFile(name string) (io.WriteCloser, error) {
+	fileLock.RLock()
+	create := fileCreator
+	fileLock.RUnlock()
+	if create == nil {
+		return os.Create(name)
+	}
+	return create(name)
+}
+
 //======================================================================
 // DEBUG messages
 //======================================================================
 
-// Shared debugger instance
-var Dbg *Debugger
+// Debug levels
+const (
+	DBG_OFF   = iota // no debug messages
+	DBG_MODEL        // parsing, sorting and initialization of models
+	DBG_TRACE        // evaluation of equations and access to variables
+)
 
-// Debugger writes debug messages to a file (if defined)
+// Debugger writes debug messages up to a given level to a stream.
 type Debugger struct {
-	file    *os.File // reference to debug file (or nil if not defined)
-	console bool
-}
-
-// SetDebugger instantiates a new Debugger
-func SetDebugger(file string) {
-	Dbg = new(Debugger)
-	if len(file) == 0 {
-		Dbg.file = nil
-	} else {
-		if file == "-" {
-			Dbg.console = true
-			Dbg.file = os.Stdout
-		} else {
-			var err error
-			if Dbg.file, err = os.Create(file); err != nil {
-				Fatal(err.Error())
-			}
-		}
+	sync.Mutex
+	out   io.Writer // debug output (or nil if not defined)
+	level int       // max. level of debug messages (DBG_???)
+}
+
+// NewDebugger creates a debugger that writes messages up to the given
+// level (DBG_???) to a stream.
+func NewDebugger(w io.Writer, level int) *Debugger {
+	return &Debugger{
+		out:   w,
+		level: level,
 	}
 }
 
-// Close debugger file
-func (dbg *Debugger) Close() *Result {
-	if dbg != nil && dbg.file != nil && !dbg.console {
-		dbg.file.Close()
+// check if messages of given level are written.
+func (dbg *Debugger) enabled(level int) bool {
+	return dbg != nil && dbg.out != nil && level <= dbg.level
+}
+
+// write a debug message with given level
+func (dbg *Debugger) write(level int, msg string) {
+	if dbg.enabled(level) {
+		dbg.Lock()
+		defer dbg.Unlock()
+		io.WriteString(dbg.out, msg)
 	}
-	return Success()
 }
 
-// Msg to write a plain message into the debugger file
+// Msg to write a plain message (DBG_MODEL)
 func (dbg *Debugger) Msg(msg string) {
-	if dbg != nil && dbg.file != nil {
-		dbg.file.WriteString(msg + "\n")
-	}
+	dbg.write(DBG_MODEL, msg+"\n")
 }
 
-// Msgf to write a formatted message into the debugger file
+// Msgf to write a formatted message (DBG_MODEL)
 func (dbg *Debugger) Msgf(format string, args ...interface{}) {
-	if dbg != nil && dbg.file != nil {
-		msg := fmt.Sprintf(format, args...)
-		dbg.file.WriteString(msg)
+	if dbg.enabled(DBG_MODEL) {
+		dbg.write(DBG_MODEL, fmt.Sprintf(format, args...))
+	}
+}
+
+// Tracef to write a formatted message on evaluations (DBG_TRACE)
+func (dbg *Debugger) Tracef(format string, args ...interface{}) {
+	if dbg.enabled(DBG_TRACE) {
+		dbg.write(DBG_TRACE, fmt.Sprintf(format, args...))
 	}
 }
@@ -0,0 +1,119 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+//======================================================================
+// Real-time paced simulation: every step (DT) of a model run takes a
+// defined amount of wall-clock time. The current values of variables
+// can be streamed (CSV format) and/or passed to a callback function
+// after each step (e.g. for dashboards or hardware-in-the-loop setups).
+//======================================================================
+
+// StepFunc is called after each step of a paced run with the current
+// state of the model. Changes to the state are used in the next step.
+type StepFunc func(epoch int, state State) *Result
+
+// Pacer paces a model run against wall-clock time.
+type Pacer struct {
+	Step     time.Duration // wall-clock time per DT
+	Vars     []string      // names of streamed variables (all if empty)
+	Out      io.Writer     // stream for live values (or nil)
+	Callback StepFunc      // function called after each step (or nil)
+
+	start time.Time  // wall-clock start of run
+	vars  []string   // names of streamed variables in run
+	csv   *CSVFormat // format of streamed values
+}
+
+// NewPacer creates a new pacer with given step duration.
+func NewPacer(step time.Duration) *Pacer {
+	return &Pacer{
+		Step: step,
+	}
+}
+
+// start a paced run
+func (p *Pacer) begin(mdl *Model) (res *Result) {
+	res = Success()
+	p.start = time.Now()
+	p.csv = mdl.Print.CSVFormat()
+	if p.vars = p.Vars; len(p.vars) == 0 {
+		// stream all (non-system) variables
+		names := make(map[string]bool)
+		for _, eqn := range mdl.Eqns.List() {
+			if name := eqn.Target.Name; name[0] != '_' && !mdl.IsSystem(name) {
+				names[name] = true
+			}
+		}
+		for name := range names {
+			p.vars = append(p.vars, name)
+		}
+		sort.Strings(p.vars)
+	}
+	if p.Out != nil {
+		fields := []string{p.csv.field("TIME")}
+		for _, name := range p.vars {
+			fields = append(fields, p.csv.field(name))
+		}
+		_, err := fmt.Fprintln(p.Out, strings.Join(fields, p.csv.Delim))
+		if err != nil {
+			res = Failure(err)
+		}
+	}
+	return
+}
+
+// step is called after each epoch of a model run: values are streamed
+// and the pacer waits for the end of the time slot of the epoch.
+func (p *Pacer) step(mdl *Model, epoch int) (res *Result) {
+	res = Success()
+	if p.Out != nil {
+		fields := []string{p.csv.value(float64(mdl.Current["TIME"]), 6)}
+		for _, name := range p.vars {
+			val, ok := mdl.Current[name]
+			if !ok {
+				return Failure(ErrModelNoVariable+": %s [Pacer]", name)
+			}
+			fields = append(fields, p.csv.value(float64(val), 6))
+		}
+		if _, err := fmt.Fprintln(p.Out, strings.Join(fields, p.csv.Delim)); err != nil {
+			return Failure(err)
+		}
+	}
+	if p.Callback != nil {
+		if res = p.Callback(epoch, mdl.Current); !res.Ok {
+			return
+		}
+	}
+	// wait for next time slot (without drift)
+	if wait := time.Until(p.start.Add(time.Duration(epoch) * p.Step)); wait > 0 {
+		time.Sleep(wait)
+	}
+	return
+}
@@ -0,0 +1,205 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"go/ast"
+	"strings"
+	"sync"
+)
+
+//======================================================================
+// Parallel evaluation: the equations computed in a step (auxiliaries and
+// rates, supplements, levels) are partitioned into strata; equations in
+// a stratum don't depend on each other and can be evaluated concurrently.
+// Strata are evaluated in order; new values are set after all equations
+// of a stratum are evaluated. Small strata (and equations that draw
+// random numbers) are evaluated sequentially, so small models keep the
+// single-threaded path.
+//======================================================================
+
+// PARALLEL_THRESHOLD is the min. number of independent equations in a
+// stratum that are evaluated concurrently.
+const PARALLEL_THRESHOLD = 64
+
+// stratum is a set of independent equations.
+type stratum struct {
+	seq []*Equation // equations evaluated sequentially
+	par []*Equation // equations evaluated concurrently
+}
+
+// parallel returns true if equations can be evaluated concurrently: the
+// model must not be debugged or profiled and numeric anomalies must not
+// be handled by a policy (all of them record state during evaluation).
+func (mdl *Model) parallel() bool {
+	return mdl.Parallel > 1 && mdl.Dbg == nil && mdl.Profile == nil && !mdl.Policy.active()
+}
+
+// computeStep computes all equations of the current run with specified
+// mode (concurrently if possible).
+func (mdl *Model) computeStep(modes string) (res *Result) {
+	run := mdl.run
+	if !mdl.parallel() || run.epoch == 1 {
+		// the first step is computed sequentially: not all variables
+		// are known before.
+		return mdl.compute(modes, run.eqns)
+	}
+	strata, ok := run.strata[modes]
+	if !ok {
+		if run.strata == nil {
+			run.strata = make(map[string][]*stratum)
+		}
+		strata = mdl.stratify(modes, run.eqns)
+		run.strata[modes] = strata
+	}
+	// automatic variables are set concurrently (in distinct slots)
+	mdl.auto.reserve(mdl.autoID + 1)
+	for _, s := range strata {
+		for _, eqn := range s.seq {
+			if _, res = eqn.Eval(mdl); !res.Ok {
+				return
+			}
+		}
+		if res = mdl.evalParallel(s.par); !res.Ok {
+			return
+		}
+	}
+	return Success()
+}
+
+// evalParallel evaluates independent equations concurrently and sets the
+// new values afterwards.
+func (mdl *Model) evalParallel(eqns []*Equation) *Result {
+	num := len(eqns)
+	if num == 0 {
+		return Success()
+	}
+	vals := make([]Variable, num)
+	results := make([]*Result, num)
+	missing := make([]bool, num)
+	workers := mdl.Parallel
+	if workers > num {
+		workers = num
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < num; i += workers {
+				m := make(map[string]*Name)
+				vals[i], results[i] = eval(eqns[i].Formula, mdl, m)
+				missing[i] = len(m) > 0
+			}
+		}(w)
+	}
+	wg.Wait()
+	for i, eqn := range eqns {
+		if missing[i] {
+			// handle missing variables like a sequential evaluation
+			if _, res := eqn.Eval(mdl); !res.Ok {
+				return res
+			}
+			continue
+		}
+		if !results[i].Ok {
+			return results[i]
+		}
+		if res := mdl.Set(eqn.Target, vals[i].Typed(eqn.Type)); !res.Ok {
+			return res
+		}
+	}
+	return Success()
+}
+
+// stratify partitions the equations with specified modes into strata of
+// independent equations (in order of evaluation).
+func (mdl *Model) stratify(modes string, eqns *EqnList) (strata []*stratum) {
+	level := make(map[string]int)
+	var list [][]*Equation
+	for _, eqn := range eqns.List() {
+		if !strings.Contains(modes, eqn.Mode) {
+			continue
+		}
+		lvl := 0
+		for _, dep := range eqn.Dependencies {
+			if l, ok := level[dep.Name]; ok && l+1 > lvl {
+				lvl = l + 1
+			}
+		}
+		level[eqn.Target.Name] = lvl
+		for len(list) <= lvl {
+			list = append(list, nil)
+		}
+		list[lvl] = append(list[lvl], eqn)
+	}
+	for _, eqns := range list {
+		s := new(stratum)
+		for _, eqn := range eqns {
+			if mdl.concurrent(eqn, level) {
+				s.par = append(s.par, eqn)
+			} else {
+				s.seq = append(s.seq, eqn)
+			}
+		}
+		if len(s.par) < PARALLEL_THRESHOLD {
+			s.seq, s.par = eqns, nil
+		}
+		strata = append(strata, s)
+	}
+	return
+}
+
+// concurrent returns true if an equation can be evaluated concurrently:
+// it must not draw random numbers (to keep the sequence of numbers) and
+// all variables it uses must be known (computed in the step or part of
+// the current or previous state), as the initial value of a missing
+// variable would be computed and set during the evaluation.
+func (mdl *Model) concurrent(eqn *Equation, computed map[string]int) bool {
+	ok := true
+	ast.Inspect(eqn.Formula, func(n ast.Node) bool {
+		if call, is := n.(*ast.CallExpr); is {
+			if fcn, is := call.Fun.(*ast.Ident); is && fcn.Name == "NOISE" {
+				ok = false
+			}
+		}
+		return ok
+	})
+	for _, list := range [][]*Name{eqn.Dependencies, eqn.References} {
+		for _, name := range list {
+			if !ok {
+				return false
+			}
+			if name.Stage == NAME_STAGE_OLD {
+				_, ok = mdl.Last[name.Name]
+			} else if _, ok = computed[name.Name]; !ok {
+				_, ok = mdl.Current[name.Name]
+			}
+			if !ok {
+				_, ok = mdl.Tables[name.Name]
+			}
+			if !ok {
+				_, ok = mdl.Series[name.Name]
+			}
+		}
+	}
+	return ok
+}
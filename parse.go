@@ -22,9 +22,12 @@ package dynamo
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 //----------------------------------------------------------------------
@@ -60,46 +63,83 @@ func (mdl *Model) Parse(rdr io.Reader) (res *Result) {
 		return s
 	}
 
+	// split statement into equation and comment part: in classic DYNAMO
+	// the equation ends at the first space (this also applies to the
+	// continuation lines of an equation).
+	split := func(mode, s string) (string, string) {
+		if strings.Contains("CNARLST", mode) {
+			if mdl.Relaxed {
+				// spaces are allowed in relaxed mode
+				return strings.Replace(s, " ", "", -1), ""
+			}
+			if pos := strings.Index(s, " "); pos != -1 {
+				return s[:pos], s[pos:]
+			}
+		}
+		return s, ""
+	}
+
 	// parse a single (complete) line of model code
 	var (
-		input  string
-		lineNo int
-		stmtNo int
+		mode    string
+		input   string
+		comment string
+		lineNo  int
+		stmtNo  int
 	)
 	parseInput := func() (res *Result) {
 		res = Success()
 		// skip empty input line
-		if len(input) == 0 {
-			return
-		}
-		// create new statement
-		stmt := new(Line)
-		// dissect inout
-		if pos := strings.Index(input, " "); pos != -1 {
-			stmt.Mode = input[:pos]
-			input = strings.TrimSpace(input[pos:])
-			stmt.Stmt = input
-			stmt.Comment = ""
-			if strings.Contains("CNARLST", stmt.Mode) {
-				if pos := strings.Index(input, " "); pos != -1 {
-					stmt.Stmt = input[:pos]
-					stmt.Comment = compact(input[pos:])
-				}
+		if len(mode) > 0 {
+			stmt := &Line{
+				Mode:    mode,
+				Stmt:    mdl.fold(mode, input),
+				Comment: compact(comment),
 			}
 			res = mdl.AddStatement(stmt).SetLine(stmtNo)
 		}
+		mode = ""
 		input = ""
+		comment = ""
 		return
 	}
 
+	// keep hash of model source (provenance)
+	rdr = io.TeeReader(rdr, mdl.source)
+
+	// EBCDIC sources are converted as a whole (as line ends are encoded
+	// differently) and processed as UTF-8.
+	enc := mdl.Encoding
+	if strings.EqualFold(enc, ENC_EBCDIC) || strings.EqualFold(enc, "CP037") {
+		data, err := io.ReadAll(rdr)
+		if err != nil {
+			return Failure(err)
+		}
+		rdr, enc = strings.NewReader(ebcdic(data)), ENC_UTF8
+	}
 	// parse source stream
 	brdr := bufio.NewReader(rdr)
 	lineNo = 0
 	for {
-		// read next line and check length limit
-		data, _, err := brdr.ReadLine()
+		// read next line, decode it and check length limit
+		data, err := brdr.ReadBytes('\n')
+		if err == io.EOF && len(data) > 0 {
+			// last line without line end
+			err = nil
+		}
 		lineNo++
-		if strict && len(data) > MAX_LINE_LENGTH {
+		raw := string(data)
+		data = bytes.TrimRight(data, "\r\n")
+		var text string
+		if text, res = decodeLine(data, lineNo == 1, enc); !res.Ok {
+			res.SetLine(lineNo)
+			return
+		}
+		src := &SourceLine{No: lineNo, Kind: SRC_BLANK, Seq: cardSeq(text), Raw: raw}
+		if err == nil {
+			mdl.Lines = append(mdl.Lines, src)
+		}
+		if mdl.Strict && utf8.RuneCountInString(text) > MAX_LINE_LENGTH {
 			res = Failure(ErrParseLineLength).SetLine(lineNo)
 			return
 		}
@@ -107,30 +147,276 @@ func (mdl *Model) Parse(rdr io.Reader) (res *Result) {
 		if err != nil {
 			if err == io.EOF {
 				// add last pending statement
-				res = parseInput()
+				if res = parseInput(); res.Ok && mdl.block != nil {
+					res = Failure(ErrParseSector+": missing ENDSECTOR for %s", mdl.block.name)
+				} else if q := mdl.checkQualifiers(mdl.Eqns); res.Ok && !q.Ok {
+					res = q.SetLine(lineNo)
+				}
 			} else {
 				res = Failure(err).SetLine(lineNo)
 			}
 			return
 		}
-		// process line
-		line := strings.ToUpper(string(data))
+		// process line (statements are folded to upper case when complete;
+		// comments keep their spelling)
+		line := text
 		if len(line) == 0 {
 			// skip empty lines
 			continue
 		}
+		// in relaxed mode, comments start with '#'
+		lineComment := ""
+		if mdl.Relaxed {
+			if pos := strings.Index(line, "#"); pos != -1 {
+				lineComment = " " + line[pos+1:]
+				if line = strings.TrimRight(line[:pos], " "); len(line) == 0 {
+					comment += lineComment
+					src.Kind, src.Stmt = SRC_COMMENT, stmtNo
+					continue
+				}
+			}
+		}
 		// check for continuation line
-		if line[0] == 'X' {
-			input += strings.TrimSpace(line[1:])
+		if line[0] == 'X' || line[0] == 'x' {
+			src.Kind, src.Stmt = SRC_CONT, stmtNo
+			// once the comment of a statement has started, the rest
+			// of the statement is comment.
+			cont := strings.TrimSpace(line[1:])
+			if !mdl.Relaxed && len(comment) > 0 {
+				comment += " " + cont + lineComment
+				continue
+			}
+			stmt, cmt := split(mode, cont)
+			input += stmt
+			comment += cmt + lineComment
 			continue
 		}
 		// process pending input
 		if res = parseInput(); !res.Ok {
 			break
 		}
-		input = line
+		// dissect input
+		mode = strings.ToUpper(line)
+		if pos := strings.Index(line, " "); pos != -1 {
+			mode = strings.ToUpper(line[:pos])
+			input, comment = split(mode, strings.TrimSpace(line[pos:]))
+		}
+		comment += lineComment
 		stmtNo = lineNo
+		if len(mode) > 0 {
+			src.Kind, src.Mode, src.Stmt = SRC_STMT, mode, lineNo
+		}
 	}
 	res.SetLine(lineNo)
 	return
 }
+
+// Source encodings
+const (
+	ENC_AUTO   = ""       // detect encoding (UTF-8 or Latin-1)
+	ENC_UTF8   = "UTF-8"  // UTF-8 encoded source
+	ENC_LATIN1 = "LATIN1" // ISO-8859-1 encoded source (legacy)
+	ENC_EBCDIC = "EBCDIC" // EBCDIC (code page 037) encoded source (mainframe)
+)
+
+// decodeLine converts a line of source code in given encoding to a string.
+// A byte-order mark on the first line is removed. In automatic mode lines
+// that are not valid UTF-8 are treated as Latin-1. Control characters
+// (like tabs, carriage returns or form feeds in legacy listings) and other
+// space characters (like non-breaking spaces) are replaced with spaces.
+func decodeLine(data []byte, first bool, enc string) (s string, res *Result) {
+	res = Success()
+	if first {
+		data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	}
+	switch strings.ToUpper(enc) {
+	case ENC_AUTO:
+		if utf8.Valid(data) {
+			s = string(data)
+		} else {
+			s = latin1(data)
+		}
+	case ENC_UTF8, "UTF8":
+		if !utf8.Valid(data) {
+			res = Failure(ErrParseEncoding + ": invalid UTF-8")
+			return
+		}
+		s = string(data)
+	case ENC_LATIN1, "ISO-8859-1":
+		s = latin1(data)
+	default:
+		res = Failure(ErrParseEncoding+": %s", enc)
+		return
+	}
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.IsSpace(r) {
+			return ' '
+		}
+		return r
+	}, s)
+	return
+}
+
+// latin1 converts ISO-8859-1 encoded data into a string
+func latin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// ebcdic converts EBCDIC (code page 037) encoded data into a string. Both
+// EBCDIC line ends (LF and NL) are converted to '\n'.
+func ebcdic(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		if runes[i] = cp037[b]; runes[i] == 0x85 {
+			runes[i] = '\n'
+		}
+	}
+	return string(runes)
+}
+
+// cp037 maps EBCDIC code page 037 to Unicode
+var cp037 = [256]rune{
+	0x0000, 0x0001, 0x0002, 0x0003, 0x009C, 0x0009, 0x0086, 0x007F,
+	0x0097, 0x008D, 0x008E, 0x000B, 0x000C, 0x000D, 0x000E, 0x000F,
+	0x0010, 0x0011, 0x0012, 0x0013, 0x009D, 0x0085, 0x0008, 0x0087,
+	0x0018, 0x0019, 0x0092, 0x008F, 0x001C, 0x001D, 0x001E, 0x001F,
+	0x0080, 0x0081, 0x0082, 0x0083, 0x0084, 0x000A, 0x0017, 0x001B,
+	0x0088, 0x0089, 0x008A, 0x008B, 0x008C, 0x0005, 0x0006, 0x0007,
+	0x0090, 0x0091, 0x0016, 0x0093, 0x0094, 0x0095, 0x0096, 0x0004,
+	0x0098, 0x0099, 0x009A, 0x009B, 0x0014, 0x0015, 0x009E, 0x001A,
+	0x0020, 0x00A0, 0x00E2, 0x00E4, 0x00E0, 0x00E1, 0x00E3, 0x00E5,
+	0x00E7, 0x00F1, 0x00A2, 0x002E, 0x003C, 0x0028, 0x002B, 0x007C,
+	0x0026, 0x00E9, 0x00EA, 0x00EB, 0x00E8, 0x00ED, 0x00EE, 0x00EF,
+	0x00EC, 0x00DF, 0x0021, 0x0024, 0x002A, 0x0029, 0x003B, 0x00AC,
+	0x002D, 0x002F, 0x00C2, 0x00C4, 0x00C0, 0x00C1, 0x00C3, 0x00C5,
+	0x00C7, 0x00D1, 0x00A6, 0x002C, 0x0025, 0x005F, 0x003E, 0x003F,
+	0x00F8, 0x00C9, 0x00CA, 0x00CB, 0x00C8, 0x00CD, 0x00CE, 0x00CF,
+	0x00CC, 0x0060, 0x003A, 0x0023, 0x0040, 0x0027, 0x003D, 0x0022,
+	0x00D8, 0x0061, 0x0062, 0x0063, 0x0064, 0x0065, 0x0066, 0x0067,
+	0x0068, 0x0069, 0x00AB, 0x00BB, 0x00F0, 0x00FD, 0x00FE, 0x00B1,
+	0x00B0, 0x006A, 0x006B, 0x006C, 0x006D, 0x006E, 0x006F, 0x0070,
+	0x0071, 0x0072, 0x00AA, 0x00BA, 0x00E6, 0x00B8, 0x00C6, 0x00A4,
+	0x00B5, 0x007E, 0x0073, 0x0074, 0x0075, 0x0076, 0x0077, 0x0078,
+	0x0079, 0x007A, 0x00A1, 0x00BF, 0x00D0, 0x00DD, 0x00DE, 0x00AE,
+	0x005E, 0x00A3, 0x00A5, 0x00B7, 0x00A9, 0x00A7, 0x00B6, 0x00BC,
+	0x00BD, 0x00BE, 0x005B, 0x005D, 0x00AF, 0x00A8, 0x00B4, 0x00D7,
+	0x007B, 0x0041, 0x0042, 0x0043, 0x0044, 0x0045, 0x0046, 0x0047,
+	0x0048, 0x0049, 0x00AD, 0x00F4, 0x00F6, 0x00F2, 0x00F3, 0x00F5,
+	0x007D, 0x004A, 0x004B, 0x004C, 0x004D, 0x004E, 0x004F, 0x0050,
+	0x0051, 0x0052, 0x00B9, 0x00FB, 0x00FC, 0x00F9, 0x00FA, 0x00FF,
+	0x005C, 0x00F7, 0x0053, 0x0054, 0x0055, 0x0056, 0x0057, 0x0058,
+	0x0059, 0x005A, 0x00B2, 0x00D4, 0x00D6, 0x00D2, 0x00D3, 0x00D5,
+	0x0030, 0x0031, 0x0032, 0x0033, 0x0034, 0x0035, 0x0036, 0x0037,
+	0x0038, 0x0039, 0x00B3, 0x00DB, 0x00DC, 0x00D9, 0x00DA, 0x009F,
+}
+
+// fold converts a statement to canonical (upper) case; titles and comments
+// (NOTE) keep their spelling. The original spelling of names in equations
+// and tables is recorded for output and diagnostics.
+func (mdl *Model) fold(mode, stmt string) string {
+	switch mode {
+	case "*", "NOTE":
+		return stmt
+	case "L", "R", "C", "N", "A", "S", "T", "T2":
+		mdl.spell(stmt)
+	}
+	return toUpper(stmt)
+}
+
+// spell records the original spelling of the names in a statement (the
+// first spelling of a name is kept). Time indices are not part of a name.
+func (mdl *Model) spell(stmt string) {
+	isAlpha := func(c byte) bool {
+		return c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+	}
+	isDigit := func(c byte) bool {
+		return c >= '0' && c <= '9'
+	}
+	for i := 0; i < len(stmt); {
+		c := stmt[i]
+		switch {
+		case c == '"':
+			// skip quoted strings
+			end := strings.IndexByte(stmt[i+1:], '"')
+			if end == -1 {
+				return
+			}
+			i += end + 2
+		case c == '@':
+			// skip file references
+			end := strings.IndexByte(stmt[i+1:], ' ')
+			if end == -1 {
+				return
+			}
+			i += end + 2
+		case isDigit(c) || (c == '.' && i+1 < len(stmt) && isDigit(stmt[i+1])):
+			// skip numbers (with exponents)
+			for i < len(stmt) && (isDigit(stmt[i]) || stmt[i] == '.') {
+				i++
+			}
+			if i < len(stmt) && (stmt[i] == 'E' || stmt[i] == 'e') {
+				if i++; i < len(stmt) && (stmt[i] == '+' || stmt[i] == '-') {
+					i++
+				}
+				for i < len(stmt) && isDigit(stmt[i]) {
+					i++
+				}
+			}
+		case isAlpha(c):
+			start := i
+			for i < len(stmt) && (isAlpha(stmt[i]) || isDigit(stmt[i]) || stmt[i] == '.') {
+				i++
+			}
+			name := strings.TrimRight(stmt[start:i], ".")
+			if pos := strings.LastIndex(name, "."); pos != -1 && isIndex(strings.ToUpper(name[pos+1:])) {
+				name = name[:pos]
+			}
+			key := strings.ToUpper(name)
+			if _, ok := mdl.casing[key]; !ok && key != name {
+				mdl.casing[key] = name
+			}
+		default:
+			i++
+		}
+	}
+}
+
+// Spelling returns the original spelling of a (folded) name as used in
+// the model source.
+func (mdl *Model) Spelling(name string) string {
+	if s, ok := mdl.casing[name]; ok {
+		return s
+	}
+	return name
+}
+
+// toUpper converts a source line to upper case. File references (starting
+// with '@') and quoted strings are kept as-is, as file names can be
+// case-sensitive.
+func toUpper(s string) string {
+	var (
+		buf    strings.Builder
+		ref    bool // inside file reference
+		quoted bool // inside quoted string
+	)
+	for _, r := range s {
+		switch {
+		case quoted:
+			quoted = (r != '"')
+		case ref:
+			ref = (r != ' ' && r != '\t')
+		case r == '"':
+			quoted = true
+		case r == '@':
+			ref = true
+		default:
+			r = unicode.ToUpper(r)
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
@@ -22,9 +22,8 @@ package dynamo
 
 import (
 	"fmt"
+	"io"
 	"math"
-	"os"
-	"strconv"
 	"strings"
 )
 
@@ -51,6 +50,12 @@ type PlotGroup struct {
 	Min, Max   float64  // plot range
 	ValidRange bool     // is plot range valid?
 	Vars       []string // list of vars in this range
+
+	fixed   bool               // plot range is defined in PLOT statement
+	stacked bool               // values are stacked (area plot)
+	labels  []string           // labels in group (with selectors)
+	sels    map[string]rune    // plot symbols of selectors
+	factors map[string]float64 // scaling factors of variables (or selectors)
 }
 
 // NewPlotGroup creates a new (empty) plot group
@@ -64,11 +69,77 @@ func NewPlotGroup() *PlotGroup {
 	}
 }
 
+// expand selectors in the group (at the start of a run)
+func (pg *PlotGroup) expand(plt *Plotter) {
+	if len(pg.sels) == 0 {
+		return
+	}
+	if pg.labels == nil {
+		pg.labels = pg.Vars
+	}
+	pg.Vars = make([]string, 0, len(pg.labels))
+	for _, label := range pg.labels {
+		sym, ok := pg.sels[label]
+		if !ok {
+			pg.Vars = append(pg.Vars, label)
+			continue
+		}
+		for _, name := range plt.mdl.selectNames(label) {
+			if _, ok := plt.vars[name]; !ok {
+				plt.vars[name] = sym
+			}
+			if f, ok := pg.factors[label]; ok {
+				pg.factors[name] = f
+			}
+			pg.Vars = append(pg.Vars, name)
+		}
+	}
+}
+
 // Norm returns the position of the y-value on the axis [0,1]
 func (pg *PlotGroup) Norm(y float64) float64 {
 	return (y - pg.Min) / (pg.Max - pg.Min)
 }
 
+// Factor returns the scaling factor of a variable in the group.
+func (pg *PlotGroup) Factor(name string) float64 {
+	if f, ok := pg.factors[name]; ok {
+		return f
+	}
+	return 1
+}
+
+// Values returns the (scaled) y-values of the variables in the group at
+// a plotted point; the values of a stacked group are accumulated.
+func (pg *PlotGroup) Values(run *PlotRun, i int) []float64 {
+	ys := make([]float64, len(pg.Vars))
+	sum := 0.
+	for j, name := range pg.Vars {
+		y := pg.Factor(name) * run.Vars[name].Values[i]
+		if pg.stacked {
+			sum += y
+			y = sum
+		}
+		ys[j] = y
+	}
+	return ys
+}
+
+// Label returns the label of a variable in the group (with scaling
+// factor).
+func (pg *PlotGroup) Label(name string) string {
+	if f, ok := pg.factors[name]; ok {
+		return fmt.Sprintf("%s*%G", name, f)
+	}
+	return name
+}
+
+// label returns the label of a variable in a plot group (in the original
+// spelling of the variable).
+func (plt *Plotter) label(grp *PlotGroup, name string) string {
+	return plt.mdl.Spelling(name) + strings.TrimPrefix(grp.Label(name), name)
+}
+
 //----------------------------------------------------------------------
 // Plot jobs
 //----------------------------------------------------------------------
@@ -103,84 +174,261 @@ const (
 	PLT_GNUPLOT        // GNUplot script
 )
 
-// Plotter to generate graphs from DYNAMO data
+// PlotRun holds the plotted values of a model run. Each run owns its
+// values: a rerun (after EDIT) starts with a new instance, so values of
+// different runs are never mixed in a plot.
+type PlotRun struct {
+	RunID string              // identifier of model run
+	Vars  map[string]*PlotVar // plotted variables (with values)
+	X     []float64           // x-values (TIME) of plotted points
+	Num   int                 // number of plotted points
+
+	next float64 // time of next plotted point
+}
+
+// NewPlotRun creates a new (empty) plot run for the named variables
+// (with plot symbols).
+func NewPlotRun(runID string, syms map[string]rune) *PlotRun {
+	run := &PlotRun{
+		RunID: runID,
+		Vars:  make(map[string]*PlotVar),
+	}
+	for name, sym := range syms {
+		run.Vars[name] = &PlotVar{
+			TSVar: TSVar{
+				Name:   name,
+				Values: make([]float64, 0),
+			},
+			Sym: sym,
+		}
+	}
+	return run
+}
+
+// PlotRenderer renders the values collected by a plotter into a plot
+// output. All renderers of a plotter share the collected values, so a run
+// can be plotted in different formats at the same time.
+type PlotRenderer interface {
+	// Start is called at the start of each plotted run.
+	Start(plt *Plotter) *Result
+	// Render a plot job with the values of the current (or last) run.
+	Render(plt *Plotter, pj *PlotJob) *Result
+	// Close the plot output.
+	Close() *Result
+}
+
+// NewPlotRenderer returns a renderer for a plot file; the plotting mode
+// is determined from the file name (classic DYNAMO plots by default).
+func NewPlotRenderer(file string) PlotRenderer {
+	pf := &plotFile{name: file, base: file}
+	if pos := strings.LastIndex(file, "."); pos != -1 {
+		pf.base = file[:pos]
+		if strings.ToUpper(file[pos:]) == ".GNUPLOT" {
+			return &gnuRenderer{plotFile: pf}
+		}
+	}
+	return &dynRenderer{plotFile: pf}
+}
+
+// plotFile is the output file of a renderer.
+type plotFile struct {
+	name string         // name of plot file
+	base string         // name of plot file (without extension)
+	file io.WriteCloser // plot file (opened at the start of a run)
+}
+
+// open the plot file (if not opened before).
+func (pf *plotFile) open() *Result {
+	if pf.file != nil {
+		return Success()
+	}
+	f, err := createFile(pf.name)
+	if err != nil {
+		return Failure(err)
+	}
+	pf.file = f
+	return Success()
+}
+
+// Close the plot file.
+func (pf *plotFile) Close() (res *Result) {
+	res = Success()
+	if pf.file != nil {
+		if err := pf.file.Close(); err != nil {
+			res = Failure(err)
+		}
+		pf.file = nil
+	}
+	return
+}
+
+// dynRenderer renders classic DYNAMO plots (PLT_DYNAMO).
+type dynRenderer struct {
+	*plotFile
+}
+
+// Start a plotted run.
+func (r *dynRenderer) Start(plt *Plotter) *Result {
+	return r.open()
+}
+
+// Render a plot job.
+func (r *dynRenderer) Render(plt *Plotter, pj *PlotJob) *Result {
+	return plt.plot_dyn(pj, r.file)
+}
+
+// gnuRenderer renders GNUplot scripts (PLT_GNUPLOT).
+type gnuRenderer struct {
+	*plotFile
+	processed int // number of processed jobs
+}
+
+// Start a plotted run.
+func (r *gnuRenderer) Start(plt *Plotter) (res *Result) {
+	if res = r.open(); !res.Ok {
+		return
+	}
+	// "plot" information shared by all jobs
+	if r.processed == 0 {
+		// set line styles for plotting
+		io.WriteString(r.file, "set style line 1 lc rgb '#ff0000' lt 1 lw 2 pi -1 ps 1.0\n")
+		io.WriteString(r.file, "set style line 2 lc rgb '#00ff00' lt 1 lw 2 pi -1 ps 1.0\n")
+		io.WriteString(r.file, "set style line 3 lc rgb '#0000ff' lt 1 lw 2 pi -1 ps 1.0\n")
+		io.WriteString(r.file, "set style line 4 lc rgb '#ff00ff' lt 1 lw 2 pi -1 ps 1.0\n")
+		io.WriteString(r.file, "set style line 5 lc rgb '#00ffff' lt 1 lw 2 pi -1 ps 1.0\n")
+		io.WriteString(r.file, "set style line 6 lc rgb '#ff0000' lt 1 dt(5,5) lw 2 pi -1 ps 1.0\n")
+		io.WriteString(r.file, "set style line 7 lc rgb '#00ff00' lt 1 dt(5,5) lw 2 pi -1 ps 1.0\n")
+		io.WriteString(r.file, "set style line 8 lc rgb '#0000ff' lt 1 dt(5,5) lw 2 pi -1 ps 1.0\n")
+		io.WriteString(r.file, "set style line 9 lc rgb '#ff00ff' lt 1 dt(5,5) lw 2 pi -1 ps 1.0\n")
+		io.WriteString(r.file, "set style line 10 lc rgb '#00ffff' lt 1 dt(5,5) lw 2 pi -1 ps 1.0\n")
+	}
+	return
+}
+
+// Render a plot job.
+func (r *gnuRenderer) Render(plt *Plotter, pj *PlotJob) *Result {
+	r.processed++
+	title := fmt.Sprintf("%s (%s)", plt.mdl.RunID, plt.mdl.Title)
+	return plt.plot_gnu(pj, r.file, r.base, r.processed, title)
+}
+
+// Plotter collects the values of plotted variables in a run; graphs are
+// generated from the collected values by renderers (if defined).
 type Plotter struct {
-	file      *os.File            // reference to debug file (or nil if not defined)
-	base      string              // name of plot file (without extension)
-	mode      int                 // plotting mode (PLT_????)
-	mdl       *Model              // back-ref to model instance
-	steps     int                 // number of DT steps between plotting points
-	vars      map[string]*PlotVar // variables to use in graphs
-	x0        float64             // first x position
-	dx        float64             // x-step
-	xnum      int                 // number of x-values
-	jobs      []*PlotJob          // list of plot jobs to perform
-	add       bool                // plotter is adding jobs
-	processed int                 // number of processed jobs
-}
-
-// NewPlotter instantiates a new plotter output.
+	renderers []PlotRenderer  // list of plot outputs
+	sinks     backendList     // list of custom plot backends
+	stream    bool            // backends are started for the current run
+	mdl       *Model          // back-ref to model instance
+	vars      map[string]rune // variables to use in graphs (with symbol)
+	run       *PlotRun        // plotted values of current (or last) run
+	jobs      []*PlotJob      // list of plot jobs to perform
+	add       bool            // plotter is adding jobs
+	dists     []*distJob      // distribution plots of sweeps
+}
+
+// NewPlotter instantiates a new plotter output. The plot file is created
+// at the start of the first run.
 func NewPlotter(file string, mdl *Model) *Plotter {
-	// determine plotting mode from file name
-	mode := PLT_DYNAMO
-	pos := strings.LastIndex(file, ".")
-	if pos != -1 {
-		switch strings.ToUpper(file[pos:]) {
-		case ".PLT":
-			mode = PLT_DYNAMO
-		case ".GNUPLOT":
-			mode = PLT_GNUPLOT
-		}
-	}
-	// create new plotting instance
 	plt := &Plotter{
-		mdl:       mdl,
-		mode:      mode,
-		vars:      make(map[string]*PlotVar),
-		xnum:      0,
-		jobs:      make([]*PlotJob, 0),
-		add:       true,
-		processed: 0,
+		mdl:  mdl,
+		vars: make(map[string]rune),
+		jobs: make([]*PlotJob, 0),
+		add:  true,
 	}
+	plt.setTarget(file)
+	return plt
+}
+
+// setTarget replaces all plot outputs with a plot file (or no file if the
+// name is empty).
+func (plt *Plotter) setTarget(file string) {
+	plt.renderers, plt.sinks = nil, nil
+	plt.addTarget(file)
+}
+
+// addTarget adds a plot file or a custom backend registered for the
+// target (if the name is not empty).
+func (plt *Plotter) addTarget(file string) {
 	if len(file) == 0 {
-		plt.file = nil
-	} else {
-		plt.base = file[:pos]
-		var err error
-		if plt.file, err = os.Create(file); err != nil {
-			Fatal(err.Error())
-		}
+		return
 	}
-	return plt
+	if be := newPlotBackend(file); be != nil {
+		plt.sinks = append(plt.sinks, be)
+		return
+	}
+	plt.AddRenderer(NewPlotRenderer(file))
 }
 
-// Reset a plotter
-func (plt *Plotter) Reset() {
-	// clear time-series on PltVar
-	for _, v := range plt.vars {
-		v.Reset()
+// AddRenderer adds a plot output; all following runs are rendered by it.
+func (plt *Plotter) AddRenderer(r PlotRenderer) {
+	plt.renderers = append(plt.renderers, r)
+}
+
+// active returns true if the plotter has plot outputs or custom backends.
+func (plt *Plotter) active() bool {
+	return len(plt.renderers) > 0 || len(plt.sinks) > 0
+}
+
+// SetPlotTarget replaces the plot files between runs: the current plot
+// files are closed and the new file (or no file if the name is empty) is
+// created at the start of the next run.
+func (mdl *Model) SetPlotTarget(file string) *Result {
+	if mdl.run != nil {
+		return Failure(ErrModelRunning)
+	}
+	if res := mdl.Plot.Close(); !res.Ok {
+		return res
+	}
+	mdl.Plot.setTarget(file)
+	return Success()
+}
+
+// AddPlotTarget adds a plot file between runs: all following runs are
+// plotted to the new file in addition to the existing plot files. The
+// plotting mode is determined from the file name.
+func (mdl *Model) AddPlotTarget(file string) *Result {
+	if mdl.run != nil {
+		return Failure(ErrModelRunning)
 	}
+	mdl.Plot.addTarget(file)
+	return Success()
+}
+
+// Reset a plotter (when editing a model): the values of the last run are
+// dropped and the next PLOT statement replaces the existing plot jobs.
+func (plt *Plotter) Reset() {
+	plt.run = nil
 	plt.add = false
-	plt.xnum = 0
 }
 
 // Generate plot output.
 func (plt *Plotter) Generate() *Result {
-	if plt.file != nil {
+	if plt.stream {
+		plt.stream = false
+		if res := plt.sinks.finish(); !res.Ok {
+			return res
+		}
+	}
+	if plt.active() && plt.run != nil && plt.run.Num > 0 {
 		// do the actual plotting
 		return plt.plot()
 	}
 	return Success()
 }
 
-// Close plotter if model run is complete
+// Close plotter if model run is complete; following runs are not plotted
+// (unless a new plot file is set).
 func (plt *Plotter) Close() (res *Result) {
 	res = Success()
-	if plt.file != nil {
-		if err := plt.file.Close(); err != nil {
-			res = Failure(err)
+	for _, r := range plt.renderers {
+		if rc := r.Close(); !rc.Ok && res.Ok {
+			res = rc
 		}
 	}
+	if rc := plt.sinks.close(); !rc.Ok && res.Ok {
+		res = rc
+	}
+	plt.renderers, plt.sinks, plt.stream = nil, nil, false
 	return
 }
 
@@ -190,7 +438,7 @@ func (plt *Plotter) Prepare(stmt string) (res *Result) {
 
 	// if we do not add jobs, clear exisiting jobs and vars
 	if !plt.add {
-		plt.vars = make(map[string]*PlotVar)
+		plt.vars = make(map[string]rune)
 		plt.jobs = make([]*PlotJob, 0)
 		plt.add = true
 	}
@@ -200,7 +448,6 @@ func (plt *Plotter) Prepare(stmt string) (res *Result) {
 	plt.jobs = append(plt.jobs, pj)
 
 	// split into groups with same scale first
-	var err error
 	for _, grp := range strings.Split(stmt, "/") {
 		// each group is a PlotGroup instance
 		pg := NewPlotGroup()
@@ -208,31 +455,61 @@ func (plt *Plotter) Prepare(stmt string) (res *Result) {
 		// get scale for group
 		if pos := strings.Index(grp, "("); pos != -1 {
 			scale := strings.Split(strings.Trim(grp[pos:], "()"), ",")
-			if pg.Min, err = strconv.ParseFloat(scale[0], 64); err != nil {
+			var ok bool
+			if pg.Min, ok = parseNumber(scale[0]); !ok {
 				return Failure(ErrParseNotANumber+": '%s'", scale[0])
 			}
-			if pg.Max, err = strconv.ParseFloat(scale[1], 64); err != nil {
+			if pg.Max, ok = parseNumber(scale[1]); !ok {
 				return Failure(ErrParseNotANumber+": '%s'", scale[1])
 			}
 			grp = grp[:pos]
 			// plot range in group instance is valid
-			pg.ValidRange = true
+			pg.ValidRange, pg.fixed = true, true
+		}
+		// get members of group: members separated by '+' are stacked
+		// (like "PLOT A=1+B=2+C=3")
+		members := splitStacked(grp)
+		if len(members) > 1 {
+			if strings.Contains(grp, ",") {
+				return Failure(ErrParseSyntax+": mixed stacked group '%s'", grp)
+			}
+			pg.stacked = true
+		} else {
+			members = strings.Split(grp, ",")
 		}
-		// get members of group
-		for _, def := range strings.Split(grp, ",") {
+		for _, def := range members {
 			x := strings.Split(def, "=")
-			if len(x) != 2 {
+			if len(x) != 2 || len(x[1]) == 0 {
 				res = Failure(ErrParseSyntax+": '%s'", def)
 				return
 			}
-			pv := &PlotVar{
-				TSVar: TSVar{
-					Name:   x[0],
-					Values: make([]float64, 0),
-				},
-				Sym: []rune(x[1])[0],
+			// optional scaling factor after the plot symbol ("P*1E-6")
+			if sym := []rune(x[1]); len(sym) > 1 {
+				if sym[1] != '*' {
+					return Failure(ErrParseSyntax+": '%s'", def)
+				}
+				f, ok := parseNumber(string(sym[2:]))
+				if !ok || f == 0 {
+					return Failure(ErrParseNotANumber+": '%s'", string(sym[2:]))
+				}
+				if pg.factors == nil {
+					pg.factors = make(map[string]float64)
+				}
+				pg.factors[x[0]] = f
+			}
+			if isSelector(x[0]) {
+				// selectors are expanded at the start of a run
+				if res = checkSelector(x[0]); !res.Ok {
+					return
+				}
+				if pg.sels == nil {
+					pg.sels = make(map[string]rune)
+				}
+				pg.sels[x[0]] = []rune(x[1])[0]
+				pg.Vars = append(pg.Vars, x[0])
+				continue
 			}
-			plt.vars[x[0]] = pv
+			plt.vars[x[0]] = []rune(x[1])[0]
 			// add member to group
 			pg.Vars = append(pg.Vars, x[0])
 		}
@@ -240,91 +517,148 @@ func (plt *Plotter) Prepare(stmt string) (res *Result) {
 	return
 }
 
-// Start a new plot
+// splitStacked splits a group into stacked members (separated by '+' that
+// is not part of a number like "1E+6").
+func splitStacked(grp string) (list []string) {
+	start := 0
+	for i := 1; i < len(grp)-1; i++ {
+		if c := grp[i+1]; grp[i] == '+' && (c == '*' || c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')) {
+			list = append(list, grp[start:i])
+			start = i + 1
+		}
+	}
+	return append(list, grp[start:])
+}
+
+// Start a new plot: a new plot run is started for the plotted variables.
 func (plt *Plotter) Start() (res *Result) {
+	// start plot outputs (files are created on first run)
 	res = Success()
-	if plt.file != nil {
-		// get plot stepping
-		x0, ok := plt.mdl.Current["TIME"]
-		if !ok {
-			return Failure(ErrModelMissingDef + ": TIME")
+	for _, r := range plt.renderers {
+		if res = r.Start(plt); !res.Ok {
+			return
 		}
-		pp, ok := plt.mdl.Current["PLTPER"]
-		if !ok {
-			return Failure(ErrModelMissingDef + ": PLTPER")
+	}
+	// expand selectors (and drop variables of earlier expansions); plot
+	// ranges not defined in PLOT statements are computed for each run.
+	used := make(map[string]bool)
+	for _, pj := range plt.jobs {
+		for _, grp := range pj.grps {
+			if !grp.fixed {
+				grp.Min, grp.Max, grp.ValidRange = 0, 0, false
+			}
+			grp.expand(plt)
+			for _, name := range grp.Vars {
+				used[name] = true
+			}
 		}
-		dt, ok := plt.mdl.Current["DT"]
-		if !ok {
-			return Failure(ErrModelMissingDef + ": DT")
-		}
-		steps := int(pp / dt)
-		if compare(float64(pp), float64(steps)*float64(dt)) != 0 {
-			Msgf("WARNING: PLTPER != n * DT")
-		}
-		plt.x0 = float64(x0)
-		plt.dx = float64(pp)
-		plt.steps = steps
-
-		// "plot" information shared by all jobs
-		if plt.mode == PLT_GNUPLOT && plt.processed == 0 {
-			// set line styles for plotting
-			plt.file.WriteString("set style line 1 lc rgb '#ff0000' lt 1 lw 2 pi -1 ps 1.0\n")
-			plt.file.WriteString("set style line 2 lc rgb '#00ff00' lt 1 lw 2 pi -1 ps 1.0\n")
-			plt.file.WriteString("set style line 3 lc rgb '#0000ff' lt 1 lw 2 pi -1 ps 1.0\n")
-			plt.file.WriteString("set style line 4 lc rgb '#ff00ff' lt 1 lw 2 pi -1 ps 1.0\n")
-			plt.file.WriteString("set style line 5 lc rgb '#00ffff' lt 1 lw 2 pi -1 ps 1.0\n")
-			plt.file.WriteString("set style line 6 lc rgb '#ff0000' lt 1 dt(5,5) lw 2 pi -1 ps 1.0\n")
-			plt.file.WriteString("set style line 7 lc rgb '#00ff00' lt 1 dt(5,5) lw 2 pi -1 ps 1.0\n")
-			plt.file.WriteString("set style line 8 lc rgb '#0000ff' lt 1 dt(5,5) lw 2 pi -1 ps 1.0\n")
-			plt.file.WriteString("set style line 9 lc rgb '#ff00ff' lt 1 dt(5,5) lw 2 pi -1 ps 1.0\n")
-			plt.file.WriteString("set style line 10 lc rgb '#00ffff' lt 1 dt(5,5) lw 2 pi -1 ps 1.0\n")
+	}
+	for name := range plt.vars {
+		if !used[name] {
+			delete(plt.vars, name)
+		}
+	}
+	plt.run = NewPlotRun(plt.mdl.RunID, plt.vars)
+	plt.run.next = float64(plt.mdl.Current["TIME"])
+	if plt.active() {
+		if res = plt.mdl.checkOutputPeriod("PLTPER"); !res.Ok {
+			return
 		}
 	}
+	// start custom backends (if values are plotted)
+	plt.stream = false
+	if len(plt.sinks) > 0 && len(plt.jobs) > 0 {
+		names := []string{"TIME"}
+		for name := range plt.run.Vars {
+			names = append(names, name)
+		}
+		if res = plt.sinks.start(plt.run.RunID, names); !res.Ok {
+			return
+		}
+		plt.stream = true
+	}
 	return
 }
 
+// output returns true if values are plotted in the current epoch.
+func (plt *Plotter) output() bool {
+	return plt.active() && plt.run != nil && plt.mdl.outputDue("PLTPER", plt.run.next)
+}
+
 // Add a new set of results in this epoch.
 func (plt *Plotter) Add(epoch int) (res *Result) {
 	res = Success()
-	if plt.file != nil {
-		// check for output epoch
-		if plt.steps > 1 && epoch%plt.steps != 1 {
-			return
-		}
+	if plt.output() {
 		// get values for graphed variables
-		for name, pv := range plt.vars {
+		values := State{"TIME": plt.mdl.Current["TIME"]}
+		for name, pv := range plt.run.Vars {
 			val, ok := plt.mdl.Current[name]
 			if !ok {
 				return Failure(ErrModelNoVariable+": %s [Plotter]", name)
 			}
 			pv.Add(float64(val))
+			values[name] = val
 		}
-		plt.xnum++
+		if plt.stream {
+			if res = plt.sinks.add(values); !res.Ok {
+				return
+			}
+		}
+		t := float64(plt.mdl.Current["TIME"])
+		plt.run.X = append(plt.run.X, t)
+		plt.run.Num++
+		plt.run.next = t + float64(plt.mdl.Current["PLTPER"])
 	}
 	return
 }
 
+// truncate collected data to n points
+func (plt *Plotter) truncate(n int) {
+	if plt.run == nil {
+		return
+	}
+	for _, pv := range plt.run.Vars {
+		pv.Truncate(n)
+	}
+	plt.run.Num = n
+	plt.run.next = float64(plt.mdl.Current["TIME"])
+	if n < len(plt.run.X) {
+		plt.run.X = plt.run.X[:n]
+	}
+	if n > 0 {
+		plt.run.next = plt.run.X[n-1] + float64(plt.mdl.Current["PLTPER"])
+	}
+}
+
 // Plot the collected data
 func (plt *Plotter) plot() (res *Result) {
 	res = Success()
 
-	Msgf("      Generating plot(s)...")
+	Log(LOG_INFO, LOG_OUTPUT, "      Generating plot(s)...")
 	for _, pj := range plt.jobs {
-		// increment 'processed' counter
-		plt.processed++
-
 		// compute range for each plot group (if not defined in PLOT statement)
 		for _, grp := range pj.grps {
 			if grp.ValidRange {
 				continue
 			}
 			for _, name := range grp.Vars {
-				pv, ok := plt.vars[name]
+				pv, ok := plt.run.Vars[name]
 				if !ok {
 					return Failure(ErrPlotNoVar+": %s", name)
 				}
-				grp.Min = math.Min(grp.Min, pv.Min)
-				grp.Max = math.Max(grp.Max, pv.Max)
+				// scaled values (a negative factor swaps the range)
+				f := grp.Factor(name)
+				grp.Min = math.Min(grp.Min, math.Min(f*pv.Min, f*pv.Max))
+				grp.Max = math.Max(grp.Max, math.Max(f*pv.Min, f*pv.Max))
+			}
+			if grp.stacked {
+				// range of accumulated values
+				for i := range plt.run.X {
+					for _, y := range grp.Values(plt.run, i) {
+						grp.Min = math.Min(grp.Min, y)
+						grp.Max = math.Max(grp.Max, y)
+					}
+				}
 			}
 			grp.ValidRange = true
 
@@ -342,15 +676,13 @@ func (plt *Plotter) plot() (res *Result) {
 				w += w0
 			}
 		}
-		// now do the actual plotting
-		switch plt.mode {
-		case PLT_DYNAMO:
-			res = plt.plot_dyn(pj)
-		case PLT_GNUPLOT:
-			title := fmt.Sprintf("%s (%s)", plt.mdl.RunID, plt.mdl.Title)
-			res = plt.plot_gnu(pj, plt.processed, title)
-		default:
-			res = Failure(ErrPlotMode)
+	}
+	// now do the actual plotting (for all outputs)
+	for _, r := range plt.renderers {
+		for _, pj := range plt.jobs {
+			if res = r.Render(plt, pj); !res.Ok {
+				return
+			}
 		}
 	}
 	return
@@ -361,7 +693,7 @@ func (plt *Plotter) plot() (res *Result) {
 //----------------------------------------------------------------------
 
 // Plot in classic DYNAMO style (ASCII plot on a line printer)
-func (plt *Plotter) plot_dyn(pj *PlotJob) *Result {
+func (plt *Plotter) plot_dyn(pj *PlotJob, out io.Writer) *Result {
 
 	// make horizontal plot line without graph
 	mkLine := func(x float64, i int) string {
@@ -379,26 +711,35 @@ func (plt *Plotter) plot_dyn(pj *PlotJob) *Result {
 			}
 		}
 		if i%10 == 0 {
+			if cal := plt.mdl.Calendar; cal.Valid() {
+				return fmt.Sprintf("%9s %s", cal.Label(x), line)
+			}
 			return fmt.Sprintf("%9.3f %s", x, line)
 		}
 		return fmt.Sprintf("          %s", line)
 	}
 
 	// emit plot header
-	fmt.Fprintf(plt.file, "\n\n")
-	fmt.Fprintf(plt.file, "Plot for '%s'\n", plt.mdl.RunID)
-	fmt.Fprintf(plt.file, "         %s\n", pj.stmt)
-	fmt.Fprintln(plt.file)
+	fmt.Fprintf(out, "\n\n")
+	fmt.Fprintf(out, "Plot for '%s'\n", plt.mdl.RunID)
+	fmt.Fprintf(out, "         %s\n", pj.stmt)
+	fmt.Fprintln(out)
+	if lines := plt.mdl.provenanceLines(""); len(lines) > 0 {
+		for _, line := range lines {
+			fmt.Fprintln(out, line)
+		}
+		fmt.Fprintln(out)
+	}
 
 	// emit plot y-axis (multiple scales; one per plot group)
 	for _, grp := range pj.grps {
 		s := ""
 		for _, v := range grp.Vars {
-			pv := plt.vars[v]
+			pv := plt.run.Vars[v]
 			if len(s) > 0 {
 				s += ","
 			}
-			s += fmt.Sprintf("%s=%c", pv.Name, pv.Sym)
+			s += fmt.Sprintf("%s=%c", plt.label(grp, pv.Name), pv.Sym)
 		}
 		w := (grp.Max - grp.Min) / 4.
 		f := int(math.Floor((math.Log10(w)) / 3))
@@ -407,18 +748,20 @@ func (plt *Plotter) plot_dyn(pj *PlotJob) *Result {
 		y2 := FormatNumber(grp.Min+2*w, f)
 		y3 := FormatNumber(grp.Min+3*w, f)
 		y4 := FormatNumber(grp.Max, f)
-		fmt.Fprintf(plt.file, "%14s%25s%25s%25s%25s %s\n", y0, y1, y2, y3, y4, s)
+		fmt.Fprintf(out, "%14s%25s%25s%25s%25s %s\n", y0, y1, y2, y3, y4, s)
 	}
 	// draw graph
-	for x, i := plt.x0, 0; i < plt.xnum; x, i = x+plt.dx, i+1 {
+	for i, x := range plt.run.X {
 		line := []rune(mkLine(x, i))
 		overlap := make(map[int]string)
 		for _, grp := range pj.grps {
-			for _, v := range grp.Vars {
-				pv := plt.vars[v]
-				pos := int(math.Round(100*grp.Norm(pv.Values[i]))) + 10
+			ys := grp.Values(plt.run, i)
+			for j, v := range grp.Vars {
+				pv := plt.run.Vars[v]
+				y := ys[j]
+				pos := int(math.Round(100*grp.Norm(y))) + 10
 				if pos < 10 || pos > 110 {
-					Msgf("y=%f, range=(%f,%f)\n", pv.Values[i], grp.Min, grp.Max)
+					Logf(LOG_WARN, LOG_OUTPUT, "Value out of plot range: y=%f, range=(%f,%f)\n", y, grp.Min, grp.Max)
 					continue
 				}
 				if _, ok := overlap[pos]; ok {
@@ -435,13 +778,13 @@ func (plt *Plotter) plot_dyn(pj *PlotJob) *Result {
 				olMap += " " + ol
 			}
 		}
-		fmt.Fprintln(plt.file, string(line)+olMap)
+		fmt.Fprintln(out, string(line)+olMap)
 	}
 	return Success()
 }
 
 // Generate GNUplot script for output
-func (plt *Plotter) plot_gnu(pj *PlotJob, num int, title string) *Result {
+func (plt *Plotter) plot_gnu(pj *PlotJob, out io.Writer, base string, num int, title string) *Result {
 
 	// assemble y-tics (multiple scales; one per plot group)
 	ytics := make([]string, 5)
@@ -462,55 +805,87 @@ func (plt *Plotter) plot_gnu(pj *PlotJob, num int, title string) *Result {
 		addScale(4, FormatNumber(grp.Max, f))
 	}
 	scales := float64(len(pj.grps))
-	// emit data
-	var list []string
-	fmt.Fprintf(plt.file, "$data_%d << EOD\n", num)
-	for x, i := plt.x0, 0; i < plt.xnum; x, i = x+plt.dx, i+1 {
-		fmt.Fprintf(plt.file, "%f", x)
+	// emit data (with lower bounds of stacked areas)
+	var list, labels, lower []string
+	for _, line := range plt.mdl.provenanceLines("# ") {
+		fmt.Fprintln(out, line)
+	}
+	fmt.Fprintf(out, "$data_%d << EOD\n", num)
+	for i, x := range plt.run.X {
+		fmt.Fprintf(out, "%f", x)
 		for _, grp := range pj.grps {
-			for _, v := range grp.Vars {
-				pv := plt.vars[v]
+			ys := grp.Values(plt.run, i)
+			for j, v := range grp.Vars {
 				if i == 0 {
 					list = append(list, v)
+					labels = append(labels, plt.label(grp, v))
+					low := ""
+					if grp.stacked {
+						// area between previous member (or zero) and value
+						low = fmt.Sprintf("(%f)", grp.Norm(0))
+						if j > 0 {
+							low = fmt.Sprint(len(list))
+						}
+					}
+					lower = append(lower, low)
 				}
-				fmt.Fprintf(plt.file, " %f", grp.Norm(pv.Values[i]))
+				fmt.Fprintf(out, " %f", grp.Norm(ys[j]))
 			}
 		}
-		fmt.Fprintln(plt.file)
+		fmt.Fprintln(out)
 	}
-	fmt.Fprintln(plt.file, "EOD")
+	fmt.Fprintln(out, "EOD")
 	offset := (scales-2)/20. + 0.1
 	if scales < 2 {
 		offset = 0.1
 	}
-	fmt.Fprintln(plt.file, "set key outside")
-	fmt.Fprintf(plt.file, "set title \"%s\"\n", title)
-	fmt.Fprintf(plt.file, "set lmargin screen %f\n", offset)
-	fmt.Fprintf(plt.file, "set xrange [%f:%f]\n", plt.x0, plt.x0+plt.dx*float64(plt.xnum-1))
-	fmt.Fprintf(plt.file, "set ytics rotate by 90 offset -%f (", scales+1)
+	fmt.Fprintln(out, "set key outside")
+	fmt.Fprintf(out, "set title \"%s\"\n", title)
+	fmt.Fprintf(out, "set lmargin screen %f\n", offset)
+	fmt.Fprintf(out, "set xrange [%f:%f]\n", plt.run.X[0], plt.run.X[plt.run.Num-1])
+	if cal := plt.mdl.Calendar; cal.Valid() {
+		// label x-axis with (up to 10) calendar dates
+		step := (plt.run.Num + 9) / 10
+		io.WriteString(out, "set xtics (")
+		for i := 0; i < plt.run.Num; i += step {
+			if i > 0 {
+				io.WriteString(out, ",")
+			}
+			x := plt.run.X[i]
+			fmt.Fprintf(out, "\"%s\" %f", cal.Label(x), x)
+		}
+		fmt.Fprintln(out, ")")
+		fmt.Fprintf(out, "set xlabel \"%s\"\n", cal.Unit)
+	}
+	fmt.Fprintf(out, "set ytics rotate by 90 offset -%f (", scales+1)
 	for i, yt := range ytics {
 		if i > 0 {
-			plt.file.WriteString(",")
+			io.WriteString(out, ",")
 		}
-		fmt.Fprintf(plt.file, "\"%s\" %f", yt, float64(i)/4.)
+		fmt.Fprintf(out, "\"%s\" %f", yt, float64(i)/4.)
 	}
-	fmt.Fprintln(plt.file, ")")
-	fmt.Fprintln(plt.file, "set yrange[0:1]")
-	fmt.Fprintln(plt.file, "set term svg size 700,500")
-	fmt.Fprintf(plt.file, "set output \"%s_(%d).svg\"\n", plt.base, num)
-	fmt.Fprintf(plt.file, "plot ")
+	fmt.Fprintln(out, ")")
+	fmt.Fprintln(out, "set yrange[0:1]")
+	fmt.Fprintln(out, "set term svg size 700,500")
+	fmt.Fprintf(out, "set output \"%s_(%d).svg\"\n", base, num)
+	fmt.Fprintf(out, "plot ")
 	for i, label := range list {
 		mode := fmt.Sprintf("with line ls %d", (i%10)+1)
-		pv := plt.vars[label]
+		pv := plt.run.Vars[label]
 		if strings.Contains("*#", string(pv.Sym)) {
 			mode = "with point"
 		}
 		if i > 0 {
-			plt.file.WriteString(",")
+			io.WriteString(out, ",")
+		}
+		if len(lower[i]) > 0 {
+			mode = fmt.Sprintf("with filledcurves fs transparent solid 0.5 ls %d", (i%10)+1)
+			fmt.Fprintf(out, "$data_%d using 1:%s:%d %s title \"%s\"", num, lower[i], i+2, mode, labels[i])
+			continue
 		}
-		fmt.Fprintf(plt.file, "$data_%d using 1:%d %s title \"%s\"", num, i+2, mode, label)
+		fmt.Fprintf(out, "$data_%d using 1:%d %s title \"%s\"", num, i+2, mode, labels[i])
 	}
-	fmt.Fprintln(plt.file)
+	fmt.Fprintln(out)
 	return Success()
 }
 
@@ -0,0 +1,230 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Numeric anomalies in model runs
+const (
+	ANOMALY_TABLE    = "TABLE"    // table argument out of range
+	ANOMALY_NEGATIVE = "NEGATIVE" // negative level (stock)
+	ANOMALY_NAN      = "NAN"      // value is not a number
+	ANOMALY_OVERFLOW = "OVERFLOW" // value is infinite
+)
+
+// anomalies is the list of all anomalies
+var anomalies = []string{ANOMALY_TABLE, ANOMALY_NEGATIVE, ANOMALY_NAN, ANOMALY_OVERFLOW}
+
+// Handling of anomalies
+const (
+	POLICY_CONTINUE = "CONTINUE" // ignore anomaly
+	POLICY_WARN     = "WARN"     // log a warning (first occurrence only)
+	POLICY_ABORT    = "ABORT"    // abort the model run
+	POLICY_CLAMP    = "CLAMP"    // clamp value to the valid range (and warn)
+)
+
+//----------------------------------------------------------------------
+// POLICY -- The run policy defines what happens on numeric anomalies:
+// table arguments out of range, negative levels, values that are not a
+// number (NaN) or infinite (overflow). An anomaly is ignored (CONTINUE),
+// logged on its first occurrence (WARN), aborts the run (ABORT) or the
+// value is clamped to the valid range (CLAMP): table arguments to the
+// table range, negative levels to zero, NaN to zero and infinite values
+// to the largest finite value. The policy is set in the API, with the
+// '-policy' option or in SPEC statements like 'SPEC NAN=ABORT'.
+//----------------------------------------------------------------------
+
+// Policy for the handling of numeric anomalies in model runs
+type Policy struct {
+	Actions map[string]string // handling of anomalies (ANOMALY_??? -> POLICY_???)
+
+	counts map[string]int // number of anomalies ("KIND:NAME") in run
+}
+
+// NewPolicy returns a policy that ignores all anomalies.
+func NewPolicy() *Policy {
+	p := &Policy{
+		Actions: make(map[string]string),
+		counts:  make(map[string]int),
+	}
+	for _, kind := range anomalies {
+		p.Actions[kind] = POLICY_CONTINUE
+	}
+	return p
+}
+
+// Set the policy from a specification like "NAN=ABORT,NEGATIVE=CLAMP";
+// the anomaly "ALL" sets the handling of all anomalies.
+func (p *Policy) Set(spec string) *Result {
+	for _, def := range strings.Split(spec, ",") {
+		def = strings.ToUpper(strings.TrimSpace(def))
+		if len(def) == 0 {
+			continue
+		}
+		x := strings.SplitN(def, "=", 2)
+		if len(x) != 2 {
+			return Failure(ErrModelPolicy+": %s", def)
+		}
+		if res := p.SetAction(x[0], x[1]); !res.Ok {
+			return res
+		}
+	}
+	return Success()
+}
+
+// SetAction sets the handling of an anomaly (or of "ALL" anomalies).
+func (p *Policy) SetAction(kind, action string) *Result {
+	kind, action = strings.ToUpper(kind), strings.ToUpper(action)
+	switch action {
+	case POLICY_CONTINUE, POLICY_WARN, POLICY_ABORT, POLICY_CLAMP:
+	default:
+		return Failure(ErrModelPolicy+": %s=%s", kind, action)
+	}
+	if kind == "ALL" {
+		for _, k := range anomalies {
+			p.Actions[k] = action
+		}
+		return Success()
+	}
+	if !isAnomaly(kind) {
+		return Failure(ErrModelPolicy+": %s=%s", kind, action)
+	}
+	p.Actions[kind] = action
+	return Success()
+}
+
+// isAnomaly returns true if the name is a known anomaly.
+func isAnomaly(kind string) bool {
+	for _, k := range anomalies {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// active returns true if any anomaly is not ignored.
+func (p *Policy) active() bool {
+	if p == nil {
+		return false
+	}
+	for _, action := range p.Actions {
+		if action != POLICY_CONTINUE {
+			return true
+		}
+	}
+	return false
+}
+
+// action returns the handling of an anomaly.
+func (p *Policy) action(kind string) string {
+	if p == nil {
+		return POLICY_CONTINUE
+	}
+	if action, ok := p.Actions[kind]; ok {
+		return action
+	}
+	return POLICY_CONTINUE
+}
+
+// anomaly handles an anomaly of a variable (or table) with given value
+// and returns the action to take (or a failure if the run is aborted).
+func (mdl *Model) anomaly(kind, name string, val float64) (action string, res *Result) {
+	p := mdl.Policy
+	action = p.action(kind)
+	if action == POLICY_CONTINUE {
+		return action, Success()
+	}
+	time := mdl.Current["TIME"]
+	if action == POLICY_ABORT {
+		return action, Failure(ErrModelAnomaly+": %s %s=%g at TIME %g", kind, name, val, time)
+	}
+	key := kind + ":" + name
+	if p.counts[key]++; p.counts[key] == 1 {
+		Logf(LOG_WARN, LOG_RUN, "%s anomaly: %s=%g at TIME %g\n", kind, name, val, time)
+	}
+	return action, Success()
+}
+
+// checkAnomalies checks the values computed by equations of given modes
+// for anomalies (NaN, overflow, negative levels).
+func (mdl *Model) checkAnomalies(modes string) (res *Result) {
+	res = Success()
+	if !mdl.Policy.active() {
+		return
+	}
+	var action string
+	for _, eqn := range mdl.run.eqns.List() {
+		if !strings.Contains(modes, eqn.Mode) {
+			continue
+		}
+		name := eqn.Target.Name
+		val := float64(mdl.Current[name])
+		kind, limit := "", 0.0
+		switch {
+		case math.IsNaN(val):
+			kind = ANOMALY_NAN
+		case math.IsInf(val, 0):
+			kind, limit = ANOMALY_OVERFLOW, math.Copysign(math.MaxFloat64, val)
+		case val < 0 && eqn.Mode == "L":
+			kind = ANOMALY_NEGATIVE
+		default:
+			continue
+		}
+		if action, res = mdl.anomaly(kind, name, val); !res.Ok {
+			return
+		}
+		if action == POLICY_CLAMP {
+			mdl.Current[name] = Variable(limit)
+		}
+	}
+	return
+}
+
+// reset the anomaly counters (at the start of a run).
+func (p *Policy) reset() {
+	if p != nil {
+		p.counts = make(map[string]int)
+	}
+}
+
+// reportAnomalies logs the number of anomalies in a run.
+func (mdl *Model) reportAnomalies() {
+	p := mdl.Policy
+	if p == nil {
+		return
+	}
+	keys := make([]string, 0, len(p.counts))
+	for key := range p.counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if n := p.counts[key]; n > 1 {
+			x := strings.SplitN(key, ":", 2)
+			Logf(LOG_WARN, LOG_RUN, "%s anomaly of %s in %d epochs\n", x[0], x[1], n)
+		}
+	}
+}
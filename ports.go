@@ -0,0 +1,115 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"strings"
+)
+
+//======================================================================
+// CO-SIMULATION PORTS -- A model can be embedded in an application that
+// runs the model step by step (Start, Step, Done, Finish). Between steps
+// the application sets input ports and reads output ports:
+//
+//     INPUT  DEMAND=10,PRICE=5
+//     OUTPUT SALES,INV
+//
+// Input ports are constants (with default values) that can be changed
+// during a run; output ports are variables of the model.
+//======================================================================
+
+// addInputs handles an INPUT statement
+func (mdl *Model) addInputs(stmt, comment string) (res *Result) {
+	if res = mdl.AddStatement(&Line{
+		Mode:    "C",
+		Stmt:    stmt,
+		Comment: comment,
+	}); !res.Ok {
+		return
+	}
+	for _, def := range strings.Split(strings.Replace(stmt, "/", ",", -1), ",") {
+		if pos := strings.Index(def, "="); pos != -1 {
+			mdl.Inputs = append(mdl.Inputs, def[:pos])
+		}
+	}
+	return
+}
+
+// addOutputs handles an OUTPUT statement
+func (mdl *Model) addOutputs(stmt string) (res *Result) {
+	res = Success()
+	for _, name := range strings.Split(stmt, ",") {
+		var n *Name
+		if n, res = NewNameFromString(name); !res.Ok {
+			return
+		}
+		if n.Kind != NAME_KIND_CONST {
+			return Failure(ErrParseInvalidName+": %s", name)
+		}
+		mdl.Outputs = append(mdl.Outputs, name)
+	}
+	return
+}
+
+// isPort checks if a name is in a list of ports
+func isPort(list []string, name string) bool {
+	for _, port := range list {
+		if port == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SetInput sets the value of an input port in a running model. The new
+// value is used in the next step.
+func (mdl *Model) SetInput(name string, val Variable) (res *Result) {
+	if !isPort(mdl.Inputs, name) {
+		return Failure(ErrModelNoPort+": %s", name)
+	}
+	if mdl.run == nil {
+		return Failure(ErrModelNotRunning)
+	}
+	mdl.Current[name] = val
+	if mdl.Replay != nil && !mdl.Replay.Replaying() {
+		return mdl.Replay.record(mdl.epoch(), REPLAY_INPUT, name, val)
+	}
+	return Success()
+}
+
+// ReadOutput returns the value of an output port as computed in the last
+// step of a model run (or the initial value if no step was computed yet).
+func (mdl *Model) ReadOutput(name string) (val Variable, res *Result) {
+	if !isPort(mdl.Outputs, name) {
+		res = Failure(ErrModelNoPort+": %s", name)
+		return
+	}
+	state := mdl.Last
+	if mdl.run != nil && mdl.run.epoch == 1 {
+		state = mdl.Current
+	}
+	var ok bool
+	if val, ok = state[name]; !ok {
+		res = Failure(ErrModelNoVariable+": %s", name)
+		return
+	}
+	return val, Success()
+}
@@ -22,8 +22,9 @@ package dynamo
 
 import (
 	"fmt"
+	"io"
 	"math"
-	"os"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -40,6 +41,7 @@ import (
 type PrintVar struct {
 	TSVar
 	Scale float64
+	Type  int // type of variable (TYPE_???)
 }
 
 // NewPrintVar creates a new named variable for print output.
@@ -53,15 +55,32 @@ func NewPrintVar(name string) *PrintVar {
 	}
 }
 
-// Calculate optimal scale of data series
+// Calculate optimal scale of data series: the scale is a power of 1000
+// (like in classic DYNAMO prints), so that all scaled values are in the
+// range [1,1000).
 func (pv *PrintVar) calcScale() {
+	pv.Scale = 1.0
 	max := math.Max(math.Abs(pv.Max), math.Abs(pv.Min))
 	if max > 0 {
-		x := int(math.Round(math.Log10(max))) - 2
-		if x > 0 {
-			pv.Scale = math.Pow10(x)
-		}
+		x := 3 * int(math.Floor(math.Log10(max)/3))
+		pv.Scale = math.Pow10(x)
+	}
+}
+
+// width returns the number of characters needed to print all (scaled)
+// values of the variable with given number of decimals.
+func (pv *PrintVar) width(prec int) int {
+	w := len(strconv.FormatFloat(pv.Max/pv.Scale, 'f', prec, 64))
+	if wMin := len(strconv.FormatFloat(pv.Min/pv.Scale, 'f', prec, 64)); wMin > w {
+		w = wMin
 	}
+	return w
+}
+
+// ScaleLabel returns the scale of the variable in 'E+03' notation.
+func (pv *PrintVar) ScaleLabel() string {
+	scale := fmt.Sprintf("%E", pv.Scale)
+	return scale[strings.LastIndex(scale, "E"):]
 }
 
 //----------------------------------------------------------------------
@@ -70,15 +89,14 @@ func (pv *PrintVar) calcScale() {
 
 // PrtCol has an ordered list of variables to appear in a column
 type PrtCol struct {
-	Vars  []string
-	Scale float64
+	Vars   []string
+	labels []string // labels in column (with selectors)
 }
 
 // NewPrtCol instantiates a new column (multi-label)
 func NewPrtCol() *PrtCol {
 	return &PrtCol{
-		Vars:  make([]string, 0),
-		Scale: -1.0,
+		Vars: make([]string, 0),
 	}
 }
 
@@ -88,13 +106,6 @@ func (pc *PrtCol) Add(name string) *PrtCol {
 	return pc
 }
 
-// Merge scales of sub-columns
-func (pc *PrtCol) mergeScale(scale float64) {
-	if scale > pc.Scale {
-		pc.Scale = scale
-	}
-}
-
 //----------------------------------------------------------------------
 // Print jobs
 //----------------------------------------------------------------------
@@ -104,6 +115,8 @@ type PrintJob struct {
 	stmt string          // PRINT statement
 	prt  *Printer        // printer instance
 	cols map[int]*PrtCol // print columns
+	prec map[string]int  // number of decimals for variables (optional)
+	flat []string        // labels of flat list of columns (with selectors)
 }
 
 // NewPrintJob creates a new print job for the printer based on
@@ -113,13 +126,165 @@ func NewPrintJob(stmt string, prt *Printer) *PrintJob {
 		stmt: stmt,
 		prt:  prt,
 		cols: make(map[int]*PrtCol, 1),
+		prec: make(map[string]int),
 	}
 	// Add TIME as first column
-	prt.vars["TIME"] = NewPrintVar("TIME")
+	prt.vars["TIME"] = true
 	pj.cols[0] = NewPrtCol().Add("TIME")
 	return pj
 }
 
+// Add a variable (with optional precision like "INV(4)") to the print job.
+// Returns the name of the variable.
+func (pj *PrintJob) add(label string) (name string, res *Result) {
+	res = Success()
+	name = label
+	if pos := strings.Index(label, "("); pos != -1 {
+		if !strings.HasSuffix(label, ")") {
+			res = Failure(ErrParseSyntax+": '%s'", label)
+			return
+		}
+		prec, err := strconv.Atoi(label[pos+1 : len(label)-1])
+		if err != nil || prec < 0 {
+			res = Failure(ErrParseNotANumber+": '%s'", label)
+			return
+		}
+		name = label[:pos]
+		pj.prec[name] = prec
+	}
+	if isSelector(name) {
+		// selectors are expanded at the start of a run
+		res = checkSelector(name)
+		return
+	}
+	pj.prt.vars[name] = true
+	return
+}
+
+// expand selectors in the columns of the print job (at the start of a run):
+// in a flat list of columns each selected variable gets its own column,
+// otherwise all selected variables are in the column of the selector.
+func (pj *PrintJob) expand() {
+	if pj.flat != nil {
+		pj.cols = map[int]*PrtCol{0: NewPrtCol().Add("TIME")}
+		for _, label := range pj.flat {
+			names := []string{label}
+			if isSelector(label) {
+				names = pj.prt.mdl.selectNames(label)
+			}
+			for _, name := range names {
+				pj.use(name, label)
+				pj.cols[len(pj.cols)] = NewPrtCol().Add(name)
+			}
+		}
+		return
+	}
+	for _, pc := range pj.cols {
+		if pc.labels == nil {
+			pc.labels = pc.Vars
+		}
+		pc.Vars = make([]string, 0, len(pc.labels))
+		for _, label := range pc.labels {
+			if !isSelector(label) {
+				pc.Vars = append(pc.Vars, label)
+				continue
+			}
+			for _, name := range pj.prt.mdl.selectNames(label) {
+				pj.use(name, label)
+				pc.Vars = append(pc.Vars, name)
+			}
+		}
+	}
+}
+
+// use a variable selected by a label in the print job: the variable gets
+// the precision of the label.
+func (pj *PrintJob) use(name, label string) {
+	if prec, ok := pj.prec[label]; ok && name != label {
+		pj.prec[name] = prec
+	}
+	pj.prt.vars[name] = true
+}
+
+// Decimals returns the number of decimals to print for a variable;
+// integer and boolean variables are printed without decimals.
+func (pj *PrintJob) decimals(name string, def int) int {
+	if prec, ok := pj.prec[name]; ok {
+		return prec
+	}
+	if pv, ok := pj.prt.run.Vars[name]; ok && pv.Type != TYPE_REAL {
+		return 0
+	}
+	return def
+}
+
+//----------------------------------------------------------------------
+// Selectors in PRINT and PLOT statements: "*LEVELS" selects all variables
+// of a class (levels, rates, auxiliaries, supplements or constants), "POP*"
+// selects all variables starting with a prefix. Selectors are expanded at
+// the start of a run.
+//----------------------------------------------------------------------
+
+// selClasses are the classes of variables (and their equation modes)
+var selClasses = map[string]string{
+	"LEVELS": "L",
+	"RATES":  "R",
+	"AUX":    "A",
+	"SUPPL":  "S",
+	"CONST":  "C",
+	"INTERN": "", // internal variables of functions (if recorded)
+}
+
+// isSelector returns true if the label selects multiple variables.
+func isSelector(label string) bool {
+	return strings.Contains(label, "*")
+}
+
+// checkSelector checks the syntax of a selector.
+func checkSelector(sel string) *Result {
+	if strings.HasPrefix(sel, "*") {
+		if _, ok := selClasses[sel[1:]]; ok {
+			return Success()
+		}
+	} else if pos := strings.Index(sel, "*"); pos > 0 && pos == len(sel)-1 {
+		return Success()
+	}
+	return Failure(ErrParseSyntax+": selector '%s'", sel)
+}
+
+// selectNames returns the (sorted) names of all variables matching a
+// selector. System and automatic variables are not selected (except the
+// recorded internal variables of functions with "*INTERN").
+func (mdl *Model) selectNames(sel string) (names []string) {
+	if sel == "*INTERN" {
+		if mdl.Internals {
+			for _, name := range mdl.internNames() {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+		return
+	}
+	found := make(map[string]bool)
+	for _, eqn := range mdl.equations().List() {
+		name := eqn.Target.Name
+		if name[0] == '_' || eqn.Mode == "N" || found[name] || mdl.IsSystem(name) {
+			continue
+		}
+		if strings.HasPrefix(sel, "*") {
+			if eqn.Mode != selClasses[sel[1:]] {
+				continue
+			}
+		} else if !strings.HasPrefix(name, strings.TrimSuffix(sel, "*")) {
+			continue
+		}
+		found[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
 //----------------------------------------------------------------------
 // Printer
 //----------------------------------------------------------------------
@@ -130,87 +295,300 @@ const (
 	PRT_CSV           // CSV-formatted print
 )
 
-// Printer writes print output to a file (if defined)
-type Printer struct {
-	file  *os.File             // reference to print file (or nil if not defined)
-	mode  int                  // printing mode (PRT_????)
-	mdl   *Model               // back-ref to model instance
-	steps int                  // number of DT steps between printed points
-	vars  map[string]*PrintVar // variables to use in print
-	xnum  int                  // number of x-values
-	jobs  []*PrintJob          // list of print jobs to perform
-	add   bool                 // printer is adding jobs
+// CSVFormat defines the formatting of CSV-formatted prints.
+type CSVFormat struct {
+	Delim   string // field delimiter
+	Decimal string // decimal separator
+	Quote   bool   // quote all fields
+	Sci     bool   // use scientific notation for values
 }
 
-// NewPrinter instantiates a new printer output.
-func NewPrinter(file string, mdl *Model) *Printer {
-	// determine printing mode from file name
-	mode := PRT_DYNAMO
+// NewCSVFormat returns the default CSV format for given delimiter.
+func NewCSVFormat(delim string) *CSVFormat {
+	return &CSVFormat{
+		Delim:   delim,
+		Decimal: ".",
+		Quote:   false,
+		Sci:     false,
+	}
+}
+
+// field returns a formatted field (label or value). Fields are quoted if
+// requested or if they contain the delimiter or quotes.
+func (f *CSVFormat) field(s string) string {
+	if f.Quote || (len(f.Delim) > 0 && strings.Contains(s, f.Delim)) || strings.Contains(s, "\"") {
+		return "\"" + strings.ReplaceAll(s, "\"", "\"\"") + "\""
+	}
+	return s
+}
+
+// value returns a formatted value with given number of decimals
+func (f *CSVFormat) value(v float64, prec int) string {
+	mode := byte('f')
+	if f.Sci {
+		mode = 'e'
+	}
+	s := strconv.FormatFloat(v, mode, prec, 64)
+	if f.Decimal != "." {
+		s = strings.Replace(s, ".", f.Decimal, 1)
+	}
+	return f.field(s)
+}
+
+// PrintRun holds the printed values of a model run. Each run owns its
+// values: a rerun (after EDIT) starts with a new instance, so values of
+// different runs are never mixed in a print.
+type PrintRun struct {
+	RunID string               // identifier of model run
+	Vars  map[string]*PrintVar // printed variables (with values)
+	Num   int                  // number of printed lines
+
+	next float64 // time of next printed line
+}
+
+// NewPrintRun creates a new (empty) print run for the named variables.
+func NewPrintRun(runID string, names map[string]bool) *PrintRun {
+	run := &PrintRun{
+		RunID: runID,
+		Vars:  make(map[string]*PrintVar),
+	}
+	for name := range names {
+		run.Vars[name] = NewPrintVar(name)
+	}
+	return run
+}
+
+// printTarget is a print file with its printing mode.
+type printTarget struct {
+	name  string         // name of print file
+	file  io.WriteCloser // print file (opened at the start of a run)
+	mode  int            // printing mode (PRT_????)
+	delim string         // column delimiter in CSV prints (empty for default)
+}
+
+// newPrintTarget returns a print target; the printing mode is determined
+// from the file name.
+func newPrintTarget(file string) *printTarget {
+	t := &printTarget{name: file, mode: PRT_DYNAMO}
 	if pos := strings.LastIndex(file, "."); pos != -1 {
 		switch strings.ToUpper(file[pos:]) {
 		case ".PRT":
-			mode = PRT_DYNAMO
+			t.mode = PRT_DYNAMO
 		case ".CSV":
-			mode = PRT_CSV
+			t.mode = PRT_CSV
+		case ".TSV":
+			t.mode = PRT_CSV
+			t.delim = "\t"
 		}
 	}
-	// create new printer instance
+	return t
+}
+
+// Printer writes print output to files (if defined); all print jobs are
+// written to each print file in its own printing mode.
+type Printer struct {
+	targets []*printTarget  // list of print files
+	sinks   backendList     // list of custom print backends
+	stream  bool            // backends are started for the current run
+	mdl     *Model          // back-ref to model instance
+	vars    map[string]bool // names of variables to use in print
+	run     *PrintRun       // printed values of current (or last) run
+	jobs    []*PrintJob     // list of print jobs to perform
+	add     bool            // printer is adding jobs
+	csv     *CSVFormat      // format of CSV prints
+	scale   bool            // scale values in DYNAMO prints
+	pageLen int             // lines per page in DYNAMO prints (0=no paging)
+	sparse  sparseSpec      // variables with sparse CSV output
+}
+
+// NewPrinter instantiates a new printer output. The print file is created
+// at the start of the first run.
+func NewPrinter(file string, mdl *Model) *Printer {
 	prt := &Printer{
-		mdl:  mdl,
-		mode: mode,
-		vars: make(map[string]*PrintVar),
-		jobs: make([]*PrintJob, 0),
-		add:  true,
+		mdl:   mdl,
+		vars:  make(map[string]bool),
+		jobs:  make([]*PrintJob, 0),
+		add:   true,
+		csv:   NewCSVFormat(";"),
+		scale: true,
 	}
-	// open file for output
+	prt.setTarget(file)
+	return prt
+}
+
+// setTarget replaces all print files with the given file (or no file if
+// the name is empty).
+func (prt *Printer) setTarget(file string) {
+	prt.targets, prt.sinks = nil, nil
+	prt.addTarget(file)
+}
+
+// addTarget adds a print file or a custom backend registered for the
+// target (if the name is not empty).
+func (prt *Printer) addTarget(file string) {
 	if len(file) == 0 {
-		prt.file = nil
-	} else {
-		var err error
-		if prt.file, err = os.Create(file); err != nil {
-			Fatal(err.Error())
+		return
+	}
+	if be := newPrintBackend(file); be != nil {
+		prt.sinks = append(prt.sinks, be)
+		return
+	}
+	prt.targets = append(prt.targets, newPrintTarget(file))
+}
+
+// open all print files (if not opened before).
+func (prt *Printer) open() *Result {
+	for _, t := range prt.targets {
+		if t.file != nil {
+			continue
+		}
+		f, err := createFile(t.name)
+		if err != nil {
+			return Failure(err)
 		}
+		t.file = f
 	}
-	return prt
+	return Success()
 }
 
-// Reset a printer
-func (prt *Printer) Reset() {
-	// clear time-series on PrintVar
-	for _, v := range prt.vars {
-		v.Reset()
+// SetPrintTarget replaces the print files between runs: the current print
+// files are closed and the new file (or no file if the name is empty) is
+// created at the start of the next run.
+func (mdl *Model) SetPrintTarget(file string) *Result {
+	if mdl.run != nil {
+		return Failure(ErrModelRunning)
+	}
+	if res := mdl.Print.Close(); !res.Ok {
+		return res
 	}
+	mdl.Print.setTarget(file)
+	return Success()
+}
+
+// AddPrintTarget adds a print file between runs: all following runs are
+// printed to the new file in addition to the existing print files. The
+// printing mode is determined from the file name.
+func (mdl *Model) AddPrintTarget(file string) *Result {
+	if mdl.run != nil {
+		return Failure(ErrModelRunning)
+	}
+	mdl.Print.addTarget(file)
+	return Success()
+}
+
+// CSVFormat returns the format used for CSV prints; changes to the returned
+// instance apply to all following prints.
+func (prt *Printer) CSVFormat() *CSVFormat {
+	return prt.csv
+}
+
+// SetScaling enables or disables the scaling of values in DYNAMO prints.
+// Without scaling the raw values are printed.
+func (prt *Printer) SetScaling(flag bool) {
+	prt.scale = flag
+}
+
+// SetPageLength sets the number of lines per page in DYNAMO prints. Pages
+// are separated by form feeds and start with a header showing the run
+// identifier and the TIME range of the page. A length of 0 disables paging.
+func (prt *Printer) SetPageLength(lines int) {
+	prt.pageLen = lines
+}
+
+// SetSparse sets the variables with sparse CSV output from a list like
+// "INV=0.01,PRICE": a value is only written if it changed by more than
+// epsilon (default 0) since the last written value of the variable.
+func (prt *Printer) SetSparse(spec string) *Result {
+	sparse := make(sparseSpec)
+	for _, def := range strings.Split(spec, ",") {
+		def = strings.ToUpper(strings.TrimSpace(def))
+		if len(def) == 0 {
+			continue
+		}
+		x := strings.SplitN(def, "=", 2)
+		eps := 0.
+		if len(x) == 2 {
+			var err error
+			if eps, err = strconv.ParseFloat(x[1], 64); err != nil || eps < 0 {
+				return Failure(ErrPrintSparse+": %s", def)
+			}
+		}
+		if x[0] == "TIME" || !isIdent(x[0]) {
+			return Failure(ErrPrintSparse+": %s", def)
+		}
+		sparse[x[0]] = eps
+	}
+	prt.sparse = sparse
+	return Success()
+}
+
+// Reset a printer (when editing a model): the values of the last run are
+// dropped and the next PRINT statement replaces the existing print jobs.
+func (prt *Printer) Reset() {
+	prt.run = nil
 	prt.add = false
-	prt.xnum = 0
+}
+
+// Writer returns the output stream of the first print file (or nil if no
+// output is defined or the print file can't be created).
+func (prt *Printer) Writer() io.Writer {
+	if res := prt.open(); !res.Ok {
+		Logf(LOG_ERROR, LOG_OUTPUT, "Print file: %s", res.Err.Error())
+		return nil
+	}
+	if len(prt.targets) == 0 {
+		return nil
+	}
+	return prt.targets[0].file
 }
 
 // Generate print output.
 func (prt *Printer) Generate() *Result {
-	if prt.file != nil {
+	if prt.stream {
+		prt.stream = false
+		if res := prt.sinks.finish(); !res.Ok {
+			return res
+		}
+	}
+	if prt.active() && prt.run != nil {
 		// do the actual printing
 		return prt.print()
 	}
 	return Success()
 }
 
-// Close a printer if job is complete
+// Close a printer if job is complete; following runs are not printed
+// (unless a new print file is set).
 func (prt *Printer) Close() (res *Result) {
 	res = Success()
-	if prt.file != nil {
-		if err := prt.file.Close(); err != nil {
+	for _, t := range prt.targets {
+		if t.file == nil {
+			continue
+		}
+		if err := t.file.Close(); err != nil && res.Ok {
 			res = Failure(err)
 		}
 	}
+	if rc := prt.sinks.close(); !rc.Ok && res.Ok {
+		res = rc
+	}
+	prt.targets, prt.sinks, prt.stream = nil, nil, false
 	return
 }
 
+// active returns true if print files are opened or custom backends are
+// defined.
+func (prt *Printer) active() bool {
+	return len(prt.sinks) > 0 || (len(prt.targets) > 0 && prt.targets[0].file != nil)
+}
+
 // Prepare the printer for output ased on the PRINT statement
 func (prt *Printer) Prepare(stmt string) (res *Result) {
 	res = Success()
 
 	// if we do not add jobs, clear exisiting jobs and vars
 	if !prt.add {
-		prt.vars = make(map[string]*PrintVar)
+		prt.vars = make(map[string]bool)
 		prt.jobs = make([]*PrintJob, 0)
 		prt.add = true
 	}
@@ -220,27 +598,35 @@ func (prt *Printer) Prepare(stmt string) (res *Result) {
 	prt.jobs = append(prt.jobs, pj)
 
 	// split into column groups
-	var err error
+	var (
+		err  error
+		name string
+	)
 	grps := strings.Split(stmt, "/")
 	if len(grps) == 1 {
 		// we only have one column group: flat list of columns
+		var (
+			names []string
+			sel   bool
+		)
 		for pos, label := range strings.Split(grps[0], ",") {
-			pv := &PrintVar{
-				TSVar: TSVar{
-					Name:   label,
-					Values: make([]float64, 0),
-				},
-				Scale: 1.0,
+			if name, res = pj.add(label); !res.Ok {
+				return
 			}
-			prt.vars[label] = pv
-			pj.cols[pos+1] = NewPrtCol().Add(label)
+			pj.cols[pos+1] = NewPrtCol().Add(name)
+			names = append(names, name)
+			sel = sel || isSelector(name)
+		}
+		if sel {
+			// columns are created when expanding selectors
+			pj.flat = names
 		}
 	} else {
 		// parse column groups
 		for pos, grp := range grps {
 			// parse optional column index.
 			col := pos + 1
-			if delim := strings.Index(grp, ")"); delim != -1 {
+			if delim := strings.Index(grp, ")"); delim != -1 && !strings.Contains(grp[:delim], "(") {
 				if col, err = strconv.Atoi(grp[:delim]); err != nil {
 					return Failure(err)
 				}
@@ -251,81 +637,138 @@ func (prt *Printer) Prepare(stmt string) (res *Result) {
 			pj.cols[col] = column
 			for _, label := range strings.Split(grp, ",") {
 				// add variable
-				pv := &PrintVar{
-					TSVar: TSVar{
-						Name:   label,
-						Values: make([]float64, 0),
-					},
-					Scale: 1.0,
+				if name, res = pj.add(label); !res.Ok {
+					return
 				}
-				prt.vars[label] = pv
 				// add to column
-				column.Add(label)
+				column.Add(name)
 			}
 		}
 	}
 	return
 }
 
-// Start is called when the model starts executing
+// Start is called when the model starts executing: a new print run is
+// started for the printed variables.
 func (prt *Printer) Start() (res *Result) {
-	res = Success()
-	if prt.file != nil {
-		// get print stepping
-		pp, ok := prt.mdl.Current["PRTPER"]
-		if !ok {
-			return Failure(ErrModelMissingDef + ": PRTPER")
+	// create print file (on first run)
+	if res = prt.open(); !res.Ok {
+		return
+	}
+	// expand selectors (and drop variables of earlier expansions)
+	used := map[string]bool{"TIME": true}
+	for _, pj := range prt.jobs {
+		pj.expand()
+		for _, pc := range pj.cols {
+			for _, name := range pc.Vars {
+				used[name] = true
+			}
 		}
-		dt, ok := prt.mdl.Current["DT"]
-		if !ok {
-			return Failure(ErrModelMissingDef + ": DT")
+	}
+	for name := range prt.vars {
+		if !used[name] {
+			delete(prt.vars, name)
 		}
-		prt.steps = int(pp / dt)
-		if compare(float64(pp), float64(prt.steps)*float64(dt)) != 0 {
-			Msgf("WARNING: PRTPER != n * DT")
+	}
+	prt.run = NewPrintRun(prt.mdl.RunID, prt.vars)
+	for name, pv := range prt.run.Vars {
+		pv.Type = prt.mdl.VarType(name)
+	}
+	prt.run.next = float64(prt.mdl.Current["TIME"])
+	if prt.active() {
+		if res = prt.mdl.checkOutputPeriod("PRTPER"); !res.Ok {
+			return
+		}
+	}
+	// start custom backends (if values are printed)
+	prt.stream = false
+	if len(prt.sinks) > 0 && len(prt.jobs) > 0 {
+		names := make([]string, 0, len(prt.run.Vars))
+		for name := range prt.run.Vars {
+			names = append(names, name)
 		}
+		if res = prt.sinks.start(prt.run.RunID, names); !res.Ok {
+			return
+		}
+		prt.stream = true
 	}
 	return
 }
 
+// output returns true if values are printed in the current epoch.
+func (prt *Printer) output() bool {
+	return prt.active() && prt.run != nil && prt.mdl.outputDue("PRTPER", prt.run.next)
+}
+
 // Add a new line for results in this epoch
 func (prt *Printer) Add(epoch int) (res *Result) {
 	res = Success()
-	if prt.file != nil {
-		// check for output epoch
-		if prt.steps == 0 || (prt.steps > 1 && epoch%prt.steps != 1) {
-			return
-		}
+	if prt.output() {
 		// get values for printed variables
-		for name, pv := range prt.vars {
+		values := make(State)
+		for name, pv := range prt.run.Vars {
 			val, ok := prt.mdl.Current[name]
 			if !ok {
 				return Failure(ErrModelNoVariable+": %s [Printer]", name)
 			}
 			pv.Add(float64(val))
+			values[name] = val
+		}
+		if prt.stream {
+			if res = prt.sinks.add(values); !res.Ok {
+				return
+			}
 		}
-		prt.xnum++
+		prt.run.Num++
+		prt.run.next = float64(prt.mdl.Current["TIME"] + prt.mdl.Current["PRTPER"])
 	}
 	return
 }
 
+// truncate collected data to n lines
+func (prt *Printer) truncate(n int) {
+	if prt.run == nil {
+		return
+	}
+	for _, pv := range prt.run.Vars {
+		pv.Truncate(n)
+	}
+	prt.run.Num = n
+	prt.run.next = float64(prt.mdl.Current["TIME"])
+	if pv, ok := prt.run.Vars["TIME"]; ok && n > 0 {
+		prt.run.next = pv.Values[n-1] + float64(prt.mdl.Current["PRTPER"])
+	}
+}
+
 //----------------------------------------------------------------------
 // Print routines
 //----------------------------------------------------------------------
 
 // Print collected data
 func (prt *Printer) print() *Result {
-	Msgf("      Generating print(s)...")
-	// handle all print jobs
-	if prt.steps > 0 {
-		for _, pj := range prt.jobs {
-			switch prt.mode {
-			case PRT_DYNAMO:
-				return prt.print_dyn(pj)
-			case PRT_CSV:
-				return prt.print_csv(pj)
-			default:
-				return Failure(ErrPrintMode)
+	Log(LOG_INFO, LOG_OUTPUT, "      Generating print(s)...")
+	// handle all print jobs (if values have been printed)
+	if prt.run.Num > 0 {
+		for _, t := range prt.targets {
+			for _, pj := range prt.jobs {
+				var res *Result
+				switch t.mode {
+				case PRT_DYNAMO:
+					res = prt.print_dyn(pj, t.file)
+				case PRT_CSV:
+					csv := prt.csv
+					if len(t.delim) > 0 {
+						tsv := *csv
+						tsv.Delim = t.delim
+						csv = &tsv
+					}
+					res = prt.print_csv(pj, t.file, csv)
+				default:
+					res = Failure(ErrPrintMode)
+				}
+				if !res.Ok {
+					return res
+				}
 			}
 		}
 	}
@@ -333,87 +776,164 @@ func (prt *Printer) print() *Result {
 }
 
 // Print data in classic DYNAMO style
-func (prt *Printer) print_dyn(pj *PrintJob) (res *Result) {
+func (prt *Printer) print_dyn(pj *PrintJob, out io.Writer) (res *Result) {
 	res = Success()
+	vars, num := prt.run.Vars, prt.run.Num
 
-	// print intro
-	fmt.Fprintf(prt.file, "\n\n")
-	fmt.Fprintf(prt.file, "      PRINT %s\n", pj.stmt)
-	fmt.Fprintln(prt.file)
+	// print intro (on a new page if paging is enabled)
+	if prt.pageLen > 0 {
+		fmt.Fprint(out, "\f")
+	} else {
+		fmt.Fprintf(out, "\n\n")
+	}
+	intro := []string{"      PRINT " + pj.stmt, ""}
 	if len(prt.mdl.Title) > 0 {
-		fmt.Fprintf(prt.file, "***** %s *****\n", prt.mdl.Title)
-		fmt.Fprintln(prt.file)
+		intro = append(intro, "***** "+prt.mdl.Title+" *****", "")
 	}
 	if len(prt.mdl.RunID) > 0 {
-		fmt.Fprintf(prt.file, "Print results for run '%s'\n", prt.mdl.RunID)
-		fmt.Fprintln(prt.file)
+		intro = append(intro, "Print results for run '"+prt.mdl.RunID+"'", "")
 	}
-	// compute optimal scale for printed variables
-	for _, pv := range prt.vars {
-		pv.calcScale()
+	for _, line := range intro {
+		fmt.Fprintln(out, line)
+	}
+	// compute optimal scale for printed variables (TIME is never scaled)
+	for _, pv := range vars {
+		if prt.scale && pv.Name != "TIME" && pv.Type == TYPE_REAL {
+			pv.calcScale()
+		} else {
+			pv.Scale = 1.0
+		}
 	}
-	// assemble array of columns with sub-columns (in print order)
+	// assemble array of columns with sub-columns (in print order);
+	// the width of a column depends on the precision of its variables.
 	list := make([][]string, 20)
+	width := make([]int, 20)
 	maxcol := 0
 	maxsub := 0
 	for col := 0; col < 20; col++ {
 		list[col] = nil
+		width[col] = 7
 		if pc, ok := pj.cols[col]; ok {
 			list[col] = pc.Vars
 			maxcol = col + 1
-			for _, name := range pc.Vars {
-				pc.mergeScale(prt.vars[name].Scale)
-			}
 			if len(pc.Vars) > maxsub {
 				maxsub = len(pc.Vars)
 			}
-		}
-	}
-	// print header
-	for sub := 0; sub < maxsub; sub++ {
-		for col := 0; col < maxcol; col++ {
-			vl := list[col]
-			if vl == nil || sub >= len(vl) {
-				fmt.Fprintf(prt.file, "         ")
-			} else {
-				fmt.Fprintf(prt.file, "  %7s", vl[sub])
+			for _, name := range pc.Vars {
+				w := vars[name].width(pj.decimals(name, 3))
+				if len(name) > w {
+					w = len(name)
+				}
+				if w > width[col] {
+					width[col] = w
+				}
 			}
 		}
-		fmt.Fprintln(prt.file)
 	}
-	// print scales
-	for col := 0; col < maxcol; col++ {
-		vl := list[col]
-		if vl == nil {
-			fmt.Fprintf(prt.file, "         ")
-		} else {
-			scale := fmt.Sprintf("%E", pj.cols[col].Scale)
-			pos := strings.LastIndex(scale, "E")
-			fmt.Fprintf(prt.file, "  %7s", scale[pos:])
-		}
+	// TIME column with calendar labels
+	cal := prt.mdl.Calendar
+	if cal.Valid() && width[0] < 9 {
+		width[0] = 9
 	}
-	fmt.Fprintln(prt.file)
-	// print data
-	for x := 0; x < prt.xnum; x++ {
+	// assemble header lines (labels and scales of variables)
+	var header []string
+	addHeader := func(label func(pv *PrintVar) string) {
 		for sub := 0; sub < maxsub; sub++ {
+			line := ""
 			for col := 0; col < maxcol; col++ {
 				vl := list[col]
 				if vl == nil || sub >= len(vl) {
-					fmt.Fprintf(prt.file, "         ")
+					line += fmt.Sprintf("  %*s", width[col], "")
 				} else {
-					val := prt.vars[vl[sub]].Values[x] / pj.cols[col].Scale
-					fmt.Fprintf(prt.file, "  %7.3f", val)
+					line += fmt.Sprintf("  %*s", width[col], label(vars[vl[sub]]))
 				}
 			}
-			fmt.Fprintln(prt.file)
+			header = append(header, line)
+		}
+	}
+	addHeader(func(pv *PrintVar) string {
+		if pv.Name == "TIME" && cal.Valid() {
+			return cal.Unit
+		}
+		return prt.mdl.Spelling(pv.Name)
+	})
+	if prt.scale {
+		addHeader(func(pv *PrintVar) string { return pv.ScaleLabel() })
+	}
+	// compute number of x-values per page: the page header consists of
+	// page line, empty line and column headers (the first page also has
+	// the intro lines).
+	perPage := func(page int) int {
+		if prt.pageLen == 0 {
+			return num
+		}
+		lines := prt.pageLen - len(header) - 2
+		if page == 1 {
+			lines -= len(intro)
+		}
+		if n := lines / maxsub; n > 0 {
+			return n
+		}
+		return 1
+	}
+	// print pages
+	time := vars["TIME"]
+	for page, x0 := 1, 0; x0 < num; page, x0 = page+1, x0+perPage(page) {
+		x1 := x0 + perPage(page)
+		if x1 > num {
+			x1 = num
+		}
+		// print page header
+		if prt.pageLen > 0 {
+			if page > 1 {
+				fmt.Fprint(out, "\f")
+			}
+			fmt.Fprintf(out, "Run '%s'  TIME %s TO %s  PAGE %d\n",
+				prt.mdl.RunID, cal.Label(time.Values[x0]), cal.Label(time.Values[x1-1]), page)
+			fmt.Fprintln(out)
+		}
+		for _, line := range header {
+			fmt.Fprintln(out, line)
+		}
+		// print data
+		for x := x0; x < x1; x++ {
+			for sub := 0; sub < maxsub; sub++ {
+				for col := 0; col < maxcol; col++ {
+					vl := list[col]
+					if vl == nil || sub >= len(vl) {
+						fmt.Fprintf(out, "  %*s", width[col], "")
+					} else {
+						pv := vars[vl[sub]]
+						if pv.Name == "TIME" && cal.Valid() {
+							fmt.Fprintf(out, "  %*s", width[col], cal.Label(pv.Values[x]))
+							continue
+						}
+						prec := pj.decimals(pv.Name, 3)
+						fmt.Fprintf(out, "  %*.*f", width[col], prec, pv.Values[x]/pv.Scale)
+					}
+				}
+				fmt.Fprintln(out)
+			}
+		}
+	}
+	// print provenance of results (on a separate page if paging is enabled)
+	if lines := prt.mdl.provenanceLines(""); len(lines) > 0 {
+		if prt.pageLen > 0 {
+			fmt.Fprint(out, "\f")
+		} else {
+			fmt.Fprintln(out)
+		}
+		for _, line := range lines {
+			fmt.Fprintln(out, line)
 		}
 	}
 	return
 }
 
 // Print data into a CSV file
-func (prt *Printer) print_csv(pj *PrintJob) (res *Result) {
+func (prt *Printer) print_csv(pj *PrintJob, out io.Writer, csv *CSVFormat) (res *Result) {
 	res = Success()
+	vars := prt.run.Vars
 
 	// get (flat) list of labels
 	var list []string
@@ -422,28 +942,208 @@ func (prt *Printer) print_csv(pj *PrintJob) (res *Result) {
 			list = append(list, pc.Vars...)
 		}
 	}
-	// emit header
+	// emit provenance (as comments) and header (with calendar dates
+	// after TIME column)
+	for _, line := range prt.mdl.provenanceLines("# ") {
+		fmt.Fprintln(out, line)
+	}
+	if line := prt.sparse.comment(list); len(line) > 0 {
+		fmt.Fprintln(out, line)
+	}
+	cal := prt.mdl.Calendar
 	for i, name := range list {
 		if i > 0 {
-			prt.file.WriteString(";")
+			io.WriteString(out, csv.Delim)
+		}
+		io.WriteString(out, csv.field(prt.mdl.Spelling(name)))
+		if name == "TIME" && cal.Valid() {
+			io.WriteString(out, csv.Delim+csv.field("DATE"))
 		}
-		prt.file.WriteString(name)
 	}
-	fmt.Fprintln(prt.file)
+	fmt.Fprintln(out)
 	// emit data
-	for x := 0; x < prt.xnum; x++ {
+	sw := prt.sparse.writer(list)
+	row := new(strings.Builder)
+	for x := 0; x < prt.run.Num; x++ {
+		row.Reset()
 		for i, name := range list {
 			if i > 0 {
-				prt.file.WriteString(";")
+				row.WriteString(csv.Delim)
 			}
-			pv, ok := prt.vars[name]
+			pv, ok := vars[name]
 			if !ok {
 				return Failure(ErrPrintNoVar)
 			}
-			fmt.Fprintf(prt.file, "%f", pv.Values[x])
+			if sw.keep(i, pv.Values[x], x == prt.run.Num-1) {
+				row.WriteString(csv.value(pv.Values[x], pj.decimals(name, 6)))
+			}
+			if name == "TIME" && cal.Valid() {
+				row.WriteString(csv.Delim + csv.field(cal.DateString(pv.Values[x])))
+			}
 		}
-		fmt.Fprintln(prt.file)
+		if sw.skip() {
+			continue
+		}
+		fmt.Fprintln(out, row.String())
 	}
 
 	return
 }
+
+//----------------------------------------------------------------------
+// Sparse output: near-constant variables in long runs can be written to
+// CSV output only if their value changed by more than epsilon since the
+// last written value. An empty field keeps the last written value, so the
+// series is a step function; rows without any written value are dropped
+// (except the last row, which is always complete). A comment line lists
+// the sparse columns with their epsilon:
+//
+//     # sparse: INV=0.01,PRICE=0
+//----------------------------------------------------------------------
+
+// sparseSpec maps variable names to epsilon for sparse output.
+type sparseSpec map[string]float64
+
+// comment returns the metadata line for sparse columns in a list of names
+// (or an empty string if no column is sparse).
+func (sp sparseSpec) comment(names []string) string {
+	var list []string
+	for _, name := range names {
+		if eps, ok := sp[name]; ok {
+			list = append(list, name+"="+strconv.FormatFloat(eps, 'g', -1, 64))
+		}
+	}
+	if len(list) == 0 {
+		return ""
+	}
+	return "# sparse: " + strings.Join(list, ",")
+}
+
+// sparseWriter decides which values in the rows of a CSV output are
+// written.
+type sparseWriter struct {
+	eps     []float64 // epsilon of column (negative: not sparse)
+	last    []float64 // last written value of column
+	set     []bool    // column has a written value
+	dense   bool      // rows have non-sparse columns (besides TIME)
+	written bool      // a sparse value is written in the current row
+}
+
+// writer returns a writer for sparse columns in a list of names (or nil if
+// no column is sparse).
+func (sp sparseSpec) writer(names []string) *sparseWriter {
+	sw := &sparseWriter{
+		eps:  make([]float64, len(names)),
+		last: make([]float64, len(names)),
+		set:  make([]bool, len(names)),
+	}
+	num := 0
+	for i, name := range names {
+		sw.eps[i] = -1
+		if eps, ok := sp[name]; ok {
+			sw.eps[i] = eps
+			num++
+		} else if name != "TIME" {
+			sw.dense = true
+		}
+	}
+	if num == 0 {
+		return nil
+	}
+	return sw
+}
+
+// keep returns true if the value in a column is written; values of sparse
+// columns are written if they changed by more than epsilon (or if forced).
+func (sw *sparseWriter) keep(col int, val float64, force bool) bool {
+	if sw == nil || sw.eps[col] < 0 {
+		return true
+	}
+	last := sw.last[col]
+	same := val == last || math.Abs(val-last) <= sw.eps[col] || (math.IsNaN(val) && math.IsNaN(last))
+	if sw.set[col] && same && !force {
+		return false
+	}
+	sw.last[col], sw.set[col] = val, true
+	sw.written = true
+	return true
+}
+
+// skip returns true if the current row has no written values (and starts
+// a new row).
+func (sw *sparseWriter) skip() bool {
+	if sw == nil {
+		return false
+	}
+	skip := !sw.dense && !sw.written
+	sw.written = false
+	return skip
+}
+
+//----------------------------------------------------------------------
+// Default output: if a model has no PRINT or PLOT statements, the levels
+// and rates of a run are written to the default output (if defined) as
+// CSV with values every PLTPER (or every DT if PLTPER is not set).
+//----------------------------------------------------------------------
+
+// defaultOutput writes the results of the last run to the default output.
+func (mdl *Model) defaultOutput() *Result {
+	if mdl.Default == nil {
+		Logf(LOG_INFO, LOG_OUTPUT, "      No PRINT or PLOT for run '%s': no output", mdl.RunID)
+		return Success()
+	}
+	ds, res := mdl.Dataset(mdl.RunID)
+	if !res.Ok {
+		return res
+	}
+	names := append([]string{"TIME"}, mdl.selectNames("*LEVELS")...)
+	names = append(names, mdl.selectNames("*RATES")...)
+	n := 1
+	if pp, dt := mdl.Current["PLTPER"], mdl.Current["DT"]; pp > 0 && dt > 0 {
+		n = int(math.Round(float64(pp / dt)))
+	}
+	ds = ds.Sample(names, n)
+	Logf(LOG_INFO, LOG_OUTPUT, "      Default output of run '%s' (%d variables)", mdl.RunID, len(names)-1)
+	return writeDatasetCSV(mdl.Default, ds, names, mdl.Print.csv, mdl.Print.sparse)
+}
+
+// writeDatasetCSV writes the named variables of a dataset in CSV format
+// (with provenance comments and sparse columns).
+func writeDatasetCSV(w io.Writer, ds *Dataset, names []string, csv *CSVFormat, sparse sparseSpec) *Result {
+	buf := new(strings.Builder)
+	if ds.Provenance != nil {
+		for _, line := range ds.Provenance.Lines() {
+			buf.WriteString("# " + line + "\n")
+		}
+	}
+	if line := sparse.comment(names); len(line) > 0 {
+		buf.WriteString(line + "\n")
+	}
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteString(csv.Delim)
+		}
+		buf.WriteString(csv.field(name))
+	}
+	buf.WriteString("\n")
+	sw := sparse.writer(names)
+	row := new(strings.Builder)
+	for x := 0; x < ds.Len(); x++ {
+		row.Reset()
+		for i, name := range names {
+			if i > 0 {
+				row.WriteString(csv.Delim)
+			}
+			if val := ds.Vars[name][x]; sw.keep(i, val, x == ds.Len()-1) {
+				row.WriteString(csv.value(val, 6))
+			}
+		}
+		if !sw.skip() {
+			buf.WriteString(row.String() + "\n")
+		}
+	}
+	if _, err := io.WriteString(w, buf.String()); err != nil {
+		return Failure(err)
+	}
+	return Success()
+}
@@ -0,0 +1,841 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCSVFormat(t *testing.T) {
+	for _, tc := range []struct {
+		delim, dec  string
+		quote, sci  bool
+		label, lval string
+		val, fval   string
+	}{
+		{";", ".", false, false, "INV", "INV", "1234.5", "1234.50"},
+		{",", ",", false, false, "INV", "INV", "1234.5", "\"1234,50\""},
+		{";", ",", false, false, "A;B", "\"A;B\"", "-0.5", "-0,50"},
+		{"\t", ".", true, false, "INV", "\"INV\"", "2", "\"2.00\""},
+		{";", ".", false, true, "INV", "INV", "1234.5", "1.23e+03"},
+		{"", ".", false, false, "INV", "INV", "1", "1.00"},
+		{";", ".", false, false, "\"X\"", "\"\"\"X\"\"\"", "1", "1.00"},
+	} {
+		f := &CSVFormat{Delim: tc.delim, Decimal: tc.dec, Quote: tc.quote, Sci: tc.sci}
+		if s := f.field(tc.label); s != tc.lval {
+			t.Errorf("label mismatch: '%s' != '%s'", s, tc.lval)
+		}
+		v, err := strconv.ParseFloat(tc.val, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := f.value(v, 2); s != tc.fval {
+			t.Errorf("value mismatch: '%s' != '%s'", s, tc.fval)
+		}
+	}
+}
+
+// runPrint runs a model and returns the generated DYNAMO print.
+func runPrint(t *testing.T, src []string, setup func(prt *Printer)) string {
+	fname := filepath.Join(t.TempDir(), "test.prt")
+	mdl := NewModel(fname, "")
+	if setup != nil {
+		setup(mdl.Print)
+	}
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Quit(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// growth is a model with large positive and negative values
+var growth = []string{
+	"* GROWTH",
+	"L POS.K=POS.J+DT*RATE.JK",
+	"N POS=900",
+	"L NEG.K=NEG.J-DT*RATE.JK",
+	"N NEG=-900",
+	"R RATE.KL=50",
+	"SPEC DT=1,LENGTH=10,PRTPER=1,PLTPER=0",
+	"PRINT POS,NEG",
+	"RUN TEST",
+}
+
+// dataLines returns the lines of a print that start with a TIME value.
+func dataLines(prt string) (lines []string) {
+	for _, line := range strings.Split(prt, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if _, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			lines = append(lines, line)
+		}
+	}
+	return
+}
+
+func TestPrintScale(t *testing.T) {
+	pv := NewPrintVar("X")
+	for _, v := range []float64{1500, -25000, 3} {
+		pv.Add(v)
+	}
+	pv.calcScale()
+	if pv.Scale != 1000 || pv.ScaleLabel() != "E+03" {
+		t.Fatalf("scale mismatch: %f (%s)", pv.Scale, pv.ScaleLabel())
+	}
+	// unscaled values must keep columns aligned
+	for _, scale := range []bool{true, false} {
+		prt := runPrint(t, growth, func(prt *Printer) {
+			prt.SetScaling(scale)
+		})
+		lines := dataLines(prt)
+		if len(lines) != 11 {
+			t.Fatalf("expected 11 lines, got %d:\n%s", len(lines), prt)
+		}
+		for _, line := range lines {
+			if len(line) != len(lines[0]) {
+				t.Fatalf("misaligned print:\n%s", prt)
+			}
+		}
+		if !scale && !strings.Contains(lines[10], "-1400.000") {
+			t.Fatalf("unscaled value missing:\n%s", prt)
+		}
+	}
+}
+
+func TestPrintPrecision(t *testing.T) {
+	src := make([]string, len(growth))
+	copy(src, growth)
+	src[7] = "PRINT POS(4),NEG(1)"
+	prt := runPrint(t, src, func(prt *Printer) {
+		prt.SetScaling(false)
+	})
+	lines := dataLines(prt)
+	if len(lines) != 11 {
+		t.Fatalf("expected 11 lines, got %d:\n%s", len(lines), prt)
+	}
+	for _, line := range lines {
+		if len(line) != len(lines[0]) {
+			t.Fatalf("misaligned print:\n%s", prt)
+		}
+	}
+	if fields := strings.Fields(lines[10]); fields[1] != "1400.0000" || fields[2] != "-1400.0" {
+		t.Fatalf("precision mismatch: %v", fields)
+	}
+}
+
+func TestPrintPaging(t *testing.T) {
+	prt := runPrint(t, growth, func(prt *Printer) {
+		prt.SetPageLength(12)
+	})
+	pages := strings.Split(strings.TrimPrefix(prt, "\f"), "\f")
+	if len(pages) != 4 {
+		t.Fatalf("expected 4 pages, got %d:\n%s", len(pages), prt)
+	}
+	// last page lists the provenance of the results
+	if !strings.HasPrefix(pages[3], "version: ") {
+		t.Fatalf("provenance page missing:\n%s", pages[3])
+	}
+	rows := 0
+	for i, page := range pages {
+		lines := strings.Split(strings.TrimSuffix(page, "\n"), "\n")
+		if len(lines) > 12 {
+			t.Fatalf("page %d too long (%d lines):\n%s", i+1, len(lines), page)
+		}
+		rows += len(dataLines(page))
+	}
+	if rows != 11 {
+		t.Fatalf("expected 11 rows, got %d", rows)
+	}
+}
+
+func TestSelectors(t *testing.T) {
+	src := make([]string, len(growth))
+	copy(src, growth)
+	src[7] = "PRINT *LEVELS(1),R*"
+	prt := runPrint(t, src, nil)
+	if !strings.Contains(prt, "TIME      NEG      POS     RATE") {
+		t.Fatalf("selectors not expanded:\n%s", prt)
+	}
+	if lines := dataLines(prt); len(lines) != 11 || !strings.Contains(lines[10], "-1.4") {
+		t.Fatalf("print mismatch:\n%s", prt)
+	}
+	// selected variables share the column of a selector in column groups
+	src[7] = "PRINT *LEVELS/RATE"
+	if prt = runPrint(t, src, nil); len(dataLines(prt)) != 22 {
+		t.Fatalf("print mismatch:\n%s", prt)
+	}
+	// plot selectors
+	fname := filepath.Join(t.TempDir(), "test.plt")
+	mdl := NewModel("", fname)
+	src[6] = "SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=1"
+	src[7] = "PLOT *LEVELS=X(-2000,2000)/RATE=R"
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Quit(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "NEG=X,POS=X") {
+		t.Fatalf("plot selectors not expanded:\n%s", string(data))
+	}
+	// invalid selectors
+	for _, stmt := range []string{"*LEVEL", "P*S"} {
+		if res := mdl.Print.Prepare(stmt); res.Ok {
+			t.Fatalf("invalid selector '%s' accepted", stmt)
+		}
+	}
+}
+
+func TestDefaultOutput(t *testing.T) {
+	src := make([]string, len(growth))
+	copy(src, growth)
+	src[6] = "SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=2"
+	src[7] = "NOTE no output statements"
+	mdl := NewModel("", "")
+	buf := new(strings.Builder)
+	mdl.Default = buf
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Quit(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) != 7 || lines[0] != "TIME;NEG;POS;RATE" {
+		t.Fatalf("default output mismatch:\n%s", buf.String())
+	}
+	if lines[6] != "10.000000;-1400.000000;1400.000000;50.000000" {
+		t.Fatalf("default output mismatch: %s", lines[6])
+	}
+	// no default output if the model has a PRINT statement
+	buf.Reset()
+	src[7] = "PRINT POS"
+	mdl = NewModel("", "")
+	mdl.Default = buf
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected default output:\n%s", buf.String())
+	}
+}
+
+func TestSparseOutput(t *testing.T) {
+	src := []string{
+		"* SPARSE",
+		"L LEV.K=LEV.J+DT*RATE.JK",
+		"N LEV=0",
+		"R RATE.KL=STEP(5,4)",
+		"SPEC DT=1,LENGTH=8,PLTPER=1",
+		"RUN TEST",
+	}
+	run := func(spec string) (comment string, lines []string) {
+		mdl := NewModel("", "")
+		buf := new(strings.Builder)
+		mdl.Default = buf
+		if res := mdl.Print.SetSparse(spec); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if strings.HasPrefix(line, "# sparse:") {
+				comment = line
+			} else if !strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+			}
+		}
+		return
+	}
+	// sparse rate: unchanged values are empty; the last row is complete
+	comment, lines := run("rate")
+	if comment != "# sparse: RATE=0" || len(lines) != 10 {
+		t.Fatalf("sparse output mismatch: %s\n%s", comment, strings.Join(lines, "\n"))
+	}
+	for i, exp := range map[int]string{
+		1: "0.000000;0.000000;0.000000",
+		2: "1.000000;0.000000;",
+		5: "4.000000;0.000000;5.000000",
+		6: "5.000000;5.000000;",
+		9: "8.000000;20.000000;5.000000",
+	} {
+		if lines[i] != exp {
+			t.Fatalf("sparse output mismatch in row %d: %s", i, lines[i])
+		}
+	}
+	// all columns sparse: rows without changes are dropped
+	comment, lines = run("RATE,LEV=7")
+	exp := []string{
+		"TIME;LEV;RATE",
+		"0.000000;0.000000;0.000000",
+		"4.000000;;5.000000",
+		"6.000000;10.000000;",
+		"8.000000;20.000000;5.000000",
+	}
+	if comment != "# sparse: LEV=7,RATE=0" || strings.Join(lines, "|") != strings.Join(exp, "|") {
+		t.Fatalf("sparse output mismatch: %s\n%s", comment, strings.Join(lines, "\n"))
+	}
+	// invalid specifications
+	for _, spec := range []string{"RATE=-1", "RATE=x", "TIME", "2X"} {
+		if res := NewModel("", "").Print.SetSparse(spec); res.Ok {
+			t.Fatalf("invalid sparse output accepted: %s", spec)
+		}
+	}
+}
+
+func TestOutputRuns(t *testing.T) {
+	dir := t.TempDir()
+	prtFile, pltFile := filepath.Join(dir, "test.prt"), filepath.Join(dir, "test.plt")
+	src := append([]string{}, growth[:6]...)
+	src = append(src,
+		"SPEC DT=1,LENGTH=10,PRTPER=1,PLTPER=1",
+		"PRINT POS",
+		"PRINT NEG",
+		"PLOT POS=P",
+		"RUN FIRST",
+		"EDIT FIRST",
+		"R RATE.KL=500",
+		"RUN SECOND",
+	)
+	mdl := NewModel(prtFile, pltFile)
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Quit(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	// all print jobs are printed for both runs; values are not mixed
+	data, err := os.ReadFile(prtFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prt := string(data)
+	if n := strings.Count(prt, "Print results for run"); n != 4 {
+		t.Fatalf("expected 4 prints, got %d:\n%s", n, prt)
+	}
+	if n := len(dataLines(prt)); n != 4*11 {
+		t.Fatalf("expected 44 lines, got %d:\n%s", n, prt)
+	}
+	// the plot range is computed for each run
+	if data, err = os.ReadFile(pltFile); err != nil {
+		t.Fatal(err)
+	}
+	var scales []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if f := strings.Fields(line); len(f) == 6 && f[5] == "POS=P" {
+			scales = append(scales, f[4])
+		}
+	}
+	if len(scales) != 2 || scales[0] == scales[1] {
+		t.Fatalf("plot ranges mismatch: %v\n%s", scales, string(data))
+	}
+}
+
+func TestOutputPeriods(t *testing.T) {
+	dir := t.TempDir()
+	prtFile, pltFile := filepath.Join(dir, "test.prt"), filepath.Join(dir, "test.plt")
+	src := append([]string{}, growth[:6]...)
+	src = append(src,
+		"SPEC DT=1,LENGTH=10",
+		"A PRTPER.K=1+STEP(1,4)",
+		"PRINT POS",
+		"PLOT POS=P",
+		"RUN TEST",
+	)
+	mdl := NewModel(prtFile, pltFile)
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Quit(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	// PRTPER changes from 1 to 2 at TIME=4
+	data, err := os.ReadFile(prtFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var times []string
+	for _, line := range dataLines(string(data)) {
+		times = append(times, strings.Fields(line)[0])
+	}
+	if strings.Join(times, ",") != "0.000,1.000,2.000,3.000,4.000,6.000,8.000,10.000" {
+		t.Fatalf("print times mismatch: %v", times)
+	}
+	// PLTPER=0 disables plotting
+	if data, err = os.ReadFile(pltFile); err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 {
+		t.Fatalf("unexpected plot:\n%s", string(data))
+	}
+}
+
+func TestTimeOffset(t *testing.T) {
+	dir := t.TempDir()
+	prtFile, pltFile := filepath.Join(dir, "test.prt"), filepath.Join(dir, "test.plt")
+	src := []string{
+		"* OFFSET",
+		"L POP.K=POP.J+DT*(BIRTH.JK+IN.JK)",
+		"N POP=100",
+		"R BIRTH.KL=STEP(10,1905)",
+		"R IN.KL=PULSE(5,1902,4)+RAMP(1,1907)",
+		"N TIME=1900",
+		"SPEC DT=1,LENGTH=1910,PRTPER=1,PLTPER=2",
+		"PRINT POP,BIRTH,IN",
+		"PLOT POP=P",
+		"RUN BASE",
+	}
+	mdl := NewModel(prtFile, pltFile)
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Quit(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	// generating functions use the absolute TIME
+	ds := mdl.Results["BASE"]
+	if n := ds.Len(); n != 11 || ds.Vars["TIME"][0] != 1900 {
+		t.Fatalf("run mismatch: %d epochs, start %f", n, ds.Vars["TIME"][0])
+	}
+	birth, in := ds.Vars["BIRTH"], ds.Vars["IN"]
+	if birth[4] != 0 || birth[5] != 10 || in[2] != 5 || in[6] != 5 || in[9] != 2 {
+		t.Fatalf("function values mismatch: %v / %v", birth, in)
+	}
+	// TIME is printed unscaled and labels the plot
+	data, err := os.ReadFile(prtFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := dataLines(string(data))
+	if len(lines) != 11 || !strings.HasPrefix(strings.TrimSpace(lines[0]), "1900.000") {
+		t.Fatalf("print mismatch:\n%s", string(data))
+	}
+	if data, err = os.ReadFile(pltFile); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), " 1900.000 -") {
+		t.Fatalf("plot mismatch:\n%s", string(data))
+	}
+}
+
+func TestPrintTypes(t *testing.T) {
+	prt := runPrint(t, []string{
+		"* TYPES",
+		"L POS.K=POS.J+DT*RATE.JK",
+		"N POS=900",
+		"R RATE.KL=50",
+		"A CNT.K=POS.K/7;TYPE=INTEGER",
+		"A HIGH.K=POS.K>1000",
+		"SPEC DT=1,LENGTH=4,PRTPER=1,PLTPER=0",
+		"PRINT POS,CNT,HIGH",
+		"RUN TEST",
+	}, nil)
+	lines := dataLines(prt)
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 lines, got %d:\n%s", len(lines), prt)
+	}
+	if fields := strings.Fields(lines[4]); fields[2] != "157" || fields[3] != "1" {
+		t.Fatalf("typed values mismatch: %v", fields)
+	}
+}
+
+func TestPlotScaling(t *testing.T) {
+	src := make([]string, len(growth))
+	copy(src, growth)
+	src[6] = "SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=1"
+	src[7] = "PLOT POS=P*1E-3(0,2)/NEG=N*-1"
+	fname := filepath.Join(t.TempDir(), "test.plt")
+	mdl := NewModel("", fname)
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Quit(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plt := string(data)
+	if !strings.Contains(plt, "POS*0.001=P") || !strings.Contains(plt, "NEG*-1=N") {
+		t.Fatalf("scaling factors not labeled:\n%s", plt)
+	}
+	// last line: POS=1.4 in (0,2), -NEG=1400 in (0,1400)
+	lines := strings.Split(strings.TrimRight(plt, "\n"), "\n")
+	last := []rune(lines[len(lines)-1])
+	if len(last) < 111 || last[80] != 'P' || last[110] != 'N' {
+		t.Fatalf("scaled values misplaced:\n%s", plt)
+	}
+	// invalid factors
+	for _, stmt := range []string{"POS=P*X", "POS=P*0", "POS=PX"} {
+		if res := mdl.Plot.Prepare(stmt); res.Ok {
+			t.Fatalf("invalid factor '%s' accepted", stmt)
+		}
+	}
+}
+
+func TestPlotStacked(t *testing.T) {
+	src := make([]string, len(growth))
+	copy(src, growth)
+	src[6] = "SPEC DT=1,LENGTH=10,PRTPER=0,PLTPER=1"
+	src[7] = "PLOT POS=P+RATE=R(0,2000)/NEG=N"
+	dir := t.TempDir()
+	for _, name := range []string{"test.plt", "test.gnuplot"} {
+		fname := filepath.Join(dir, name)
+		mdl := NewModel("", fname)
+		if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		if res := mdl.Quit(); !res.Ok {
+			t.Fatal(res.Err)
+		}
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plt := string(data)
+		if strings.HasSuffix(name, ".gnuplot") {
+			// areas between zero and POS and between POS and POS+RATE
+			if !strings.Contains(plt, "using 1:(0.000000):2 with filledcurves") ||
+				!strings.Contains(plt, "using 1:2:3 with filledcurves") ||
+				!strings.Contains(plt, "using 1:4 with line") {
+				t.Fatalf("stacked areas missing:\n%s", plt)
+			}
+			continue
+		}
+		// last line: POS=1400, POS+RATE=1450 in (0,2000)
+		lines := strings.Split(strings.TrimRight(plt, "\n"), "\n")
+		last := []rune(lines[len(lines)-1])
+		if len(last) < 83 || last[80] != 'P' || last[83] != 'R' {
+			t.Fatalf("stacked values misplaced:\n%s", plt)
+		}
+	}
+	// stacked and plain members can't be mixed in a group
+	mdl := NewModel("", "")
+	if res := mdl.Plot.Prepare("POS=P+RATE=R,NEG=N"); res.Ok {
+		t.Fatal("mixed group accepted")
+	}
+}
+
+func TestOutputVars(t *testing.T) {
+	src := make([]string, len(growth))
+	copy(src, growth)
+	src[7] = "PRINT POS,FOO,NEG,BAR\nPLOT POS=P/BAZ=B"
+	mdl := NewModel("", "")
+	res := mdl.Parse(strings.NewReader(strings.Join(src, "\n")))
+	if !errors.Is(res, ErrorKind(ErrModelNoVariable)) {
+		t.Fatalf("missing variables not detected: %v", res.Err)
+	}
+	// all missing variables are reported before the run
+	msg := res.Err.Error()
+	for _, name := range []string{"BAR [PRINT]", "FOO [PRINT]", "BAZ [PLOT]"} {
+		if !strings.Contains(msg, name) {
+			t.Fatalf("missing variable %s not reported: %s", name, msg)
+		}
+	}
+	if _, ok := mdl.Results["TEST"]; ok {
+		t.Fatal("model run with missing variables")
+	}
+}
+
+func TestCasePreserving(t *testing.T) {
+	src := []string{
+		"* Growth of a Population",
+		"note @Sector Demography",
+		"note @units persons",
+		"l Pop.k=POP.j+dt*births.jk   Population",
+		"n pop=100",
+		"r Births.kl=pop.k*",
+		"x Rate                       (per Year)",
+		"c rate=.1e-1",
+		"spec dt=1,length=10,prtper=5,pltper=0",
+		"print pop,BIRTHS",
+		"run Test",
+	}
+	var mdl *Model
+	prt := runPrint(t, src, func(prt *Printer) {
+		mdl = prt.mdl
+	})
+	if mdl.Title != "Growth of a Population" {
+		t.Fatalf("title: '%s'", mdl.Title)
+	}
+	if eqn := mdl.DefinitionOf("BIRTHS"); eqn == nil || eqn.Comment != "(per Year)" {
+		t.Fatalf("comment: %v", eqn)
+	}
+	for name, spelling := range map[string]string{"POP": "Pop", "BIRTHS": "births", "RATE": "Rate", "DT": "dt"} {
+		if s := mdl.Spelling(name); s != spelling {
+			t.Fatalf("spelling of %s: '%s'", name, s)
+		}
+	}
+	for _, entry := range mdl.Glossary() {
+		if entry.Name == "POP" && (entry.Units != "persons" || entry.Sector != "Demography") {
+			t.Fatalf("metadata: %v", entry)
+		}
+	}
+	if !regexp.MustCompile(`TIME +Pop +births`).MatchString(prt) {
+		t.Fatalf("print header:\n%s", prt)
+	}
+	if _, ok := mdl.Results["TEST"]; !ok {
+		t.Fatal("run not found")
+	}
+}
+
+func TestPrintTarget(t *testing.T) {
+	dir := t.TempDir()
+	// print files that can't be created fail the run (not the program)
+	mdl := NewModel(filepath.Join(dir, "missing", "test.prt"), filepath.Join(dir, "missing", "test.plt"))
+	if res := mdl.Parse(strings.NewReader(strings.Join(growth, "\n"))); res.Ok {
+		t.Fatal("missing print directory accepted")
+	}
+	// replace print and plot files between runs
+	first, second := filepath.Join(dir, "first.prt"), filepath.Join(dir, "second.csv")
+	mdl = NewModel(first, "")
+	if res := mdl.Parse(strings.NewReader(strings.Join(growth, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.SetPrintTarget(second); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.SetPlotTarget(filepath.Join(dir, "second.plt")); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Parse(strings.NewReader("EDIT TEST\nRUN SECOND\n")); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Quit(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	for _, tc := range []struct {
+		file, want, not string
+	}{
+		{first, "run 'TEST'", "SECOND"},
+		{second, "TIME;POS;NEG", "TEST"},
+	} {
+		data, err := os.ReadFile(tc.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := string(data); !strings.Contains(out, tc.want) || strings.Contains(out, tc.not) {
+			t.Fatalf("%s:\n%s", tc.file, out)
+		}
+	}
+	// no plot jobs: the plot file is created
+	if _, err := os.Stat(filepath.Join(dir, "second.plt")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrintTargets(t *testing.T) {
+	dir := t.TempDir()
+	prt, csv, tsv := filepath.Join(dir, "test.prt"), filepath.Join(dir, "test.csv"), filepath.Join(dir, "test.tsv")
+	mdl := NewModel(prt, "")
+	for _, file := range []string{csv, tsv} {
+		if res := mdl.AddPrintTarget(file); !res.Ok {
+			t.Fatal(res.Err)
+		}
+	}
+	if res := mdl.Parse(strings.NewReader(strings.Join(growth, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Quit(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	for _, tc := range []struct {
+		file, want string
+	}{
+		{prt, "run 'TEST'"},
+		{csv, "TIME;POS;NEG"},
+		{tsv, "TIME\tPOS\tNEG"},
+	} {
+		data, err := os.ReadFile(tc.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := string(data); !strings.Contains(out, tc.want) {
+			t.Fatalf("%s:\n%s", tc.file, out)
+		}
+	}
+}
+
+func TestPlotTargets(t *testing.T) {
+	dir := t.TempDir()
+	plt, gnu := filepath.Join(dir, "test.plt"), filepath.Join(dir, "test.gnuplot")
+	mdl := NewModel("", plt)
+	if res := mdl.AddPlotTarget(gnu); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	src := append([]string{}, growth[:6]...)
+	src = append(src, "SPEC DT=1,LENGTH=10,PLTPER=1", "PLOT POS=P,NEG=N", "RUN TEST")
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Quit(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	for _, tc := range []struct {
+		file, want string
+	}{
+		{plt, "Plot for 'TEST'"},
+		{gnu, "$data_1 << EOD"},
+	} {
+		data, err := os.ReadFile(tc.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := string(data); !strings.Contains(out, tc.want) {
+			t.Fatalf("%s:\n%s", tc.file, out)
+		}
+	}
+}
+
+// memBackend collects printed or plotted values in memory.
+type memBackend struct {
+	target  string
+	runs    []string
+	names   []string
+	samples []State
+	done    int
+}
+
+func (be *memBackend) Start(runID string, names []string) *Result {
+	be.runs, be.names = append(be.runs, runID), names
+	return Success()
+}
+
+func (be *memBackend) AddSample(values State) *Result {
+	be.samples = append(be.samples, values)
+	return Success()
+}
+
+func (be *memBackend) Finish() *Result {
+	be.done++
+	return Success()
+}
+
+func TestBackends(t *testing.T) {
+	var prt, plt *memBackend
+	RegisterPrintBackend("mem", func(target string) PrintBackend {
+		prt = &memBackend{target: target}
+		return prt
+	})
+	RegisterPlotBackend(".mem", func(target string) PlotBackend {
+		plt = &memBackend{target: target}
+		return plt
+	})
+	mdl := NewModel("mem:growth", "growth.mem")
+	src := append([]string{}, growth[:6]...)
+	src = append(src, "SPEC DT=1,LENGTH=10,PRTPER=1,PLTPER=2", "PRINT POS,NEG", "PLOT POS=P", "RUN TEST")
+	if res := mdl.Parse(strings.NewReader(strings.Join(src, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Quit(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if prt == nil || plt == nil {
+		t.Fatal("backends not created")
+	}
+	for _, tc := range []struct {
+		be      *memBackend
+		target  string
+		names   string
+		samples int
+	}{
+		{prt, "mem:growth", "NEG,POS,TIME", 11},
+		{plt, "growth.mem", "POS,TIME", 6},
+	} {
+		be := tc.be
+		if be.target != tc.target || strings.Join(be.runs, ",") != "TEST" || be.done != 1 {
+			t.Fatalf("%s: %v %d", be.target, be.runs, be.done)
+		}
+		if names := strings.Join(be.names, ","); names != tc.names {
+			t.Fatalf("%s: names %s", be.target, names)
+		}
+		if len(be.samples) != tc.samples {
+			t.Fatalf("%s: %d samples", be.target, len(be.samples))
+		}
+		if last := be.samples[len(be.samples)-1]; last["TIME"] != 10 || last["POS"] != 1400 {
+			t.Fatalf("%s: last sample %v", be.target, last)
+		}
+	}
+}
+
+// memFile is an in-memory print or plot file.
+type memFile struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (f *memFile) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestFileCreator(t *testing.T) {
+	files := make(map[string]*memFile)
+	SetFileCreator(func(name string) (io.WriteCloser, error) {
+		f := new(memFile)
+		files[name] = f
+		return f, nil
+	})
+	defer SetFileCreator(nil)
+
+	mdl := NewModel("test.csv", "")
+	if res := mdl.Parse(strings.NewReader(strings.Join(growth, "\n"))); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	if res := mdl.Quit(); !res.Ok {
+		t.Fatal(res.Err)
+	}
+	f, ok := files["test.csv"]
+	if !ok || !f.closed || !strings.Contains(f.String(), "TIME;POS;NEG") {
+		t.Fatalf("in-memory print file: %v", f)
+	}
+	if _, err := os.Stat("test.csv"); err == nil {
+		t.Fatal("print file created in file system")
+	}
+}
@@ -0,0 +1,124 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+//======================================================================
+// Execution profile: the wall time and the number of evaluations are
+// recorded for each equation and each built-in function during model
+// runs. The report shows where the time of a run is spent (e.g. to
+// decide where a larger DT or a simpler table pays off).
+//======================================================================
+
+// ProfileEntry holds the execution costs of an equation or function.
+type ProfileEntry struct {
+	Name  string        // name of equation ("MODE TARGET") or function
+	Calls int           // number of evaluations
+	Time  time.Duration // total wall time of evaluations
+}
+
+// Profile records the execution costs of equations and functions.
+type Profile struct {
+	eqns map[string]*ProfileEntry // costs of equations
+	fcns map[string]*ProfileEntry // costs of built-in functions
+}
+
+// NewProfile creates a new (empty) execution profile.
+func NewProfile() *Profile {
+	return &Profile{
+		eqns: make(map[string]*ProfileEntry),
+		fcns: make(map[string]*ProfileEntry),
+	}
+}
+
+// eqn records an evaluation of an equation started at given time.
+func (p *Profile) eqn(eqn *Equation, start time.Time) {
+	p.add(p.eqns, eqn.Mode+" "+eqn.Target.Name, start)
+}
+
+// fcn records a call of a built-in function started at given time.
+func (p *Profile) fcn(name string, start time.Time) {
+	p.add(p.fcns, name, start)
+}
+
+// add an evaluation to a list of entries.
+func (p *Profile) add(list map[string]*ProfileEntry, name string, start time.Time) {
+	e, ok := list[name]
+	if !ok {
+		e = &ProfileEntry{Name: name}
+		list[name] = e
+	}
+	e.Calls++
+	e.Time += time.Since(start)
+}
+
+// Equations returns the costs of equations (most expensive first).
+func (p *Profile) Equations() []*ProfileEntry {
+	return sortEntries(p.eqns)
+}
+
+// Functions returns the costs of built-in functions (most expensive first).
+func (p *Profile) Functions() []*ProfileEntry {
+	return sortEntries(p.fcns)
+}
+
+// sortEntries returns a list of entries sorted by descending time.
+func sortEntries(list map[string]*ProfileEntry) (out []*ProfileEntry) {
+	for _, e := range list {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Time != out[j].Time {
+			return out[i].Time > out[j].Time
+		}
+		return out[i].Name < out[j].Name
+	})
+	return
+}
+
+// Write a report of the profile to a stream. The time of an equation
+// includes the time of the functions it calls.
+func (p *Profile) Write(w io.Writer) {
+	section := func(title string, list []*ProfileEntry) {
+		var total time.Duration
+		for _, e := range list {
+			total += e.Time
+		}
+		fmt.Fprintf(w, "%-24s %10s %12s %12s %6s\n", title, "CALLS", "TIME", "PER CALL", "%")
+		for _, e := range list {
+			share := 0.
+			if total > 0 {
+				share = 100 * float64(e.Time) / float64(total)
+			}
+			fmt.Fprintf(w, "%-24s %10d %12s %12s %6.2f\n", e.Name, e.Calls,
+				e.Time, e.Time/time.Duration(e.Calls), share)
+		}
+		fmt.Fprintln(w)
+	}
+	section("EQUATION", p.Equations())
+	section("FUNCTION", p.Functions())
+}
@@ -0,0 +1,111 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VERSION of the DYNAMO interpreter
+const VERSION = "0.6"
+
+//======================================================================
+// PROVENANCE -- The results of a model run (prints, plots and datasets)
+// carry the information needed to reproduce them: the version of the
+// interpreter, a hash of the model source, the start time of the run,
+// the seed for random numbers and the values of all constants.
+//======================================================================
+
+// Provenance of the results of a model run
+type Provenance struct {
+	Version   string             // version of the interpreter
+	Source    string             // SHA-256 hash of the model source
+	Time      time.Time          // start of the run
+	Seed      int64              // seed for random numbers
+	Constants map[string]float64 // values of constants
+}
+
+// provenance of the current model run (called after initialization)
+func (mdl *Model) provenance(seed int64) *Provenance {
+	p := &Provenance{
+		Version:   VERSION,
+		Source:    hex.EncodeToString(mdl.source.Sum(nil)),
+		Time:      time.Now().UTC(),
+		Seed:      seed,
+		Constants: make(map[string]float64),
+	}
+	for _, eqn := range mdl.Eqns.List() {
+		name := eqn.Target.Name
+		if eqn.Mode == "C" && name[0] != '_' {
+			p.Constants[name] = float64(mdl.Current[name])
+		}
+	}
+	return p
+}
+
+// Lines returns the provenance as a list of "key: value" lines; the
+// constants are listed in one line (sorted by name).
+func (p *Provenance) Lines() []string {
+	names := make([]string, 0, len(p.Constants))
+	for name := range p.Constants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	consts := make([]string, len(names))
+	for i, name := range names {
+		consts[i] = name + "=" + strconv.FormatFloat(p.Constants[name], 'g', -1, 64)
+	}
+	return []string{
+		fmt.Sprintf("version: DYNAMO interpreter v%s", p.Version),
+		fmt.Sprintf("source: sha256:%s", p.Source),
+		fmt.Sprintf("time: %s", p.Time.Format(time.RFC3339)),
+		fmt.Sprintf("seed: %d", p.Seed),
+		fmt.Sprintf("constants: %s", strings.Join(consts, ",")),
+	}
+}
+
+// runProvenance returns the provenance of the current (or last) run or
+// nil if not available.
+func (mdl *Model) runProvenance() *Provenance {
+	if mdl.run != nil {
+		return mdl.run.ds.Provenance
+	}
+	if ds, ok := mdl.Results[mdl.RunID]; ok {
+		return ds.Provenance
+	}
+	return nil
+}
+
+// provenanceLines returns the provenance lines of the current run with
+// given prefix (like "# " for comments).
+func (mdl *Model) provenanceLines(prefix string) (list []string) {
+	if p := mdl.runProvenance(); p != nil {
+		for _, line := range p.Lines() {
+			list = append(list, prefix+line)
+		}
+	}
+	return
+}
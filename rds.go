@@ -0,0 +1,182 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+)
+
+//======================================================================
+// RDS -- Results of runs are exported as a data frame in the RDS format
+// of R (serialization format version 2, gzip-compressed), so they can be
+// read with 'readRDS()' directly. The data frame is in "long" format:
+// a column 'RUN' (identifier of the run) followed by 'TIME' and all other
+// variables (NA if a variable is not part of a run).
+//======================================================================
+
+// R object types (SEXPTYPE) and flags used in RDS files
+const (
+	rdsSYM     = 1   // symbol
+	rdsLIST    = 2   // pairlist
+	rdsCHAR    = 9   // string
+	rdsINT     = 13  // integer vector
+	rdsREAL    = 14  // numeric vector
+	rdsSTR     = 16  // character vector
+	rdsVEC     = 19  // generic vector (list)
+	rdsNIL     = 254 // NULL
+	rdsOBJECT  = 1 << 8
+	rdsATTR    = 1 << 9
+	rdsTAG     = 1 << 10
+	rdsUTF8    = 1 << 15 // UTF-8 string (level 8)
+	rdsASCII   = 1 << 18 // ASCII string (level 64)
+	rdsVersion = 2
+)
+
+// NA values in R
+var (
+	rdsNAInt  = int32(math.MinInt32)
+	rdsNAReal = math.Float64frombits(0x7ff00000000007a2)
+)
+
+// rdsWriter writes R objects in XDR (big-endian) format.
+type rdsWriter struct {
+	bytes.Buffer
+}
+
+// int writes an integer.
+func (w *rdsWriter) int(v int32) {
+	binary.Write(w, binary.BigEndian, v)
+}
+
+// str writes a string (CHARSXP).
+func (w *rdsWriter) str(s string) {
+	enc := int32(rdsASCII)
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			enc = rdsUTF8
+			break
+		}
+	}
+	w.int(rdsCHAR | enc)
+	w.int(int32(len(s)))
+	w.WriteString(s)
+}
+
+// strings writes a character vector.
+func (w *rdsWriter) strings(list []string) {
+	w.int(rdsSTR)
+	w.int(int32(len(list)))
+	for _, s := range list {
+		w.str(s)
+	}
+}
+
+// reals writes a numeric vector.
+func (w *rdsWriter) reals(list []float64) {
+	w.int(rdsREAL)
+	w.int(int32(len(list)))
+	for _, v := range list {
+		binary.Write(w, binary.BigEndian, v)
+	}
+}
+
+// attr starts a tagged pairlist node (attribute) with given name.
+func (w *rdsWriter) attr(name string) {
+	w.int(rdsLIST | rdsTAG)
+	w.int(rdsSYM)
+	w.str(name)
+}
+
+// WriteRDS writes the results of runs as a data frame in RDS format.
+func WriteRDS(w io.Writer, list ...*Dataset) *Result {
+	// collect column names and number of rows
+	seen := make(map[string]bool)
+	var names []string
+	rows := 0
+	for _, ds := range list {
+		for _, name := range ds.Names() {
+			if name != "TIME" && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		rows += ds.Len()
+	}
+	sort.Strings(names)
+	names = append([]string{"TIME"}, names...)
+
+	out := new(rdsWriter)
+	out.WriteString("X\n")
+	out.int(rdsVersion)
+	out.int(0x040100) // written by R 4.1.0
+	out.int(0x020300) // readable by R 2.3.0 and later
+
+	// data frame (list of columns)
+	out.int(rdsVEC | rdsOBJECT | rdsATTR)
+	out.int(int32(len(names) + 1))
+	runs := make([]string, 0, rows)
+	for _, ds := range list {
+		for i := 0; i < ds.Len(); i++ {
+			runs = append(runs, ds.RunID)
+		}
+	}
+	out.strings(runs)
+	for _, name := range names {
+		col := make([]float64, 0, rows)
+		for _, ds := range list {
+			values := ds.Vars[name]
+			for i := 0; i < ds.Len(); i++ {
+				if i < len(values) {
+					col = append(col, values[i])
+				} else {
+					col = append(col, rdsNAReal)
+				}
+			}
+		}
+		out.reals(col)
+	}
+	// attributes: names, class and (compact) row names
+	out.attr("names")
+	out.strings(append([]string{"RUN"}, names...))
+	out.attr("class")
+	out.strings([]string{"data.frame"})
+	out.attr("row.names")
+	out.int(rdsINT)
+	out.int(2)
+	out.int(rdsNAInt)
+	out.int(int32(-rows))
+	out.int(rdsNIL)
+
+	// write compressed data
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(out.Bytes()); err != nil {
+		return Failure(err)
+	}
+	if err := zw.Close(); err != nil {
+		return Failure(err)
+	}
+	return Success()
+}
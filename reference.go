@@ -0,0 +1,79 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"embed"
+	"path"
+	"sort"
+	"strings"
+)
+
+//======================================================================
+// REFERENCE -- Classic models are bundled with the expected results of
+// their runs (as CSV print output). Running a reference model verifies
+// that the interpreter reproduces the canonical results.
+//======================================================================
+
+//go:embed reference
+var references embed.FS
+
+// References returns the (sorted) names of the bundled reference models.
+func References() (names []string) {
+	list, _ := references.ReadDir("reference")
+	for _, entry := range list {
+		if name := entry.Name(); strings.HasSuffix(name, ".dynamo") {
+			names = append(names, strings.TrimSuffix(name, ".dynamo"))
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// RunReference runs a bundled reference model and compares the results of
+// its (last) run against the expected results. It returns the list of
+// values that don't match within the given (relative) tolerance.
+func RunReference(name string, tolerance float64) (list []*Mismatch, res *Result) {
+	base := path.Join("reference", name)
+	src, err := references.Open(base + ".dynamo")
+	if err != nil {
+		return nil, Failure(ErrModelNotAvailable+": %s", name)
+	}
+	defer src.Close()
+	data, err := references.Open(base + ".csv")
+	if err != nil {
+		return nil, Failure(ErrModelNoData+": %s", name)
+	}
+	defer data.Close()
+	var ref *Dataset
+	if ref, res = NewDatasetFromReader(data, name); !res.Ok {
+		return
+	}
+	mdl := NewModel("", "")
+	if res = mdl.Parse(src); !res.Ok {
+		return
+	}
+	ds, ok := mdl.Results[mdl.RunID]
+	if !ok {
+		return nil, Failure(ErrModelNotAvailable+": %s", mdl.RunID)
+	}
+	return ds.Verify(ref, tolerance)
+}
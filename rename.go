@@ -0,0 +1,452 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//======================================================================
+// RENAME -- Variables (and tables) are renamed in the source of a model:
+// all uses in equations, tables, PRINT, PLOT, SPEC, CONSERVE and DATA
+// statements are renamed; the formatting of lines and all comments are
+// preserved. Only unqualified names (like the names in a sector block)
+// are renamed.
+//======================================================================
+
+// modes of statements with variable names that are renamed
+var renameModes = []string{"C", "N", "A", "R", "L", "S", "T", "T2", "PRINT", "PLOT", "SPEC", "CONSERVE", "DATA"}
+
+// Rename copies a model source and renames a variable. The new name must
+// follow the naming rules (in strict mode) and must not be used in the
+// model. It returns the number of renamed occurrences.
+func Rename(w io.Writer, rdr io.Reader, from, to string, relaxed, strict bool) (n int, res *Result) {
+	return RenameAll(w, rdr, map[string]string{from: to}, relaxed, strict)
+}
+
+// RenameAll copies a model source and renames all variables in the map
+// (old name -> new name); the names are case-insensitive. It returns the number of renamed occurrences.
+func RenameAll(w io.Writer, rdr io.Reader, names map[string]string, relaxed, strict bool) (n int, res *Result) {
+	// normalize and check new names
+	ren := make(map[string]string)
+	for from, to := range names {
+		from, to = strings.ToUpper(from), strings.ToUpper(to)
+		var name *Name
+		if name, res = NewNameFromString(to); !res.Ok {
+			return
+		}
+		if !isIdent(to) {
+			return 0, Failure(ErrParseInvalidName+": %s", to)
+		}
+		if res = name.Check(strict); !res.Ok {
+			return
+		}
+		ren[from] = to
+	}
+	var lines []string
+	if lines, res = readLines(rdr); !res.Ok {
+		return
+	}
+	if res = checkRenames(lines, ren, relaxed); !res.Ok {
+		return
+	}
+	// rename variables and write source
+	out := renameLines(lines, relaxed, false, func(id string, _ int) string {
+		if to, ok := ren[id]; ok {
+			n++
+			return to
+		}
+		return id
+	})
+	if strict && !relaxed {
+		// keep lines within the length limit
+		out = wrapLines(out)
+	}
+	return n, writeLines(w, out)
+}
+
+// readLines reads all lines of a model source.
+func readLines(rdr io.Reader) (lines []string, res *Result) {
+	brdr := bufio.NewScanner(rdr)
+	for brdr.Scan() {
+		lines = append(lines, brdr.Text())
+	}
+	if err := brdr.Err(); err != nil {
+		return nil, Failure(err)
+	}
+	return lines, Success()
+}
+
+// writeLines writes the lines of a model source.
+func writeLines(w io.Writer, lines []string) *Result {
+	for _, line := range lines {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return Failure(err)
+		}
+	}
+	return Success()
+}
+
+// wrapLines splits classic statements with lines longer than the length
+// limit (MAX_LINE_LENGTH) into new continuation lines; all other lines
+// are copied unchanged.
+func wrapLines(lines []string) (out []string) {
+	isCont := func(line string) bool {
+		return len(line) > 0 && (line[0] == 'X' || line[0] == 'x')
+	}
+	for i := 0; i < len(lines); {
+		// get statement with continuation lines
+		j := i + 1
+		for j < len(lines) && isCont(lines[j]) {
+			j++
+		}
+		group := lines[i:j]
+		i = j
+
+		long := false
+		for _, line := range group {
+			long = long || utf8.RuneCountInString(line) > MAX_LINE_LENGTH
+		}
+		mode := ""
+		if fields := strings.Fields(group[0]); len(fields) > 0 && strings.HasPrefix(group[0], fields[0]) {
+			mode = fields[0]
+		}
+		if !long || len(mode) != 1 || !strings.Contains("CNARLST", strings.ToUpper(mode)) {
+			out = append(out, group...)
+			continue
+		}
+		// re-assemble statement and comment: in classic DYNAMO the
+		// statement ends at the first space.
+		var stmt, comment []string
+		for k, line := range group {
+			text := strings.TrimSpace(line[1:])
+			if k == 0 {
+				text = strings.TrimSpace(line[len(mode):])
+			}
+			if len(comment) > 0 {
+				comment = append(comment, text)
+				continue
+			}
+			if pos := strings.IndexAny(text, " \t"); pos != -1 {
+				stmt = append(stmt, text[:pos])
+				comment = append(comment, strings.TrimSpace(text[pos:]))
+			} else {
+				stmt = append(stmt, text)
+			}
+		}
+		out = append(out, splitStatement(mode, strings.Join(stmt, ""), strings.Join(comment, " "))...)
+	}
+	return
+}
+
+// checkRenames checks that all renamed variables are used in the model
+// source and that no new name is used already.
+func checkRenames(lines []string, ren map[string]string, relaxed bool) *Result {
+	used := make(map[string]int)
+	renameLines(lines, relaxed, false, func(id string, _ int) string {
+		used[id]++
+		return id
+	})
+	for from, to := range ren {
+		if used[from] == 0 {
+			return Failure(ErrModelNoVariable+": %s", from)
+		}
+		if used[to] > 0 {
+			return Failure(ErrModelVariabeExists+": %s", to)
+		}
+	}
+	return Success()
+}
+
+// renameLines calls a function for all variable names in the statements
+// of a model source (with the line number); the function returns the
+// replacement for the name. Only the statement parts of the lines are
+// changed. If 'modern' is set, comments in classic lines are marked with
+// '#' (as required in relaxed mode).
+func renameLines(lines []string, relaxed, modern bool, f func(id string, lineNo int) string) (out []string) {
+	var (
+		mode    string // mode of current statement
+		comment bool   // comment of current statement has started
+	)
+	// mark start of comment in a line (modern dialect)
+	mark := func(text string, pos int) string {
+		for pos < len(text) && (text[pos] == ' ' || text[pos] == '\t') {
+			pos++
+		}
+		if !modern || pos == len(text) {
+			return text
+		}
+		return text[:pos] + "# " + text[pos:]
+	}
+	for i, line := range lines {
+		// split off comments in relaxed mode
+		text, rest := line, ""
+		if relaxed {
+			if pos := strings.Index(line, "#"); pos != -1 {
+				text, rest = line[:pos], line[pos:]
+			}
+		}
+		// get start of statement
+		start := 0
+		if len(strings.TrimSpace(text)) == 0 {
+			out = append(out, line)
+			continue
+		}
+		if text[0] == 'X' || text[0] == 'x' {
+			// continuation line
+			start = 1
+		} else {
+			mode = strings.ToUpper(strings.Fields(text)[0])
+			start, comment = strings.IndexAny(text, " \t"), false
+			if start == -1 {
+				out = append(out, line)
+				continue
+			}
+		}
+		if comment {
+			out = append(out, mark(line, start))
+			continue
+		}
+		if !renameMode(mode) {
+			out = append(out, line)
+			continue
+		}
+		for start < len(text) && (text[start] == ' ' || text[start] == '\t') {
+			start++
+		}
+		// get end of statement: in classic DYNAMO equations end at the
+		// first space.
+		end := len(text)
+		if !relaxed && strings.Contains("CNARLST", mode) {
+			if pos := strings.IndexAny(text[start:], " \t"); pos != -1 {
+				end, comment = start+pos, true
+			}
+		}
+		g := func(id string) string {
+			return f(id, i+1)
+		}
+		stmt := text[:start] + renameIdents(text[start:end], mode, g)
+		out = append(out, stmt+mark(text[end:], 0)+rest)
+	}
+	return
+}
+
+// renameMode returns true if names in statements of given mode are renamed.
+func renameMode(mode string) bool {
+	for _, m := range renameModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// renameIdents calls a function for all unqualified variable names in a
+// statement (part) of given mode. Function names (in equations), plot
+// symbols, indices, numbers, quoted strings and file references are
+// skipped.
+func renameIdents(stmt, mode string, f func(id string) string) string {
+	isAlpha := func(c byte) bool {
+		return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || c == '_'
+	}
+	isDigit := func(c byte) bool {
+		return c >= '0' && c <= '9'
+	}
+	out := new(strings.Builder)
+	for i := 0; i < len(stmt); {
+		c := stmt[i]
+		switch {
+		case c == '"':
+			// quoted string (like file names)
+			end := strings.IndexByte(stmt[i+1:], '"')
+			if end == -1 {
+				end = len(stmt) - i - 2
+			}
+			out.WriteString(stmt[i : i+end+2])
+			i += end + 2
+			continue
+		case c == '@':
+			// file reference (up to next space)
+			end := strings.IndexByte(stmt[i:], ' ')
+			if end == -1 {
+				end = len(stmt) - i
+			}
+			out.WriteString(stmt[i : i+end])
+			i += end
+			continue
+		case c == ';':
+			// inline attributes
+			out.WriteString(stmt[i:])
+			return out.String()
+		case !isAlpha(c):
+			out.WriteByte(c)
+			i++
+			continue
+		}
+		start := i
+		for i < len(stmt) && (isAlpha(stmt[i]) || isDigit(stmt[i])) {
+			i++
+		}
+		id, rest := stmt[start:i], stmt[i:]
+		prev := byte(0)
+		if start > 0 {
+			prev = stmt[start-1]
+		}
+		// skip exponents in numbers, indices and qualified names
+		skip := isDigit(prev) || prev == '.'
+		switch mode {
+		case "PRINT":
+			// names can be followed by precision: "POP(4)"
+		case "PLOT":
+			// skip plot symbols: "POP=P(0,1000)"
+			skip = skip || prev == '='
+		default:
+			// skip function names
+			skip = skip || strings.HasPrefix(rest, "(")
+		}
+		// skip sector names
+		if !skip && strings.HasPrefix(rest, ".") {
+			end := 1
+			for end < len(rest) && isAlpha(rest[end]) {
+				end++
+			}
+			skip = !isIndex(strings.ToUpper(rest[1:end]))
+		}
+		if !skip {
+			if repl := f(strings.ToUpper(id)); repl != strings.ToUpper(id) {
+				id = repl
+			}
+		}
+		out.WriteString(id)
+	}
+	return out.String()
+}
+
+// isIdent returns true if the string is a (unqualified) variable name.
+func isIdent(s string) bool {
+	for i, c := range s {
+		letter := (c >= 'A' && c <= 'Z') || c == '_'
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+//----------------------------------------------------------------------
+// UPGRADE -- Classic models with cryptic (short) names are converted into
+// the modern dialect (relaxed mode) with descriptive names taken from a
+// mapping table:
+//
+//     # old name = new name
+//     SUSC = SUSCEPTIBLE
+//     CNTCTS = CONTACTS
+//
+// The converted model must be processed in relaxed mode ('-r').
+//----------------------------------------------------------------------
+
+// ReadNameMap reads a mapping table of names: each line holds an old and a
+// new name separated by '=', ',', ';' or spaces. Empty lines and lines
+// starting with '#' are skipped.
+func ReadNameMap(rdr io.Reader) (names map[string]string, res *Result) {
+	var lines []string
+	if lines, res = readLines(rdr); !res.Ok {
+		return
+	}
+	names = make(map[string]string)
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return strings.ContainsRune("=,; \t", r)
+		})
+		if len(fields) != 2 {
+			return nil, Failure(ErrParseSyntax+": '%s' [line %d]", line, i+1)
+		}
+		from := strings.ToUpper(fields[0])
+		if _, ok := names[from]; ok {
+			return nil, Failure(ErrModelVariabeExists+": %s [line %d]", from, i+1)
+		}
+		names[from] = fields[1]
+	}
+	return names, Success()
+}
+
+// Upgrade converts a classic model source into the modern dialect (with
+// comments marked by '#') and renames the variables in the mapping table
+// (old name -> new name). A cross-reference listing of the renamed
+// variables with the numbers of the lines using them is written to
+// 'xref' (if not nil).
+func Upgrade(w, xref io.Writer, rdr io.Reader, names map[string]string) (res *Result) {
+	ren := make(map[string]string)
+	for from, to := range names {
+		from, to = strings.ToUpper(from), strings.ToUpper(to)
+		if !isIdent(to) {
+			return Failure(ErrParseInvalidName+": %s", to)
+		}
+		ren[from] = to
+	}
+	var lines []string
+	if lines, res = readLines(rdr); !res.Ok {
+		return
+	}
+	if res = checkRenames(lines, ren, false); !res.Ok {
+		return
+	}
+	uses := make(map[string][]int)
+	out := renameLines(lines, false, true, func(id string, lineNo int) string {
+		if to, ok := ren[id]; ok {
+			if list := uses[id]; len(list) == 0 || list[len(list)-1] != lineNo {
+				uses[id] = append(list, lineNo)
+			}
+			return to
+		}
+		return id
+	})
+	if res = writeLines(w, out); !res.Ok || xref == nil {
+		return
+	}
+	// write cross-reference listing (sorted by new name)
+	froms := make([]string, 0, len(ren))
+	for from := range ren {
+		froms = append(froms, from)
+	}
+	sort.Slice(froms, func(i, j int) bool {
+		return ren[froms[i]] < ren[froms[j]]
+	})
+	fmt.Fprintf(xref, "%-24s %-8s %s\n", "NAME", "OLD", "LINES")
+	for _, from := range froms {
+		nums := make([]string, len(uses[from]))
+		for i, n := range uses[from] {
+			nums[i] = strconv.Itoa(n)
+		}
+		fmt.Fprintf(xref, "%-24s %-8s %s\n", ren[from], from, strings.Join(nums, ","))
+	}
+	return
+}
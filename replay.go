@@ -0,0 +1,228 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+//======================================================================
+// REPLAY LOG -- All stochastic draws (NOISE) and interactive inputs
+// (gaming decisions, input ports) of a model run can be recorded in a
+// replay log. Feeding the log back into a model reproduces the run
+// exactly. Each line in the log describes an event:
+//
+//     <epoch> <kind> <name> <value>
+//======================================================================
+
+// Kinds of replay events
+const (
+	REPLAY_NOISE  = "NOISE"  // random number
+	REPLAY_DECIDE = "DECIDE" // decision in gaming mode
+	REPLAY_INPUT  = "INPUT"  // value of input port
+
+	REPLAY_ROLLBACK = "ROLLBACK" // drop events after a rollback (value is number of kept events)
+)
+
+// ReplayEvent is a recorded event in a model run
+type ReplayEvent struct {
+	Epoch int      // epoch of event
+	Kind  string   // kind of event (REPLAY_???)
+	Name  string   // name of variable (or "-")
+	Value Variable // value of event
+}
+
+// String returns the event as a line in the replay log
+func (e *ReplayEvent) String() string {
+	return fmt.Sprintf("%d %s %s %s", e.Epoch, e.Kind, e.Name,
+		strconv.FormatFloat(float64(e.Value), 'g', -1, 64))
+}
+
+// ReplayLog records or replays the events of model runs
+type ReplayLog struct {
+	out    io.Writer      // stream for recorded events (or nil if replaying)
+	events []*ReplayEvent // list of events to be replayed
+	pos    int            // position of next replayed event
+	num    int            // number of recorded events
+}
+
+// NewRecorder creates a replay log that records events to a stream.
+func NewRecorder(out io.Writer) *ReplayLog {
+	return &ReplayLog{
+		out: out,
+	}
+}
+
+// NewReplay creates a replay log from recorded events in a stream.
+func NewReplay(in io.Reader) (rl *ReplayLog, res *Result) {
+	res = Success()
+	rl = new(ReplayLog)
+	rdr := bufio.NewScanner(in)
+	for lineNo := 1; rdr.Scan(); lineNo++ {
+		line := strings.TrimSpace(rdr.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 4 {
+			res = Failure(ErrModelReplay + ": invalid event").SetLine(lineNo)
+			return
+		}
+		e := &ReplayEvent{Kind: f[1], Name: f[2]}
+		var err error
+		if e.Epoch, err = strconv.Atoi(f[0]); err != nil {
+			res = Failure(ErrModelReplay+": %s", err.Error()).SetLine(lineNo)
+			return
+		}
+		val, err := strconv.ParseFloat(f[3], 64)
+		if err != nil {
+			res = Failure(ErrModelReplay+": %s", err.Error()).SetLine(lineNo)
+			return
+		}
+		e.Value = Variable(val)
+		if e.Kind == REPLAY_ROLLBACK {
+			// drop events of rolled back steps
+			if n := int(val); n >= 0 && n <= len(rl.events) {
+				rl.events = rl.events[:n]
+				continue
+			}
+			res = Failure(ErrModelReplay + ": invalid rollback").SetLine(lineNo)
+			return
+		}
+		rl.events = append(rl.events, e)
+	}
+	if err := rdr.Err(); err != nil {
+		res = Failure(err)
+	}
+	return
+}
+
+// Replaying returns true if events are replayed.
+func (rl *ReplayLog) Replaying() bool {
+	return rl.out == nil
+}
+
+// record an event
+func (rl *ReplayLog) record(epoch int, kind, name string, val Variable) (res *Result) {
+	res = Success()
+	e := &ReplayEvent{Epoch: epoch, Kind: kind, Name: name, Value: val}
+	if _, err := fmt.Fprintln(rl.out, e.String()); err != nil {
+		res = Failure(err)
+	}
+	if kind != REPLAY_ROLLBACK {
+		rl.num++
+	}
+	return
+}
+
+// count returns the number of recorded (or replayed) events.
+func (rl *ReplayLog) count() int {
+	if rl.Replaying() {
+		return rl.pos
+	}
+	return rl.num
+}
+
+// rewind the log to given number of events (after a rollback): replayed
+// events are replayed again; recorded events are dropped (by a ROLLBACK
+// event in the log).
+func (rl *ReplayLog) rewind(epoch, n int) *Result {
+	if rl.Replaying() {
+		rl.pos = n
+		return Success()
+	}
+	if n == rl.num {
+		return Success()
+	}
+	res := rl.record(epoch, REPLAY_ROLLBACK, "-", Variable(n))
+	rl.num = n
+	return res
+}
+
+// next returns the value of the next replayed event; the event must be of
+// given kind and name.
+func (rl *ReplayLog) next(kind, name string) (val Variable, res *Result) {
+	if rl.pos >= len(rl.events) {
+		res = Failure(ErrModelReplay+": no more events (%s %s)", kind, name)
+		return
+	}
+	e := rl.events[rl.pos]
+	if e.Kind != kind || e.Name != name {
+		res = Failure(ErrModelReplay+": expected %s %s, got %s %s", kind, name, e.Kind, e.Name)
+		return
+	}
+	rl.pos++
+	return e.Value, Success()
+}
+
+// peek returns the next replayed event (or nil)
+func (rl *ReplayLog) peek() *ReplayEvent {
+	if rl.pos >= len(rl.events) {
+		return nil
+	}
+	return rl.events[rl.pos]
+}
+
+// epoch of the current model run (or 0 if not running)
+func (mdl *Model) epoch() int {
+	if mdl.run == nil {
+		return 0
+	}
+	return mdl.run.epoch
+}
+
+// random returns a (recorded or replayed) random number in [0,1).
+func (mdl *Model) random() (val Variable, res *Result) {
+	rl := mdl.Replay
+	if rl != nil && rl.Replaying() {
+		return rl.next(REPLAY_NOISE, "-")
+	}
+	if mdl.rng != nil {
+		val = Variable(mdl.rng.Float64())
+	} else {
+		val = Variable(rand.Float64())
+	}
+	res = Success()
+	if rl != nil {
+		res = rl.record(mdl.epoch(), REPLAY_NOISE, "-", val)
+	}
+	return
+}
+
+// replayInputs sets the replayed values of input ports for the current
+// epoch of a model run.
+func (mdl *Model) replayInputs() (res *Result) {
+	res = Success()
+	rl := mdl.Replay
+	if rl == nil || !rl.Replaying() {
+		return
+	}
+	for e := rl.peek(); e != nil && e.Kind == REPLAY_INPUT && e.Epoch == mdl.epoch(); e = rl.peek() {
+		mdl.Current[e.Name] = e.Value
+		rl.pos++
+	}
+	return
+}
@@ -0,0 +1,105 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+//----------------------------------------------------------------------
+// REPRODUCIBLE RUNS -- In reproducible mode the levels (and TIME) are
+// updated with Kahan-compensated summation: rounding errors don't
+// accumulate over (many) epochs, so results of archival models are stable
+// across platforms and implementations.
+//----------------------------------------------------------------------
+
+// add an increment to a value with compensation (Kahan summation).
+func (run *runState) add(name string, val, inc Variable) Variable {
+	y := Variable(inc - run.comp[name])
+	t := Variable(val + y)
+	run.comp[name] = Variable(t-val) - y
+	return t
+}
+
+// computeLevels computes the new values of levels with compensated
+// summation. Only level equations of the form 'L X.K=X.J+A-B...' are
+// compensated (the sum of the terms is the increment of the level); other
+// level equations are evaluated as usual.
+func (mdl *Model) computeLevels(eqns *EqnList) (res *Result) {
+	res = Success()
+	for _, eqn := range eqns.List() {
+		if eqn.Mode != "L" {
+			continue
+		}
+		if terms, signs := levelTerms(eqn); terms != nil {
+			// evaluate increment
+			missing := make(map[string]*Name)
+			var inc Variable
+			for i, term := range terms {
+				var val Variable
+				if val, res = eval(term, mdl, missing); !res.Ok {
+					return
+				}
+				// explicit conversion: no fused multiply-add
+				inc += Variable(signs[i] * val)
+			}
+			for name := range missing {
+				Logf(LOG_WARN, LOG_RUN, "Missing variable %s", name)
+			}
+			name := eqn.Target.Name
+			val := mdl.run.add(name, mdl.Last[name], inc).Typed(eqn.Type)
+			if res = mdl.Set(eqn.Target, val); !res.Ok {
+				return
+			}
+			continue
+		}
+		if _, res = eqn.Eval(mdl); !res.Ok {
+			mdl.Dbg.Msg(eqn.String())
+			return
+		}
+	}
+	return
+}
+
+// levelTerms returns the terms (and their signs) of the increment in a
+// level equation 'L X.K=X.J+A-B...' (or nil if the equation has a
+// different form).
+func levelTerms(eqn *Equation) (terms []ast.Expr, signs []Variable) {
+	expr := eqn.Formula
+	for {
+		x, ok := expr.(*ast.BinaryExpr)
+		if !ok || (x.Op != token.ADD && x.Op != token.SUB) {
+			break
+		}
+		sign := Variable(1)
+		if x.Op == token.SUB {
+			sign = -1
+		}
+		terms = append([]ast.Expr{x.Y}, terms...)
+		signs = append([]Variable{sign}, signs...)
+		expr = x.X
+	}
+	if name, res := NewName(expr); res.Ok && name.Name == eqn.Target.Name && name.Stage == NAME_STAGE_OLD {
+		return
+	}
+	return nil, nil
+}
@@ -21,6 +21,7 @@ package dynamo
 //----------------------------------------------------------------------
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 )
@@ -40,7 +41,9 @@ const (
 	ErrModelNoVariable        = "No variable found"
 	ErrModelVariabeExists     = "Variable already known"
 	ErrModelNoSuchTable       = "No such table"
+	ErrModelNoSuchSeries      = "No such data series"
 	ErrModelWrongTableSize    = "Tabe size mismatch"
+	ErrModelWrongTableRange   = "Table range mismatch"
 	ErrModelNoTime            = "No TIME defined"
 	ErrModelMaxRetry          = "Retry limit reached"
 	ErrModelMissingDef        = "Missing definition of value"
@@ -48,6 +51,19 @@ const (
 	ErrModelFunction          = "Error in function"
 	ErrModelNotAvailable      = "Model equations not available"
 	ErrModelNoInitial         = "No initial value"
+	ErrModelRunMismatch       = "Model runs don't match"
+	ErrModelGame              = "Invalid game definition"
+	ErrModelNotRunning        = "Model is not running"
+	ErrModelNoPort            = "No such port"
+	ErrModelNoHistory         = "Not enough history for rollback"
+	ErrModelNoSnapshot        = "No such snapshot"
+	ErrModelRunning           = "Model is running"
+	ErrModelReplay            = "Replay failed"
+	ErrModelBounds            = "Variable out of bounds"
+	ErrModelAnomaly           = "Numeric anomaly"
+	ErrModelPolicy            = "Invalid run policy"
+	ErrModelSystemVar         = "Invalid equation for system variable"
+	ErrModelCanceled          = "Model run canceled"
 
 	ErrParseLineLength      = "Line too long"
 	ErrParseInvalidSpace    = "Space in equation"
@@ -58,22 +74,185 @@ const (
 	ErrParseSyntax          = "Syntax error"
 	ErrParseInvalidOp       = "Unknown operand"
 	ErrParseTableTooSmall   = "Not enough table elements"
+	ErrParseTableFormat     = "Invalid table data"
+	ErrParseSeriesFormat    = "Invalid data series"
 	ErrParseUnknownFunction = "Unknown function"
 	ErrParseInvalidNumArgs  = "Invalid number of arguments"
 	ErrParseMacroDepth      = "Invalid nesting for macro function"
 	ErrParseNotANumber      = "Not a number"
+	ErrParseEncoding        = "Invalid source encoding"
+	ErrParseSector          = "Invalid sector definition"
+	ErrParseSpec            = "Invalid SPEC statement"
 
 	ErrPlotRange = "Range failure"
 	ErrPlotNoVar = "Not a plot variable"
 	ErrPlotMode  = "No such plotter mode"
 
-	ErrPrintNoVar = "Not a print variable"
-	ErrPrintMode  = "No such plotter mode"
+	ErrLogCategory = "Unknown log category"
+	ErrLogLevel    = "Unknown log level"
+
+	ErrPrintNoVar  = "Not a print variable"
+	ErrPrintMode   = "No such printer mode"
+	ErrPrintSparse = "Invalid sparse output"
+
+	ErrBatchManifest = "Invalid batch manifest"
+	ErrLintRule      = "Unknown lint rule"
+	ErrFuzz          = "Fuzz test failed"
 )
 
+//----------------------------------------------------------------------
+// Error kinds
+//----------------------------------------------------------------------
+
+// Kind is a kind of DYNAMO error with a stable numeric code. Each error
+// message defined above has a registered kind that is used as a sentinel
+// in 'errors.Is()' like in 'errors.Is(res, ErrorKind(ErrModelNoSuchTable))'.
+type Kind struct {
+	Code int    // stable numeric code
+	Msg  string // error message (Err??? constant)
+}
+
+// Error returns the message of the error kind.
+func (k *Kind) Error() string {
+	return k.Msg
+}
+
+// errCodes assigns stable numeric codes to error messages. Codes must not
+// be changed or reused: model errors are 1xx, parse errors 2xx, output
+// errors 3xx, logging errors 4xx and tool errors (batch, lint) 5xx.
+var errCodes = []struct {
+	code int
+	msg  string
+}{
+	{100, ErrModelDependencyLoop},
+	{101, ErrModelEqnBadTargetKind},
+	{102, ErrModelEqnBadTargetStage},
+	{103, ErrModelEqnBadDependClass},
+	{104, ErrModelEqnBadMode},
+	{105, ErrModelEqnOverwrite},
+	{106, ErrModelEqnAmbigious},
+	{107, ErrModelUnknownEqn},
+	{108, ErrModelUnknownFunction},
+	{109, ErrModelFunctionArg},
+	{110, ErrModelNoVariable},
+	{111, ErrModelVariabeExists},
+	{112, ErrModelNoSuchTable},
+	{113, ErrModelNoSuchSeries},
+	{114, ErrModelWrongTableSize},
+	{115, ErrModelWrongTableRange},
+	{116, ErrModelNoTime},
+	{117, ErrModelMaxRetry},
+	{118, ErrModelMissingDef},
+	{119, ErrModelNoData},
+	{120, ErrModelFunction},
+	{121, ErrModelNotAvailable},
+	{122, ErrModelNoInitial},
+	{123, ErrModelRunMismatch},
+	{124, ErrModelGame},
+	{125, ErrModelNotRunning},
+	{126, ErrModelNoPort},
+	{127, ErrModelNoHistory},
+	{128, ErrModelNoSnapshot},
+	{129, ErrModelRunning},
+	{130, ErrModelReplay},
+	{131, ErrModelBounds},
+	{132, ErrModelAnomaly},
+	{133, ErrModelPolicy},
+	{134, ErrModelSystemVar},
+	{135, ErrModelCanceled},
+	{200, ErrParseLineLength},
+	{201, ErrParseInvalidSpace},
+	{202, ErrParseInvalidMode},
+	{203, ErrParseInvalidName},
+	{204, ErrParseInvalidIndex},
+	{205, ErrParseNameLength},
+	{206, ErrParseSyntax},
+	{207, ErrParseInvalidOp},
+	{208, ErrParseTableTooSmall},
+	{209, ErrParseTableFormat},
+	{210, ErrParseSeriesFormat},
+	{211, ErrParseUnknownFunction},
+	{212, ErrParseInvalidNumArgs},
+	{213, ErrParseMacroDepth},
+	{214, ErrParseNotANumber},
+	{215, ErrParseEncoding},
+	{216, ErrParseSector},
+	{217, ErrParseSpec},
+	{300, ErrPlotRange},
+	{301, ErrPlotNoVar},
+	{302, ErrPlotMode},
+	{400, ErrLogCategory},
+	{401, ErrLogLevel},
+	{310, ErrPrintNoVar},
+	{311, ErrPrintMode},
+	{500, ErrBatchManifest},
+	{501, ErrLintRule},
+	{502, ErrFuzz},
+}
+
+// errKinds is the registry of error kinds (sentinels)
+var errKinds = make(map[string]*Kind)
+
+func init() {
+	for _, ec := range errCodes {
+		errKinds[ec.msg] = &Kind{Code: ec.code, Msg: ec.msg}
+	}
+}
+
+// ErrorKind returns the error kind (sentinel) for an error message (Err???
+// constant). It returns nil for unknown messages.
+func ErrorKind(msg string) error {
+	if kind, ok := errKinds[msg]; ok {
+		return kind
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------
+// DYNAMO errors
+//----------------------------------------------------------------------
+
+// Error is a DYNAMO error of a given kind; the message can have additional
+// details. Errors match their kind in 'errors.Is()'; errors wrapped with
+// '%w' in the message are available with 'errors.Unwrap()'.
+type Error struct {
+	Kind  *Kind  // kind of error (or nil if not registered)
+	Msg   string // complete error message
+	cause error  // wrapped error (if any)
+}
+
+// Error returns the error message.
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+// Code returns the numeric code of the error kind (or 0 if unknown).
+func (e *Error) Code() int {
+	if e.Kind == nil {
+		return 0
+	}
+	return e.Kind.Code
+}
+
+// Is returns true if the target is the kind of the error.
+func (e *Error) Is(target error) bool {
+	k, ok := target.(*Kind)
+	return ok && e.Kind != nil && k == e.Kind
+}
+
+// Unwrap returns a wrapped error (as specified with '%w' in the message)
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+//----------------------------------------------------------------------
+// Results
+//----------------------------------------------------------------------
+
 // Result represents the response of a method call in the Dynamo framework.
 // It allows to track failures with more information than 'error' alone
-// provides.
+// provides. A failed result can be used as an error; it wraps the failure
+// so that 'errors.Is()' and 'errors.As()' work on results.
 type Result struct {
 	Ok   bool        // call returned without problems
 	Err  error       // error (if !Ok)
@@ -92,17 +271,24 @@ func Success() *Result {
 }
 
 // Failure returns a result for a failed operation. The parameter can be
-// of type 'string' or 'error'.
+// of type 'string' or 'error'. A string is used as a format for the error
+// message; the part before the first colon is the kind of the error.
 func Failure(err interface{}, args ...interface{}) *Result {
 	var e error = nil
 	switch x := err.(type) {
 	case error:
 		e = x
 	case string:
+		var cause error
 		if len(args) > 0 {
-			e = fmt.Errorf(x, args...)
+			cause = fmt.Errorf(x, args...)
 		} else {
-			e = fmt.Errorf(x)
+			cause = fmt.Errorf(x)
+		}
+		e = &Error{
+			Kind:  errKinds[strings.TrimSpace(strings.SplitN(x, ":", 2)[0])],
+			Msg:   cause.Error(),
+			cause: errors.Unwrap(cause),
 		}
 	}
 	return &Result{
@@ -121,9 +307,41 @@ func (r *Result) SetLine(line int) *Result {
 }
 
 // IsA returns true if the given (failure) result is of given error
+//
+// Deprecated: use 'errors.Is(res, ErrorKind(err))' instead.
 func (r *Result) IsA(err string) bool {
 	if r.Err == nil {
 		return false
 	}
+	if kind := ErrorKind(err); kind != nil && errors.Is(r.Err, kind) {
+		return true
+	}
 	return strings.HasPrefix(r.Err.Error(), err)
 }
+
+// Code returns the numeric code of the error kind of a failed result (or
+// 0 if the result is successful or the error has no registered kind).
+func (r *Result) Code() int {
+	var e *Error
+	if r.Err != nil && errors.As(r.Err, &e) {
+		return e.Code()
+	}
+	return 0
+}
+
+// Error returns the failure message of a result (including the line
+// number if available).
+func (r *Result) Error() string {
+	if r.Err == nil {
+		return ""
+	}
+	if r.Line > 0 {
+		return fmt.Sprintf("line %d: %s", r.Line, r.Err.Error())
+	}
+	return r.Err.Error()
+}
+
+// Unwrap returns the error of a failed result.
+func (r *Result) Unwrap() error {
+	return r.Err
+}
@@ -0,0 +1,156 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+//======================================================================
+// Scatter plots of sweep results: the output metrics of all runs (values
+// of variables at a point in time) are plotted against the swept
+// parameters (and the parameters against each other) in a scatter matrix
+// to visualize the sensitivity of a model. The swept parameters are
+// taken from the recorded (initial) values of the runs.
+//======================================================================
+
+// Scatter holds the values of parameters and outputs of sweep runs.
+type Scatter struct {
+	Names  []string    // names of parameters followed by names of outputs
+	Params int         // number of parameters
+	Time   float64     // point in time of outputs
+	Points [][]float64 // values of parameters and outputs (per run)
+}
+
+// NewScatter collects the initial values of parameters and the values of
+// outputs at a point in time (use +Inf for the final values) from the
+// results of a sweep.
+func NewScatter(list []*Dataset, params, outputs []string, t float64) (sc *Scatter, res *Result) {
+	if len(params) == 0 {
+		return nil, Failure(ErrModelNoVariable + ": no parameters")
+	}
+	sc = &Scatter{
+		Names:  append(append([]string{}, params...), outputs...),
+		Params: len(params),
+		Time:   t,
+	}
+	for _, ds := range list {
+		pnt := make([]float64, len(sc.Names))
+		for i, name := range sc.Names {
+			vals, ok := ds.Vars[name]
+			if !ok || len(vals) == 0 {
+				return nil, Failure(ErrModelNoVariable+": %s in %s", name, ds.RunID)
+			}
+			epoch := 0
+			if i >= sc.Params {
+				if epoch = len(vals) - 1; !math.IsInf(t, 1) {
+					epoch = ds.epoch(t)
+				}
+			}
+			if epoch < 0 || epoch >= len(vals) {
+				return nil, Failure(ErrModelNoData+": %s at TIME=%g in %s", name, t, ds.RunID)
+			}
+			pnt[i] = vals[epoch]
+		}
+		sc.Points = append(sc.Points, pnt)
+	}
+	if len(sc.Points) == 0 {
+		return nil, Failure(ErrModelNoData + ": no runs")
+	}
+	return sc, Success()
+}
+
+// Correlation returns the (Pearson) correlation coefficient of the i-th
+// and j-th variable across runs (or NaN if a variable doesn't vary).
+func (sc *Scatter) Correlation(i, j int) float64 {
+	n := float64(len(sc.Points))
+	var mx, my float64
+	for _, pnt := range sc.Points {
+		mx += pnt[i] / n
+		my += pnt[j] / n
+	}
+	var sxy, sxx, syy float64
+	for _, pnt := range sc.Points {
+		dx, dy := pnt[i]-mx, pnt[j]-my
+		sxy += dx * dy
+		sxx += dx * dx
+		syy += dy * dy
+	}
+	if sxx == 0 || syy == 0 {
+		return math.NaN()
+	}
+	return sxy / math.Sqrt(sxx*syy)
+}
+
+// Write a table of correlation coefficients of all variables (rows) with
+// the parameters (columns).
+func (sc *Scatter) Write(w io.Writer) {
+	fmt.Fprintf(w, "\n\n      SENSITIVITY (%d RUNS)\n\n", len(sc.Points))
+	fmt.Fprintf(w, "  %-8s", "")
+	for _, name := range sc.Names[:sc.Params] {
+		fmt.Fprintf(w, "  %8s", name)
+	}
+	fmt.Fprintln(w)
+	for i, name := range sc.Names {
+		fmt.Fprintf(w, "  %-8s", name)
+		for j := 0; j < sc.Params; j++ {
+			fmt.Fprintf(w, "  %8.3f", sc.Correlation(i, j))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// Gnuplot writes a GNUplot script for the scatter matrix that renders the
+// plot into the SVG file "<base>.svg": a row for each variable and a
+// column for each parameter (the diagonal is left empty).
+func (sc *Scatter) Gnuplot(w io.Writer, base string) *Result {
+	fmt.Fprintln(w, "$runs << EOD")
+	for _, pnt := range sc.Points {
+		for i, val := range pnt {
+			if i > 0 {
+				fmt.Fprint(w, " ")
+			}
+			fmt.Fprintf(w, "%f", val)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, "EOD")
+	rows, cols := len(sc.Names), sc.Params
+	fmt.Fprintf(w, "set term svg size %d,%d\n", 250*cols+50, 200*rows+50)
+	fmt.Fprintf(w, "set output \"%s.svg\"\n", base)
+	fmt.Fprintln(w, "unset key")
+	fmt.Fprintf(w, "set multiplot layout %d,%d\n", rows, cols)
+	for i, y := range sc.Names {
+		for j, x := range sc.Names[:cols] {
+			if i == j {
+				fmt.Fprintln(w, "set multiplot next")
+				continue
+			}
+			fmt.Fprintf(w, "set xlabel \"%s\"\n", x)
+			fmt.Fprintf(w, "set ylabel \"%s\"\n", y)
+			fmt.Fprintf(w, "plot $runs using %d:%d with points pt 7 ps 0.5\n", j+1, i+1)
+		}
+	}
+	fmt.Fprintln(w, "unset multiplot")
+	return Success()
+}
@@ -0,0 +1,381 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+//======================================================================
+// SECTORS -- Large models can be split into sectors:
+//
+//     SECTOR PROD
+//     L INV.K=INV.J+DT*(PR.JK-SALES.SHIP.JK)
+//     ...
+//     ENDSECTOR
+//
+// Variables (and tables) defined in a sector are qualified with the
+// sector name (PROD.INV); inside the sector the unqualified names can be
+// used. Variables of other sectors (the interface of a sector) must be
+// referenced with qualified names; unqualified names that are not defined
+// in the sector refer to global variables (like TIME).
+//======================================================================
+
+// Statement modes allowed in a sector
+var sectorModes = []string{"L", "R", "C", "N", "A", "S", "T", "T2", "NOTE"}
+
+// sectorBlock collects the statements of a sector
+type sectorBlock struct {
+	name  string            // name of the sector
+	stmts []*Line           // list of statements in sector
+	wires map[string]string // inputs of a submodel instance
+}
+
+// startSector begins a new SECTOR block.
+func (mdl *Model) startSector(name string) (res *Result) {
+	var n *Name
+	if n, res = NewNameFromString(name); !res.Ok {
+		return
+	}
+	if len(n.Qualifier()) > 0 || n.Kind != NAME_KIND_CONST {
+		return Failure(ErrParseSector+": %s", name)
+	}
+	if res = n.Check(mdl.Strict); res.Ok {
+		mdl.block = &sectorBlock{name: name}
+		mdl.known[name] = true
+	}
+	return
+}
+
+// checkQualifiers checks that all qualified names (SECTOR.NAME) in the
+// equations refer to known sectors; otherwise the name has an invalid
+// index (like "INV.KK").
+func (mdl *Model) checkQualifiers(eqns *EqnList) *Result {
+	if eqns == nil {
+		return Success()
+	}
+	for _, eqn := range eqns.List() {
+		for _, list := range [][]*Name{{eqn.Target}, eqn.Dependencies, eqn.References} {
+			for _, n := range list {
+				if q := n.Qualifier(); len(q) > 0 && !mdl.known[q] {
+					return Failure(ErrParseInvalidIndex+": %s", n.Name)
+				}
+			}
+		}
+	}
+	return Success()
+}
+
+// addSectorStatement collects statements in a SECTOR block. At the end of
+// the block (ENDSECTOR) all statements are qualified and added to the model.
+func (mdl *Model) addSectorStatement(stmt *Line) (res *Result) {
+	blk := mdl.block
+	if blk == nil {
+		return Failure(ErrParseSector + ": ENDSECTOR without SECTOR")
+	}
+	if stmt.Mode != "ENDSECTOR" {
+		for _, mode := range sectorModes {
+			if stmt.Mode == mode {
+				blk.stmts = append(blk.stmts, stmt)
+				return Success()
+			}
+		}
+		return Failure(ErrParseSector+": mode '%s' in sector %s", stmt.Mode, blk.name)
+	}
+	mdl.block = nil
+	return mdl.addSector(blk)
+}
+
+// addSector adds the (qualified) statements of a sector to the model.
+func (mdl *Model) addSector(blk *sectorBlock) (res *Result) {
+	// collect names defined and used in the sector
+	defs := make(map[string]bool)
+	used := make(map[string]bool)
+	for _, s := range blk.stmts {
+		if s.Mode != "NOTE" {
+			forIdents(s.Stmt, func(id, rest string) string {
+				if isDefinition(rest) {
+					defs[id] = true
+				} else {
+					used[id] = true
+				}
+				return id
+			})
+		}
+	}
+	// check wiring of inputs
+	for input := range blk.wires {
+		if defs[input] || !used[input] {
+			return Failure(ErrParseSector+": %s is not an input of %s", input, blk.name)
+		}
+	}
+	// add qualified statements to the model
+	sector := mdl.sector
+	mdl.sector, mdl.scope = blk.name, blk.name
+	defer func() {
+		mdl.sector, mdl.scope = sector, ""
+	}()
+	for _, s := range blk.stmts {
+		q := *s
+		if s.Mode != "NOTE" {
+			q.Stmt = forIdents(s.Stmt, func(id, rest string) string {
+				if defs[id] {
+					return blk.name + "." + id
+				}
+				if wire, ok := blk.wires[id]; ok {
+					return wire
+				}
+				return id
+			})
+		}
+		if res = mdl.AddStatement(&q); !res.Ok {
+			break
+		}
+	}
+	return
+}
+
+//----------------------------------------------------------------------
+// SUBMODEL instances -- a (parsed) model can be instantiated multiple
+// times in another model: the instance is added like a sector with the
+// instance name as prefix. Inputs of the submodel (variables that are
+// not defined in the submodel) can be wired to variables of the model:
+//
+//     INSTANCE MKT1(DEMAND=DEM1)=@market.dynamo
+//
+// System variables (like TIME and DT) are shared between the model and
+// its instances.
+//----------------------------------------------------------------------
+
+// Instantiate adds the equations and tables of a submodel to the model.
+// All names defined in the submodel are qualified with the prefix; inputs
+// of the submodel are renamed as defined in the wiring map.
+func (mdl *Model) Instantiate(prefix string, sub *Model, wires map[string]string) (res *Result) {
+	if mdl.block != nil {
+		return Failure(ErrParseSector+": instance %s in sector %s", prefix, mdl.block.name)
+	}
+	if res = mdl.startSector(prefix); !res.Ok {
+		return
+	}
+	blk := mdl.block
+	mdl.block = nil
+	blk.wires = wires
+
+	// add submodel equations and tables (skip system variables)
+	for _, stmt := range sub.Statements() {
+		if stmt.Mode != "T" && stmt.Mode != "T2" && sub.IsSystem(strings.Split(stmt.Stmt, "=")[0]) {
+			continue
+		}
+		blk.stmts = append(blk.stmts, stmt)
+	}
+	if res = mdl.addSector(blk); !res.Ok {
+		return
+	}
+	// tables and data series are not fully described by statements:
+	// keep x-values of tables (from file) and the series of DATA variables.
+	qualify := func(name string) string {
+		if strings.Contains(name, ".") {
+			return name
+		}
+		return prefix + "." + name
+	}
+	for name, tbl := range sub.Tables {
+		if t, ok := mdl.Tables[qualify(name)]; ok {
+			t.X, t.Kind, t.uneven = tbl.X, tbl.Kind, tbl.uneven
+		}
+	}
+	for name, series := range sub.Series {
+		if strings.Contains(name, "@") {
+			// series from EXTDAT("file","COLUMN") are shared
+			mdl.Series[name] = series
+		} else {
+			mdl.Series[qualify(name)] = series
+		}
+	}
+	return
+}
+
+// addInstance handles an INSTANCE statement: "NAME(IN=VAR,...)=@file"
+func (mdl *Model) addInstance(stmt string) (res *Result) {
+	pos := strings.Index(stmt, "=@")
+	if pos == -1 {
+		return Failure(ErrParseSyntax+": %s", stmt)
+	}
+	name, fname := stmt[:pos], stmt[pos+2:]
+	// parse wiring of inputs
+	wires := make(map[string]string)
+	if pos = strings.Index(name, "("); pos != -1 {
+		if !strings.HasSuffix(name, ")") {
+			return Failure(ErrParseSyntax+": %s", stmt)
+		}
+		for _, wire := range strings.Split(name[pos+1:len(name)-1], ",") {
+			w := strings.Split(wire, "=")
+			if len(w) != 2 {
+				return Failure(ErrParseSyntax+": %s", wire)
+			}
+			wires[w[0]] = w[1]
+		}
+		name = name[:pos]
+	}
+	// parse submodel
+	f, err := os.Open(fname)
+	if err != nil {
+		return Failure(err)
+	}
+	defer f.Close()
+	sub := NewModel("", "")
+	sub.Dbg = mdl.Dbg
+	sub.Strict, sub.Relaxed, sub.Encoding = mdl.Strict, mdl.Relaxed, mdl.Encoding
+	if res = sub.Parse(f); !res.Ok {
+		return Failure(ErrParseSector+": %s [%s:%d]", res.Err.Error(), fname, res.Line)
+	}
+	return mdl.Instantiate(name, sub, wires)
+}
+
+// forIdents calls a function for all unqualified variable names in a
+// statement; the function returns the replacement for the name. Function
+// names, indices, numbers and file references are skipped.
+func forIdents(stmt string, f func(id, rest string) string) string {
+	isAlpha := func(c byte) bool {
+		return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || c == '_'
+	}
+	isDigit := func(c byte) bool {
+		return c >= '0' && c <= '9'
+	}
+	out := new(strings.Builder)
+	for i := 0; i < len(stmt); {
+		c := stmt[i]
+		if c == '"' {
+			// quoted string (like file names)
+			end := strings.IndexByte(stmt[i+1:], '"')
+			if end == -1 {
+				out.WriteString(stmt[i:])
+				break
+			}
+			out.WriteString(stmt[i : i+end+2])
+			i += end + 2
+			continue
+		}
+		if c == '@' || c == ';' {
+			// file reference (or inline attributes) up to end of statement
+			out.WriteString(stmt[i:])
+			break
+		}
+		if !isAlpha(c) {
+			out.WriteByte(c)
+			i++
+			continue
+		}
+		start := i
+		for i < len(stmt) && (isAlpha(stmt[i]) || isDigit(stmt[i])) {
+			i++
+		}
+		id, rest := stmt[start:i], stmt[i:]
+		// skip exponents in numbers, indices and qualified names
+		skip := start > 0 && (isDigit(stmt[start-1]) || stmt[start-1] == '.')
+		// skip function names
+		skip = skip || strings.HasPrefix(rest, "(")
+		// skip sector names
+		if !skip && strings.HasPrefix(rest, ".") {
+			end := 1
+			for end < len(rest) && isAlpha(rest[end]) {
+				end++
+			}
+			skip = !isIndex(rest[1:end])
+		}
+		if !skip {
+			id = f(id, rest)
+		}
+		out.WriteString(id)
+	}
+	return out.String()
+}
+
+// isDefinition returns true if a variable name followed by 'rest' is the
+// target of an equation (or table).
+func isDefinition(rest string) bool {
+	if strings.HasPrefix(rest, ".") {
+		pos := strings.Index(rest, "=")
+		if pos == -1 || !isIndex(rest[1:pos]) {
+			return false
+		}
+		rest = rest[pos:]
+	}
+	return strings.HasPrefix(rest, "=")
+}
+
+// SectorGraph writes the dependencies between sectors as a directed
+// graph in GraphViz (DOT) format. An edge from sector A to sector B means
+// that B uses variables defined in A.
+func (mdl *Model) SectorGraph(w io.Writer) {
+	// assign variables to sectors
+	owner := make(map[string]string)
+	eqns := mdl.equations().List()
+	for _, eqn := range eqns {
+		if sector := eqn.Meta[META_SECTOR]; len(sector) > 0 {
+			owner[eqn.Target.Name] = sector
+		}
+	}
+	for name, tbl := range mdl.Tables {
+		if sector := tbl.Meta[META_SECTOR]; len(sector) > 0 {
+			owner[name] = sector
+		}
+	}
+	// collect dependencies between sectors
+	sectors := make(map[string]bool)
+	edges := make(map[string]bool)
+	for _, sector := range owner {
+		sectors[sector] = true
+	}
+	for _, eqn := range eqns {
+		to := eqn.Meta[META_SECTOR]
+		if len(to) == 0 {
+			continue
+		}
+		for _, list := range [][]*Name{eqn.Dependencies, eqn.References} {
+			for _, dep := range list {
+				if from := owner[dep.Name]; len(from) > 0 && from != to {
+					edges[fmt.Sprintf("\"%s\" -> \"%s\"", from, to)] = true
+				}
+			}
+		}
+	}
+	// write sorted graph
+	sorted := func(m map[string]bool) (list []string) {
+		for key := range m {
+			list = append(list, key)
+		}
+		sort.Strings(list)
+		return
+	}
+	fmt.Fprintln(w, "digraph sectors {")
+	for _, sector := range sorted(sectors) {
+		fmt.Fprintf(w, "    \"%s\";\n", sector)
+	}
+	for _, edge := range sorted(edges) {
+		fmt.Fprintf(w, "    %s;\n", edge)
+	}
+	fmt.Fprintln(w, "}")
+}
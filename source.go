@@ -0,0 +1,173 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//======================================================================
+// Model source: the equations and tables of a (parsed) model can be
+// written as DYNAMO source code.
+//======================================================================
+
+// Statements returns the equations (without automatic variables) and
+// tables of a model as a list of source statements.
+func (mdl *Model) Statements() (list []*Line) {
+	for _, eqn := range mdl.equations().List() {
+		if eqn.Target.Name[0] == '_' {
+			continue
+		}
+		list = append(list, &Line{
+			Mode:    eqn.Mode,
+			Stmt:    eqn.Statement(),
+			Comment: eqn.Comment,
+		})
+	}
+	names := make([]string, 0, len(mdl.Tables))
+	for name := range mdl.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tbl := mdl.Tables[name]
+		if tbl.Cols > 0 {
+			// 2-D table (rows separated by '/')
+			var rows []string
+			for i := 0; i < len(tbl.Data); i += tbl.Cols {
+				row := make([]string, tbl.Cols)
+				for j, v := range tbl.Data[i : i+tbl.Cols] {
+					row[j] = strconv.FormatFloat(v, 'g', -1, 64)
+				}
+				rows = append(rows, strings.Join(row, ","))
+			}
+			list = append(list, &Line{
+				Mode:    "T2",
+				Stmt:    name + "=" + strings.Join(rows, "/"),
+				Comment: tbl.Comment,
+			})
+			continue
+		}
+		vals := make([]string, len(tbl.Data))
+		for i, v := range tbl.Data {
+			vals[i] = strconv.FormatFloat(v, 'g', -1, 64)
+			if tbl.uneven {
+				// explicit (x,y) pairs
+				x := strconv.FormatFloat(tbl.X[i], 'g', -1, 64)
+				vals[i] = "(" + x + "," + vals[i] + ")"
+			}
+		}
+		sep := "/"
+		if tbl.uneven {
+			sep = ","
+		}
+		list = append(list, &Line{
+			Mode:    "T",
+			Stmt:    name + "=" + strings.Join(vals, sep),
+			Comment: tbl.Comment,
+		})
+	}
+	return
+}
+
+// WriteSource writes the model as DYNAMO source code to a stream. Input
+// and output ports are included. Strict models are written in classic
+// syntax (with long statements continued on 'X' lines), all other models
+// in relaxed syntax.
+func (mdl *Model) WriteSource(w io.Writer) {
+	mdl.writeSource(w, mdl.Strict)
+}
+
+// writeSource writes the model source in classic or relaxed syntax.
+func (mdl *Model) writeSource(w io.Writer, classic bool) {
+	if len(mdl.Title) > 0 {
+		fmt.Fprintf(w, "* %s\n", mdl.Title)
+	}
+	for _, stmt := range mdl.Statements() {
+		mode := stmt.Mode
+		if mode == "C" && isPort(mdl.Inputs, strings.Split(stmt.Stmt, "=")[0]) {
+			mode = "INPUT"
+		}
+		if classic {
+			for _, line := range splitStatement(mode, stmt.Stmt, stmt.Comment) {
+				fmt.Fprintln(w, line)
+			}
+			continue
+		}
+		fmt.Fprintf(w, "%-6s %s", mode, stmt.Stmt)
+		if len(stmt.Comment) > 0 {
+			fmt.Fprintf(w, "  # %s", stmt.Comment)
+		}
+		fmt.Fprintln(w)
+	}
+	if len(mdl.Outputs) > 0 {
+		fmt.Fprintf(w, "%-6s %s\n", "OUTPUT", strings.Join(mdl.Outputs, ","))
+	}
+}
+
+// splitStatement formats a statement (and its comment) in classic syntax
+// with lines of at most MAX_LINE_LENGTH characters: long statements are
+// continued on 'X' lines after operators (or commas); the comment follows
+// the statement after a space and is continued at word boundaries.
+func splitStatement(mode, stmt, comment string) (lines []string) {
+	prefix, cont := fmt.Sprintf("%-6s", mode), fmt.Sprintf("%-6s", "X")
+	width := MAX_LINE_LENGTH - len(prefix)
+	if len(comment) > 0 {
+		// the comment must start on the last line of the statement
+		width -= 3
+	}
+	for len(stmt) > width {
+		pos := strings.LastIndexAny(stmt[:width], "+-*/,(=")
+		if pos < width/2 {
+			pos = width - 1
+		}
+		lines = append(lines, prefix+stmt[:pos+1])
+		stmt = stmt[pos+1:]
+		prefix = cont
+	}
+	line := prefix + stmt
+	for i, word := range strings.Fields(comment) {
+		sep := " "
+		if i == 0 {
+			sep = "  "
+		} else if len(line)+1+len(word) > MAX_LINE_LENGTH {
+			lines = append(lines, line)
+			line, sep = cont, ""
+		}
+		line += sep
+		for len(line)+len(word) > MAX_LINE_LENGTH {
+			// split words longer than a line
+			n := MAX_LINE_LENGTH - len(line)
+			for n > 0 && !utf8.RuneStart(word[n]) {
+				n--
+			}
+			lines = append(lines, line+word[:n])
+			line, word = cont, word[n:]
+		}
+		line += word
+	}
+	return append(lines, line)
+}
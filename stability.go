@@ -0,0 +1,201 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"go/ast"
+	"go/token"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// DT_FRACTION is the max. fraction of the smallest time constant that is
+// considered a safe DT.
+const DT_FRACTION = 0.25
+
+// order of delay functions (time constant is the second argument)
+var delayOrder = map[string]float64{
+	"DELAY1": 1,
+	"DELAY3": 3,
+	"SMOOTH": 1,
+	"DLINF1": 1,
+	"DLINF3": 3,
+}
+
+//----------------------------------------------------------------------
+// DT STABILITY -- The integration (Euler method) is only stable if DT is
+// small compared to the time constants in a model. Time constants are
+// estimated from delay and smoothing functions (per stage) and from
+// first-order rates like 'R OUT.KL=STOCK.K/DUR'.
+//----------------------------------------------------------------------
+
+// TimeConstant of a model
+type TimeConstant struct {
+	Name string  // variable with time constant
+	Tau  float64 // time constant
+}
+
+// TimeConstants returns the (constant) time constants in the model,
+// smallest first. The model must be initialized (to evaluate constants).
+func (mdl *Model) TimeConstants() (list []*TimeConstant) {
+	// evaluate a constant expression
+	consts := make(map[string]bool)
+	for _, eqn := range mdl.Eqns.List() {
+		if eqn.Mode == "C" {
+			consts[eqn.Target.Name] = true
+		}
+	}
+	constant := func(expr ast.Expr) (float64, bool) {
+		isConst := true
+		ast.Inspect(expr, func(n ast.Node) bool {
+			switch x := n.(type) {
+			case *ast.CallExpr:
+				isConst = false
+			case *ast.Ident, *ast.SelectorExpr:
+				if name, res := NewName(x.(ast.Expr)); !res.Ok || !(consts[name.Name] || mdl.IsSystem(name.Name)) {
+					isConst = false
+				}
+				return false
+			}
+			return isConst
+		})
+		if !isConst {
+			return 0, false
+		}
+		missing := make(map[string]*Name)
+		if val, res := eval(expr, mdl, missing); res.Ok && len(missing) == 0 {
+			return float64(val), true
+		}
+		return 0, false
+	}
+	// check if an expression is a level
+	levels := make(map[string]bool)
+	for _, eqn := range mdl.Eqns.List() {
+		if eqn.Mode == "L" {
+			levels[eqn.Target.Name] = true
+		}
+	}
+	level := func(expr ast.Expr) bool {
+		name, res := NewName(expr)
+		return res.Ok && name.Stage == NAME_STAGE_NEW && levels[name.Name]
+	}
+	add := func(name string, tau float64) {
+		if tau > 0 && !math.IsInf(tau, 0) {
+			list = append(list, &TimeConstant{Name: name, Tau: tau})
+		}
+	}
+	for _, eqn := range mdl.Eqns.List() {
+		// delay and smoothing functions
+		ast.Inspect(eqn.Formula, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) < 2 {
+				return true
+			}
+			if fcn, ok := call.Fun.(*ast.Ident); ok {
+				if order, ok := delayOrder[fcn.Name]; ok {
+					if del, ok := constant(call.Args[1]); ok {
+						add(eqn.Target.Name, del/order)
+					}
+				}
+			}
+			return true
+		})
+		// first-order rates
+		if eqn.Mode != "R" {
+			continue
+		}
+		if x, ok := eqn.Formula.(*ast.BinaryExpr); ok && level(x.X) {
+			if c, ok := constant(x.Y); ok {
+				switch x.Op {
+				case token.QUO:
+					add(eqn.Target.Name, math.Abs(c))
+				case token.MUL:
+					add(eqn.Target.Name, math.Abs(1/c))
+				}
+			}
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Tau < list[j].Tau
+	})
+	return
+}
+
+// SuggestDT returns a safe DT for the model (a "round" number like 0.25
+// or 0.1 not larger than a fraction of the smallest time constant) or 0
+// if the model has no time constants.
+func (mdl *Model) SuggestDT() float64 {
+	list := mdl.TimeConstants()
+	if len(list) == 0 {
+		return 0
+	}
+	limit := DT_FRACTION * list[0].Tau
+	scale := math.Pow10(int(math.Floor(math.Log10(limit))))
+	for _, f := range []float64{5, 2.5, 2, 1} {
+		if dt := f * scale; compare(dt, limit) <= 0 {
+			return dt
+		}
+	}
+	return scale
+}
+
+// checkDT warns if DT is too large for the time constants of the model;
+// in 'AutoDT' mode the suggested DT is returned instead (or 0 if DT is
+// not changed). Only the constants of the model must be computed.
+func (mdl *Model) checkDT() float64 {
+	list := mdl.TimeConstants()
+	if len(list) == 0 {
+		return 0
+	}
+	dt, ok := mdl.Current["DT"]
+	if !ok {
+		dt = 0.1 // default
+	}
+	tc := list[0]
+	if compare(float64(dt), DT_FRACTION*tc.Tau) <= 0 {
+		return 0
+	}
+	suggest := mdl.SuggestDT()
+	if mdl.AutoDT {
+		Logf(LOG_INFO, LOG_MODEL, "      Setting DT=%g (time constant %g in %s)\n", suggest, tc.Tau, tc.Name)
+		return suggest
+	}
+	Logf(LOG_WARN, LOG_MODEL, "DT=%g is too large for time constant %g in %s (use DT=%g)\n", dt, tc.Tau, tc.Name, suggest)
+	return 0
+}
+
+// setDT sets a new DT before the initialization of a model run: the
+// equation for DT is replaced (initial values can depend on DT).
+func (mdl *Model) setDT(dt float64) (res *Result) {
+	mdl.Current["DT"] = Variable(dt)
+	if mdl.Eqns.Find("DT") == nil {
+		return Success()
+	}
+	var eqns *EqnList
+	stmt := &Line{Mode: "C", Stmt: "DT=" + strconv.FormatFloat(dt, 'g', -1, 64)}
+	if eqns, res = NewEquation(stmt, mdl); res.Ok {
+		for _, eqn := range eqns.List() {
+			mdl.Eqns.Replace(eqn)
+		}
+	}
+	return
+}
@@ -0,0 +1,701 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//======================================================================
+// SWEEP -- A parameter sweep (e.g. for Monte Carlo or sensitivity
+// analysis) runs a model for many parameter vectors. The initial state of
+// each run is computed as usual; the run-time equations are then compiled
+// once into functions that compute an equation for all runs at the same
+// time on slices of values (one value per run). This amortizes the
+// interpretation of equations across runs.
+//
+// Models that can't be vectorized (functions with internal state like
+// DELAY or NOISE, bounds, conserved groups, run policies, gaming, pacing
+// or replay) are run one after the other with the same results.
+//======================================================================
+
+// Sweep runs the model (as defined by the last RUN statement or the
+// current equations) for a list of parameter vectors. A vector holds
+// constants that are overridden (in addition to Model.Overrides). It
+// returns the recorded results (without supplementary variables) in the
+// order of the vectors; the results are named "<RunID>#<n>". The model
+// results and the print and plot output are not changed by a sweep; the
+// distribution plots of the plotter (Plotter.AddDistribution) are added
+// to the plot files.
+func (mdl *Model) Sweep(params []State) (list []*Dataset, res *Result) {
+	if list, _, res = mdl.sweep(params, true); res.Ok {
+		res = mdl.Plot.plotDistributions(list)
+	}
+	return
+}
+
+// sweep runs a parameter sweep (vectorized if possible and requested).
+func (mdl *Model) sweep(params []State, vector bool) (list []*Dataset, vectorized bool, res *Result) {
+	if mdl.run != nil {
+		return nil, false, Failure(ErrModelRunning)
+	}
+	// get model equations to run
+	eqns := mdl.Eqns
+	if eqns == nil {
+		stacked, ok := mdl.Stack[mdl.RunID]
+		if !ok {
+			return nil, false, Failure(ErrModelNotAvailable+": %s", mdl.RunID)
+		}
+		eqns = stacked
+	}
+	// restore model after sweep
+	orig, sorted, edited := mdl.Eqns, mdl.sorted, mdl.edited
+	runID, overrides := mdl.RunID, mdl.Overrides
+	last, current, auto := mdl.Last, mdl.Current, mdl.auto
+	prtRun, pltRun := mdl.Print.run, mdl.Plot.run
+	defer func() {
+		mdl.Eqns, mdl.sorted, mdl.edited = orig, sorted, edited
+		mdl.RunID, mdl.Overrides = runID, overrides
+		mdl.Last, mdl.Current, mdl.auto = last, current, auto
+		mdl.Print.run, mdl.Plot.run = prtRun, pltRun
+		mdl.run = nil
+	}()
+	// start the run for a parameter vector
+	start := func(i int) *Result {
+		mdl.RunID = fmt.Sprintf("%s#%d", runID, i+1)
+		mdl.Overrides = make(State)
+		for name, val := range overrides {
+			mdl.Overrides[name] = val
+		}
+		for name, val := range params[i] {
+			mdl.Overrides[name] = val
+		}
+		mdl.Eqns, mdl.sorted, mdl.edited = eqns.Clone(), orig == nil || sorted, edited
+		mdl.Last, mdl.Current = make(State), make(State)
+		mdl.auto.reset()
+		return mdl.Start()
+	}
+	// vectorized run
+	if vector && mdl.vectorizable() {
+		sw := newSweep(len(params))
+		var runEqns *EqnList
+		for i := range params {
+			if res = start(i); !res.Ok {
+				return
+			}
+			runEqns = mdl.run.eqns
+			ok := len(mdl.run.bounds) == 0 && len(mdl.conserve) == 0 && sw.add(i, mdl)
+			mdl.run = nil
+			if !ok {
+				sw = nil
+				break
+			}
+		}
+		if sw != nil && !sw.compile(mdl, runEqns) {
+			sw = nil
+		}
+		if sw != nil {
+			Logf(LOG_INFO, LOG_RUN, "      Iterating epochs of %d runs (vectorized)...", sw.n)
+			res = sw.run()
+			return sw.ds, true, res
+		}
+		Log(LOG_VERBOSE, LOG_RUN, "      Sweep not vectorized")
+	}
+	// run one after the other
+	for i := range params {
+		if res = start(i); !res.Ok {
+			return
+		}
+		for !mdl.Done() {
+			if res = mdl.step(); !res.Ok {
+				break
+			}
+		}
+		mdl.Finish()
+		if !res.Ok {
+			return
+		}
+		ds := mdl.Results[mdl.RunID]
+		delete(mdl.Results, mdl.RunID)
+		for _, name := range supplements(mdl.Eqns) {
+			delete(ds.Vars, name)
+		}
+		list = append(list, ds)
+	}
+	return
+}
+
+// vectorizable returns false if the model settings don't allow a
+// vectorized run.
+func (mdl *Model) vectorizable() bool {
+	return mdl.Game == nil && mdl.Pacer == nil && mdl.Replay == nil &&
+		!mdl.Repro && !mdl.Policy.active() && len(mdl.snapAt) == 0
+}
+
+//----------------------------------------------------------------------
+// Vectorized runs
+//----------------------------------------------------------------------
+
+// vecEqn is a compiled run-time equation
+type vecEqn struct {
+	mode   string           // mode of equation
+	target []float64        // values of target variable
+	expr   func() []float64 // compiled formula
+}
+
+// sweep is a vectorized run for a list of parameter vectors
+type sweep struct {
+	n     int                  // number of runs
+	cur   map[string][]float64 // current values of variables (K)
+	last  map[string][]float64 // previous values of variables (J)
+	names []string             // names of recorded variables
+	eqns  []*vecEqn            // compiled run-time equations
+	ds    []*Dataset           // recorded results of runs
+	autos []arena              // automatic variables of runs (initial)
+	res   *Result              // first failed computation (or nil)
+	skip  []bool               // runs not evaluating a branch (or nil)
+}
+
+// newSweep creates a new vectorized run for n parameter vectors.
+func newSweep(n int) *sweep {
+	return &sweep{
+		n:     n,
+		cur:   make(map[string][]float64),
+		last:  make(map[string][]float64),
+		ds:    make([]*Dataset, n),
+		autos: make([]arena, n),
+	}
+}
+
+// add the initial state of the i-th run; all runs must have the same set
+// of variables and the same time frame.
+func (sw *sweep) add(i int, mdl *Model) bool {
+	state := mdl.Current
+	if i == 0 {
+		for name := range state {
+			sw.cur[name] = make([]float64, sw.n)
+			sw.last[name] = make([]float64, sw.n)
+		}
+	} else {
+		if len(state) != len(sw.cur) {
+			return false
+		}
+		for _, name := range []string{"TIME", "DT", "LENGTH", "MAXLEN"} {
+			if vals, ok := sw.cur[name]; ok && float64(state[name]) != vals[0] {
+				return false
+			}
+		}
+	}
+	for name, val := range state {
+		vals, ok := sw.cur[name]
+		if !ok {
+			return false
+		}
+		vals[i] = float64(val)
+	}
+	sw.autos[i] = mdl.auto
+	sw.ds[i] = NewDataset(mdl.RunID)
+	sw.ds[i].Provenance = mdl.run.ds.Provenance
+	return true
+}
+
+// compile the run-time equations (supplements are not computed). Returns
+// false if an equation can't be vectorized.
+func (sw *sweep) compile(mdl *Model, eqns *EqnList) bool {
+	// record variables of the initial state and auxiliaries and rates
+	suppl := make(map[string]bool)
+	for _, name := range supplements(eqns) {
+		suppl[name] = true
+	}
+	for name := range sw.cur {
+		if name[0] != '_' && !suppl[name] {
+			sw.names = append(sw.names, name)
+		}
+	}
+	for _, eqn := range eqns.List() {
+		name := eqn.Target.Name
+		if _, ok := sw.cur[name]; !ok && eqn.Mode != "S" {
+			if eqn.Mode != "L" && name[0] != '_' {
+				sw.names = append(sw.names, name)
+			}
+			sw.cur[name] = make([]float64, sw.n)
+			sw.last[name] = make([]float64, sw.n)
+		}
+	}
+	for _, eqn := range eqns.List() {
+		if eqn.Mode == "S" {
+			continue
+		}
+		if eqn.Type != TYPE_REAL {
+			mdl.Dbg.Msgf("Can't vectorize typed equation: %s\n", eqn.String())
+			return false
+		}
+		// only level equations can use previous values (J)
+		expr, ok := sw.expr(eqn.Formula, mdl, eqn.Mode == "L")
+		if !ok {
+			mdl.Dbg.Msgf("Can't vectorize: %s\n", eqn.String())
+			return false
+		}
+		sw.eqns = append(sw.eqns, &vecEqn{
+			mode:   eqn.Mode,
+			target: sw.cur[eqn.Target.Name],
+			expr:   expr,
+		})
+	}
+	sort.Strings(sw.names)
+	return true
+}
+
+// run the compiled equations for all parameter vectors.
+func (sw *sweep) run() *Result {
+	t, dt := sw.cur["TIME"][0], sw.cur["DT"][0]
+	epochs := 0
+	done := func() bool {
+		if maxLen, ok := sw.cur["MAXLEN"]; ok && t > maxLen[0] {
+			return true
+		}
+		return t > sw.cur["LENGTH"][0]
+	}
+	for !done() {
+		// compute auxiliaries and rates
+		if res := sw.compute("AR"); !res.Ok {
+			return res
+		}
+		// record current state
+		for i, ds := range sw.ds {
+			for _, name := range sw.names {
+				ds.Vars[name] = append(ds.Vars[name], sw.cur[name][i])
+			}
+		}
+		// propagate state and time
+		for name, vals := range sw.cur {
+			copy(sw.last[name], vals)
+		}
+		time, delta := sw.cur["TIME"], sw.cur["DT"]
+		for i := range time {
+			time[i] += delta[i]
+		}
+		// compute new levels
+		if res := sw.compute("L"); !res.Ok {
+			return res
+		}
+		epochs++
+		t += dt
+	}
+	Logf(LOG_INFO, LOG_RUN, "         %d epochs computed.", epochs)
+	return Success()
+}
+
+// compute all equations with specified mode
+func (sw *sweep) compute(modes string) *Result {
+	for _, eqn := range sw.eqns {
+		if strings.Contains(modes, eqn.mode) {
+			copy(eqn.target, eqn.expr())
+			if sw.res != nil {
+				return sw.res
+			}
+		}
+	}
+	return Success()
+}
+
+// fail records the first failed computation.
+func (sw *sweep) fail(res *Result) {
+	if sw.res == nil {
+		sw.res = res
+	}
+}
+
+// constant returns a compiled formula for a constant value.
+func (sw *sweep) constant(val float64) func() []float64 {
+	vals := make([]float64, sw.n)
+	for i := range vals {
+		vals[i] = val
+	}
+	return func() []float64 {
+		return vals
+	}
+}
+
+// variable returns a compiled formula for a named variable.
+func (sw *sweep) variable(name *Name, old bool) (fn func() []float64, ok bool) {
+	if name.Stage == NAME_STAGE_OLD && !old {
+		return nil, false
+	}
+	state := sw.cur
+	if name.Stage == NAME_STAGE_OLD {
+		state = sw.last
+	}
+	vals, ok := state[name.Name]
+	if !ok {
+		return nil, false
+	}
+	return func() []float64 {
+		return vals
+	}, true
+}
+
+// expr compiles a formula; the computed values are returned in a slice
+// (owned by the compiled formula).
+func (sw *sweep) expr(expr ast.Expr, mdl *Model, old bool) (fn func() []float64, ok bool) {
+	switch x := expr.(type) {
+	case *ast.BinaryExpr:
+		var a, b func() []float64
+		if a, ok = sw.expr(x.X, mdl, old); !ok {
+			return
+		}
+		if b, ok = sw.expr(x.Y, mdl, old); !ok {
+			return
+		}
+		out := make([]float64, sw.n)
+		var op func(u, v float64) float64
+		switch x.Op {
+		case token.ADD:
+			op = func(u, v float64) float64 { return u + v }
+		case token.SUB:
+			op = func(u, v float64) float64 { return u - v }
+		case token.MUL:
+			op = func(u, v float64) float64 { return u * v }
+		case token.QUO:
+			op = func(u, v float64) float64 { return u / v }
+		default:
+			if _, ok = relation(x.Op, 0, 0); !ok {
+				return nil, false
+			}
+			rel := x.Op
+			op = func(u, v float64) float64 {
+				val, _ := relation(rel, u, v)
+				return val
+			}
+		}
+		return func() []float64 {
+			u, v := a(), b()
+			for i := range out {
+				out[i] = op(u[i], v[i])
+			}
+			return out
+		}, true
+
+	case *ast.ParenExpr:
+		return sw.expr(x.X, mdl, old)
+
+	case *ast.BasicLit:
+		v, err := strconv.ParseFloat(x.Value, 64)
+		if err != nil {
+			return nil, false
+		}
+		return sw.constant(v), true
+
+	case *ast.Ident, *ast.SelectorExpr:
+		name, res := NewName(x)
+		if !res.Ok {
+			return nil, false
+		}
+		return sw.variable(name, old)
+
+	case *ast.UnaryExpr:
+		var a func() []float64
+		if a, ok = sw.expr(x.X, mdl, old); !ok || x.Op != token.SUB {
+			return nil, false
+		}
+		out := make([]float64, sw.n)
+		return func() []float64 {
+			u := a()
+			for i := range out {
+				out[i] = -u[i]
+			}
+			return out
+		}, true
+
+	case *ast.CallExpr:
+		return sw.call(x, mdl, old)
+	}
+	return nil, false
+}
+
+// vecFcn computes a function for all runs (with TIME as first argument)
+type vecFcn func(out, t []float64, x [][]float64)
+
+// vectorized functions (same semantics as the functions in 'fcnList')
+var vecFcns = map[string]vecFcn{
+	"SQRT": func(out, t []float64, x [][]float64) {
+		for i := range out {
+			out[i] = math.Sqrt(x[0][i])
+		}
+	},
+	"SIN": func(out, t []float64, x [][]float64) {
+		for i := range out {
+			out[i] = math.Sin(x[0][i])
+		}
+	},
+	"COS": func(out, t []float64, x [][]float64) {
+		for i := range out {
+			out[i] = math.Cos(x[0][i])
+		}
+	},
+	"EXP": func(out, t []float64, x [][]float64) {
+		for i := range out {
+			out[i] = math.Exp(x[0][i])
+		}
+	},
+	"LOG": func(out, t []float64, x [][]float64) {
+		for i := range out {
+			out[i] = math.Log(x[0][i])
+		}
+	},
+	"POW": func(out, t []float64, x [][]float64) {
+		for i := range out {
+			out[i] = math.Pow(x[0][i], x[1][i])
+		}
+	},
+	"MAX": func(out, t []float64, x [][]float64) {
+		for i := range out {
+			out[i] = x[0][i]
+			for _, v := range x[1:] {
+				if compare(out[i], v[i]) < 0 {
+					out[i] = v[i]
+				}
+			}
+		}
+	},
+	"MIN": func(out, t []float64, x [][]float64) {
+		for i := range out {
+			out[i] = x[0][i]
+			for _, v := range x[1:] {
+				if compare(out[i], v[i]) >= 0 {
+					out[i] = v[i]
+				}
+			}
+		}
+	},
+	"SUM": func(out, t []float64, x [][]float64) {
+		for i := range out {
+			out[i] = x[0][i]
+			for _, v := range x[1:] {
+				out[i] += v[i]
+			}
+		}
+	},
+	"MEAN": func(out, t []float64, x [][]float64) {
+		for i := range out {
+			out[i] = x[0][i]
+			for _, v := range x[1:] {
+				out[i] += v[i]
+			}
+			out[i] /= float64(len(x))
+		}
+	},
+	"CLIP": func(out, t []float64, x [][]float64) {
+		for i := range out {
+			if out[i] = x[0][i]; compare(x[2][i], x[3][i]) < 0 {
+				out[i] = x[1][i]
+			}
+		}
+	},
+	"SWITCH": func(out, t []float64, x [][]float64) {
+		for i := range out {
+			if out[i] = x[1][i]; compare(x[2][i], 0) == 0 {
+				out[i] = x[0][i]
+			}
+		}
+	},
+	"STEP": func(out, t []float64, x [][]float64) {
+		for i := range out {
+			if out[i] = 0; compare(t[i], x[1][i]) >= 0 {
+				out[i] = x[0][i]
+			}
+		}
+	},
+	"RAMP": func(out, t []float64, x [][]float64) {
+		for i := range out {
+			if out[i] = 0; compare(t[i], x[1][i]) >= 0 {
+				out[i] = x[0][i] * (t[i] - x[1][i])
+			}
+		}
+	},
+	"PULSE": func(out, t []float64, x [][]float64) {
+		for i := range out {
+			y := (t[i] - x[1][i]) / x[2][i]
+			if out[i] = 0; compare(y, math.Floor(y)) == 0 {
+				out[i] = x[0][i]
+			}
+		}
+	},
+}
+
+// table functions and their inter-/extrapolation mode
+var vecTables = map[string]int{
+	"TABLE": 0,
+	"TABHL": 0,
+	"TABXT": 1,
+	"TABPL": 2,
+}
+
+// call compiles a function call.
+func (sw *sweep) call(x *ast.CallExpr, mdl *Model, old bool) (fn func() []float64, ok bool) {
+	name, res := NewName(x.Fun)
+	if !res.Ok {
+		return nil, false
+	}
+	if _, ok = vecTables[name.Name]; ok {
+		return sw.table(x, mdl, old)
+	}
+	if name.Name == "IFTHENELSE" {
+		return sw.cond(x, mdl, old)
+	}
+	f, ok := vecFcns[name.Name]
+	if !ok {
+		return nil, false
+	}
+	list, res := mdl.args(x)
+	if !res.Ok {
+		return nil, false
+	}
+	var args []func() []float64
+	if args, ok = sw.args(list, mdl, old); !ok {
+		return nil, false
+	}
+	out := make([]float64, sw.n)
+	vals := make([][]float64, len(args))
+	time := sw.cur["TIME"]
+	return func() []float64 {
+		for i, arg := range args {
+			vals[i] = arg()
+		}
+		f(out, time, vals)
+		return out
+	}, true
+}
+
+// args compiles the (resolved) arguments of a function call.
+func (sw *sweep) args(list []*Arg, mdl *Model, old bool) (args []func() []float64, ok bool) {
+	args = make([]func() []float64, len(list))
+	for i, arg := range list {
+		switch arg.Kind {
+		case ARG_CONST:
+			args[i], ok = sw.constant(float64(arg.val)), true
+		case ARG_VAR:
+			args[i], ok = sw.variable(arg.name, old)
+		case ARG_EXPR:
+			args[i], ok = sw.expr(arg.expr, mdl, old)
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	return args, true
+}
+
+// cond compiles a conditional: a branch is only evaluated for the runs
+// that select it (tables are not accessed in the other runs).
+func (sw *sweep) cond(x *ast.CallExpr, mdl *Model, old bool) (fn func() []float64, ok bool) {
+	list, res := mdl.args(x)
+	if !res.Ok || len(list) != 3 {
+		return nil, false
+	}
+	var args []func() []float64
+	if args, ok = sw.args(list, mdl, old); !ok {
+		return nil, false
+	}
+	out := make([]float64, sw.n)
+	skipA, skipB := make([]bool, sw.n), make([]bool, sw.n)
+	return func() []float64 {
+		c, skip := args[0](), sw.skip
+		for i := range out {
+			off, sel := skip != nil && skip[i], compare(c[i], 0) != 0
+			skipA[i], skipB[i] = off || !sel, off || sel
+		}
+		sw.skip = skipA
+		a := args[1]()
+		sw.skip = skipB
+		b := args[2]()
+		sw.skip = skip
+		for i := range out {
+			if out[i] = b[i]; compare(c[i], 0) != 0 {
+				out[i] = a[i]
+			}
+		}
+		return out
+	}, true
+}
+
+// table compiles a call of a table function.
+func (sw *sweep) table(x *ast.CallExpr, mdl *Model, old bool) (fn func() []float64, ok bool) {
+	name, _ := NewName(x.Fun)
+	mode := vecTables[name.Name]
+	list, res := mdl.args(x)
+	if !res.Ok || len(list) < 5 {
+		return nil, false
+	}
+	tbl, ok := mdl.Tables[list[0].Text]
+	if !ok || tbl.Cols > 0 {
+		return nil, false
+	}
+	if tbl.Kind == TBL_EXTRAPOLATE && mode == 0 {
+		mode = 1
+	}
+	var args []func() []float64
+	if args, ok = sw.args(list[1:5], mdl, old); !ok {
+		return nil, false
+	}
+	// region of table argument (range check)
+	var region []float64
+	if len(list) == 6 {
+		region = make([]float64, sw.n)
+		for i, auto := range sw.autos {
+			val, _ := auto.get(list[5].Text)
+			region[i] = float64(val)
+		}
+	}
+	out := make([]float64, sw.n)
+	n := Variable(len(tbl.Data) - 1)
+	checked := [3]float64{math.NaN(), math.NaN(), math.NaN()}
+	return func() []float64 {
+		v, lo, hi, st := args[0](), args[1](), args[2](), args[3]()
+		for i := range out {
+			if sw.skip != nil && sw.skip[i] {
+				// run doesn't evaluate the table (conditional)
+				continue
+			}
+			// check table parameters (if changed)
+			if lo[i] != checked[0] || hi[i] != checked[1] || st[i] != checked[2] {
+				if res := tbl.check(list[0].Text, Variable(lo[i]), Variable(hi[i]), Variable(st[i])); !res.Ok {
+					sw.fail(res)
+					return out
+				}
+				checked = [3]float64{lo[i], hi[i], st[i]}
+			}
+			pos := tbl.position(Variable(v[i]), Variable(lo[i]), Variable(hi[i]))
+			if region != nil {
+				below, above := pos.Compare(0) < 0, pos.Compare(n) >= 0
+				region[i] = float64(tableRegion(list[0].Text, int(region[i]), below, above))
+			}
+			out[i] = float64(tbl.value(pos, n, mode))
+		}
+		return out
+	}, true
+}
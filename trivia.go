@@ -0,0 +1,94 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"io"
+	"strings"
+)
+
+//======================================================================
+// Source lines: the parser keeps every line of the model source as read
+// (including comments, blank lines and card sequence numbers) alongside
+// the semantic model. Tools like formatters and exporters can use the
+// lines to reproduce the original source exactly or to map statements
+// back to their position in the source.
+//======================================================================
+
+// Kinds of source lines
+const (
+	SRC_BLANK   = iota // empty line (or only spaces)
+	SRC_STMT           // first line of a statement (including NOTE and '*')
+	SRC_CONT           // continuation ('X') line of a statement
+	SRC_COMMENT        // comment line ('#' in relaxed mode)
+)
+
+// Layout of punched cards
+const (
+	CARD_LENGTH = 80 // columns 73-80 hold the sequence number
+)
+
+// SourceLine is a line of model source as read by the parser.
+type SourceLine struct {
+	No   int    // line number (starting at 1)
+	Kind int    // kind of line (SRC_???)
+	Mode string // mode of statement (for SRC_STMT lines)
+	Stmt int    // line number of the statement the line belongs to (or 0)
+	Seq  string // card sequence number (columns 73-80) or empty
+	Raw  string // line as read (undecoded, including the line end)
+}
+
+// WriteLines writes the source lines of a model to a stream; the output
+// is identical to the parsed source (EBCDIC sources are written as UTF-8).
+func (mdl *Model) WriteLines(w io.Writer) *Result {
+	for _, line := range mdl.Lines {
+		if _, err := io.WriteString(w, line.Raw); err != nil {
+			return Failure(err)
+		}
+	}
+	return Success()
+}
+
+// StatementLines returns the source lines of the statement starting at given
+// line number.
+func (mdl *Model) StatementLines(lineNo int) (list []*SourceLine) {
+	for _, line := range mdl.Lines {
+		if line.Stmt == lineNo {
+			list = append(list, line)
+		}
+	}
+	return
+}
+
+// cardSeq returns the sequence number in columns 73-80 of a line in card
+// layout: the line is longer than MAX_LINE_LENGTH (but not longer than a
+// card) and the extra columns hold a single word.
+func cardSeq(text string) string {
+	runes := []rune(text)
+	if len(runes) <= MAX_LINE_LENGTH || len(runes) > CARD_LENGTH {
+		return ""
+	}
+	seq := strings.TrimSpace(string(runes[MAX_LINE_LENGTH:]))
+	if strings.ContainsAny(seq, " \t") {
+		return ""
+	}
+	return seq
+}
@@ -44,6 +44,9 @@ import (
 // index (whether it refers to 'now' or 'past' states).
 //
 // Example names: COFFEE, SHPMTS.JK, INV.K
+//
+// Names of variables defined in a sector are qualified with the sector
+// name, like PROD.INV.K (see sector.go).
 //----------------------------------------------------------------------
 
 // Name-related constants
@@ -71,16 +74,6 @@ const (
 	NAME_MATCH     = 7 // names match fully
 )
 
-var (
-	autoId = 0 // last automatic variable identifier
-)
-
-// NewAutoVar generates a new automatic variable name
-func NewAutoVar() string {
-	autoId++
-	return fmt.Sprintf("_%d", autoId)
-}
-
 // Class is a classification for variables
 type Class struct {
 	Kind  int // NAME_KIND_?
@@ -103,26 +96,16 @@ func NewName(v ast.Expr) (name *Name, res *Result) {
 		name.Kind = NAME_KIND_CONST
 		name.Stage = NAME_STAGE_NONE
 		name.Name = x.Name
-		if len(name.Name) > MAX_NAME_LENGTH {
-			if strict {
-				res = Failure(ErrParseNameLength+": %s", name.Name)
-			} else {
-				Msgf("WARN: "+ErrParseNameLength+": %s", name.Name)
-			}
-		}
-		start := []rune(name.Name)[0]
-		if !unicode.IsLetter(start) && start != '_' {
-			if strict {
-				res = Failure(ErrParseInvalidName+": %s", name.Name)
-			} else {
-				Msgf("WARN: "+ErrParseInvalidName+": %s", name.Name)
-			}
-		}
 		return
 	case *ast.SelectorExpr:
 		if name, res = NewName(x.X); !res.Ok {
 			return
 		}
+		if name.Stage == NAME_STAGE_NONE && !isIndex(x.Sel.Name) {
+			// qualified name (SECTOR.NAME)
+			name.Name += "." + x.Sel.Name
+			return
+		}
 		res = name.setIndex(x.Sel.Name)
 	default:
 		res = Failure(ErrParseInvalidName+": %s", reflect.TypeOf(v))
@@ -133,24 +116,62 @@ func NewName(v ast.Expr) (name *Name, res *Result) {
 // NewNameFromString returns a name instance for a given identifier.
 func NewNameFromString(n string) (name *Name, res *Result) {
 	res = Success()
-	parts := strings.Split(n, ".")
 	name = new(Name)
 	name.Kind = NAME_KIND_CONST
 	name.Stage = NAME_STAGE_NONE
-	name.Name = parts[0]
-	if len(name.Name) > MAX_NAME_LENGTH {
+	name.Name = n
+	if pos := strings.LastIndex(n, "."); pos != -1 && isIndex(n[pos+1:]) {
+		name.Name = n[:pos]
+		res = name.setIndex(n[pos+1:])
+	}
+	return
+}
+
+// Check if a name follows the DYNAMO naming rules (max. length, starts
+// with a letter). In strict mode a violation is a failure, otherwise only
+// a warning is logged.
+func (n *Name) Check(strict bool) (res *Result) {
+	res = Success()
+	fail := func(err string) {
 		if strict {
-			res = Failure(ErrParseNameLength+": %d", len(name.Name))
+			res = Failure(err+": %s", n.Name)
 		} else {
-			Msgf("WARN: "+ErrParseNameLength+": %s", name.Name)
+			Logf(LOG_WARN, LOG_PARSE, err+": %s", n.Name)
 		}
 	}
-	if len(parts) > 1 {
-		res = name.setIndex(parts[1])
+	// skip automatic variables
+	if len(n.Name) == 0 || n.Name[0] == '_' {
+		return
+	}
+	// check all parts of a qualified name
+	for _, part := range strings.Split(n.Name, ".") {
+		if len(part) > MAX_NAME_LENGTH {
+			fail(ErrParseNameLength)
+		}
+		if len(part) == 0 || !unicode.IsLetter([]rune(part)[0]) {
+			fail(ErrParseInvalidName)
+		}
 	}
 	return
 }
 
+// Qualifier returns the sector of a qualified name (or an empty string)
+func (n *Name) Qualifier() string {
+	if pos := strings.LastIndex(n.Name, "."); pos != -1 {
+		return n.Name[:pos]
+	}
+	return ""
+}
+
+// isIndex returns true if the string is a valid variable index
+func isIndex(idx string) bool {
+	switch idx {
+	case "J", "JK", "K", "KL":
+		return true
+	}
+	return false
+}
+
 // SetIndex sets name flags for a given index string
 func (n *Name) setIndex(idx string) (res *Result) {
 	res = Success()
@@ -235,6 +256,43 @@ func (n *Name) Compare(m *Name) int {
 // Variable has a floating point value
 type Variable float64
 
+// Types of variables: integer and boolean values are stored as floating
+// point values, but are converted when they are computed (and printed
+// without decimals).
+const (
+	TYPE_REAL    = iota // real number (default)
+	TYPE_INTEGER        // integer (values are rounded)
+	TYPE_BOOLEAN        // boolean (1 = true, 0 = false)
+)
+
+// parseType returns the type of a variable from its name ("REAL",
+// "INTEGER" or "BOOLEAN").
+func parseType(s string) (int, *Result) {
+	switch strings.ToUpper(s) {
+	case "REAL":
+		return TYPE_REAL, Success()
+	case "INTEGER", "INT":
+		return TYPE_INTEGER, Success()
+	case "BOOLEAN", "BOOL":
+		return TYPE_BOOLEAN, Success()
+	}
+	return TYPE_REAL, Failure(ErrParseSyntax+": unknown type %s", s)
+}
+
+// Typed converts a value to a variable type.
+func (v Variable) Typed(typ int) Variable {
+	switch typ {
+	case TYPE_INTEGER:
+		return Variable(math.Round(float64(v)))
+	case TYPE_BOOLEAN:
+		if v.Compare(0) != 0 {
+			return 1
+		}
+		return 0
+	}
+	return v
+}
+
 // String returns the human-readable representation of a variable
 func (v Variable) String() string {
 	return fmt.Sprintf("%f", v)
@@ -265,6 +323,10 @@ func (v Variable) Log() Variable {
 	return Variable(math.Log(float64(v)))
 }
 
+func (v Variable) Pow(x Variable) Variable {
+	return Variable(math.Pow(float64(v), float64(x)))
+}
+
 func (v Variable) Floor() Variable {
 	return Variable(math.Floor(float64(v)))
 }
@@ -297,6 +359,18 @@ func (ts *TSVar) Add(y float64) {
 	ts.Values = append(ts.Values, y)
 }
 
+// Truncate time-series to n values
+func (ts *TSVar) Truncate(n int) {
+	if n >= len(ts.Values) {
+		return
+	}
+	values := ts.Values[:n]
+	ts.Reset()
+	for _, y := range values {
+		ts.Add(y)
+	}
+}
+
 // Reset time-series
 func (ts *TSVar) Reset() {
 	ts.Values = make([]float64, 0)
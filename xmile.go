@@ -0,0 +1,205 @@
+package dynamo
+
+//----------------------------------------------------------------------
+// This file is part of Dynamo.
+// Copyright (C) 2020-2021 Bernd Fix
+//
+// Dynamo is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Dynamo is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//======================================================================
+// XMILE graphical functions: lookup tables of XMILE models (like models
+// from STELLA or iThink) are imported as tables with their x-values
+// (explicit point pairs or an x-range), so non-equidistant points are
+// preserved; the type of the graphical function ("continuous",
+// "extrapolate" or "discrete") sets the kind of the table:
+//
+//     T EFFECT_OF_PRICE=@model.stmx
+//======================================================================
+
+// xmilePoints is a list of numbers in an XMILE graphical function.
+type xmilePoints struct {
+	Sep  string `xml:"sep,attr"`
+	Data string `xml:",chardata"`
+}
+
+// values returns the numbers in the list.
+func (p *xmilePoints) values() (list []float64, res *Result) {
+	sep := p.Sep
+	if len(sep) == 0 {
+		sep = ","
+	}
+	for _, s := range strings.Split(p.Data, sep) {
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, Failure(ErrParseNotANumber+": %s", s)
+		}
+		list = append(list, v)
+	}
+	return list, Success()
+}
+
+// xmileScale is the range of values in an XMILE graphical function.
+type xmileScale struct {
+	Min float64 `xml:"min,attr"`
+	Max float64 `xml:"max,attr"`
+}
+
+// xmileGF is an XMILE graphical function.
+type xmileGF struct {
+	Name   string       `xml:"name,attr"`
+	Type   string       `xml:"type,attr"`
+	XScale *xmileScale  `xml:"xscale"`
+	XPts   *xmilePoints `xml:"xpts"`
+	YPts   xmilePoints  `xml:"ypts"`
+}
+
+// table returns the table for a graphical function.
+func (gf *xmileGF) table() (tbl *Table, res *Result) {
+	var x, y []float64
+	if y, res = gf.YPts.values(); !res.Ok {
+		return
+	}
+	if gf.XPts != nil {
+		// explicit x-values
+		if x, res = gf.XPts.values(); !res.Ok {
+			return
+		}
+	} else if gf.XScale != nil && len(y) > 1 {
+		// equidistant x-values in range
+		step := (gf.XScale.Max - gf.XScale.Min) / float64(len(y)-1)
+		for i := range y {
+			x = append(x, gf.XScale.Min+float64(i)*step)
+		}
+	} else {
+		return nil, Failure(ErrParseTableFormat+": no x-values for '%s'", gf.Name)
+	}
+	if tbl, res = NewTableXY(x, y); !res.Ok {
+		return
+	}
+	switch strings.ToLower(gf.Type) {
+	case "", "continuous":
+		tbl.Kind = TBL_CONTINUOUS
+	case "extrapolate":
+		tbl.Kind = TBL_EXTRAPOLATE
+	case "discrete":
+		tbl.Kind = TBL_DISCRETE
+	default:
+		return nil, Failure(ErrParseTableFormat+": type '%s'", gf.Type)
+	}
+	return
+}
+
+// xmileName returns the DYNAMO name for an XMILE name: letters are
+// uppercased, spaces become underscores and other characters are dropped.
+func xmileName(name string) string {
+	var buf strings.Builder
+	for _, r := range strings.Join(strings.Fields(name), "_") {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			buf.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return buf.String()
+}
+
+// ReadXMILETables reads the graphical functions of an XMILE model (named
+// and embedded in variables) as tables; the tables are keyed by the
+// DYNAMO names of the functions (or of the variables they are part of).
+func ReadXMILETables(r io.Reader) (list map[string]*Table, res *Result) {
+	list = make(map[string]*Table)
+	dec := xml.NewDecoder(r)
+	owner := ""
+	for {
+		tk, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, Failure(ErrParseTableFormat+": %s", err.Error())
+		}
+		switch el := tk.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "aux", "flow", "stock":
+				for _, attr := range el.Attr {
+					if attr.Name.Local == "name" {
+						owner = attr.Value
+					}
+				}
+			case "gf":
+				gf := new(xmileGF)
+				if err = dec.DecodeElement(gf, &el); err != nil {
+					return nil, Failure(ErrParseTableFormat+": %s", err.Error())
+				}
+				if len(gf.Name) == 0 {
+					gf.Name = owner
+				}
+				var tbl *Table
+				if tbl, res = gf.table(); !res.Ok {
+					return nil, res
+				}
+				list[xmileName(gf.Name)] = tbl
+			}
+		case xml.EndElement:
+			switch el.Name.Local {
+			case "aux", "flow", "stock":
+				owner = ""
+			}
+		}
+	}
+	return list, Success()
+}
+
+// NewTableFromXMILE returns the named graphical function of an XMILE model
+// file as a table.
+func NewTableFromXMILE(fname, name string) (tbl *Table, res *Result) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, Failure(err)
+	}
+	defer f.Close()
+	var list map[string]*Table
+	if list, res = ReadXMILETables(f); !res.Ok {
+		return
+	}
+	var ok bool
+	if tbl, ok = list[name]; !ok {
+		return nil, Failure(ErrModelNoSuchTable+": %s", name)
+	}
+	return tbl, Success()
+}
+
+// isXMILE returns true if a file name has an extension of XMILE models.
+func isXMILE(fname string) bool {
+	pos := strings.LastIndex(fname, ".")
+	if pos == -1 {
+		return false
+	}
+	switch strings.ToLower(fname[pos:]) {
+	case ".xmile", ".xmi", ".stmx", ".itmx":
+		return true
+	}
+	return false
+}